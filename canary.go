@@ -0,0 +1,623 @@
+// Package canary exposes the Kafka canary checks as a library, so other Go services can embed
+// them in their own health systems instead of running the standalone binary.
+//
+//	c, err := canary.New(canary.Config{
+//		Brokers: []string{"localhost:9092"},
+//		Canary:  settings,
+//		Callbacks: canary.Callbacks{
+//			OnConsume: func(r canary.ConsumeResult) { ... },
+//		},
+//	}, &logger)
+//	if err != nil {
+//		...
+//	}
+//	err = c.Run(ctx)
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/internal/ratelimit"
+	"github.com/pecigonzalo/kafka-canary/internal/workers"
+	canaryconfig "github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
+)
+
+type (
+	// Settings contains the canary check settings (topic, intervals, buckets, etc.)
+	Settings = canaryconfig.Config
+	// TLSConfig stores the TLS-related configuration for broker connections
+	TLSConfig = client.TLSConfig
+	// SASLConfig stores the SASL-related configuration for broker connections
+	SASLConfig = client.SASLConfig
+	// SASLMechanism is the name of a SASL mechanism used for client authentication
+	SASLMechanism = client.SASLMechanism
+	// ProxyConfig stores the configuration of the proxy used to reach the brokers
+	ProxyConfig = client.ProxyConfig
+	// DNSConfig stores the name resolution configuration for broker connections
+	DNSConfig = client.DNSConfig
+	// IPFamily is the address family preferred when dialing brokers
+	IPFamily = client.IPFamily
+
+	// Callbacks defines optional functions invoked with the result of each canary check
+	Callbacks = services.Callbacks
+	// ProduceResult contains the outcome of producing a single canary record
+	ProduceResult = services.ProduceResult
+	// LatencyBreach contains the partition context captured when a record exceeds its latency SLO
+	LatencyBreach = services.LatencyBreach
+	// ConsumeResult contains the outcome of consuming a single canary record
+	ConsumeResult = services.ConsumeResult
+	// ReconcileResult contains the outcome of a topic reconcile
+	ReconcileResult = services.ReconcileResult
+	// CheckResult contains the outcome of an additional check, e.g. a plugin
+	CheckResult = services.CheckResult
+)
+
+const (
+	SASLMechanismAWSMSKIAM   = client.SASLMechanismAWSMSKIAM
+	SASLMechanismPlain       = client.SASLMechanismPlain
+	SASLMechanismScramSHA256 = client.SASLMechanismScramSHA256
+	SASLMechanismScramSHA512 = client.SASLMechanismScramSHA512
+
+	IPFamilyAuto = client.IPFamilyAuto
+	IPFamilyIPv4 = client.IPFamilyIPv4
+	IPFamilyIPv6 = client.IPFamilyIPv6
+)
+
+// Config contains the configuration used to construct an embedded canary
+type Config struct {
+	Brokers  []string
+	TLS      TLSConfig
+	SASL     SASLConfig
+	Proxy    ProxyConfig
+	DNS      DNSConfig
+	IPFamily IPFamily
+	// IPs or interface names the broker connections are bound to, unbound when empty
+	SourceAddrs []string
+	Canary      Settings
+	Callbacks   Callbacks
+	Metrics     MetricsConfig
+}
+
+// MetricsConfig defines how the canary metrics are exposed
+type MetricsConfig struct {
+	// namespace of the metric names, kafka_canary when empty
+	Namespace string
+	Subsystem string
+	// registerer the metrics are exposed on, the default Prometheus one when nil
+	Registerer prometheus.Registerer
+}
+
+type metricsExposition struct {
+	registerer prometheus.Registerer
+	namespace  string
+	subsystem  string
+}
+
+var (
+	exposedMetricsLock sync.Mutex
+	// the collectors can't be unregistered, each exposition is registered once and exposes the
+	// metrics of the live canary attached to it
+	exposedMetrics = map[metricsExposition]*exposedCollector{}
+)
+
+// exposedCollector exposes the metrics of the canary attached to an exposition, none while
+// detached
+type exposedCollector struct {
+	lock      sync.RWMutex
+	collector prometheus.Collector
+}
+
+func (c *exposedCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *exposedCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.collector != nil {
+		c.collector.Collect(ch)
+	}
+}
+
+// Canary runs the topic, producer and consumer checks against a Kafka cluster
+type Canary struct {
+	state          *services.State
+	manager        workers.Worker
+	topic          services.TopicService
+	status         services.StatusService
+	stallThreshold time.Duration
+	// partitions stalled for longer than the threshold
+	stalledPartitions func(threshold time.Duration) []int
+	settings          Settings
+	permissions       client.ConnectorConfig
+	configHash        string
+	logger            *zerolog.Logger
+	// claims the canary topic before starting, nil without ownership
+	ownership services.CheckService
+	// detaches the canary metrics from their exposition
+	unexpose func()
+	stopOnce sync.Once
+}
+
+// New returns a Canary for the given configuration, ready to be started. The canaries of a process
+// have their own state and metrics, they run side by side as long as they expose their metrics
+// with a different namespace, subsystem or registerer.
+func New(config Config, logger *zerolog.Logger) (c *Canary, err error) {
+	hash, err := configHash(config)
+	if err != nil {
+		return nil, err
+	}
+	state := services.NewState(config.Canary)
+	exportConfigHash(state.Metrics(), hash)
+	unexpose, err := exposeMetrics(config.Metrics, state.Metrics())
+	if err != nil {
+		return nil, err
+	}
+	// what was opened so far is closed when failing, the checks included
+	closers := []func(){unexpose}
+	var checks []services.CheckService
+	defer func() {
+		if err != nil {
+			for _, check := range checks {
+				check.Close()
+			}
+			for i := len(closers) - 1; i >= 0; i-- {
+				closers[i]()
+			}
+		}
+	}()
+	if config.Canary.Chaos.Enabled {
+		logger.Warn().Msgf("Chaos mode enabled, faults will be injected: %+v", config.Canary.Chaos)
+	}
+
+	connectorConfig := client.ConnectorConfig{
+		BrokerAddrs: config.Brokers,
+		TLS:         config.TLS,
+		SASL:        config.SASL,
+		Proxy:       config.Proxy,
+		DNS:         config.DNS,
+		IPFamily:    config.IPFamily,
+		SourceAddrs: config.SourceAddrs,
+		Metrics:     state.Metrics(),
+	}
+	if config.Canary.AdminRateLimit > 0 {
+		connectorConfig.AdminLimiter = ratelimit.NewTokenBucket(config.Canary.AdminRateLimit, config.Canary.AdminRateBurst)
+	}
+	connectorFor := func(service string) client.ConnectorConfig {
+		return serviceConnector(connectorConfig, config.Canary, service)
+	}
+
+	if err := state.OpenAuditLog(config.Canary, connectorFor("audit"), logger); err != nil {
+		return nil, err
+	}
+	closers = append(closers, state.CloseAuditLog)
+	topicService := services.NewTopicService(state, config.Canary, connectorFor("topic"), logger)
+	closers = append(closers, topicService.Close)
+	producerService, err := services.NewProducerService(state, config.Canary, connectorFor("producer"), logger)
+	if err != nil {
+		return nil, err
+	}
+	closers = append(closers, producerService.Close)
+	consumerService, err := services.NewConsumerService(state, config.Canary, connectorFor("consumer"), logger)
+	if err != nil {
+		return nil, err
+	}
+	closers = append(closers, consumerService.Close)
+	connectionService := services.NewConnectionService(state, config.Canary, connectorFor("connection"))
+	statusService := services.NewStatusServiceService(state, config.Canary, logger)
+	closers = append(closers, connectionService.Close, statusService.Close)
+	if sampler, ok := statusService.(services.RecordsSampler); ok {
+		for _, service := range []interface{}{producerService, consumerService} {
+			if aware, ok := service.(services.RecordsSamplerAware); ok {
+				aware.SetRecordsSampler(sampler)
+			}
+		}
+	}
+
+	enabled := config.Canary.CheckEnabled
+	checks = make([]services.CheckService, 0, len(config.Canary.Plugins))
+	for _, plugin := range config.Canary.Plugins {
+		if !enabled("plugin_" + plugin.Name) {
+			continue
+		}
+		checks = append(checks, services.NewPluginService(state, plugin, config.Canary, connectorFor("plugin_"+plugin.Name), logger))
+	}
+	if enabled("message_size") && config.Canary.MessageSize.Enabled {
+		check, err := services.NewMessageSizeService(state, config.Canary, connectorFor("message_size"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("group_coordinator") && config.Canary.GroupCoordinator {
+		check, err := services.NewGroupCoordinatorService(state, config.Canary, connectorFor("group_coordinator"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("internal_topics") && config.Canary.InternalTopics.Enabled {
+		check, err := services.NewInternalTopicsService(state, config.Canary, connectorFor("internal_topics"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("transaction_coordinator") && config.Canary.TransactionCoordinator.Enabled {
+		check, err := services.NewTransactionCoordinatorService(state, config.Canary, connectorFor("transaction_coordinator"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("consumer_groups") && len(config.Canary.ConsumerGroups.Groups) > 0 {
+		check, err := services.NewConsumerGroupsService(state, config.Canary, connectorFor("consumer_groups"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("metadata_consistency") && config.Canary.MetadataConsistency.Enabled {
+		check, err := services.NewMetadataConsistencyService(state, config.Canary, connectorFor("metadata_consistency"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("denied_operations") && config.Canary.DeniedOperations.Enabled() {
+		check, err := services.NewDeniedOperationsService(state, config.Canary, connectorFor("denied_operations"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("rest_proxy") && config.Canary.RestProxy.URL != "" {
+		check, err := services.NewRestProxyService(state, config.Canary, connectorFor("rest_proxy"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("cluster_comparison") && len(config.Canary.Comparison.Brokers) > 0 {
+		check, err := services.NewClusterComparisonService(state, config.Canary, connectorFor("cluster_comparison"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("cruise_control") && config.Canary.CruiseControl.URL != "" {
+		check, err := services.NewCruiseControlService(state, config.Canary, connectorFor("cruise_control"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("replay") && config.Canary.Replay.Enabled {
+		check, err := services.NewReplayService(state, config.Canary, connectorFor("replay"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("static_membership") && config.Canary.StaticMembership.Enabled {
+		check, err := services.NewStaticMembershipService(state, config.Canary, connectorFor("static_membership"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("direct_leader") && config.Canary.DirectLeader.Enabled {
+		check, err := services.NewDirectLeaderService(state, config.Canary, connectorFor("direct_leader"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	var ownership services.CheckService
+	if enabled("ownership") && config.Canary.Ownership.Enabled {
+		check, err := services.NewOwnershipService(state, config.Canary, connectorFor("ownership"), logger)
+		if err != nil {
+			return nil, err
+		}
+		ownership = check
+		checks = append(checks, check)
+	}
+	if enabled("failover") && config.Canary.Failover.Enabled {
+		check, err := services.NewFailoverService(state, config.Canary, connectorFor("failover"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("bandwidth") && config.Canary.Bandwidth.Enabled {
+		check, err := services.NewBandwidthService(state, config.Canary, connectorFor("bandwidth"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("clock") && config.Canary.Clock.NTPServer != "" {
+		check, err := services.NewClockService(state, config.Canary, connectorFor("clock"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	if enabled("offset_for_timestamp") && config.Canary.OffsetTimestamp.Enabled {
+		check, err := services.NewOffsetTimestampService(state, config.Canary, connectorFor("offset_for_timestamp"), logger)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	manager := workers.NewCanaryManager(state, config.Canary,
+		topicService, producerService, consumerService, connectionService, statusService,
+		checks, config.Callbacks, logger)
+
+	return &Canary{
+		state:             state,
+		manager:           manager,
+		topic:             topicService,
+		status:            statusService,
+		stallThreshold:    config.Canary.StallThreshold,
+		stalledPartitions: state.StalledPartitions,
+		settings:          config.Canary,
+		permissions:       connectorFor("permissions"),
+		ownership:         ownership,
+		configHash:        hash,
+		logger:            logger,
+		unexpose:          unexpose,
+	}, nil
+}
+
+// serviceConnector returns the connector config of a service, every service reporting its own
+// client ID to the brokers
+func serviceConnector(connectorConfig client.ConnectorConfig, settings Settings, service string) client.ConnectorConfig {
+	connectorConfig.ClientID = settings.ClientIDFor(service)
+	connectorConfig.Service = service
+	return connectorConfig
+}
+
+// exposeMetrics exposes the metrics of the registry on the configured registerer until detached
+// with the returned function, failing while another canary exposes its own the same way
+func exposeMetrics(config MetricsConfig, registry *metrics.Registry) (func(), error) {
+	exposition := metricsExposition{
+		registerer: config.Registerer,
+		namespace:  config.Namespace,
+		subsystem:  config.Subsystem,
+	}
+	if exposition.registerer == nil {
+		exposition.registerer = prometheus.DefaultRegisterer
+	}
+	if exposition.namespace == "" {
+		exposition.namespace = metrics.Namespace
+	}
+
+	exposedMetricsLock.Lock()
+	defer exposedMetricsLock.Unlock()
+	exposed, ok := exposedMetrics[exposition]
+	if !ok {
+		exposed = &exposedCollector{}
+		if err := exposition.registerer.Register(exposed); err != nil {
+			return nil, fmt.Errorf("error registering the canary metrics: %w", err)
+		}
+		exposedMetrics[exposition] = exposed
+	}
+
+	exposed.lock.Lock()
+	defer exposed.lock.Unlock()
+	if exposed.collector != nil {
+		return nil, fmt.Errorf("another canary exposes its metrics with the namespace %s and subsystem %q, stop it first or configure another one",
+			exposition.namespace, exposition.subsystem)
+	}
+	exposed.collector = registry.Collector(exposition.namespace, exposition.subsystem)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			exposed.lock.Lock()
+			defer exposed.lock.Unlock()
+			exposed.collector = nil
+		})
+	}, nil
+}
+
+// Start runs a first reconcile and starts the periodic checks in the background
+func (c *Canary) Start() error {
+	c.state.StartWarmUp(c.settings.WarmUp)
+	c.logPrincipal()
+	if c.settings.PermissionsCheck && c.settings.CheckEnabled("permissions") {
+		c.verifyPermissions()
+	}
+	if err := c.claimTopic(); err != nil {
+		return err
+	}
+	return c.manager.Start()
+}
+
+// claimTopic claims the canary topic, refusing to start on a topic claimed by another live
+// instance so a misconfigured fleet doesn't mix up the statistics of its canaries
+func (c *Canary) claimTopic() error {
+	if c.ownership == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.ownership.Check(ctx)
+	if errors.Is(err, services.ErrTopicOwned) {
+		return err
+	}
+	if err != nil {
+		// the claim is retried by the ownership check
+		c.logger.Warn().Err(err).Msg("Error claiming the canary topic")
+	}
+	return nil
+}
+
+// verifyPermissions reports the ACLs the canary principal is missing, the canary starts anyway
+// as they may be granted while it runs
+func (c *Canary) verifyPermissions() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.state.VerifyPermissions(ctx, c.settings, c.permissions)
+	var missing *services.ErrMissingPermissions
+	switch {
+	case errors.As(err, &missing):
+		c.logger.Error().Strs("missing", missing.Missing).Msg("The canary principal is missing permissions, the canary will fail until they are granted")
+	case errors.Is(err, services.ErrPermissionsUnsupported):
+		c.logger.Info().Err(err).Msg("Skipping the permissions check")
+	case err != nil:
+		c.logger.Warn().Err(err).Msg("Error verifying the canary permissions")
+	default:
+		c.logger.Info().Msg("Canary permissions verified")
+	}
+}
+
+// logPrincipal logs the principal the canary connects as and how many ACLs it has, so a
+// misconfigured certificate or user shows up at startup
+func (c *Canary) logPrincipal() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info := services.DescribePrincipal(ctx, c.settings, c.permissions)
+	event := c.logger.Info().Str("principal", info.Principal).Str("source", info.Source)
+	if info.Error != "" {
+		event = event.Str("error", info.Error)
+	}
+	if info.ACLsError != "" {
+		event = event.Str("aclsError", info.ACLsError)
+	} else {
+		event = event.Int("acls", len(info.ACLs))
+	}
+	event.Msg("Connecting as principal")
+}
+
+// Stop stops the periodic checks and closes all the services
+func (c *Canary) Stop() {
+	c.stopOnce.Do(func() {
+		c.manager.Stop()
+		c.state.CloseAuditLog()
+		c.unexpose()
+	})
+}
+
+// Run starts the canary and blocks until the context is done, stopping it afterwards
+func (c *Canary) Run(ctx context.Context) error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	c.Stop()
+	return nil
+}
+
+// Ready returns an error when a service or check fails authenticating or being authorized, or when
+// partitions haven't had records consumed for longer than the stall threshold, a single dead
+// partition is otherwise hidden by the healthy ones in the status. The canary is always ready
+// during its warm-up period.
+func (c *Canary) Ready() error {
+	if c.state.WarmingUp() {
+		return nil
+	}
+	// a credential expired or an ACL removed, told apart from the cluster being down
+	if failures := c.state.AuthFailures(); len(failures) > 0 {
+		names := make([]string, 0, len(failures))
+		for name := range failures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("authentication or authorization failing for %v: %s", names, failures[names[0]])
+	}
+	if c.stallThreshold <= 0 {
+		return nil
+	}
+	if stalled := c.stalledPartitions(c.stallThreshold); len(stalled) > 0 {
+		return fmt.Errorf("partitions %v stalled for more than %s", stalled, c.stallThreshold)
+	}
+	return nil
+}
+
+// ConfigHash returns the SHA-256 of the canary configuration, also exported in the config_hash
+// metric, so instances checking the clusters the same way can be told apart from the others
+func (c *Canary) ConfigHash() string {
+	return c.configHash
+}
+
+// StatusHandler returns an HTTP handler serving the canary status
+func (c *Canary) StatusHandler() http.Handler {
+	return c.status.StatusHandler()
+}
+
+// ClusterInfoHandler returns an HTTP handler serving the brokers and the canary topic layout
+// seen by the last reconcile
+func (c *Canary) ClusterInfoHandler() http.Handler {
+	return c.state.ClusterInfoHandler()
+}
+
+// PrincipalHandler returns an HTTP handler serving the principal of the canary, its ACLs and the
+// operations it's authorized for
+func (c *Canary) PrincipalHandler() http.Handler {
+	return services.PrincipalHandler(c.settings, c.permissions)
+}
+
+// Reset starts the canary over from clean baselines without restarting it, e.g. after recreating
+// a test cluster: the counters and histograms start from zero, and the event log, the consuming
+// percentage and the latency SLO records are emptied. With bootstrap, the next reconcile also
+// bootstraps the topic again as on the first one.
+func (c *Canary) Reset(bootstrap bool) error {
+	if err := c.state.Metrics().Reset(); err != nil {
+		return err
+	}
+	c.state.ClearEvents()
+	resettable := []interface{}{c.manager, c.status}
+	if bootstrap {
+		resettable = append(resettable, c.topic)
+	}
+	for _, service := range resettable {
+		if r, ok := service.(services.Resettable); ok {
+			r.Reset()
+		}
+	}
+	c.logger.Info().Bool("bootstrap", bootstrap).Msg("Canary reset")
+	return nil
+}
+
+// ResetHandler returns an HTTP handler resetting the canary on POST, bootstrapping the topic again
+// with the bootstrap=true query parameter
+func (c *Canary) ResetHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if err := c.Reset(r.URL.Query().Get("bootstrap") == "true"); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// RollsHandler returns an HTTP handler serving the maintenance roll reports, starting a roll on
+// POST and ending it on DELETE
+func (c *Canary) RollsHandler() http.Handler {
+	return c.state.RollsHandler()
+}
+
+// EventsHandler returns an HTTP handler serving the recent significant events, e.g. the services
+// degraded, the checks health changes and the reconcile actions
+func (c *Canary) EventsHandler() http.Handler {
+	return c.state.EventsHandler()
+}
+
+// Gather returns the canary metrics named with the default namespace, e.g. to export them
+// elsewhere than on the registerer
+func (c *Canary) Gather() ([]*dto.MetricFamily, error) {
+	return c.state.Metrics().Gather()
+}
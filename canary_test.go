@@ -0,0 +1,121 @@
+package canary
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	canaryconfig "github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
+)
+
+func TestCanariesSideBySide(t *testing.T) {
+	logger := zerolog.Nop()
+	registry := prometheus.NewRegistry()
+	configFor := func(topic, namespace string) Config {
+		return Config{
+			Brokers: []string{"127.0.0.1:1"},
+			Canary:  Settings{Topic: topic},
+			Metrics: MetricsConfig{Namespace: namespace, Registerer: registry},
+		}
+	}
+	first, err := New(configFor("first", "first"), &logger)
+	require.NoError(t, err)
+	second, err := New(configFor("second", "second"), &logger)
+	require.NoError(t, err)
+	defer second.Stop()
+
+	// the same exposition as a live canary is refused
+	_, err = New(configFor("other", "first"), &logger)
+	assert.ErrorContains(t, err, "another canary exposes its metrics")
+
+	first.state.StartWarmUp(time.Minute)
+	assert.NoError(t, first.Ready())
+	expected := `
+# HELP first_warming_up Whether the canary is in its warm-up period, its failures don't fail the readiness nor the checks health
+# TYPE first_warming_up gauge
+first_warming_up 1
+# HELP second_warming_up Whether the canary is in its warm-up period, its failures don't fail the readiness nor the checks health
+# TYPE second_warming_up gauge
+second_warming_up 0
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "first_warming_up", "second_warming_up"))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	hashes := map[string]float64{}
+	for _, family := range families {
+		if strings.HasSuffix(family.GetName(), "_config_hash") {
+			hashes[family.GetName()] = family.Metric[0].GetGauge().GetValue()
+		}
+	}
+	require.Len(t, hashes, 2)
+	assert.NotEqual(t, hashes["first_config_hash"], hashes["second_config_hash"])
+
+	// once stopped, the exposition is free for a new canary
+	first.Stop()
+	assert.Equal(t, 0, testutil.CollectAndCount(registry, "first_warming_up"))
+	third, err := New(configFor("third", "first"), &logger)
+	require.NoError(t, err)
+	third.Stop()
+}
+
+func TestNewClosesOnError(t *testing.T) {
+	logger := zerolog.Nop()
+	registry := prometheus.NewRegistry()
+	config := Config{
+		Brokers: []string{"127.0.0.1:1"},
+		Canary: Settings{
+			Topic:      "canary",
+			AuditTopic: "audit",
+			// the consumer fails after the audit log, the topic and the producer services
+			Commit: canaryconfig.CommitConfig{Strategy: services.CommitInterval},
+		},
+		Metrics: MetricsConfig{Namespace: "failed", Registerer: registry},
+	}
+	_, err := New(config, &logger)
+	assert.ErrorContains(t, err, "positive commit interval")
+
+	// the exposition was released with the rest
+	config.Canary.Commit = canaryconfig.CommitConfig{}
+	c, err := New(config, &logger)
+	require.NoError(t, err)
+	c.Stop()
+}
+
+func TestServiceConnector(t *testing.T) {
+	connectorConfig := client.ConnectorConfig{BrokerAddrs: []string{"broker:9092"}}
+	settings := Settings{ClientID: "kafka-canary", ClientIDs: map[string]string{"producer": "kafka-canary-producer"}}
+
+	producer := serviceConnector(connectorConfig, settings, "producer")
+	assert.Equal(t, "kafka-canary-producer", producer.ClientID)
+	assert.Equal(t, "producer", producer.Service)
+	assert.Equal(t, []string{"broker:9092"}, producer.BrokerAddrs)
+
+	topic := serviceConnector(connectorConfig, settings, "topic")
+	assert.Equal(t, "kafka-canary", topic.ClientID, "the canary client ID without override")
+	assert.Equal(t, "topic", topic.Service)
+	assert.Empty(t, connectorConfig.ClientID, "the shared config is left as is")
+}
+
+func TestReadyStalledPartitions(t *testing.T) {
+	stalled := []int{}
+	c := &Canary{
+		state:             services.NewState(Settings{}),
+		stallThreshold:    2 * time.Minute,
+		stalledPartitions: func(time.Duration) []int { return stalled },
+	}
+	assert.NoError(t, c.Ready())
+
+	stalled = []int{1, 3}
+	assert.EqualError(t, c.Ready(), "partitions [1 3] stalled for more than 2m0s")
+
+	c.stallThreshold = 0
+	assert.NoError(t, c.Ready(), "stall readiness disabled")
+}
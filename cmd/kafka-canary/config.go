@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// redacted replaces the secrets in the effective configuration
+const redacted = "REDACTED"
+
+// effectiveConfig returns the resolved configuration keyed like the flags and the configuration
+// file, with the secrets redacted, so deployments can be verified without guessing which of the
+// defaults, file, environment and flags won
+func effectiveConfig(config Config) map[string]interface{} {
+	settings := map[string]interface{}{}
+	settingsOf(reflect.ValueOf(config), settings)
+	return settings
+}
+
+// configHandler serves the effective configuration as JSON
+func configHandler(config func() (Config, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		current, ok := config()
+		if !ok {
+			http.Error(w, "no canary running", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(effectiveConfig(current))
+	})
+}
+
+// settingsOf adds the fields of the struct to the settings under their mapstructure keys
+func settingsOf(v reflect.Value, settings map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key, options, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if options == "squash" {
+			settingsOf(v.Field(i), settings)
+			continue
+		}
+		if key == "" || key == "-" {
+			continue
+		}
+		settings[key] = redactSetting(key, settingValue(v.Field(i)))
+	}
+}
+
+// settingValue returns the value with the structs turned into settings and the durations into
+// their string form
+func settingValue(v reflect.Value) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		settings := map[string]interface{}{}
+		settingsOf(v, settings)
+		return settings
+	case reflect.Slice:
+		if v.IsNil() {
+			return []interface{}{}
+		}
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = settingValue(v.Index(i))
+		}
+		return values
+	case reflect.Map:
+		values := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			// the entries are redacted by their own key, e.g. an Authorization header
+			values[key] = redactSetting(key, settingValue(iter.Value()))
+		}
+		return values
+	}
+	return v.Interface()
+}
+
+// redactSetting redacts the value of the secret settings, empty values are kept so unset secrets
+// can be told apart, and the credentials of the URLs. The strings of a list are redacted by the
+// key of the list.
+func redactSetting(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = redactSetting(key, v[i])
+		}
+		return v
+	case string:
+		if v == "" {
+			return v
+		}
+		switch {
+		case secretSetting(key):
+			return redacted
+		case strings.HasSuffix(strings.ToLower(key), "url"):
+			if u, err := url.Parse(v); err == nil {
+				return u.Redacted()
+			}
+			return redacted
+		}
+	}
+	return value
+}
+
+// secretSetting returns true for the keys of secrets: ending with key (e.g. api-key, but not
+// key-file) or with a word such as password or token in them, whatever their case and separators
+func secretSetting(key string) bool {
+	words := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+	if len(words) == 0 {
+		return false
+	}
+	if last := words[len(words)-1]; last == "key" || last == "apikey" {
+		return true
+	}
+	for _, word := range words {
+		switch word {
+		case "password", "passwd", "token", "secret", "credentials", "authorization":
+			return true
+		}
+	}
+	return false
+}
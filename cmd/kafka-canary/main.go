@@ -1,35 +1,86 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
+	kafkacanary "github.com/pecigonzalo/kafka-canary"
 	"github.com/pecigonzalo/kafka-canary/internal/api"
-	"github.com/pecigonzalo/kafka-canary/internal/canary"
-	"github.com/pecigonzalo/kafka-canary/internal/client"
-	"github.com/pecigonzalo/kafka-canary/internal/services"
+	"github.com/pecigonzalo/kafka-canary/internal/emf"
+	"github.com/pecigonzalo/kafka-canary/internal/kubernetes"
+	"github.com/pecigonzalo/kafka-canary/internal/logging"
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/internal/service"
 	"github.com/pecigonzalo/kafka-canary/internal/signals"
-	"github.com/pecigonzalo/kafka-canary/internal/workers"
+	"github.com/pecigonzalo/kafka-canary/internal/systemd"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
 )
 
 var (
 	version = "development"
+	// commit the binary was built from, read from the Go build info when not set at link time
+	commit = ""
 )
 
 type Config struct {
-	Host    string        `mapstructure:"host"`
-	Port    int           `mapstructure:"port"`
-	Level   string        `mapstructure:"level"`
-	Brokers []string      `mapstructure:"brokers"`
-	Canary  canary.Config `mapstructure:"canary"`
-	Output  string        `mapstructure:"output"`
+	Host                 string         `mapstructure:"host"`
+	Port                 int            `mapstructure:"port"`
+	MetricsPort          int            `mapstructure:"metrics-port"`
+	MetricsInstanceLabel bool           `mapstructure:"metrics-instance-label"`
+	MetricsNamespace     string         `mapstructure:"metrics-namespace"`
+	MetricsSubsystem     string         `mapstructure:"metrics-subsystem"`
+	KubernetesMetadata   bool           `mapstructure:"kubernetes-metadata"`
+	Operator             OperatorConfig `mapstructure:"operator"`
+	EMF                  emf.Config     `mapstructure:"emf"`
+	HTTP                 HTTPConfig     `mapstructure:"http"`
+	Level                string         `mapstructure:"level"`
+	Log                  LogConfig      `mapstructure:"log"`
+	Brokers              []string       `mapstructure:"brokers"`
+	ProxyURL             string         `mapstructure:"proxy-url"`
+	DNS                  DNSConfig      `mapstructure:"dns"`
+	IPFamily             string         `mapstructure:"ip-family"`
+	SourceAddrs          []string       `mapstructure:"source-addrs"`
+	Canary               canary.Config  `mapstructure:"canary"`
+	Output               string         `mapstructure:"output"`
+	Service              string         `mapstructure:"service"`
+	Force                bool           `mapstructure:"force"`
+}
+
+type HTTPConfig struct {
+	api.SecurityConfig `mapstructure:",squash"`
+	api.LimitsConfig   `mapstructure:",squash"`
+	EnableAdmin        bool   `mapstructure:"enable-admin"`
+	EnablePprof        bool   `mapstructure:"enable-pprof"`
+	DumpDir            string `mapstructure:"dump-dir"`
+	UnixSocket         string `mapstructure:"unix-socket"`
+}
+
+type DNSConfig struct {
+	Servers   []string `mapstructure:"servers"`
+	Overrides []string `mapstructure:"overrides"`
+}
+
+type OperatorConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Resource string        `mapstructure:"resource"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+type LogConfig struct {
+	SampleRepeated uint32        `mapstructure:"sample-repeated"`
+	SamplePeriod   time.Duration `mapstructure:"sample-period"`
 }
 
 func main() {
@@ -46,52 +97,224 @@ func main() {
 
 	logger := setupLogger(config)
 
+	if config.Service != "" {
+		if err := service.Control(config.Service, serviceArgs(os.Args[1:])); err != nil {
+			exitError(err, 1, "Service "+config.Service+" failed")
+		}
+		return
+	}
+
+	isService, err := service.IsService()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Error detecting the service manager")
+	}
+	if isService {
+		err := service.Run(func(stopCh <-chan struct{}) {
+			run(config, logger, stopCh)
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Error running as a service")
+		}
+		return
+	}
+
+	run(config, logger, signals.SetupSignalHandler())
+}
+
+// runner is the canary run by the binary, either directly or by the operator
+type runner interface {
+	Start() error
+	Stop()
+	Ready() error
+	StatusHandler() http.Handler
+	ClusterInfoHandler() http.Handler
+	EventsHandler() http.Handler
+	RollsHandler() http.Handler
+	ResetHandler() http.Handler
+	PrincipalHandler() http.Handler
+	ConfigHash() string
+	// the canary metrics, named with the default namespace
+	prometheus.Gatherer
+}
+
+// run starts the canary and its HTTP servers, shutting them down once stopCh is closed
+func run(config Config, logger zerolog.Logger, stopCh <-chan struct{}) {
 	// Start HTTP server
+	var metadata kubernetes.Metadata
+	if config.KubernetesMetadata && kubernetes.InCluster() {
+		metadata = discoverKubernetesMetadata(logger)
+		withKubernetesMetadata(&config, metadata)
+	}
+	if err := resolveTopic(&config); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid canary topic")
+	}
 	logger.Info().
-		Str("config", fmt.Sprintf("%+v", config)).
-		Str("config", fmt.Sprintf("%+v", config)).
+		Interface("config", effectiveConfig(config)).
 		Msg("Starting Kafka Canary")
-	srvCfg := api.Config{
-		Host:    config.Host,
-		Port:    strconv.Itoa(config.Port),
-		Service: "kafka-canary",
+	if err := prometheus.Register(metrics.Rename(binaryMetrics, config.MetricsNamespace, config.MetricsSubsystem)); err != nil {
+		logger.Fatal().Err(err).Msg("Error registering the binary metrics")
+	}
+	metricsLabels := metadata.Labels()
+	if config.MetricsInstanceLabel {
+		metricsLabels["canary_instance"] = config.Canary.InstanceID
 	}
-	srv, _ := api.NewServer(&srvCfg, &logger)
-	httpServer, healthy, ready := srv.ListenAndServe()
 
-	connectorConfig := client.ConnectorConfig{
-		BrokerAddrs: config.Brokers,
-		TLS:         client.TLSConfig{Enabled: true},
-		SASL:        client.SASLConfig{Enabled: true, Mechanism: client.SASLMechanismAWSMSKIAM},
+	var c runner
+	var err error
+	// the operator serves the configuration of the canary it runs
+	effective := func() (Config, bool) { return config, true }
+	if config.Operator.Enabled {
+		var o *operator
+		if o, err = newOperator(config, metadata, logger); err == nil {
+			c, effective = o, o.Config
+		}
+	} else {
+		c, err = newCanary(config, logger)
+	}
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Error creating canary")
 	}
 
-	topicService := services.NewTopicService(config.Canary, connectorConfig, &logger)
-	producerService := services.NewProducerService(config.Canary, connectorConfig, &logger)
-	consumerService := services.NewConsumerService(config.Canary, connectorConfig, &logger)
-	connectionService := services.NewConnectionService(config.Canary, connectorConfig)
-	statusService := services.NewStatusServiceService(config.Canary, &logger)
+	srvCfg := api.Config{
+		Host:          config.Host,
+		Port:          strconv.Itoa(config.Port),
+		MetricsPort:   strconv.Itoa(config.MetricsPort),
+		Service:       "kafka-canary",
+		EnableAdmin:   config.HTTP.EnableAdmin,
+		EnablePprof:   config.HTTP.EnablePprof,
+		DumpDir:       config.HTTP.DumpDir,
+		UnixSocket:    config.HTTP.UnixSocket,
+		Security:      config.HTTP.SecurityConfig,
+		Limits:        config.HTTP.LimitsConfig,
+		MetricsLabels: metricsLabels,
+	}
+	srv, err := api.NewServer(&srvCfg, &logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Error creating HTTP server")
+	}
+	srv.Handle("/status", c.StatusHandler())
+	srv.Handle("/clusterinfo", c.ClusterInfoHandler())
+	srv.Handle("/events", c.EventsHandler())
+	srv.Handle("/rolls", c.RollsHandler())
+	srv.Handle("/principal", c.PrincipalHandler())
+	srv.Handle("/version", versionHandler(c))
+	srv.Handle("/config", configHandler(effective))
+	srv.Handle("/sd", sdHandler(effective, metricsLabels))
+	if config.HTTP.EnableAdmin {
+		srv.Handle("/admin/rolls", c.RollsHandler(), "GET", "POST", "DELETE")
+		// resetting wipes the baselines, it's never served without credentials
+		if config.HTTP.BearerToken != "" || config.HTTP.BasicAuthUsername != "" {
+			srv.Handle("/admin/reset", c.ResetHandler(), "POST")
+		} else {
+			logger.Warn().Msg("Not serving /admin/reset without --http.bearer-token or --http.basic-auth-username")
+		}
+	}
+	srv.AddReadinessCheck(c.Ready)
+	httpServer, healthy, ready := srv.ListenAndServe()
 
 	// start canary manager
-	canaryManager := workers.NewCanaryManager(config.Canary, topicService, producerService, consumerService, connectionService, statusService, &logger)
-	canaryManager.Start()
+	if err := c.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Error starting canary manager")
+	}
+	if config.EMF.Enabled {
+		exporter, err := emf.NewExporter(config.EMF, c, map[string]string{
+			"Instance": config.Canary.InstanceID,
+			"Topic":    config.Canary.Topic,
+		}, &logger)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Error creating EMF exporter")
+		}
+		go exporter.Run(stopCh)
+	}
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		logger.Warn().Err(err).Msg("Error notifying systemd")
+	}
+	go func() {
+		<-stopCh
+		_, _ = systemd.Notify(systemd.Stopping)
+	}()
 
 	// graceful shutdown
-	stopCh := signals.SetupSignalHandler()
 	serverShutdownTimeout := 5 * time.Second
 	sd, _ := signals.NewShutdown(serverShutdownTimeout, &logger)
-	sd.Graceful(stopCh, httpServer, canaryManager, healthy, ready)
+	sd.Graceful(stopCh, httpServer, c, healthy, ready)
 }
 
 func setupFlags() *pflag.FlagSet {
+	// the hostname is the pod name on Kubernetes
+	hostname, _ := os.Hostname()
+
 	fs := pflag.NewFlagSet("default", pflag.ContinueOnError)
 	fs.String("host", "", "Host to bind service to")
 	fs.Int("port", 9898, "HTTP port to bind service to")
+	fs.Int("metrics-port", 8081, "HTTP port to serve metrics on, 0 to serve them on the service port")
+	fs.Bool("metrics-instance-label", false, "Add the canary instance ID as a canary_instance label to every metric")
+	fs.String("metrics-namespace", "kafka_canary", "Namespace of the metric names")
+	fs.String("metrics-subsystem", "", "Subsystem of the metric names, between the namespace and the name")
+	fs.Bool("kubernetes-metadata", false, "On Kubernetes, add the pod, node and zone to every metric and produced record")
+	fs.Bool("operator.enabled", false, "Configure the canary from a KafkaCanary resource in the pod namespace, rebuilding it on changes")
+	fs.String("operator.resource", "kafka-canary", "Name of the KafkaCanary resource configuring the canary in operator mode")
+	fs.Duration("operator.interval", 30*time.Second, "Interval between KafkaCanary resource lookups in operator mode")
+	fs.Bool("emf.enabled", false, "Write the core metrics as CloudWatch Embedded Metric Format lines")
+	fs.String("emf.target", "stdout", "Where the EMF lines are written: stdout, or the tcp://host:port or udp://host:port of the CloudWatch agent")
+	fs.String("emf.namespace", "KafkaCanary", "CloudWatch namespace of the EMF metrics")
+	fs.Duration("emf.interval", time.Minute, "Interval between EMF lines")
+	fs.String("http.tls-cert-file", "", "TLS certificate file for the HTTP servers")
+	fs.String("http.tls-key-file", "", "TLS key file for the HTTP servers")
+	fs.String("http.basic-auth-username", "", "Basic auth username required by the HTTP servers")
+	fs.String("http.basic-auth-password", "", "Basic auth password required by the HTTP servers")
+	fs.String("http.bearer-token", "", "Bearer token required by the HTTP servers")
+	fs.StringSlice("http.allowed-cidrs", []string{}, "IPs or CIDRs allowed to reach the HTTP servers, all when empty")
+	fs.Duration("http.read-timeout", 30*time.Second, "HTTP servers read timeout")
+	fs.Duration("http.write-timeout", 30*time.Second, "HTTP servers write timeout")
+	fs.Duration("http.idle-timeout", 60*time.Second, "HTTP servers idle timeout")
+	fs.Int("http.max-header-bytes", 1<<16, "HTTP servers max request header size in bytes")
+	fs.Float64("http.rate-limit", 10, "HTTP servers max requests per second, 0 to disable")
+	fs.Int("http.rate-burst", 20, "HTTP servers max burst of requests over the rate limit")
+	fs.Bool("http.enable-admin", false, "Enable the /admin endpoints, e.g. to change the log level at runtime")
+	fs.Bool("http.enable-pprof", false, "Enable /debug/pprof and the /admin/dump goroutine and heap dumps trigger")
+	fs.String("http.dump-dir", "", "Directory where /admin/dump writes the dumps, the temporary directory by default")
+	fs.String("http.unix-socket", "", "Path of a Unix socket also serving the status server and the metrics, e.g. for a sidecar agent")
+	fs.String("service", "", "Windows service action [install, uninstall, start, stop], install registers the other flags")
 	fs.StringSlice("brokers", []string{}, "Kafka broker address")
+	fs.StringSlice("dns.servers", []string{}, "DNS servers used to resolve the brokers instead of the system ones")
+	fs.StringSlice("dns.overrides", []string{}, "Broker address overrides as advertised-host=address[:port]")
+	fs.String("ip-family", "auto", "Address family used to dial the brokers [auto, ipv4, ipv6]")
+	fs.StringSlice("source-addrs", []string{}, "IPs or interface names the broker connections are bound to, tried in turn")
+	fs.String("proxy-url", "", "SOCKS5 (socks5://) or HTTP CONNECT (http://) proxy used to reach the brokers")
 	fs.String("output", "json", "Output target [console, json]")
 	fs.String("level", "info", "Log level [debug, info, warn, error, fatal, panic]")
-	fs.String("canary.topic", "__kafka_canary", "Name of the topic used by the canary")
-	fs.String("canary.client-id", "kafka-canary", "Id of the producer used by the canary")
+	fs.Uint32("log.sample-repeated", 0, "Only log one every N identical warnings and errors, 0 to log all")
+	fs.Duration("log.sample-period", time.Minute, "Period after which repeated logs are sampled from scratch")
+	fs.String("canary.topic", "__kafka_canary", "Name of the topic used by the canary, {name} placeholders are replaced by the topic variables, {instance}, {zone} or the environment variables")
+	fs.StringToString("canary.topic-variables", map[string]string{}, "Values of the canary topic name placeholders, e.g. cluster=payments")
+	fs.StringToString("canary.topic-config.desired", map[string]string{}, "Canary topic config entries added to or overriding the defaults as key=value, e.g. retention.ms=600000")
+	fs.Bool("canary.topic-config.remediate", false, "Re-apply the desired canary topic config when it drifted")
+	fs.String("canary.client-id", "kafka-canary", "Client ID reported to the brokers by the canary")
+	fs.StringToString("canary.client-ids", map[string]string{}, "Client IDs overriding canary.client-id by service as service=id, e.g. producer=canary-producer")
+	fs.String("canary.instance-id", hostname, "ID of this canary instance, added as a header to the produced records")
+	fs.StringToString("canary.headers", map[string]string{}, "Static headers added to the produced records as key=value")
+	fs.String("canary.sequence-state-file", "", "File persisting the partition sequences, so loss detection survives restarts")
+	fs.String("canary.encryption.key", "", "Base64 encoded AES key encrypting the canary records with AES-GCM, disabled when empty")
+	fs.String("canary.encryption.key-id", "", "ID of the encryption key sent in the records header")
+	fs.Int("canary.record-version", 0, "Encoding version of the produced records, 0 for the plain JSON read by every canary version")
+	fs.Bool("canary.ignore-other-instances", false, "Ignore records produced by other canary instances sharing the topic")
+	fs.Bool("canary.coordination.enabled", false, "Produce only to the partitions owned by this instance and measure the latency of the others' records")
+	fs.String("canary.coordination.zone", "", "Zone of this instance in coordinated mode, e.g. its availability zone")
+	fs.StringSlice("canary.coordination.instances", []string{}, "IDs of all the coordinated instances, in the same order on every instance")
 	fs.String("canary.consumer-group-id", "kafka-canary-group", "Id of the consumer group used by the canary")
+	fs.String("canary.commit.strategy", "per-record", "How the canary consumer commits its offsets: auto, interval, per-record or none")
+	fs.StringSlice("canary.assignment-strategies", []string{"range", "roundrobin"}, "Priority-ordered partition assignment strategies of the canary consumer: range or roundrobin")
+	fs.Duration("canary.rebalance-delay-threshold", time.Second, "End-to-end latency over which a record consumed during a consumer group rebalance is counted as delayed")
+	fs.Duration("canary.commit.interval", 5*time.Second, "Period of the commits of the interval commit strategy")
+	fs.Bool("canary.anomaly.enabled", false, "Detect the produce and end-to-end latencies deviating from their learned baseline")
+	fs.Float64("canary.anomaly.factor", 2, "Recent latency over the baseline one flagged as an anomaly")
+	fs.Int("canary.anomaly.baseline-samples", 1000, "Latency samples the baseline is averaged over")
+	fs.Int("canary.anomaly.recent-samples", 10, "Latency samples the recent latency is averaged over")
+	fs.String("canary.dead-letter.file", "", "File the consumed records that can't be verified are appended to with diagnostics, as JSON lines")
+	fs.String("canary.dead-letter.topic", "", "Topic the consumed records that can't be verified are written to with diagnostics")
+	fs.Int("canary.dead-letter.max-records", 10, "Unverifiable records captured at most per dead-letter interval")
+	fs.Duration("canary.dead-letter.interval", time.Minute, "Interval of the dead-letter capture limit")
 	fs.StringSlice(
 		"canary.producer-latency-buckets",
 		[]string{"100", "500", "1000", "1500", "2000", "4000", "8000"},
@@ -104,8 +327,103 @@ func setupFlags() *pflag.FlagSet {
 	)
 	fs.Duration("canary.reconcile-interval", 5*time.Second, "Reconcile interval")
 	fs.Duration("canary.status-check-interval", 30*time.Second, "Status check interval")
+	fs.Duration("canary.status-time-window", 15*time.Minute, "Time window covered by the status")
+	fs.String("canary.status-state-file", "", "File persisting the status samples across restarts")
 	fs.Int("canary.bootstrap-backoff-max-attempts", 10, "Bootstrap backoff max attempts")
 	fs.Duration("canary.bootstrap-backoff-scale", 5*time.Second, "Bootstrap backoff scale")
+	fs.Bool("canary.chaos.enabled", false, "Inject faults in the canary pipeline to test alerts, never enable in production")
+	fs.Duration("canary.chaos.produce-delay", 0, "Chaos mode delay added before producing each record")
+	fs.Duration("canary.chaos.consume-delay", 0, "Chaos mode delay added to each consumed record")
+	fs.Float64("canary.chaos.drop-ack-rate", 0, "Chaos mode probability of reporting a produced record as failed")
+	fs.Float64("canary.chaos.drop-record-rate", 0, "Chaos mode probability of discarding a consumed record as lost")
+	fs.Float64("canary.chaos.sequence-gap-rate", 0, "Chaos mode probability of skipping a message ID")
+	fs.Duration("canary.latency-slo.threshold", 0, "Produce latency SLO, breaches are logged with the partition leader and ISR, 0 to disable")
+	fs.Bool("canary.message-size.enabled", false, "Periodically produce records just under and over the topic max.message.bytes")
+	fs.Duration("canary.message-size.interval", 10*time.Minute, "Interval of the message size check")
+	fs.Int("canary.message-size.max-message-bytes", 1048588, "max.message.bytes assumed when the topic doesn't override it")
+	fs.Bool("canary.group-coordinator", false, "Check the canary consumer group coordinator discovery on every reconcile")
+	fs.Bool("canary.internal-topics.enabled", false, "Periodically report the health of the internal topics")
+	fs.Duration("canary.internal-topics.interval", time.Minute, "Interval of the internal topics check")
+	fs.StringSlice(
+		"canary.internal-topics.topics",
+		[]string{"__consumer_offsets", "__transaction_state"},
+		"Internal topics checked",
+	)
+	fs.Bool("canary.transaction-coordinator.enabled", false, "Check the transaction coordinator with InitProducerId on every reconcile")
+	fs.String("canary.transaction-coordinator.transactional-id", "kafka-canary", "Transactional ID used by the transaction coordinator check")
+	fs.Bool("canary.offset-timestamp.enabled", false, "Periodically verify the offsets ListOffsets returns for a timestamp")
+	fs.Duration("canary.offset-timestamp.interval", 5*time.Minute, "Interval of the offset for timestamp check")
+	fs.Duration("canary.offset-timestamp.lookback", time.Minute, "Age of the timestamp looked up by the offset for timestamp check")
+	fs.StringSlice("canary.consumer-groups.groups", []string{}, "External consumer groups to describe, exporting their members, state and lag")
+	fs.Duration("canary.consumer-groups.interval", time.Minute, "Interval of the consumer groups check")
+	fs.StringSlice("canary.denied-operations.produce-topics", []string{}, "Topics the canary must be denied producing to, alerting if allowed")
+	fs.StringSlice("canary.denied-operations.describe-groups", []string{}, "Consumer groups the canary must be denied describing, alerting if allowed")
+	fs.Duration("canary.denied-operations.interval", 5*time.Minute, "Interval of the denied operations check")
+	fs.StringSlice("canary.comparison.brokers", []string{}, "Brokers of a second cluster compared with the canary one, e.g. the target of a migration")
+	fs.String("canary.comparison.topic", "", "Topic of the comparison round trips, it must exist on both clusters, the canary topic when empty")
+	fs.Duration("canary.comparison.interval", 10*time.Second, "Interval of the clusters comparison round trips")
+	fs.Duration("canary.comparison.window", time.Hour, "Window the clusters availability and latency are compared over")
+	fs.Duration("canary.comparison.latency-budget", 0, "Latency the second cluster may add over the canary one while within budget")
+	fs.String("canary.cruise-control.url", "", "URL of Cruise Control, polled for ongoing rebalances and anomalies")
+	fs.String("canary.cruise-control.username", "", "Basic auth username of Cruise Control")
+	fs.String("canary.cruise-control.password", "", "Basic auth password of Cruise Control")
+	fs.Duration("canary.cruise-control.interval", 30*time.Second, "Interval of the Cruise Control check")
+	fs.String("canary.topic-policy.name-pattern", "", "Regexp the cluster topic names must match, reported as policy violations otherwise")
+	fs.Int("canary.topic-policy.min-replication-factor", 0, "Lowest replication factor of the cluster topics, reported as policy violations otherwise")
+	fs.Bool("canary.replay.enabled", false, "Periodically rewind a secondary consumer group and verify the recent canary records are still readable and intact")
+	fs.Duration("canary.replay.interval", 15*time.Minute, "Interval of the replay check")
+	fs.Duration("canary.replay.lookback", 10*time.Minute, "How far back the replay check rewinds the secondary consumer group")
+	fs.String("canary.replay.group-id", "", "Secondary consumer group rewound by the replay check, the canary one suffixed with -replay when empty")
+	fs.Bool("canary.direct-leader.enabled", false, "Produce to and fetch from every canary partition leader dialed directly, bypassing the bootstrap brokers and the client metadata")
+	fs.Duration("canary.direct-leader.interval", 30*time.Second, "Interval of the direct leader check")
+	fs.Bool("canary.ownership.enabled", false, "Claim the canary topic for this instance, refusing to run on a topic claimed by another live instance")
+	fs.String("canary.ownership.group-id", "kafka-canary-ownership", "Consumer group the canary topic ownership claims are committed to")
+	fs.Duration("canary.ownership.lease", time.Minute, "How long the canary topic ownership claim is held without being renewed")
+	fs.Bool("force", false, "Take over the canary topic ownership claim of another live instance")
+	fs.Bool("canary.static-membership.enabled", false, "Keep a static member, with a group.instance.id, in a dedicated consumer group to exercise the static membership protocol")
+	fs.String("canary.static-membership.group-id", "", "Consumer group of the static member, the canary one suffixed with -static when empty")
+	fs.String("canary.static-membership.instance-id", "", "group.instance.id of the static member, unique per canary, the canary instance ID when empty")
+	fs.Duration("canary.static-membership.interval", 10*time.Second, "Interval of the static member heartbeats")
+	fs.Duration("canary.static-membership.session-timeout", 5*time.Minute, "Session timeout of the static member, how long a restarting canary keeps its membership")
+	fs.Bool("canary.failover.enabled", false, "Periodically fail over the leader of a canary partition and measure how long producing and consuming take to recover")
+	fs.Bool("canary.failover.non-production", false, "Confirm the cluster isn't a production one, required by the failover probe")
+	fs.Duration("canary.failover.interval", time.Hour, "Interval of the failover probe")
+	fs.Duration("canary.failover.recovery-timeout", 20*time.Second, "How long the failover election, each of the produce and consume recoveries and the restore may take, bounded by their share of the check timeout")
+	fs.Bool("canary.bandwidth.enabled", false, "Periodically write a burst of records to a dedicated topic and read it back to measure the throughput")
+	fs.String("canary.bandwidth.topic", "kafka-canary-bandwidth", "Topic the bandwidth probe writes to, it must exist")
+	fs.Int("canary.bandwidth.volume", 64, "MiB written and read back by every bandwidth probe burst")
+	fs.Int("canary.bandwidth.record-size", 64<<10, "Size of the bandwidth probe records in bytes")
+	fs.Duration("canary.bandwidth.interval", time.Hour, "Interval of the bandwidth probe bursts")
+	fs.Float64("canary.bandwidth.min-throughput", 0, "Throughput in MiB/s below which the bandwidth probe fails, 0 to never fail")
+	fs.String("canary.clock.ntp-server", "", "NTP server the local clock skew is checked against, e.g. pool.ntp.org")
+	fs.Duration("canary.clock.interval", time.Minute, "Interval of the NTP clock check")
+	fs.Duration("canary.clock.skew-threshold", 100*time.Millisecond, "Local clock skew beyond which the end-to-end latency is flagged as unreliable, 0 to disable")
+	fs.String("canary.rest-proxy.url", "", "URL of a Confluent REST Proxy to produce through, consuming with the native client")
+	fs.String("canary.rest-proxy.topic", "kafka-canary-rest", "Topic the REST Proxy check produces to, it must exist")
+	fs.String("canary.rest-proxy.username", "", "Basic auth username of the REST Proxy")
+	fs.String("canary.rest-proxy.password", "", "Basic auth password of the REST Proxy")
+	fs.Duration("canary.rest-proxy.interval", time.Minute, "Interval of the REST Proxy check")
+	fs.Bool("canary.metadata-consistency.enabled", false, "Periodically compare the canary topic metadata returned by each broker")
+	fs.Duration("canary.metadata-consistency.interval", time.Minute, "Interval of the metadata consistency check")
+	fs.Duration("canary.stall-threshold", 2*time.Minute, "Fail /readyz when a partition has no records consumed for longer, 0 to disable")
+	fs.Bool("canary.backpressure.enabled", false, "Pause producing while the canary consumer lags, instead of inflating the loss statistics")
+	fs.Int64("canary.backpressure.max-lag-intervals", 10, "Produce intervals the consumer may fall behind before producing pauses")
+	fs.Int("canary.event-log-size", 100, "Number of recent significant events kept and served at /events, 0 to disable")
+	fs.Duration("canary.warm-up", 0, "Period after startup during which failures are recorded but fail neither /readyz nor the checks health")
+	fs.Duration("canary.check-timeout", 30*time.Second, "Timeout for additional checks, e.g. plugins, and for each stage of the reconcile loop")
+	fs.StringToString("canary.check-timeouts", map[string]string{}, "Timeouts overriding the check timeout by check name, e.g. metadata_consistency=1m")
+	fs.Float64("canary.admin-rate-limit", 20, "Admin API calls per second (metadata, describes, alters) before they are delayed, 0 to disable")
+	fs.Int("canary.admin-rate-burst", 40, "Admin API calls allowed at once over canary.admin-rate-limit")
+	fs.String("canary.audit-topic", "", "Topic the mutating admin operations are also audited to, they are always logged")
+	fs.StringSlice("canary.disabled-checks", []string{}, "Checks disabled whatever their own settings, reported as DISABLED in /status, e.g. topic_management,consumer_groups")
+	fs.Bool("canary.permissions-check", true, "Verify on startup the canary principal has the ACLs it needs, reporting the missing ones")
+	fs.Int("canary.health.failure-threshold", 3, "Consecutive failures moving a check from DEGRADED to FAILED")
+	fs.Int("canary.health.recovery-threshold", 2, "Consecutive successes moving a FAILED check back to OK")
+	fs.Bool("canary.adaptive-interval.enabled", false, "Retry failing checks on a faster interval until they recover")
+	fs.Duration("canary.adaptive-interval.retry-interval", 5*time.Second, "Interval of a check after its first failure")
+	fs.Float64("canary.adaptive-interval.backoff", 2, "Factor applied to the retry interval on every further failure")
+	fs.Duration("canary.adaptive-interval.max-interval", 0, "Cap of the retry interval, the check interval when 0")
+	fs.Int("canary.adaptive-interval.successes", 3, "Consecutive successes moving a check back to its interval")
 
 	err := viper.BindPFlags(fs)
 	if err != nil {
@@ -119,6 +437,10 @@ func loadConfigFile() {
 	viper.SetConfigName("kafka-canary")
 	viper.AddConfigPath("/etc/kafka-canary/")
 	viper.AddConfigPath(".")
+	// services don't run from the executable directory, e.g. System32 on Windows
+	if exe, err := os.Executable(); err == nil {
+		viper.AddConfigPath(filepath.Dir(exe))
+	}
 	err := viper.ReadInConfig()
 	if err != nil {
 		exitError(err, 2, "Load config failed")
@@ -170,6 +492,9 @@ func setupLogger(config Config) zerolog.Logger {
 	} else {
 		logger = zerolog.New(os.Stderr)
 	}
+	if config.Log.SampleRepeated > 1 {
+		logger = logger.Hook(logging.NewRepeatSamplingHook(config.Log.SampleRepeated, config.Log.SamplePeriod))
+	}
 	logger = logger.With().
 		Timestamp().
 		Str("version", version).
@@ -189,6 +514,126 @@ func loadConfig() Config {
 	return config
 }
 
+// newCanary returns the canary checking the configured cluster
+func newCanary(config Config, logger zerolog.Logger) (*kafkacanary.Canary, error) {
+	config.Canary.Ownership.Force = config.Force
+	return kafkacanary.New(kafkacanary.Config{
+		Brokers: config.Brokers,
+		TLS:     kafkacanary.TLSConfig{Enabled: true},
+		SASL:    kafkacanary.SASLConfig{Enabled: true, Mechanism: kafkacanary.SASLMechanismAWSMSKIAM},
+		Proxy:   kafkacanary.ProxyConfig{URL: config.ProxyURL},
+		DNS: kafkacanary.DNSConfig{
+			Servers:   config.DNS.Servers,
+			Overrides: parseOverrides(config.DNS.Overrides),
+		},
+		IPFamily:    kafkacanary.IPFamily(config.IPFamily),
+		SourceAddrs: config.SourceAddrs,
+		Canary:      config.Canary,
+		Callbacks:   watchdogCallbacks(config, logger),
+		Metrics: kafkacanary.MetricsConfig{
+			Namespace: config.MetricsNamespace,
+			Subsystem: config.MetricsSubsystem,
+		},
+	}, &logger)
+}
+
+// watchdogCallbacks returns the callbacks sending the systemd watchdog keepalive after every
+// reconcile loop iteration, so a wedged loop gets the canary restarted. Kafka errors don't stop
+// the keepalives, restarting the canary wouldn't fix the cluster.
+func watchdogCallbacks(config Config, logger zerolog.Logger) kafkacanary.Callbacks {
+	interval := systemd.WatchdogInterval()
+	if interval == 0 {
+		return kafkacanary.Callbacks{}
+	}
+	if config.Canary.ReconcileInterval >= interval/2 {
+		logger.Warn().
+			Dur("watchdog", interval).
+			Dur("interval", config.Canary.ReconcileInterval).
+			Msg("The reconcile interval should be under half of the systemd WatchdogSec")
+	}
+	return kafkacanary.Callbacks{
+		OnReconcile: func(kafkacanary.ReconcileResult) {
+			if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+				logger.Warn().Err(err).Msg("Error sending the systemd watchdog keepalive")
+			}
+		},
+	}
+}
+
+// discoverKubernetesMetadata returns the canary pod location, partial when discovery fails
+func discoverKubernetesMetadata(logger zerolog.Logger) kubernetes.Metadata {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m, err := kubernetes.Discover(ctx)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Error discovering the Kubernetes metadata, using the partial results")
+	}
+	logger.Info().
+		Str("pod", m.Pod).
+		Str("namespace", m.Namespace).
+		Str("node", m.Node).
+		Str("zone", m.Zone).
+		Msg("Discovered Kubernetes metadata")
+	return m
+}
+
+// withKubernetesMetadata adds the pod and node as record headers and defaults the zone
+func withKubernetesMetadata(config *Config, m kubernetes.Metadata) {
+	headers := map[string]string{}
+	if m.Pod != "" {
+		headers[services.PodHeader] = m.Pod
+	}
+	if m.Node != "" {
+		headers[services.NodeHeader] = m.Node
+	}
+	// the configured headers take precedence
+	for key, value := range config.Canary.Headers {
+		headers[key] = value
+	}
+	config.Canary.Headers = headers
+	if config.Canary.Coordination.Zone == "" {
+		config.Canary.Coordination.Zone = m.Zone
+	}
+}
+
+// resolveTopic replaces the placeholders of the canary topic name, once the zone is known
+func resolveTopic(config *Config) error {
+	topic, err := config.Canary.ResolveTopic(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	config.Canary.Topic = topic
+	return nil
+}
+
+// serviceArgs returns the arguments the service is installed with, i.e. all but --service
+func serviceArgs(args []string) []string {
+	filtered := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--service":
+			i++ // skip the action
+		case strings.HasPrefix(args[i], "--service="):
+		default:
+			filtered = append(filtered, args[i])
+		}
+	}
+	return filtered
+}
+
+// parseOverrides parses the host=address pairs of the DNS overrides
+func parseOverrides(pairs []string) map[string]string {
+	overrides := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		host, address, ok := strings.Cut(pair, "=")
+		if !ok || host == "" || address == "" {
+			exitError(fmt.Errorf("invalid override %q", pair), 2, "Config unmarshal failed")
+		}
+		overrides[host] = address
+	}
+	return overrides
+}
+
 func exitError(err error, code int, msg string) {
 	fmt.Fprintf(os.Stderr, "%s: %s\n\n", msg, err.Error())
 	os.Exit(code)
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+
+	"github.com/pecigonzalo/kafka-canary/internal/kubernetes"
+)
+
+// operator runs the canary configured by a KafkaCanary resource, looking it up periodically and
+// rebuilding the canary when its spec changes. The spec is merged over the flags and the
+// configuration file, so only the fleet-wide settings need to live in the resource.
+type operator struct {
+	config   Config
+	settings map[string]interface{}
+	metadata kubernetes.Metadata
+	logger   zerolog.Logger
+	// lookup returns the current KafkaCanary resource
+	lookup func(ctx context.Context) (kubernetes.KafkaCanary, error)
+	// build returns the canary of a configuration, not started yet
+	build func(config Config, logger zerolog.Logger) (runner, error)
+
+	lock       sync.RWMutex
+	canary     runner
+	applied    Config
+	generation int64
+
+	stop     chan struct{}
+	syncStop sync.WaitGroup
+}
+
+func newOperator(config Config, metadata kubernetes.Metadata, logger zerolog.Logger) (*operator, error) {
+	client, err := kubernetes.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := kubernetes.Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	return &operator{
+		config:   config,
+		settings: viper.AllSettings(),
+		metadata: metadata,
+		logger:   logger.With().Str("resource", namespace+"/"+config.Operator.Resource).Logger(),
+		lookup: func(ctx context.Context) (kubernetes.KafkaCanary, error) {
+			return client.KafkaCanary(ctx, namespace, config.Operator.Resource)
+		},
+		build: func(config Config, logger zerolog.Logger) (runner, error) {
+			return newCanary(config, logger)
+		},
+		stop: make(chan struct{}),
+	}, nil
+}
+
+// Start runs the canary of the current resource and starts watching it for changes
+func (o *operator) Start() error {
+	if err := o.reconcile(); err != nil {
+		return err
+	}
+
+	o.syncStop.Add(1)
+	go func() {
+		defer o.syncStop.Done()
+		ticker := time.NewTicker(o.config.Operator.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := o.reconcile(); err != nil {
+					o.logger.Error().Err(err).Msg("Error reconciling the KafkaCanary resource")
+				}
+			case <-o.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the resource and stops the canary
+func (o *operator) Stop() {
+	close(o.stop)
+	o.syncStop.Wait()
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.canary != nil {
+		o.canary.Stop()
+		o.canary = nil
+	}
+}
+
+// Ready returns the canary readiness, or an error while no canary runs
+func (o *operator) Ready() error {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	if o.canary == nil {
+		return errors.New("no canary running for the KafkaCanary resource")
+	}
+	return o.canary.Ready()
+}
+
+// StatusHandler returns an HTTP handler serving the status of the current canary
+func (o *operator) StatusHandler() http.Handler {
+	return o.forward(runner.StatusHandler)
+}
+
+// ClusterInfoHandler returns an HTTP handler serving the cluster info of the current canary
+func (o *operator) ClusterInfoHandler() http.Handler {
+	return o.forward(runner.ClusterInfoHandler)
+}
+
+// EventsHandler returns an HTTP handler serving the recent events of the current canary
+func (o *operator) EventsHandler() http.Handler {
+	return o.forward(runner.EventsHandler)
+}
+
+// RollsHandler returns an HTTP handler serving and marking the maintenance rolls of the current canary
+func (o *operator) RollsHandler() http.Handler {
+	return o.forward(runner.RollsHandler)
+}
+
+// ResetHandler returns an HTTP handler resetting the current canary
+func (o *operator) ResetHandler() http.Handler {
+	return o.forward(runner.ResetHandler)
+}
+
+// PrincipalHandler returns an HTTP handler serving the principal of the current canary
+func (o *operator) PrincipalHandler() http.Handler {
+	return o.forward(runner.PrincipalHandler)
+}
+
+// ConfigHash returns the configuration hash of the current canary, empty while no canary runs
+func (o *operator) ConfigHash() string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	if o.canary == nil {
+		return ""
+	}
+	return o.canary.ConfigHash()
+}
+
+// Gather returns the metrics of the current canary, none while no canary runs
+func (o *operator) Gather() ([]*dto.MetricFamily, error) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	if o.canary == nil {
+		return nil, nil
+	}
+	return o.canary.Gather()
+}
+
+// Config returns the configuration of the current canary, false while no canary runs
+func (o *operator) Config() (Config, bool) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.applied, o.canary != nil
+}
+
+// forward returns an HTTP handler serving the given handler of the current canary
+func (o *operator) forward(handler func(runner) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.lock.RLock()
+		c := o.canary
+		o.lock.RUnlock()
+		if c == nil {
+			http.Error(w, "no canary running", http.StatusServiceUnavailable)
+			return
+		}
+		handler(c).ServeHTTP(w, r)
+	})
+}
+
+// reconcile rebuilds the canary when the resource spec changed since the last successful build
+func (o *operator) reconcile() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resource, err := o.lookup(ctx)
+	if err != nil {
+		return err
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	// the generation only changes with the spec
+	if o.canary != nil && resource.Metadata.Generation == o.generation {
+		return nil
+	}
+
+	config, err := o.configFor(resource)
+	if err != nil {
+		return err
+	}
+	o.logger.Info().
+		Int64("generation", resource.Metadata.Generation).
+		Strs("brokers", config.Brokers).
+		Str("topic", config.Canary.Topic).
+		Msg("Applying KafkaCanary resource")
+
+	// the previous canary is stopped first, the new one takes over its metrics exposition
+	if o.canary != nil {
+		o.canary.Stop()
+		o.canary = nil
+	}
+	c, err := o.build(config, o.logger)
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	o.canary = c
+	o.applied = config
+	o.generation = resource.Metadata.Generation
+	return nil
+}
+
+// configFor merges the resource spec over the base settings
+func (o *operator) configFor(resource kubernetes.KafkaCanary) (Config, error) {
+	v := viper.New()
+	if err := v.MergeConfigMap(o.settings); err != nil {
+		return Config{}, err
+	}
+	spec := map[string]interface{}{"canary": resource.Spec.Canary}
+	if len(resource.Spec.Brokers) > 0 {
+		spec["brokers"] = resource.Spec.Brokers
+	}
+	if err := v.MergeConfigMap(spec); err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return Config{}, err
+	}
+	withKubernetesMetadata(&config, o.metadata)
+	if err := resolveTopic(&config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/internal/kubernetes"
+)
+
+// fakeRunner is a canary counting its starts and stops
+type fakeRunner struct {
+	config  Config
+	hash    string
+	started int
+	stopped int
+}
+
+func (r *fakeRunner) Start() error                         { r.started++; return nil }
+func (r *fakeRunner) Stop()                                { r.stopped++ }
+func (r *fakeRunner) Ready() error                         { return nil }
+func (r *fakeRunner) StatusHandler() http.Handler          { return http.NotFoundHandler() }
+func (r *fakeRunner) ClusterInfoHandler() http.Handler     { return http.NotFoundHandler() }
+func (r *fakeRunner) EventsHandler() http.Handler          { return http.NotFoundHandler() }
+func (r *fakeRunner) RollsHandler() http.Handler           { return http.NotFoundHandler() }
+func (r *fakeRunner) ResetHandler() http.Handler           { return http.NotFoundHandler() }
+func (r *fakeRunner) PrincipalHandler() http.Handler       { return http.NotFoundHandler() }
+func (r *fakeRunner) ConfigHash() string                   { return r.hash }
+func (r *fakeRunner) Gather() ([]*dto.MetricFamily, error) { return nil, nil }
+
+func kafkaCanary(generation int64, topic string) kubernetes.KafkaCanary {
+	var resource kubernetes.KafkaCanary
+	resource.Metadata.Generation = generation
+	resource.Spec.Brokers = []string{"broker:9092"}
+	resource.Spec.Canary = map[string]interface{}{"topic": topic}
+	return resource
+}
+
+func TestOperatorReconcile(t *testing.T) {
+	var resource kubernetes.KafkaCanary
+	var lookupErr error
+	var built []*fakeRunner
+	o := &operator{
+		settings: map[string]interface{}{"brokers": []string{"seed:9092"}, "canary": map[string]interface{}{"topic": "base"}},
+		logger:   zerolog.Nop(),
+		lookup: func(ctx context.Context) (kubernetes.KafkaCanary, error) {
+			return resource, lookupErr
+		},
+		build: func(config Config, logger zerolog.Logger) (runner, error) {
+			r := &fakeRunner{config: config}
+			built = append(built, r)
+			return r, nil
+		},
+	}
+
+	resource = kafkaCanary(1, "canary-a")
+	require.NoError(t, o.reconcile())
+	require.Len(t, built, 1)
+	assert.Equal(t, 1, built[0].started)
+	assert.Equal(t, []string{"broker:9092"}, built[0].config.Brokers)
+	assert.Equal(t, "canary-a", built[0].config.Canary.Topic)
+	applied, ok := o.Config()
+	assert.True(t, ok)
+	assert.Equal(t, "canary-a", applied.Canary.Topic)
+
+	// the generation only changes with the spec
+	require.NoError(t, o.reconcile())
+	assert.Len(t, built, 1, "rebuilt with the generation unchanged")
+	assert.Equal(t, 0, built[0].stopped)
+
+	resource = kafkaCanary(2, "canary-b")
+	require.NoError(t, o.reconcile())
+	require.Len(t, built, 2)
+	assert.Equal(t, 1, built[0].stopped, "previous canary not stopped")
+	assert.Equal(t, 1, built[1].started)
+	assert.Equal(t, "canary-b", built[1].config.Canary.Topic)
+
+	// the running canary is kept while the resource can't be read
+	lookupErr = errors.New("forbidden")
+	resource = kafkaCanary(3, "canary-c")
+	assert.Error(t, o.reconcile())
+	assert.Len(t, built, 2)
+	assert.Equal(t, 0, built[1].stopped)
+	assert.NoError(t, o.Ready())
+	applied, _ = o.Config()
+	assert.Equal(t, "canary-b", applied.Canary.Topic)
+}
+
+func TestOperatorBuildError(t *testing.T) {
+	o := &operator{
+		logger: zerolog.Nop(),
+		lookup: func(ctx context.Context) (kubernetes.KafkaCanary, error) {
+			return kafkaCanary(1, "canary-a"), nil
+		},
+		build: func(config Config, logger zerolog.Logger) (runner, error) {
+			return nil, errors.New("invalid config")
+		},
+	}
+	assert.Error(t, o.reconcile())
+	assert.Error(t, o.Ready(), "ready without a canary")
+	_, ok := o.Config()
+	assert.False(t, ok)
+}
+
+func TestOperatorConfigHash(t *testing.T) {
+	o := &operator{}
+	assert.Empty(t, o.ConfigHash(), "no hash without a canary")
+	o.canary = &fakeRunner{hash: "abc"}
+	assert.Equal(t, "abc", o.ConfigHash())
+}
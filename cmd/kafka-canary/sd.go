@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// sdTarget is a target group of the Prometheus HTTP service discovery
+type sdTarget struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler serves the canary metrics endpoint as Prometheus HTTP service discovery targets. The
+// canary and cluster identity are __meta_kafka_canary_ labels, left for the scrape configuration
+// to relabel so they never clash with the labels of the metrics.
+func sdHandler(config func() (Config, bool), metricsLabels map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, ok := config()
+		if !ok {
+			http.Error(w, "no canary running", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]sdTarget{scrapeTarget(current, metricsLabels, r.Host)})
+	})
+}
+
+// scrapeTarget returns the target of the canary metrics, addressed with the configured host or
+// the one the discovery request was sent to
+func scrapeTarget(config Config, metricsLabels map[string]string, requestHost string) sdTarget {
+	host := config.Host
+	if host == "" {
+		host = requestHost
+		if h, _, err := net.SplitHostPort(requestHost); err == nil {
+			host = h
+		}
+	}
+	port := config.MetricsPort
+	if port == 0 {
+		port = config.Port
+	}
+	scheme := "http"
+	if config.HTTP.TLSEnabled() {
+		scheme = "https"
+	}
+
+	labels := map[string]string{
+		"__scheme__":                   scheme,
+		"__metrics_path__":             "/metrics",
+		"__meta_kafka_canary_instance": config.Canary.InstanceID,
+		"__meta_kafka_canary_topic":    config.Canary.Topic,
+		"__meta_kafka_canary_brokers":  strings.Join(config.Brokers, ","),
+	}
+	// e.g. canary_pod from the Kubernetes metadata as __meta_kafka_canary_pod
+	for name, value := range metricsLabels {
+		labels["__meta_kafka_canary_"+strings.TrimPrefix(name, "canary_")] = value
+	}
+	return sdTarget{
+		Targets: []string{net.JoinHostPort(host, strconv.Itoa(port))},
+		Labels:  labels,
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/internal/api"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestSDHandler(t *testing.T) {
+	config := Config{
+		Port:    8080,
+		Brokers: []string{"broker-1:9092", "broker-2:9092"},
+		Canary:  canary.Config{InstanceID: "canary-a", Topic: "canary"},
+	}
+	handler := sdHandler(func() (Config, bool) { return config, true }, map[string]string{"canary_pod": "canary-0"})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://canary.local:8080/sd", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var got []sdTarget
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, []sdTarget{{
+		Targets: []string{"canary.local:8080"},
+		Labels: map[string]string{
+			"__scheme__":                   "http",
+			"__metrics_path__":             "/metrics",
+			"__meta_kafka_canary_instance": "canary-a",
+			"__meta_kafka_canary_topic":    "canary",
+			"__meta_kafka_canary_brokers":  "broker-1:9092,broker-2:9092",
+			"__meta_kafka_canary_pod":      "canary-0",
+		},
+	}}, got)
+}
+
+func TestSDHandlerWithoutCanary(t *testing.T) {
+	handler := sdHandler(func() (Config, bool) { return Config{}, false }, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/sd", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestScrapeTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		requestHost string
+		wantTarget  string
+		wantScheme  string
+	}{
+		{
+			name:        "request host without port",
+			config:      Config{Port: 8080},
+			requestHost: "canary.local",
+			wantTarget:  "canary.local:8080",
+			wantScheme:  "http",
+		},
+		{
+			name:        "configured host and metrics port",
+			config:      Config{Host: "10.0.0.1", Port: 8080, MetricsPort: 9090},
+			requestHost: "canary.local:8080",
+			wantTarget:  "10.0.0.1:9090",
+			wantScheme:  "http",
+		},
+		{
+			name: "TLS",
+			config: Config{Port: 8443, HTTP: HTTPConfig{
+				SecurityConfig: api.SecurityConfig{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key"},
+			}},
+			requestHost: "[::1]:8443",
+			wantTarget:  "[::1]:8443",
+			wantScheme:  "https",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := scrapeTarget(tt.config, nil, tt.requestHost)
+			assert.Equal(t, []string{tt.wantTarget}, target.Targets)
+			assert.Equal(t, tt.wantScheme, target.Labels["__scheme__"])
+		})
+	}
+}
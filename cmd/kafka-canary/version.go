@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// binaryMetrics holds the metrics of the binary, exposed along with those of the canary it runs
+var binaryMetrics = prometheus.NewRegistry()
+
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name:      "build_info",
+	Namespace: metrics.Namespace,
+	Help:      "Always 1, labeled with the version, commit and Go version of the canary binary",
+}, []string{"version", "commit", "go_version"})
+
+func init() {
+	if commit == "" {
+		commit = vcsRevision()
+	}
+	binaryMetrics.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(version, commit, runtime.Version()).Set(1)
+}
+
+// vcsRevision returns the commit stamped by go build, unknown outside a repository
+func vcsRevision() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// versionHandler serves the build and the configuration hash of the running canary
+func versionHandler(c runner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Version    string `json:"version"`
+			Commit     string `json:"commit"`
+			GoVersion  string `json:"goVersion"`
+			ConfigHash string `json:"configHash,omitempty"`
+		}{version, commit, runtime.Version(), c.ConfigHash()})
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionHandler(t *testing.T) {
+	for _, hash := range []string{"abc", ""} {
+		rec := httptest.NewRecorder()
+		versionHandler(&fakeRunner{hash: hash}).ServeHTTP(rec, httptest.NewRequest("GET", "/version", nil))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var got map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		want := map[string]string{"version": version, "commit": commit, "goVersion": runtime.Version()}
+		if hash != "" {
+			want["configHash"] = hash
+		}
+		assert.Equal(t, want, got, "the config hash is omitted without a canary")
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	assert.NotEmpty(t, commit, "the commit falls back to the build info")
+	assert.Equal(t, 1.0, testutil.ToFloat64(buildInfo.WithLabelValues(version, commit, runtime.Version())))
+}
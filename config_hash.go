@@ -0,0 +1,48 @@
+package canary
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var configHashGauge = metrics.NewGauge(prometheus.GaugeOpts{
+	Name:      "config_hash",
+	Namespace: metrics.Namespace,
+	Help:      "Hash of the canary configuration, the first 48 bits of its SHA-256 so it's exact as a float",
+})
+
+// configHash returns the SHA-256 of the configuration, the callbacks and the metrics exposition
+// excluded. Equal hashes across the fleet mean the clusters are checked the same way.
+func configHash(config Config) (string, error) {
+	data, err := json.Marshal(struct {
+		Brokers  []string
+		TLS      TLSConfig
+		SASL     SASLConfig
+		Proxy    ProxyConfig
+		DNS      DNSConfig
+		IPFamily IPFamily
+		Canary   Settings
+	}{config.Brokers, config.TLS, config.SASL, config.Proxy, config.DNS, config.IPFamily, config.Canary})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// exportConfigHash sets the config hash gauge of the registry to the first 48 bits of the hash
+func exportConfigHash(registry *metrics.Registry, hash string) {
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) < 6 {
+		return
+	}
+	var value [8]byte
+	copy(value[2:], sum[:6])
+	configHashGauge.In(registry).Set(float64(binary.BigEndian.Uint64(value[:])))
+}
@@ -0,0 +1,42 @@
+package canary
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+func TestConfigHash(t *testing.T) {
+	config := Config{Brokers: []string{"broker:9092"}, Canary: Settings{Topic: "canary"}}
+	hash, err := configHash(config)
+	require.NoError(t, err)
+	assert.Len(t, hash, 64)
+
+	exposed := config
+	exposed.Metrics = MetricsConfig{Namespace: "canary", Registerer: prometheus.NewRegistry()}
+	exposed.Callbacks = Callbacks{OnProduce: func(ProduceResult) {}}
+	same, err := configHash(exposed)
+	require.NoError(t, err)
+	assert.Equal(t, hash, same, "the callbacks and the metrics exposition aren't hashed")
+
+	other := config
+	other.Canary.Topic = "other"
+	different, err := configHash(other)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, different)
+}
+
+func TestExportConfigHash(t *testing.T) {
+	registry := metrics.NewRegistry()
+	exportConfigHash(registry, "0123456789abffff")
+	assert.Equal(t, float64(0x0123456789ab), testutil.ToFloat64(configHashGauge.In(registry)))
+
+	exportConfigHash(registry, "not hex")
+	exportConfigHash(registry, "0123")
+	assert.Equal(t, float64(0x0123456789ab), testutil.ToFloat64(configHashGauge.In(registry)), "invalid hashes aren't exported")
+}
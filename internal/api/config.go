@@ -1,7 +1,60 @@
 package api
 
+import (
+	"fmt"
+	"time"
+)
+
 type Config struct {
-	Host    string `mapstructure:"host"`
-	Port    string `mapstructure:"port"`
-	Service string `mapstructure:"service"`
+	Host        string `mapstructure:"host"`
+	Port        string `mapstructure:"port"`
+	MetricsPort string `mapstructure:"metrics-port"`
+	Service     string `mapstructure:"service"`
+	EnableAdmin bool   `mapstructure:"enable-admin"`
+	EnablePprof bool   `mapstructure:"enable-pprof"`
+	DumpDir     string `mapstructure:"dump-dir"`
+	// path of a Unix socket serving the status server and the metrics too, e.g. for sidecar agents
+	UnixSocket string         `mapstructure:"unix-socket"`
+	Security   SecurityConfig `mapstructure:"security"`
+	Limits     LimitsConfig   `mapstructure:"limits"`
+	// labels added to every metric served on /metrics
+	MetricsLabels map[string]string `mapstructure:"metrics-labels"`
+}
+
+// LimitsConfig defines the timeouts, header size and request rate limits of the HTTP servers
+type LimitsConfig struct {
+	ReadTimeout    time.Duration `mapstructure:"read-timeout"`
+	WriteTimeout   time.Duration `mapstructure:"write-timeout"`
+	IdleTimeout    time.Duration `mapstructure:"idle-timeout"`
+	MaxHeaderBytes int           `mapstructure:"max-header-bytes"`
+	RateLimit      float64       `mapstructure:"rate-limit"`
+	RateBurst      int           `mapstructure:"rate-burst"`
+}
+
+// SecurityConfig defines the optional TLS, authentication and IP allowlisting of the HTTP servers
+type SecurityConfig struct {
+	TLSCertFile       string   `mapstructure:"tls-cert-file"`
+	TLSKeyFile        string   `mapstructure:"tls-key-file"`
+	BasicAuthUsername string   `mapstructure:"basic-auth-username"`
+	BasicAuthPassword string   `mapstructure:"basic-auth-password"`
+	BearerToken       string   `mapstructure:"bearer-token"`
+	AllowedCIDRs      []string `mapstructure:"allowed-cidrs"`
+}
+
+// TLSEnabled returns true when a certificate and key are configured
+func (c SecurityConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// String returns the configuration with the credentials redacted
+func (c SecurityConfig) String() string {
+	redacted := c
+	if redacted.BasicAuthPassword != "" {
+		redacted.BasicAuthPassword = "REDACTED"
+	}
+	if redacted.BearerToken != "" {
+		redacted.BearerToken = "REDACTED"
+	}
+	type plain SecurityConfig
+	return fmt.Sprintf("%+v", plain(redacted))
 }
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+// registerPprof registers the net/http/pprof handlers under /debug/pprof/
+func (s *Server) registerPprof() {
+	s.router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+}
+
+// dumpHandler writes goroutine and heap dumps to the dump directory, so they can be collected
+// from a wedged canary even if the HTTP server stops responding afterwards
+func (s *Server) dumpHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := s.writeDumps(time.Now())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Error writing diagnostic dumps")
+		s.JSONResponseCode(w, r, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info().Strs("files", files).Msg("Wrote diagnostic dumps")
+	s.JSONResponse(w, r, map[string][]string{"files": files})
+}
+
+func (s *Server) writeDumps(now time.Time) ([]string, error) {
+	dir := s.config.DumpDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// collect up to date heap statistics
+	runtime.GC()
+
+	files := []string{}
+	for _, dump := range []struct {
+		profile string
+		debug   int
+	}{
+		{"goroutine", 2},
+		{"heap", 0},
+	} {
+		path := filepath.Join(dir, fmt.Sprintf("kafka-canary-%s-%s.pprof", dump.profile, now.UTC().Format("20060102T150405Z")))
+		if err := writeProfile(path, dump.profile, dump.debug); err != nil {
+			return files, err
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+func writeProfile(path string, profile string, debug int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p := runtimepprof.Lookup(profile)
+	if p == nil {
+		return fmt.Errorf("unknown profile %s", profile)
+	}
+	return p.WriteTo(f, debug)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+func TestDumpHandler(t *testing.T) {
+	logger := zerolog.Nop()
+	dir := filepath.Join(t.TempDir(), "dumps")
+	s := &Server{config: &Config{DumpDir: dir}, logger: &logger}
+
+	recorder := httptest.NewRecorder()
+	s.dumpHandler(recorder, httptest.NewRequest("POST", "/admin/dump", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d, expected 200: %s", recorder.Code, recorder.Body.String())
+	}
+	var body struct {
+		Files []string
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Files) != 2 {
+		t.Fatalf("files %v, expected the goroutine and heap dumps", body.Files)
+	}
+	for i, profile := range []string{"goroutine", "heap"} {
+		if !strings.HasPrefix(body.Files[i], filepath.Join(dir, "kafka-canary-"+profile+"-")) {
+			t.Errorf("dump %s, expected a %s dump in %s", body.Files[i], profile, dir)
+		}
+		if info, err := os.Stat(body.Files[i]); err != nil || info.Size() == 0 {
+			t.Errorf("dump %s missing or empty: %v", body.Files[i], err)
+		}
+	}
+
+	// the dump directory can't be created over a file
+	file := filepath.Join(t.TempDir(), "canary.conf")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s.config.DumpDir = file
+	recorder = httptest.NewRecorder()
+	s.dumpHandler(recorder, httptest.NewRequest("POST", "/admin/dump", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, expected 500", recorder.Code)
+	}
+}
+
+func TestRegisterPprof(t *testing.T) {
+	s := &Server{router: mux.NewRouter()}
+	s.registerPprof()
+
+	for path, code := range map[string]int{
+		"/debug/pprof/":          http.StatusOK,
+		"/debug/pprof/goroutine": http.StatusOK,
+		"/debug/pprof/cmdline":   http.StatusOK,
+		"/debug/pprof/unknown":   http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		s.router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != code {
+			t.Errorf("%s: status %d, expected %d", path, recorder.Code, code)
+		}
+	}
+}
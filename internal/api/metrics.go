@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsHandler returns the /metrics handler, adding the configured labels to every metric
+func (s *Server) metricsHandler() http.Handler {
+	if len(s.config.MetricsLabels) == 0 {
+		return promhttp.Handler()
+	}
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(labelingGatherer{
+			gatherer: prometheus.DefaultGatherer,
+			labels:   s.config.MetricsLabels,
+		}, promhttp.HandlerOpts{}),
+	)
+}
+
+// labelingGatherer adds constant labels to the metrics of the wrapped gatherer, it's used
+// instead of registration-time labels because most metrics are registered before the
+// configuration is loaded
+type labelingGatherer struct {
+	gatherer prometheus.Gatherer
+	labels   map[string]string
+}
+
+func (g labelingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			for name, value := range g.labels {
+				metric.Label = append(metric.Label, &dto.LabelPair{
+					Name:  stringPtr(name),
+					Value: stringPtr(value),
+				})
+			}
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+		}
+	}
+	return families, err
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
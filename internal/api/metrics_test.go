@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLabelingGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "test"}, []string{"partition"})
+	registry.MustRegister(counter)
+	counter.WithLabelValues("0").Inc()
+
+	gatherer := labelingGatherer{
+		gatherer: registry,
+		labels:   map[string]string{"canary_instance": "canary-a"},
+	}
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	labels := families[0].Metric[0].Label
+	if len(labels) != 2 {
+		t.Fatalf("got = %d labels, want = 2", len(labels))
+	}
+	if labels[0].GetName() != "canary_instance" || labels[0].GetValue() != "canary-a" {
+		t.Errorf("got = %s=%s, want = canary_instance=canary-a", labels[0].GetName(), labels[0].GetValue())
+	}
+	if labels[1].GetName() != "partition" {
+		t.Errorf("got = %s, want = partition", labels[1].GetName())
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pecigonzalo/kafka-canary/internal/ratelimit"
+)
+
+// probePaths are served without authentication nor rate limiting so probes keep working
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// allowlistHandler rejects requests coming from addresses outside the allowed networks
+func allowlistHandler(networks []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(networks) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			ip := net.ParseIP(host)
+			for _, network := range networks {
+				if ip != nil && network.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+}
+
+// authHandler requires basic auth or bearer token credentials when any are configured
+func authHandler(config SecurityConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if config.BearerToken == "" && config.BasicAuthUsername == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if probePaths[r.URL.Path] || authorized(config, r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if config.BasicAuthUsername != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="kafka-canary"`)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		})
+	}
+}
+
+func authorized(config SecurityConfig, r *http.Request) bool {
+	if config.BearerToken != "" {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(config.BearerToken)) == 1 {
+			return true
+		}
+	}
+	if config.BasicAuthUsername != "" {
+		username, password, ok := r.BasicAuth()
+		if ok &&
+			subtle.ConstantTimeCompare([]byte(username), []byte(config.BasicAuthUsername)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(config.BasicAuthPassword)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitHandler rejects requests over the configured rate with a 429 status
+func rateLimitHandler(limiter *ratelimit.TokenBucket) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !probePaths[r.URL.Path] && !limiter.Allow() {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthHandler(t *testing.T) {
+	config := SecurityConfig{
+		BasicAuthUsername: "user",
+		BasicAuthPassword: "pass",
+		BearerToken:       "token",
+	}
+	handler := authHandler(config)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name     string
+		path     string
+		setup    func(r *http.Request)
+		expected int
+	}{
+		{"no credentials", "/status", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"health without credentials", "/healthz", func(r *http.Request) {}, http.StatusOK},
+		{"valid basic auth", "/status", func(r *http.Request) { r.SetBasicAuth("user", "pass") }, http.StatusOK},
+		{"invalid basic auth", "/status", func(r *http.Request) { r.SetBasicAuth("user", "nope") }, http.StatusUnauthorized},
+		{"valid token", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"invalid token", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+	}
+
+	for _, tst := range cases {
+		r := httptest.NewRequest(http.MethodGet, tst.path, nil)
+		tst.setup(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tst.expected {
+			t.Errorf("%s: got = %d, want = %d", tst.name, w.Code, tst.expected)
+		}
+	}
+}
+
+func TestAllowlistHandler(t *testing.T) {
+	networks, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := allowlistHandler(networks)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		remoteAddr string
+		expected   int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"192.168.1.1:1234", http.StatusOK},
+		{"192.168.1.2:1234", http.StatusForbidden},
+		{"[::1]:1234", http.StatusForbidden},
+	}
+
+	for _, tst := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		r.RemoteAddr = tst.remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tst.expected {
+			t.Errorf("%s: got = %d, want = %d", tst.remoteAddr, w.Code, tst.expected)
+		}
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/ratelimit"
 )
 
 var (
@@ -21,31 +24,65 @@ var (
 )
 
 type Server struct {
-	config  *Config
-	router  *mux.Router
-	handler http.Handler
-	chain   alice.Chain
-	logger  *zerolog.Logger
+	config          *Config
+	router          *mux.Router
+	handler         http.Handler
+	chain           alice.Chain
+	allowedNetworks []*net.IPNet
+	limiter         *ratelimit.TokenBucket
+	readinessChecks []func() error
+	logger          *zerolog.Logger
 }
 
 func NewServer(config *Config, logger *zerolog.Logger) (*Server, error) {
+	allowedNetworks, err := parseCIDRs(config.Security.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
 	srv := &Server{
-		config: config,
-		router: mux.NewRouter(),
-		chain:  alice.New(),
-		logger: logger,
+		config:          config,
+		router:          mux.NewRouter(),
+		chain:           alice.New(),
+		allowedNetworks: allowedNetworks,
+		limiter:         ratelimit.NewTokenBucket(config.Limits.RateLimit, config.Limits.RateBurst),
+		logger:          logger,
 	}
 
 	return srv, nil
 }
 
-func (s *Server) ListenAndServe() (*http.Server, *int32, *int32) {
-	go s.startMetricsServer()
+// AddReadinessCheck registers a function failing /readyz when it returns an error, it must be
+// called before ListenAndServe.
+func (s *Server) AddReadinessCheck(check func() error) {
+	s.readinessChecks = append(s.readinessChecks, check)
+}
+
+// Handle registers an additional handler in the status server, it must be called before ListenAndServe.
+// Handlers only serve GET requests unless other methods are given.
+func (s *Server) Handle(path string, handler http.Handler, methods ...string) {
+	if len(methods) == 0 {
+		methods = []string{"GET"}
+	}
+	s.router.Handle(path, handler).Methods(methods...)
+}
 
+func (s *Server) ListenAndServe() (*http.Server, *int32, *int32) {
 	// Register Handlers
-	s.router.Handle("/metrics", promhttp.Handler())
+	if s.separateMetricsServer() {
+		go s.startMetricsServer()
+	} else {
+		s.router.Handle("/metrics", s.metricsHandler())
+	}
 	s.router.HandleFunc("/healthz", s.healthzHandler).Methods("GET")
 	s.router.HandleFunc("/readyz", s.readyzHandler).Methods("GET")
+	if s.config.EnableAdmin {
+		s.router.HandleFunc("/admin/loglevel", s.logLevelHandler).Methods("GET", "PUT")
+	}
+	if s.config.EnablePprof {
+		s.registerPprof()
+		s.router.HandleFunc("/admin/dump", s.dumpHandler).Methods("POST")
+	}
 
 	// Register middlewares
 	logger := s.logger.With().Logger()
@@ -66,10 +103,14 @@ func (s *Server) ListenAndServe() (*http.Server, *int32, *int32) {
 			Dur("duration", duration).
 			Msg("")
 	}))
-	s.handler = chain.Then(s.router)
+	s.handler = chain.Append(s.protectionHandlers()...).Then(s.router)
 
 	// create the http server
 	srv := s.startServer()
+	if s.config.UnixSocket != "" {
+		unixSrv := s.startUnixServer(chain)
+		srv.RegisterOnShutdown(func() { _ = unixSrv.Close() })
+	}
 
 	// signal Kubernetes the server is ready to receive traffic
 	atomic.StoreInt32(&healthy, 1)
@@ -79,20 +120,15 @@ func (s *Server) ListenAndServe() (*http.Server, *int32, *int32) {
 }
 
 func (s *Server) startServer() *http.Server {
-	srv := &http.Server{
-		Addr:         s.config.Host + ":" + s.config.Port,
-		WriteTimeout: 30 * time.Second,
-		ReadTimeout:  30 * time.Second,
-		IdleTimeout:  2 * 30 * time.Second,
-		Handler:      s.handler,
-	}
+	srv := s.newHTTPServer(s.config.Host+":"+s.config.Port, s.handler)
 
 	// start the server in the background
 	go func() {
 		s.logger.Info().
 			Str("addr", srv.Addr).
+			Bool("tls", s.config.Security.TLSEnabled()).
 			Msg("Starting HTTP Server")
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		if err := s.serve(srv); err != http.ErrServerClosed {
 			s.logger.Fatal().
 				Err(err).
 				Msg("HTTP server crashed")
@@ -103,9 +139,63 @@ func (s *Server) startServer() *http.Server {
 	return srv
 }
 
+// startUnixServer serves the status server routes and the metrics on the Unix socket. The peers are
+// local, so the IP allowlist doesn't apply and TLS isn't used, the rate limit and authentication do.
+func (s *Server) startUnixServer(chain alice.Chain) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.router)
+	if s.separateMetricsServer() {
+		mux.Handle("/metrics", s.metricsHandler())
+	}
+	srv := s.newHTTPServer(s.config.UnixSocket, chain.Append(rateLimitHandler(s.limiter), authHandler(s.config.Security)).Then(mux))
+
+	listener, err := listenUnix(s.config.UnixSocket)
+	if err != nil {
+		s.logger.Fatal().Err(err).Msg("Error listening on the Unix socket")
+	}
+	go func() {
+		s.logger.Info().
+			Str("socket", s.config.UnixSocket).
+			Msg("Starting Unix socket HTTP Server")
+		if err := srv.Serve(listener); err != http.ErrServerClosed {
+			s.logger.Fatal().
+				Err(err).
+				Msg("Unix socket HTTP server crashed")
+		}
+	}()
+	return srv
+}
+
+// listenUnix listens on the Unix socket, replacing the one left by a previous run, readable and
+// writable by the owner and group only
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// separateMetricsServer returns true when metrics are served on their own port
+func (s *Server) separateMetricsServer() bool {
+	return s.config.MetricsPort != "" && s.config.MetricsPort != "0" && s.config.MetricsPort != s.config.Port
+}
+
 func (s *Server) startMetricsServer() {
-	mux := http.DefaultServeMux
-	mux.Handle("/metrics", promhttp.Handler())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metricsHandler())
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, err := w.Write([]byte("OK"))
@@ -114,17 +204,57 @@ func (s *Server) startMetricsServer() {
 		}
 	})
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%v", 8081),
-		Handler: mux,
-	}
+	srv := s.newHTTPServer(s.config.Host+":"+s.config.MetricsPort, alice.New(s.protectionHandlers()...).Then(mux))
 
-	err := srv.ListenAndServe()
+	s.logger.Info().
+		Str("addr", srv.Addr).
+		Bool("tls", s.config.Security.TLSEnabled()).
+		Msg("Starting metrics HTTP Server")
+	err := s.serve(srv)
 	if err != nil {
 		s.logger.Err(err).Msg("Metrics server error")
 	}
 }
 
+// protectionHandlers returns the allowlist, rate limit and authentication middlewares, in that order
+func (s *Server) protectionHandlers() []alice.Constructor {
+	return []alice.Constructor{
+		allowlistHandler(s.allowedNetworks),
+		rateLimitHandler(s.limiter),
+		authHandler(s.config.Security),
+	}
+}
+
+func (s *Server) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	limits := s.config.Limits
+	if limits.ReadTimeout == 0 {
+		limits.ReadTimeout = 30 * time.Second
+	}
+	if limits.WriteTimeout == 0 {
+		limits.WriteTimeout = 30 * time.Second
+	}
+	if limits.IdleTimeout == 0 {
+		limits.IdleTimeout = 2 * 30 * time.Second
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		WriteTimeout:      limits.WriteTimeout,
+		ReadTimeout:       limits.ReadTimeout,
+		ReadHeaderTimeout: limits.ReadTimeout,
+		IdleTimeout:       limits.IdleTimeout,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
+		Handler:           handler,
+	}
+}
+
+func (s *Server) serve(srv *http.Server) error {
+	if s.config.Security.TLSEnabled() {
+		return srv.ListenAndServeTLS(s.config.Security.TLSCertFile, s.config.Security.TLSKeyFile)
+	}
+	return srv.ListenAndServe()
+}
+
 func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
 	if atomic.LoadInt32(&healthy) == 1 {
 		s.JSONResponse(w, r, map[string]string{"status": "OK"})
@@ -134,11 +264,45 @@ func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
-	if atomic.LoadInt32(&ready) == 1 {
-		s.JSONResponse(w, r, map[string]string{"status": "OK"})
+	if atomic.LoadInt32(&ready) != 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
-	w.WriteHeader(http.StatusServiceUnavailable)
+	var failures []string
+	for _, check := range s.readinessChecks {
+		if err := check(); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		s.JSONResponseCode(w, r, map[string]interface{}{"status": "NOT READY", "errors": failures}, http.StatusServiceUnavailable)
+		return
+	}
+	s.JSONResponse(w, r, map[string]string{"status": "OK"})
+}
+
+// logLevelHandler returns the global log level, or changes it on PUT with a {"level": "debug"} body
+func (s *Server) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			s.JSONResponseCode(w, r, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		level, err := zerolog.ParseLevel(body.Level)
+		if err != nil || body.Level == "" {
+			s.JSONResponseCode(w, r, map[string]string{"error": "invalid log level: " + body.Level}, http.StatusBadRequest)
+			return
+		}
+		s.logger.Info().
+			Stringer("from", zerolog.GlobalLevel()).
+			Stringer("to", level).
+			Msg("Changing log level")
+		zerolog.SetGlobalLevel(level)
+	}
+	s.JSONResponse(w, r, map[string]string{"level": zerolog.GlobalLevel().String()})
 }
 
 func (s *Server) JSONResponse(w http.ResponseWriter, r *http.Request, result interface{}) {
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "canary.sock")
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o660 {
+		t.Errorf("socket permissions %o, expected 660", perm)
+	}
+	// a socket left by a crashed run is replaced
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = listenUnix(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	listener.Close()
+
+	// anything else is left alone
+	file := filepath.Join(t.TempDir(), "canary.conf")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(file); err == nil {
+		t.Error("expected an error listening over a regular file")
+	}
+}
+
+func TestReadyzFailures(t *testing.T) {
+	logger := zerolog.Nop()
+	s := &Server{logger: &logger}
+	atomic.StoreInt32(&ready, 1)
+	defer atomic.StoreInt32(&ready, 0)
+
+	s.AddReadinessCheck(func() error { return nil })
+	recorder := httptest.NewRecorder()
+	s.readyzHandler(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status %d, expected 200", recorder.Code)
+	}
+
+	s.AddReadinessCheck(func() error { return errors.New("partitions [1 3] stalled for more than 2m0s") })
+	recorder = httptest.NewRecorder()
+	s.readyzHandler(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, expected 503", recorder.Code)
+	}
+	var body struct {
+		Status string
+		Errors []string
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "NOT READY" || len(body.Errors) != 1 || body.Errors[0] != "partitions [1 3] stalled for more than 2m0s" {
+		t.Errorf("unexpected body %s", recorder.Body.String())
+	}
+}
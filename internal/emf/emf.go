@@ -0,0 +1,292 @@
+// Package emf writes the core canary metrics as CloudWatch Embedded Metric Format (EMF) JSON lines,
+// for deployments without Prometheus: to stdout, collected with the container logs, or to the
+// CloudWatch agent socket.
+package emf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// Config defines where and how often the EMF lines are written
+type Config struct {
+	Enabled bool `mapstructure:"enabled"`
+	// stdout, or the tcp://host:port or udp://host:port address of the CloudWatch agent
+	Target    string        `mapstructure:"target"`
+	Namespace string        `mapstructure:"namespace"`
+	Interval  time.Duration `mapstructure:"interval"`
+}
+
+// kind is how a core metric is computed from the canary metric families
+type kind int
+
+const (
+	// increase of a counter over the interval, summed over its series
+	counterDelta kind = iota
+	// average of the histogram samples observed over the interval
+	histogramAverage
+	// value of the gauge series with the label stat=<stat>
+	gaugeStat
+	// sum of a gauge series
+	gaugeSum
+	// number of gauge series with the label state=FAILED set
+	gaugeFailed
+)
+
+// coreMetric is a CloudWatch metric computed from a canary metric family
+type coreMetric struct {
+	name   string
+	unit   string
+	family string
+	kind   kind
+	stat   string
+}
+
+// coreMetrics are the metrics written, the families named without the canary namespace
+var coreMetrics = []coreMetric{
+	{name: "RecordsProduced", unit: "Count", family: "records_produced_total", kind: counterDelta},
+	{name: "RecordsProducedFailed", unit: "Count", family: "records_produced_failed_total", kind: counterDelta},
+	{name: "RecordsConsumed", unit: "Count", family: "records_consumed_total", kind: counterDelta},
+	{name: "ProduceLatency", unit: "Milliseconds", family: "records_produced_latency", kind: histogramAverage},
+	{name: "EndToEndLatency", unit: "Milliseconds", family: "records_consumed_latency", kind: histogramAverage},
+	{name: "EndToEndLatencyMin", unit: "Milliseconds", family: "records_consumed_latency_interval", kind: gaugeStat, stat: "min"},
+	{name: "EndToEndLatencyMax", unit: "Milliseconds", family: "records_consumed_latency_interval", kind: gaugeStat, stat: "max"},
+	{name: "ServicesDegraded", unit: "Count", family: "service_degraded", kind: gaugeSum},
+	{name: "ChecksFailed", unit: "Count", family: "check_state", kind: gaugeFailed},
+}
+
+// Exporter writes the core metrics every interval, as the change since the previous write for the
+// counters and histograms
+type Exporter struct {
+	config     Config
+	gatherer   prometheus.Gatherer
+	dimensions map[string]string
+	logger     *zerolog.Logger
+	stdout     io.Writer
+	// connection to the agent, dialed again after a failed write
+	conn io.WriteCloser
+	// totals of the counters and histograms at the previous write, by metric
+	previous map[string]float64
+}
+
+// NewExporter returns an exporter of the metrics gathered from the canary with the given
+// dimensions, e.g. the instance ID
+func NewExporter(config Config, gatherer prometheus.Gatherer, dimensions map[string]string, logger *zerolog.Logger) (*Exporter, error) {
+	if config.Namespace == "" {
+		return nil, errors.New("the EMF output needs a CloudWatch namespace")
+	}
+	if config.Interval <= 0 {
+		return nil, errors.New("the EMF output needs a positive interval")
+	}
+	if config.Target != "stdout" {
+		if _, _, err := agentAddr(config.Target); err != nil {
+			return nil, err
+		}
+	}
+	return &Exporter{
+		config:     config,
+		gatherer:   gatherer,
+		dimensions: dimensions,
+		logger:     logger,
+		stdout:     os.Stdout,
+		previous:   map[string]float64{},
+	}, nil
+}
+
+// agentAddr returns the network and address of a tcp:// or udp:// target
+func agentAddr(target string) (string, string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid EMF target %s: %w", target, err)
+	}
+	if (u.Scheme != "tcp" && u.Scheme != "udp") || u.Host == "" {
+		return "", "", fmt.Errorf("invalid EMF target %s, expected stdout, tcp://host:port or udp://host:port", target)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// Run writes the metrics every interval until stopped, and a last time when stopped
+func (e *Exporter) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+	defer e.close()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.logError(e.Export(now))
+		case <-stop:
+			e.logError(e.Export(time.Now()))
+			return
+		}
+	}
+}
+
+func (e *Exporter) logError(err error) {
+	if err != nil {
+		e.logger.Warn().Err(err).Str("target", e.config.Target).Msg("Error writing the EMF metrics")
+	}
+}
+
+// Export writes the core metrics as an EMF line
+func (e *Exporter) Export(now time.Time) error {
+	line, err := e.line(now)
+	if err != nil {
+		return err
+	}
+	if e.config.Target == "stdout" {
+		_, err := e.stdout.Write(line)
+		return err
+	}
+	if e.conn == nil {
+		network, addr, _ := agentAddr(e.config.Target)
+		conn, err := net.DialTimeout(network, addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	if _, err := e.conn.Write(line); err != nil {
+		e.close()
+		return err
+	}
+	return nil
+}
+
+func (e *Exporter) close() {
+	if e.conn != nil {
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+}
+
+// line returns the EMF document of the core metrics, newline terminated
+func (e *Exporter) line(now time.Time) ([]byte, error) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*familyValues, len(families))
+	for _, family := range families {
+		byName[strings.TrimPrefix(family.GetName(), metrics.Namespace+"_")] = newFamilyValues(family)
+	}
+
+	document := map[string]interface{}{}
+	definitions := make([]map[string]string, 0, len(coreMetrics))
+	for _, metric := range coreMetrics {
+		value, ok := e.value(metric, byName[metric.family])
+		if !ok {
+			continue
+		}
+		document[metric.name] = value
+		definitions = append(definitions, map[string]string{"Name": metric.name, "Unit": metric.unit})
+	}
+
+	dimensions := make([]string, 0, len(e.dimensions))
+	for name, value := range e.dimensions {
+		dimensions = append(dimensions, name)
+		document[name] = value
+	}
+	sort.Strings(dimensions)
+	document["_aws"] = map[string]interface{}{
+		"Timestamp": now.UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  e.config.Namespace,
+			"Dimensions": [][]string{dimensions},
+			"Metrics":    definitions,
+		}},
+	}
+	line, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// value returns the value of the core metric, false when it has none over the interval
+func (e *Exporter) value(metric coreMetric, family *familyValues) (float64, bool) {
+	if family == nil {
+		// the counters not incremented yet are zero, the rest is unknown
+		return 0, metric.kind == counterDelta
+	}
+	switch metric.kind {
+	case counterDelta:
+		return e.delta(metric.name, family.sum), true
+	case histogramAverage:
+		count := e.delta(metric.name+"_count", family.count)
+		sum := e.delta(metric.name+"_sum", family.sum)
+		if count == 0 {
+			return 0, false
+		}
+		return sum / count, true
+	case gaugeStat:
+		value, ok := family.stats[metric.stat]
+		return value, ok
+	case gaugeSum:
+		return family.sum, true
+	case gaugeFailed:
+		return family.failed, true
+	}
+	return 0, false
+}
+
+// delta returns the increase of the total since the previous write. A total below the previous
+// one was reset since, e.g. its series deleted, and counts from zero.
+func (e *Exporter) delta(key string, total float64) float64 {
+	previous := e.previous[key]
+	e.previous[key] = total
+	if total < previous {
+		return total
+	}
+	return total - previous
+}
+
+// familyValues are the values of a metric family aggregated over its series
+type familyValues struct {
+	// counter or gauge values, or histogram samples sum
+	sum float64
+	// histogram samples count
+	count float64
+	// gauge values by their stat label
+	stats map[string]float64
+	// gauge series with the state=FAILED label set
+	failed float64
+}
+
+func newFamilyValues(family *dto.MetricFamily) *familyValues {
+	values := &familyValues{stats: map[string]float64{}}
+	for _, metric := range family.Metric {
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			values.sum += metric.GetCounter().GetValue()
+		case dto.MetricType_HISTOGRAM:
+			values.sum += metric.GetHistogram().GetSampleSum()
+			values.count += float64(metric.GetHistogram().GetSampleCount())
+		case dto.MetricType_GAUGE:
+			value := metric.GetGauge().GetValue()
+			values.sum += value
+			for _, label := range metric.Label {
+				switch {
+				case label.GetName() == "stat":
+					values.stats[label.GetValue()] = value
+				case label.GetName() == "state" && label.GetValue() == "FAILED":
+					values.failed += value
+				}
+			}
+		}
+	}
+	return values
+}
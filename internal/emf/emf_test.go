@@ -0,0 +1,93 @@
+package emf
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	produced := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "kafka_canary_records_produced_total"}, []string{"partition"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "kafka_canary_records_consumed_latency"})
+	interval := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "kafka_canary_records_consumed_latency_interval"}, []string{"stat"})
+	checks := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "kafka_canary_check_state"}, []string{"check", "state"})
+	registry.MustRegister(produced, latency, interval, checks)
+
+	var out bytes.Buffer
+	logger := zerolog.Nop()
+	exporter, err := NewExporter(Config{Target: "stdout", Namespace: "KafkaCanary", Interval: time.Minute},
+		registry, map[string]string{"Instance": "canary-0"}, &logger)
+	require.NoError(t, err)
+	exporter.stdout = &out
+
+	produced.WithLabelValues("0").Add(3)
+	produced.WithLabelValues("1").Add(2)
+	latency.Observe(100)
+	latency.Observe(300)
+	interval.WithLabelValues("min").Set(100)
+	interval.WithLabelValues("max").Set(300)
+	checks.WithLabelValues("message_size", "FAILED").Set(1)
+	checks.WithLabelValues("message_size", "OK").Set(0)
+	require.NoError(t, exporter.Export(time.UnixMilli(1600000000000)))
+
+	// the counters and histograms are written as their change since the previous line
+	produced.WithLabelValues("0").Add(4)
+	require.NoError(t, exporter.Export(time.UnixMilli(1600000060000)))
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var first, second map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &first))
+	require.NoError(t, json.Unmarshal(lines[1], &second))
+
+	assert.Equal(t, "canary-0", first["Instance"])
+	assert.Equal(t, 5.0, first["RecordsProduced"])
+	assert.Equal(t, 200.0, first["EndToEndLatency"])
+	assert.Equal(t, 100.0, first["EndToEndLatencyMin"])
+	assert.Equal(t, 300.0, first["EndToEndLatencyMax"])
+	assert.Equal(t, 1.0, first["ChecksFailed"])
+	aws := first["_aws"].(map[string]interface{})
+	assert.Equal(t, 1600000000000.0, aws["Timestamp"])
+	directive := aws["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "KafkaCanary", directive["Namespace"])
+	assert.Equal(t, []interface{}{[]interface{}{"Instance"}}, directive["Dimensions"])
+	assert.Contains(t, directive["Metrics"], map[string]interface{}{"Name": "RecordsProduced", "Unit": "Count"})
+
+	assert.Equal(t, 4.0, second["RecordsProduced"])
+	// no latency observed over the interval
+	assert.NotContains(t, second, "EndToEndLatency")
+}
+
+func TestExporterAgent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	logger := zerolog.Nop()
+	exporter, err := NewExporter(Config{Target: "tcp://" + listener.Addr().String(), Namespace: "KafkaCanary", Interval: time.Minute}, prometheus.NewRegistry(), nil, &logger)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(time.Now()))
+	defer exporter.close()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Contains(t, string(buf[:n]), `"Namespace":"KafkaCanary"`)
+}
+
+func TestNewExporterInvalidTarget(t *testing.T) {
+	logger := zerolog.Nop()
+	_, err := NewExporter(Config{Target: "http://agent:25888", Namespace: "KafkaCanary", Interval: time.Minute}, prometheus.NewRegistry(), nil, &logger)
+	assert.Error(t, err)
+}
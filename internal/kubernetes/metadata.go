@@ -0,0 +1,178 @@
+// Package kubernetes discovers the pod, node and zone the canary runs in, from the downward API
+// environment variables or else the API server
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// zone labels of the nodes, the deprecated one is still set by some providers
+var zoneLabels = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+
+// Metadata contains the location of the canary pod
+type Metadata struct {
+	Pod       string
+	Namespace string
+	Node      string
+	Zone      string
+}
+
+// Labels returns the metadata as metric labels, skipping the unknown fields
+func (m Metadata) Labels() map[string]string {
+	labels := map[string]string{}
+	for name, value := range map[string]string{
+		"canary_pod":       m.Pod,
+		"canary_namespace": m.Namespace,
+		"canary_node":      m.Node,
+		"canary_zone":      m.Zone,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// InCluster returns true when running in a Kubernetes pod
+func InCluster() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// Discover returns the canary pod metadata. POD_NAME, POD_NAMESPACE, NODE_NAME and ZONE set
+// through the downward API take precedence, the rest is looked up in the API server, which
+// needs get permissions on the pod and its node. The metadata found so far is returned along
+// with any error.
+func Discover(ctx context.Context) (Metadata, error) {
+	m := Metadata{
+		Pod:       os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Node:      os.Getenv("NODE_NAME"),
+		Zone:      os.Getenv("ZONE"),
+	}
+	if m.Pod == "" {
+		m.Pod, _ = os.Hostname()
+	}
+	if m.Namespace == "" {
+		namespace, err := Namespace()
+		if err != nil {
+			return m, err
+		}
+		m.Namespace = namespace
+	}
+	if m.Node != "" && m.Zone != "" {
+		return m, nil
+	}
+
+	client, err := NewClient()
+	if err != nil {
+		return m, err
+	}
+	return client.complete(ctx, m)
+}
+
+// Namespace returns the namespace of the canary pod, from POD_NAMESPACE or the service account
+func Namespace() (string, error) {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace, nil
+	}
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(namespace)), nil
+}
+
+// Client is a minimal API server client authenticated with the pod service account
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient returns a client for the API server of the cluster the canary runs in
+func NewClient() (*Client, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account CA certificate")
+	}
+
+	return &Client{
+		baseURL: "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")),
+		token:   strings.TrimSpace(string(token)),
+		http: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// complete fills the node from the pod spec and the zone from the node labels
+func (c *Client) complete(ctx context.Context, m Metadata) (Metadata, error) {
+	if m.Node == "" {
+		var pod struct {
+			Spec struct {
+				NodeName string `json:"nodeName"`
+			} `json:"spec"`
+		}
+		if err := c.get(ctx, "/api/v1/namespaces/"+m.Namespace+"/pods/"+m.Pod, &pod); err != nil {
+			return m, err
+		}
+		m.Node = pod.Spec.NodeName
+	}
+
+	if m.Zone == "" && m.Node != "" {
+		var node struct {
+			Metadata struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+		}
+		if err := c.get(ctx, "/api/v1/nodes/"+m.Node, &node); err != nil {
+			return m, err
+		}
+		for _, label := range zoneLabels {
+			if zone, ok := node.Metadata.Labels[label]; ok {
+				m.Zone = zone
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
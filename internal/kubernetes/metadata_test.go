@@ -0,0 +1,40 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/v1/namespaces/kafka/pods/canary-0":
+			_, _ = w.Write([]byte(`{"spec": {"nodeName": "node-a"}}`))
+		case "/api/v1/nodes/node-a":
+			_, _ = w.Write([]byte(`{"metadata": {"labels": {"topology.kubernetes.io/zone": "eu-west-1a"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{baseURL: server.URL, token: "token", http: server.Client()}
+	m, err := client.complete(context.Background(), Metadata{Pod: "canary-0", Namespace: "kafka"})
+	require.NoError(t, err)
+	assert.Equal(t, Metadata{Pod: "canary-0", Namespace: "kafka", Node: "node-a", Zone: "eu-west-1a"}, m)
+	assert.Equal(t, map[string]string{
+		"canary_pod":       "canary-0",
+		"canary_namespace": "kafka",
+		"canary_node":      "node-a",
+		"canary_zone":      "eu-west-1a",
+	}, m.Labels())
+
+	_, err = client.complete(context.Background(), Metadata{Pod: "missing", Namespace: "kafka"})
+	assert.ErrorContains(t, err, "404")
+}
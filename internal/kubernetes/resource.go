@@ -0,0 +1,35 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+)
+
+// KafkaCanaryGroupVersion is the API group and version of the KafkaCanary custom resource
+const KafkaCanaryGroupVersion = "canary.pecigonzalo.github.io/v1alpha1"
+
+// KafkaCanary is the custom resource configuring the canary in operator mode
+type KafkaCanary struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		Generation      int64  `json:"generation"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec KafkaCanarySpec `json:"spec"`
+}
+
+// KafkaCanarySpec contains the cluster to check and the canary settings, using the same keys as
+// the canary section of the configuration file
+type KafkaCanarySpec struct {
+	Brokers []string               `json:"brokers"`
+	Canary  map[string]interface{} `json:"canary"`
+}
+
+// KafkaCanary returns the KafkaCanary resource with the given name
+func (c *Client) KafkaCanary(ctx context.Context, namespace, name string) (KafkaCanary, error) {
+	var resource KafkaCanary
+	path := fmt.Sprintf("/apis/%s/namespaces/%s/kafkacanaries/%s", KafkaCanaryGroupVersion, namespace, name)
+	err := c.get(ctx, path, &resource)
+	return resource, err
+}
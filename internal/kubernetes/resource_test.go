@@ -0,0 +1,35 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafkaCanary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/canary.pecigonzalo.github.io/v1alpha1/namespaces/kafka/kafkacanaries/canary" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+			"metadata": {"name": "canary", "namespace": "kafka", "generation": 3},
+			"spec": {"brokers": ["broker:9092"], "canary": {"topic": "__canary"}}
+		}`))
+	}))
+	defer server.Close()
+
+	client := &Client{baseURL: server.URL, token: "token", http: server.Client()}
+	resource, err := client.KafkaCanary(context.Background(), "kafka", "canary")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resource.Metadata.Generation)
+	assert.Equal(t, []string{"broker:9092"}, resource.Spec.Brokers)
+	assert.Equal(t, map[string]interface{}{"topic": "__canary"}, resource.Spec.Canary)
+
+	_, err = client.KafkaCanary(context.Background(), "kafka", "missing")
+	assert.ErrorContains(t, err, "404")
+}
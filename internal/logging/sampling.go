@@ -0,0 +1,78 @@
+// Package logging provides helpers to control the canary logs
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// RepeatSamplingHook drops repetitive warning and error logs, only letting through the first
+// occurrence of a message and then one every N identical ones in each period.
+// Logged events include the number of occurrences suppressed since the previous one. Messages are
+// told apart by their level and text only, the sampled ones log their variable parts as fields.
+type RepeatSamplingHook struct {
+	N      uint32
+	Period time.Duration
+
+	mu       sync.Mutex
+	counters map[string]*repeatCounter
+	now      func() time.Time
+}
+
+type repeatCounter struct {
+	resetAt    time.Time
+	count      uint32
+	suppressed uint32
+}
+
+// NewRepeatSamplingHook returns a hook letting through one every n identical messages per period
+func NewRepeatSamplingHook(n uint32, period time.Duration) *RepeatSamplingHook {
+	return &RepeatSamplingHook{
+		N:        n,
+		Period:   period,
+		counters: map[string]*repeatCounter{},
+		now:      time.Now,
+	}
+}
+
+// Run implements zerolog.Hook
+func (h *RepeatSamplingHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if h.N <= 1 || level < zerolog.WarnLevel {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	key := level.String() + msg
+	counter, ok := h.counters[key]
+	if !ok || now.After(counter.resetAt) {
+		if ok && counter.suppressed > 0 {
+			e.Uint32("suppressed", counter.suppressed)
+		}
+		h.counters[key] = &repeatCounter{resetAt: now.Add(h.Period), count: 1}
+		h.cleanup(now)
+		return
+	}
+
+	counter.count++
+	if (counter.count-1)%h.N != 0 {
+		counter.suppressed++
+		e.Discard()
+		return
+	}
+	e.Uint32("suppressed", counter.suppressed)
+	counter.suppressed = 0
+}
+
+// cleanup drops expired counters, so unique messages don't accumulate forever
+func (h *RepeatSamplingHook) cleanup(now time.Time) {
+	for key, counter := range h.counters {
+		if now.After(counter.resetAt) {
+			delete(h.counters, key)
+		}
+	}
+}
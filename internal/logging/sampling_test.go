@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRepeatSamplingHook(t *testing.T) {
+	now := time.Unix(0, 0)
+	hook := NewRepeatSamplingHook(3, time.Minute)
+	hook.now = func() time.Time { return now }
+
+	var out bytes.Buffer
+	logger := zerolog.New(&out).Hook(hook)
+
+	for i := 0; i < 7; i++ {
+		logger.Error().Msg("broker down")
+	}
+	logger.Info().Msg("info is never sampled")
+	logger.Error().Msg("another error")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	// 1st, 4th and 7th "broker down", the info and the other error
+	if len(lines) != 5 {
+		t.Fatalf("got = %d lines, want = %d: %v", len(lines), 5, lines)
+	}
+	if !strings.Contains(lines[1], `"suppressed":2`) {
+		t.Errorf("expected suppressed count in %s", lines[1])
+	}
+
+	// a new period lets the message through again
+	out.Reset()
+	now = now.Add(2 * time.Minute)
+	logger.Error().Msg("broker down")
+	if !strings.Contains(out.String(), "broker down") {
+		t.Errorf("expected the message to be logged after the period")
+	}
+}
+
+func TestRepeatSamplingHookFields(t *testing.T) {
+	hook := NewRepeatSamplingHook(3, time.Minute)
+	var out bytes.Buffer
+	logger := zerolog.New(&out).Hook(hook)
+
+	// the variable parts are fields, the message is sampled whatever their values
+	for i := 0; i < 3; i++ {
+		logger.Warn().Int("partition", i).Str("error", "timeout").Msg("Got connection error reading from partition, retrying")
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got = %d lines, want = %d: %v", len(lines), 1, lines)
+	}
+}
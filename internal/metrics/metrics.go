@@ -0,0 +1,367 @@
+// Package metrics holds the registries of the canary metrics, exposed under a configurable
+// namespace and subsystem on the registerer of the binary or of the embedding service. The
+// metrics are defined once by the packages and held by the registry of every canary.
+package metrics
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Namespace is the namespace the canary metrics are defined with
+const Namespace = "kafka_canary"
+
+// Registry holds the metrics of a canary, every canary has its own so the canaries of a process
+// don't share their values. It isn't exposed by itself, see Collector.
+type Registry struct {
+	*prometheus.Registry
+
+	lock sync.RWMutex
+	// the defined metrics by definition ID
+	collectors []prometheus.Collector
+
+	baselineLock sync.RWMutex
+	// counters, histograms and summaries values at the last reset by series, subtracted when
+	// collected
+	baseline map[string]*dto.Metric
+}
+
+var (
+	definitionsLock sync.RWMutex
+	// constructors of the defined metrics by definition ID
+	definitions []func() prometheus.Collector
+
+	unexposedOnce sync.Once
+	// holds the metrics recorded without a registry, e.g. by the clients built outside a canary
+	unexposed *Registry
+)
+
+// NewRegistry returns a registry holding every defined metric
+func NewRegistry() *Registry {
+	r := &Registry{Registry: prometheus.NewRegistry(), baseline: map[string]*dto.Metric{}}
+	definitionsLock.RLock()
+	defined := len(definitions)
+	definitionsLock.RUnlock()
+	for id := 0; id < defined; id++ {
+		r.collector(id)
+	}
+	return r
+}
+
+// define adds a metric to the registries, returning its definition ID
+func define(newCollector func() prometheus.Collector) int {
+	definitionsLock.Lock()
+	defer definitionsLock.Unlock()
+	definitions = append(definitions, newCollector)
+	return len(definitions) - 1
+}
+
+// collector returns the metric of the definition held by the registry, creating it for the
+// metrics defined after the registry. A nil registry records into one never exposed.
+func (r *Registry) collector(id int) prometheus.Collector {
+	if r == nil {
+		unexposedOnce.Do(func() { unexposed = NewRegistry() })
+		r = unexposed
+	}
+	r.lock.RLock()
+	if id < len(r.collectors) && r.collectors[id] != nil {
+		c := r.collectors[id]
+		r.lock.RUnlock()
+		return c
+	}
+	r.lock.RUnlock()
+
+	definitionsLock.RLock()
+	newCollector := definitions[id]
+	definitionsLock.RUnlock()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for len(r.collectors) <= id {
+		r.collectors = append(r.collectors, nil)
+	}
+	if r.collectors[id] == nil {
+		c := newCollector()
+		r.MustRegister(c)
+		r.collectors[id] = c
+	}
+	return r.collectors[id]
+}
+
+// Definition is a metric defined once and held by every registry
+type Definition interface {
+	// CollectorIn returns the metric held by the registry
+	CollectorIn(r *Registry) prometheus.Collector
+}
+
+// Counter is a defined prometheus.Counter
+type Counter struct{ id int }
+
+// NewCounter defines a counter
+func NewCounter(opts prometheus.CounterOpts) Counter {
+	return Counter{define(func() prometheus.Collector { return prometheus.NewCounter(opts) })}
+}
+
+// In returns the counter held by the registry
+func (m Counter) In(r *Registry) prometheus.Counter { return r.collector(m.id).(prometheus.Counter) }
+
+// CollectorIn returns the counter held by the registry as a collector
+func (m Counter) CollectorIn(r *Registry) prometheus.Collector { return r.collector(m.id) }
+
+// CounterVec is a defined *prometheus.CounterVec
+type CounterVec struct{ id int }
+
+// NewCounterVec defines a counter vector
+func NewCounterVec(opts prometheus.CounterOpts, labels []string) CounterVec {
+	return CounterVec{define(func() prometheus.Collector { return prometheus.NewCounterVec(opts, labels) })}
+}
+
+// In returns the counter vector held by the registry
+func (m CounterVec) In(r *Registry) *prometheus.CounterVec {
+	return r.collector(m.id).(*prometheus.CounterVec)
+}
+
+// CollectorIn returns the counter vector held by the registry as a collector
+func (m CounterVec) CollectorIn(r *Registry) prometheus.Collector { return r.collector(m.id) }
+
+// Gauge is a defined prometheus.Gauge
+type Gauge struct{ id int }
+
+// NewGauge defines a gauge
+func NewGauge(opts prometheus.GaugeOpts) Gauge {
+	return Gauge{define(func() prometheus.Collector { return prometheus.NewGauge(opts) })}
+}
+
+// In returns the gauge held by the registry
+func (m Gauge) In(r *Registry) prometheus.Gauge { return r.collector(m.id).(prometheus.Gauge) }
+
+// CollectorIn returns the gauge held by the registry as a collector
+func (m Gauge) CollectorIn(r *Registry) prometheus.Collector { return r.collector(m.id) }
+
+// GaugeVec is a defined *prometheus.GaugeVec
+type GaugeVec struct{ id int }
+
+// NewGaugeVec defines a gauge vector
+func NewGaugeVec(opts prometheus.GaugeOpts, labels []string) GaugeVec {
+	return GaugeVec{define(func() prometheus.Collector { return prometheus.NewGaugeVec(opts, labels) })}
+}
+
+// In returns the gauge vector held by the registry
+func (m GaugeVec) In(r *Registry) *prometheus.GaugeVec {
+	return r.collector(m.id).(*prometheus.GaugeVec)
+}
+
+// CollectorIn returns the gauge vector held by the registry as a collector
+func (m GaugeVec) CollectorIn(r *Registry) prometheus.Collector { return r.collector(m.id) }
+
+// Histogram is a defined prometheus.Histogram
+type Histogram struct{ id int }
+
+// NewHistogram defines a histogram
+func NewHistogram(opts prometheus.HistogramOpts) Histogram {
+	return Histogram{define(func() prometheus.Collector { return prometheus.NewHistogram(opts) })}
+}
+
+// In returns the histogram held by the registry
+func (m Histogram) In(r *Registry) prometheus.Histogram {
+	return r.collector(m.id).(prometheus.Histogram)
+}
+
+// CollectorIn returns the histogram held by the registry as a collector
+func (m Histogram) CollectorIn(r *Registry) prometheus.Collector { return r.collector(m.id) }
+
+// HistogramVec is a defined *prometheus.HistogramVec
+type HistogramVec struct{ id int }
+
+// NewHistogramVec defines a histogram vector
+func NewHistogramVec(opts prometheus.HistogramOpts, labels []string) HistogramVec {
+	return HistogramVec{define(func() prometheus.Collector { return prometheus.NewHistogramVec(opts, labels) })}
+}
+
+// In returns the histogram vector held by the registry
+func (m HistogramVec) In(r *Registry) *prometheus.HistogramVec {
+	return r.collector(m.id).(*prometheus.HistogramVec)
+}
+
+// CollectorIn returns the histogram vector held by the registry as a collector
+func (m HistogramVec) CollectorIn(r *Registry) prometheus.Collector { return r.collector(m.id) }
+
+// Reset starts the counters, histograms and summaries over from zero, e.g. for a clean baseline
+// after recreating a test cluster. The collected values are those since the reset, the gauges
+// are left alone as they report a current state.
+func (r *Registry) Reset() error {
+	families, err := r.Gather()
+	if err != nil {
+		return err
+	}
+	values := map[string]*dto.Metric{}
+	for _, family := range families {
+		switch family.GetType() {
+		case dto.MetricType_COUNTER, dto.MetricType_HISTOGRAM, dto.MetricType_SUMMARY:
+			for _, metric := range family.Metric {
+				values[seriesKey(family.GetName(), metric)] = metric
+			}
+		}
+	}
+	r.baselineLock.Lock()
+	r.baseline = values
+	r.baselineLock.Unlock()
+	return nil
+}
+
+// seriesKey identifies the series of the metric family by its label values
+func seriesKey(name string, metric *dto.Metric) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, label := range metric.Label {
+		b.WriteByte(0)
+		b.WriteString(label.GetName())
+		b.WriteByte('=')
+		b.WriteString(label.GetValue())
+	}
+	return b.String()
+}
+
+// Collector returns a collector exposing the metrics of the registry since its last reset, with
+// the given namespace and subsystem instead of the default namespace
+func (r *Registry) Collector(namespace, subsystem string) prometheus.Collector {
+	return renamingCollector{gatherer: r, namespace: namespace, subsystem: subsystem, baseline: r.baselineOf}
+}
+
+// baselineOf returns the value of the series at the last reset, nil without one
+func (r *Registry) baselineOf(key string) *dto.Metric {
+	r.baselineLock.RLock()
+	defer r.baselineLock.RUnlock()
+	return r.baseline[key]
+}
+
+// Rename returns a collector exposing the metrics of the gatherer with the given namespace and
+// subsystem instead of the default namespace, e.g. the metrics of the binary
+func Rename(gatherer prometheus.Gatherer, namespace, subsystem string) prometheus.Collector {
+	return renamingCollector{gatherer: gatherer, namespace: namespace, subsystem: subsystem}
+}
+
+// renamingCollector is an unchecked collector, the metrics are only known once collected
+type renamingCollector struct {
+	gatherer  prometheus.Gatherer
+	namespace string
+	subsystem string
+	// value of the series at the last reset, nil without resets
+	baseline func(key string) *dto.Metric
+}
+
+func (c renamingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c renamingCollector) Collect(ch chan<- prometheus.Metric) {
+	families, err := c.gatherer.Gather()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc(c.rename(Namespace+"_gather_error"), "Error gathering the canary metrics", nil, nil), err)
+	}
+	for _, family := range families {
+		name := c.rename(family.GetName())
+		for _, metric := range family.Metric {
+			if c.baseline != nil {
+				metric = sinceBaseline(family.GetType(), metric, c.baseline(seriesKey(family.GetName(), metric)))
+			}
+			names := make([]string, 0, len(metric.Label))
+			values := make([]string, 0, len(metric.Label))
+			for _, label := range metric.Label {
+				names = append(names, label.GetName())
+				values = append(values, label.GetValue())
+			}
+			desc := prometheus.NewDesc(name, family.GetHelp(), names, nil)
+			if m, err := constMetric(desc, family.GetType(), metric, values); err != nil {
+				ch <- prometheus.NewInvalidMetric(desc, err)
+			} else {
+				ch <- m
+			}
+		}
+	}
+}
+
+// rename replaces the default namespace of the metric name with the configured one
+func (c renamingCollector) rename(name string) string {
+	if c.namespace == Namespace && c.subsystem == "" {
+		return name
+	}
+	if !strings.HasPrefix(name, Namespace+"_") {
+		return name
+	}
+	return prometheus.BuildFQName(c.namespace, c.subsystem, strings.TrimPrefix(name, Namespace+"_"))
+}
+
+// constMetric returns a snapshot of the gathered metric
+func constMetric(desc *prometheus.Desc, kind dto.MetricType, metric *dto.Metric, values []string) (prometheus.Metric, error) {
+	switch kind {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, metric.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, metric.GetGauge().GetValue(), values...)
+	case dto.MetricType_HISTOGRAM:
+		h := metric.GetHistogram()
+		buckets := make(map[float64]uint64, len(h.GetBucket()))
+		for _, b := range h.GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		return prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, values...)
+	case dto.MetricType_SUMMARY:
+		s := metric.GetSummary()
+		quantiles := make(map[float64]float64, len(s.GetQuantile()))
+		for _, q := range s.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return prometheus.NewConstSummary(desc, s.GetSampleCount(), s.GetSampleSum(), quantiles, values...)
+	default:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, metric.GetUntyped().GetValue(), values...)
+	}
+}
+
+// sinceBaseline returns the metric minus its value at the last reset. A series that went below
+// its baseline was deleted and created again since, it's returned as is.
+func sinceBaseline(kind dto.MetricType, metric, base *dto.Metric) *dto.Metric {
+	if base == nil {
+		return metric
+	}
+	switch kind {
+	case dto.MetricType_COUNTER:
+		value, baseValue := metric.GetCounter().GetValue(), base.GetCounter().GetValue()
+		if value < baseValue {
+			return metric
+		}
+		return &dto.Metric{Label: metric.Label, Counter: &dto.Counter{Value: float64Ptr(value - baseValue)}}
+	case dto.MetricType_HISTOGRAM:
+		h, baseH := metric.GetHistogram(), base.GetHistogram()
+		if h.GetSampleCount() < baseH.GetSampleCount() || len(h.GetBucket()) != len(baseH.GetBucket()) {
+			return metric
+		}
+		buckets := make([]*dto.Bucket, len(h.GetBucket()))
+		for i, b := range h.GetBucket() {
+			buckets[i] = &dto.Bucket{
+				UpperBound:      b.UpperBound,
+				CumulativeCount: uint64Ptr(b.GetCumulativeCount() - baseH.GetBucket()[i].GetCumulativeCount()),
+			}
+		}
+		return &dto.Metric{Label: metric.Label, Histogram: &dto.Histogram{
+			SampleCount: uint64Ptr(h.GetSampleCount() - baseH.GetSampleCount()),
+			SampleSum:   float64Ptr(h.GetSampleSum() - baseH.GetSampleSum()),
+			Bucket:      buckets,
+		}}
+	case dto.MetricType_SUMMARY:
+		s, baseS := metric.GetSummary(), base.GetSummary()
+		if s.GetSampleCount() < baseS.GetSampleCount() {
+			return metric
+		}
+		return &dto.Metric{Label: metric.Label, Summary: &dto.Summary{
+			SampleCount: uint64Ptr(s.GetSampleCount() - baseS.GetSampleCount()),
+			SampleSum:   float64Ptr(s.GetSampleSum() - baseS.GetSampleSum()),
+			Quantile:    s.GetQuantile(),
+		}}
+	}
+	return metric
+}
+
+func float64Ptr(v float64) *float64 { return &v }
+
+func uint64Ptr(v uint64) *uint64 { return &v }
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRenamingCollector(t *testing.T) {
+	source := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "records_total", Namespace: Namespace, Help: "test",
+	}, []string{"partition"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "latency", Namespace: Namespace, Help: "test", Buckets: []float64{10, 100},
+	})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "other", Help: "test"})
+	source.MustRegister(counter, histogram, other)
+	counter.WithLabelValues("0").Add(2)
+	histogram.Observe(50)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(renamingCollector{gatherer: source, namespace: "edge", subsystem: "canary"})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, family := range families {
+		got[family.GetName()] = true
+		switch family.GetName() {
+		case "edge_canary_records_total":
+			metric := family.Metric[0]
+			if metric.GetCounter().GetValue() != 2 || metric.Label[0].GetValue() != "0" {
+				t.Errorf("got = %v, want = records_total{partition=\"0\"} 2", metric)
+			}
+		case "edge_canary_latency":
+			if count := family.Metric[0].GetHistogram().GetSampleCount(); count != 1 {
+				t.Errorf("got = %d samples, want = 1", count)
+			}
+		}
+	}
+	for _, name := range []string{"edge_canary_records_total", "edge_canary_latency", "other"} {
+		if !got[name] {
+			t.Errorf("missing metric %s in %v", name, got)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "reset_records_total", Namespace: Namespace, Help: "test",
+	}, []string{"partition"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "reset_latency", Namespace: Namespace, Help: "test", Buckets: []float64{10, 100},
+	})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "reset_up", Namespace: Namespace, Help: "test"})
+	source := NewRegistry()
+	source.MustRegister(counter, histogram, gauge)
+
+	counter.WithLabelValues("0").Add(5)
+	histogram.Observe(50)
+	gauge.Set(1)
+	if err := source.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	counter.WithLabelValues("0").Add(2)
+	counter.WithLabelValues("1").Inc()
+	histogram.Observe(5)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(source.Collector(Namespace, ""))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, family := range families {
+		switch family.GetName() {
+		case "kafka_canary_reset_records_total":
+			for _, metric := range family.Metric {
+				want := map[string]float64{"0": 2, "1": 1}[metric.Label[0].GetValue()]
+				if got := metric.GetCounter().GetValue(); got != want {
+					t.Errorf("got = %g records on partition %s, want = %g", got, metric.Label[0].GetValue(), want)
+				}
+			}
+		case "kafka_canary_reset_latency":
+			h := family.Metric[0].GetHistogram()
+			if h.GetSampleCount() != 1 || h.GetSampleSum() != 5 || h.GetBucket()[0].GetCumulativeCount() != 1 {
+				t.Errorf("got = %v, want = the sample observed since the reset", h)
+			}
+		case "kafka_canary_reset_up":
+			if got := family.Metric[0].GetGauge().GetValue(); got != 1 {
+				t.Errorf("got = %g, want = the gauge left alone", got)
+			}
+		}
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	records := NewCounterVec(prometheus.CounterOpts{
+		Name: "defined_records_total", Namespace: Namespace, Help: "test",
+	}, []string{"partition"})
+	first, second := NewRegistry(), NewRegistry()
+	records.In(first).WithLabelValues("0").Add(2)
+	records.In(second).WithLabelValues("0").Inc()
+
+	// defined after the registries were created
+	up := NewGauge(prometheus.GaugeOpts{Name: "defined_up", Namespace: Namespace, Help: "test"})
+	up.In(first).Set(1)
+
+	for _, tt := range []struct {
+		registry *Registry
+		records  float64
+		up       float64
+	}{{first, 2, 1}, {second, 1, 0}} {
+		families, err := tt.registry.Gather()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		values := map[string]float64{}
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				values[family.GetName()] = metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+			}
+		}
+		if values["kafka_canary_defined_records_total"] != tt.records || values["kafka_canary_defined_up"] != tt.up {
+			t.Errorf("got = %v, want = %g records and up %g", values, tt.records, tt.up)
+		}
+	}
+
+	// without a registry the metrics are recorded but never exposed
+	records.In(nil).WithLabelValues("0").Inc()
+}
@@ -0,0 +1,103 @@
+// Package ntp queries the clock offset from an NTP server, SNTP style (RFC 4330)
+package ntp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// seconds between the NTP era (1900) and the Unix epoch
+const eraOffset = 2208988800
+
+const (
+	packetSize = 48
+	modeClient = 3
+	modeServer = 4
+	version    = 4
+)
+
+// Response is the result of a query
+type Response struct {
+	// server clock minus the local one, positive when the local clock is behind
+	Offset time.Duration
+	// round trip to the server, without its processing time
+	RTT     time.Duration
+	Stratum uint8
+}
+
+// Query asks the server, a host with an optional port defaulting to 123, for its time
+func Query(ctx context.Context, server string) (Response, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return Response{}, err
+		}
+	}
+
+	request := make([]byte, packetSize)
+	request[0] = version<<3 | modeClient
+	sent := time.Now()
+	// the server echoes the transmit timestamp, matching the response to the request
+	binary.BigEndian.PutUint64(request[40:], toNTP(sent))
+	if _, err := conn.Write(request); err != nil {
+		return Response{}, err
+	}
+
+	response := make([]byte, packetSize)
+	n, err := conn.Read(response)
+	received := time.Now()
+	if err != nil {
+		return Response{}, err
+	}
+	return parse(response[:n], request, sent, received)
+}
+
+// parse computes the clock offset from the server response to the request
+func parse(response, request []byte, sent, received time.Time) (Response, error) {
+	if len(response) < packetSize {
+		return Response{}, fmt.Errorf("short NTP response of %d bytes", len(response))
+	}
+	if mode := response[0] & 0x7; mode != modeServer {
+		return Response{}, fmt.Errorf("unexpected NTP response mode %d", mode)
+	}
+	if string(response[24:32]) != string(request[40:48]) {
+		return Response{}, errors.New("NTP response to another request")
+	}
+	stratum := response[1]
+	if stratum == 0 {
+		return Response{}, fmt.Errorf("NTP kiss-of-death %q", response[12:16])
+	}
+
+	receive := fromNTP(binary.BigEndian.Uint64(response[32:]))
+	transmit := fromNTP(binary.BigEndian.Uint64(response[40:]))
+	return Response{
+		Offset:  (receive.Sub(sent) + transmit.Sub(received)) / 2,
+		RTT:     received.Sub(sent) - transmit.Sub(receive),
+		Stratum: stratum,
+	}, nil
+}
+
+// toNTP returns the 64 bits NTP timestamp of the time, 32 bits of seconds and 32 of fraction
+func toNTP(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + eraOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+func fromNTP(timestamp uint64) time.Time {
+	seconds := int64(timestamp>>32) - eraOffset
+	nanos := int64((timestamp & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
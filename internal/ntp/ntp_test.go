@@ -0,0 +1,78 @@
+package ntp
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	// a server 2 seconds ahead
+	go func() {
+		buf := make([]byte, packetSize)
+		_, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		now := toNTP(time.Now().Add(2 * time.Second))
+		response := make([]byte, packetSize)
+		response[0] = version<<3 | modeServer
+		response[1] = 2
+		copy(response[24:32], buf[40:48])
+		binary.BigEndian.PutUint64(response[32:], now)
+		binary.BigEndian.PutUint64(response[40:], now)
+		_, _ = conn.WriteTo(response, addr)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response, err := Query(ctx, conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.Offset < 1900*time.Millisecond || response.Offset > 2100*time.Millisecond {
+		t.Errorf("got offset = %v, want = ~2s", response.Offset)
+	}
+	if response.Stratum != 2 {
+		t.Errorf("got stratum = %d, want = 2", response.Stratum)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	request := make([]byte, packetSize)
+	binary.BigEndian.PutUint64(request[40:], 42)
+	now := time.Now()
+
+	for name, response := range map[string]func([]byte){
+		"mode":          func(r []byte) { r[0] = version<<3 | modeClient },
+		"other request": func(r []byte) { r[24] = 1 },
+		"kiss-of-death": func(r []byte) { r[1] = 0 },
+	} {
+		r := make([]byte, packetSize)
+		r[0] = version<<3 | modeServer
+		r[1] = 1
+		copy(r[24:32], request[40:48])
+		response(r)
+		if _, err := parse(r, request, now, now); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := parse(make([]byte, 12), request, now, now); err == nil {
+		t.Error("short: expected an error")
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+	if got := fromNTP(toNTP(now)); got.Sub(now) > time.Microsecond || got.Sub(now) < -time.Microsecond {
+		t.Errorf("got = %v, want = %v", got, now)
+	}
+}
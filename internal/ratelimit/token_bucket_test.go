@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewTokenBucket(2, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("expected the burst to be allowed")
+	}
+	if b.Allow() {
+		t.Fatal("expected the bucket to be empty")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("expected a token after refilling")
+	}
+	if b.Allow() {
+		t.Fatal("expected the bucket to be empty")
+	}
+
+	now = now.Add(time.Hour)
+	if !b.Allow() || !b.Allow() || b.Allow() {
+		t.Fatal("expected the refill to be capped at the burst")
+	}
+}
+
+func TestTokenBucketDisabled(t *testing.T) {
+	b := NewTokenBucket(0, 1)
+	for i := 0; i < 100; i++ {
+		if !b.Allow() {
+			t.Fatal("expected a disabled bucket to always allow")
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Package service integrates the canary with the operating system service manager
+package service
+
+// Name is the name the canary is registered with in the service manager
+const Name = "kafka-canary"
+
+// Control actions accepted by Control
+const (
+	ActionInstall   = "install"
+	ActionUninstall = "uninstall"
+	ActionStart     = "start"
+	ActionStop      = "stop"
+)
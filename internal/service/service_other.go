@@ -0,0 +1,20 @@
+//go:build !windows
+
+package service
+
+import "fmt"
+
+// IsService returns true when the process was started by the service manager
+func IsService() (bool, error) {
+	return false, nil
+}
+
+// Run runs the given function as a service until the service manager stops it
+func Run(run func(stopCh <-chan struct{})) error {
+	return fmt.Errorf("running as a service is only supported on Windows")
+}
+
+// Control performs the given action on the canary service, installing it with the given arguments
+func Control(action string, args []string) error {
+	return fmt.Errorf("service %s is only supported on Windows, use the system service manager", action)
+}
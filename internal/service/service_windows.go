@@ -0,0 +1,114 @@
+//go:build windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// IsService returns true when the process was started by the service manager
+func IsService() (bool, error) {
+	return svc.IsWindowsService()
+}
+
+// Run runs the given function as a service until the service manager stops it
+func Run(run func(stopCh <-chan struct{})) error {
+	return svc.Run(Name, &handler{run: run})
+}
+
+type handler struct {
+	run func(stopCh <-chan struct{})
+}
+
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.run(stop)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		case <-done:
+			// the canary exited on its own
+			return false, 1
+		}
+	}
+}
+
+// Control performs the given action on the canary service, installing it with the given arguments
+func Control(action string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if action == ActionInstall {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		s, err := m.CreateService(Name, exe, mgr.Config{
+			DisplayName: "Kafka Canary",
+			Description: "Checks the availability of Kafka clusters",
+			StartType:   mgr.StartAutomatic,
+		}, args...)
+		if err != nil {
+			return err
+		}
+		return s.Close()
+	}
+
+	s, err := m.OpenService(Name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	switch action {
+	case ActionUninstall:
+		return s.Delete()
+	case ActionStart:
+		return s.Start()
+	case ActionStop:
+		_, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		// wait for the canary to shut down gracefully
+		deadline := time.Now().Add(30 * time.Second)
+		for time.Now().Before(deadline) {
+			st, err := s.Query()
+			if err != nil {
+				return err
+			}
+			if st.State == svc.Stopped {
+				return nil
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+		return fmt.Errorf("timeout waiting for the service to stop")
+	default:
+		return fmt.Errorf("unknown service action: %s", action)
+	}
+}
@@ -0,0 +1,56 @@
+// Package systemd implements the sd_notify protocol, so systemd knows when the canary is ready
+// and restarts it when the watchdog keepalives stop
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Ready tells systemd the service finished starting up
+	Ready = "READY=1"
+	// Stopping tells systemd the service is shutting down
+	Stopping = "STOPPING=1"
+	// Watchdog is the keepalive expected at least every WatchdogInterval
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends the state to systemd, it returns false without error when not running under
+// systemd, i.e. NOTIFY_SOCKET isn't set
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// abstract sockets are prefixed with @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval systemd expects keepalives at, or 0 when the watchdog
+// isn't enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -3,127 +3,522 @@ package workers
 
 import (
 	"context"
-	"reflect"
+	"runtime/debug"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 
-	"github.com/pecigonzalo/kafka-canary/internal/canary"
-	"github.com/pecigonzalo/kafka-canary/internal/services"
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
+	"github.com/pecigonzalo/kafka-canary/pkg/services/util"
 )
 
 // Worker interface exposing main operations on canary workers
 type Worker interface {
-	Start()
+	Start() error
 	Stop()
 }
 
 // CanaryManager defines the manager driving the different producer, consumer and topic services
 type CanaryManager struct {
+	state             *services.State
 	canaryConfig      *canary.Config
 	topicService      services.TopicService
 	producerService   services.ProducerService
 	consumerService   services.ConsumerService
 	connectionService services.ConnectionService
 	statusService     services.StatusService
+	checks            []services.CheckService
+	checksLastRun     map[string]time.Time
+	checksRunning     map[string]bool
+	checksIntervals   map[string]time.Duration
+	checksSuccesses   map[string]int
+	checksLock        sync.Mutex
+	checksWait        sync.WaitGroup
+	breachesWait      sync.WaitGroup
+	callbacks         services.Callbacks
+	consuming         bool
 	stop              chan struct{}
 	syncStop          sync.WaitGroup
 	logger            *zerolog.Logger
+
+	// records produced with a latency SLO and the ones breaching it
+	sloRecords  *util.SlidingWindow
+	sloBreaches *util.SlidingWindow
+}
+
+func init() {
+	services.TrackPartitionLabel(latencySLOBreaches, "partition")
+	services.TrackBrokerLabel(latencySLOBreaches, "leader")
+	services.AggregateByRack(latencySLOBreaches, "leader", "rack_produce_latency_slo_breach_total",
+		"Total number of records produced breaching the latency SLO, by rack of the partition leader")
 }
 
+// resolution of the checks scheduling
+const checksSchedulerTick = time.Second
+
+// windows the latency SLO compliance is exported over
+var sloWindows = map[string]time.Duration{"1m": time.Minute, "5m": 5 * time.Minute, "1h": time.Hour}
+
 var (
-//	expectedClusterSizeError = promauto.NewCounterVec(prometheus.CounterOpts{
-//		Name:      "expected_cluster_size_error_total",
-//		Namespace: "strimzi_canary",
-//		Help:      "Total number of errors while waiting the Kafka cluster having the expected size",
-//	}, nil)
+	reconcileTickDrift = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "reconcile_tick_drift",
+		Namespace: metrics.Namespace,
+		Help:      "Difference between the actual and the configured reconcile interval in milliseconds",
+	})
+
+	reconcileDuration = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "reconcile_duration",
+		Namespace: metrics.Namespace,
+		Help:      "Duration of the last reconcile loop iteration in milliseconds",
+	})
+
+	latencySLOBreaches = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "produce_latency_slo_breach_total",
+		Namespace: metrics.Namespace,
+		Help:      "Total number of produced records exceeding the partition latency SLO",
+	}, []string{"partition", "leader"})
+
+	checksInterval = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "check_interval",
+		Namespace: metrics.Namespace,
+		Help:      "Effective interval of the additional checks in milliseconds, shorter while they fail with adaptive intervals",
+	}, []string{"check"})
+
+	checksSkipped = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "check_skipped_total",
+		Namespace: metrics.Namespace,
+		Help:      "Total number of check runs skipped because the previous run was still going on",
+	}, []string{"check"})
+
+	latencySLOCompliance = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "produce_latency_slo_compliance",
+		Namespace: metrics.Namespace,
+		Help:      "Percentage of the records produced within the partition latency SLO over the window",
+	}, []string{"window"})
+
+	// expectedClusterSizeError = promauto.NewCounterVec(prometheus.CounterOpts{
+	// 	Name:      "expected_cluster_size_error_total",
+	// 	Namespace: "strimzi_canary",
+	// 	Help:      "Total number of errors while waiting the Kafka cluster having the expected size",
+	// }, nil)
 )
 
 // NewCanaryManager returns an instance of the cananry manager worker
-func NewCanaryManager(canaryConfig canary.Config,
+func NewCanaryManager(state *services.State, canaryConfig canary.Config,
 	topicService services.TopicService, producerService services.ProducerService,
 	consumerService services.ConsumerService, connectionService services.ConnectionService,
-	statusService services.StatusService, logger *zerolog.Logger) Worker {
+	statusService services.StatusService, checks []services.CheckService,
+	callbacks services.Callbacks, logger *zerolog.Logger) Worker {
 	cm := CanaryManager{
+		state:             state,
 		canaryConfig:      &canaryConfig,
 		topicService:      topicService,
 		producerService:   producerService,
 		consumerService:   consumerService,
 		connectionService: connectionService,
 		statusService:     statusService,
+		checks:            checks,
+		checksLastRun:     map[string]time.Time{},
+		checksRunning:     map[string]bool{},
+		checksIntervals:   map[string]time.Duration{},
+		checksSuccesses:   map[string]int{},
+		sloRecords:        util.NewSlidingWindow(time.Hour, 10*time.Second),
+		sloBreaches:       util.NewSlidingWindow(time.Hour, 10*time.Second),
+		callbacks:         callbacks,
 		logger:            logger,
 	}
 	return &cm
 }
 
 // Start runs a first reconcile and start a timer for periodic reconciling
-func (cm *CanaryManager) Start() {
+func (cm *CanaryManager) Start() error {
 	cm.logger.Info().Msg("Starting canary manager")
 
-	cm.stop = make(chan struct{})
-	cm.syncStop.Add(1)
-
 	cm.connectionService.Open()
 	cm.statusService.Open()
 
-	result, err := cm.topicService.Reconcile()
+	cm.stop = make(chan struct{})
+	cm.syncStop.Add(1)
+
+	// a failed first reconcile leaves the canary degraded, it is retried on every tick
+	result, err := cm.reconcileTopic()
+	cm.reconciled(result, err)
 	if err != nil {
-		cm.logger.Fatal().Err(err).Msg("Error starting canary manager")
+		cm.logger.Error().Err(err).Msg("Error on the first reconcile, retrying on the next interval")
+	} else {
+		cm.logger.Info().Msg("Consume and produce")
+		cm.startConsuming()
+		cm.refreshProducer(result)
+		// producer has to send to partitions assigned to brokers
+		cm.produce(result.Assignments)
 	}
-	cm.logger.Info().Msg("Consume and produce")
-	// consumer will subscribe to the topic so all partitions (even if we have less brokers)
-	cm.consumerService.Consume()
-	// producer has to send to partitions assigned to brokers
-	cm.producerService.Send(result.Assignments)
 
 	cm.logger.Info().Dur("interval", cm.canaryConfig.ReconcileInterval).Msg("Running reconciliation loop")
 	ticker := time.NewTicker(cm.canaryConfig.ReconcileInterval)
+	checksTicker := time.NewTicker(checksSchedulerTick)
 	go func() {
+		defer cm.state.TrackGoroutine("manager")()
+		last := time.Now()
 		for {
 			select {
-			case <-ticker.C:
-				cm.reconcile()
+			case tick := <-ticker.C:
+				reconcileTickDrift.In(cm.state.Metrics()).Set(float64((tick.Sub(last) - cm.canaryConfig.ReconcileInterval).Milliseconds()))
+				last = tick
+				start := time.Now()
+				cm.safeReconcile()
+				reconcileDuration.In(cm.state.Metrics()).Set(float64(time.Since(start).Milliseconds()))
+			case <-checksTicker.C:
+				cm.runChecks()
 			case <-cm.stop:
 				ticker.Stop()
+				checksTicker.Stop()
 				defer cm.syncStop.Done()
 				cm.logger.Info().Msg("Stopping canary manager reconcile loop")
 				return
 			}
 		}
 	}()
+
+	return nil
+}
+
+// Reset drops the latency SLO records so far
+func (cm *CanaryManager) Reset() {
+	cm.sloRecords.Reset()
+	cm.sloBreaches.Reset()
 }
 
 // Stop stops the services and the reconcile timer
 func (cm *CanaryManager) Stop() {
 	cm.logger.Info().Msg("Stopping canary manager")
 
-	// ask to stop the ticker reconcile loop and wait
-	close(cm.stop)
-	cm.syncStop.Wait()
+	// ask to stop the ticker reconcile loop and wait, along with the checks and the breached
+	// partitions descriptions still running, unless never started
+	if cm.stop != nil {
+		close(cm.stop)
+		cm.syncStop.Wait()
+		cm.checksWait.Wait()
+	}
+	cm.breachesWait.Wait()
 
 	cm.producerService.Close()
 	cm.consumerService.Close()
 	cm.topicService.Close()
 	cm.connectionService.Close()
 	cm.statusService.Close()
+	for _, check := range cm.checks {
+		check.Close()
+	}
 
 	cm.logger.Info().Msg("Canary manager closed")
 }
 
+// safeReconcile reconciles, recovering from panics so the loop keeps running
+func (cm *CanaryManager) safeReconcile() {
+	defer func() {
+		if r := recover(); r != nil {
+			cm.logger.Error().
+				Interface("panic", r).
+				Bytes("stack", debug.Stack()).
+				Msg("Canary manager reconcile panicked")
+		}
+	}()
+	cm.reconcile()
+}
+
 func (cm *CanaryManager) reconcile() {
 	cm.logger.Info().Msg("Canary manager reconcile")
 
-	if result, err := cm.topicService.Reconcile(); err == nil {
-		if result.RefreshProducerMetadata {
-			cm.producerService.Refresh()
-		}
+	result, err := cm.reconcileTopic()
+	cm.reconciled(result, err)
+	if err == nil {
+		cm.startConsuming()
+		cm.refreshProducer(result)
 
-		leaders, err := cm.consumerService.Leaders(context.Background())
-		if err != nil || !reflect.DeepEqual(result.Leaders, leaders) {
+		ctx, cancel := cm.stageContext()
+		leaders, err := cm.consumerService.Leaders(ctx)
+		cancel()
+		if err != nil || !sameLeaders(result.Leaders, leaders) {
 			cm.consumerService.Refresh()
 		}
 		// producer has to send to partitions assigned to brokers
-		cm.producerService.Send(result.Assignments)
+		cm.produce(result.Assignments)
+	}
+}
+
+// stageContext returns the context of a reconcile stage, bounded by the check timeout so a hung
+// broker call doesn't stall the loop and the latency sampling with it
+func (cm *CanaryManager) stageContext() (context.Context, context.CancelFunc) {
+	if cm.canaryConfig.CheckTimeout > 0 {
+		return context.WithTimeout(context.Background(), cm.canaryConfig.CheckTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (cm *CanaryManager) reconcileTopic() (services.TopicReconcileResult, error) {
+	ctx, cancel := cm.stageContext()
+	defer cancel()
+	return cm.topicService.Reconcile(ctx)
+}
+
+func (cm *CanaryManager) produce(assignments []int) {
+	ctx, cancel := cm.stageContext()
+	defer cancel()
+	cm.produced(cm.producerService.Send(ctx, assignments))
+}
+
+// refreshProducer propagates the partition leaders to the producer, which refreshes its metadata
+// when they changed. The producers not tracking the leaders are refreshed on the reconcile result.
+func (cm *CanaryManager) refreshProducer(result services.TopicReconcileResult) {
+	if producer, ok := cm.producerService.(services.LeadersAware); ok {
+		producer.SetLeaders(result.Leaders)
+		return
+	}
+	if result.RefreshProducerMetadata {
+		cm.producerService.Refresh()
+	}
+}
+
+// sameLeaders returns true when the reconciled and the consumer leaders match
+func sameLeaders(reconciled map[int32]int32, leaders map[int]int) bool {
+	if len(reconciled) != len(leaders) {
+		return false
+	}
+	for partition, leader := range reconciled {
+		if l, ok := leaders[int(partition)]; !ok || l != int(leader) {
+			return false
+		}
+	}
+	return true
+}
+
+// runChecks starts the checks due, each one in its own goroutine so a slow check delays neither
+// the others nor the produce and consume sampling. A check still running since its previous
+// interval is skipped.
+func (cm *CanaryManager) runChecks() {
+	for _, check := range cm.checks {
+		if time.Since(cm.checksLastRun[check.Name()]) < cm.checkInterval(check) {
+			continue
+		}
+		if !cm.startCheck(check.Name()) {
+			checksSkipped.In(cm.state.Metrics()).WithLabelValues(check.Name()).Inc()
+			cm.logger.Warn().Str("check", check.Name()).Msg("Check still running, skipping it")
+			continue
+		}
+		cm.checksLastRun[check.Name()] = time.Now()
+
+		cm.checksWait.Add(1)
+		go func(check services.CheckService) {
+			defer cm.state.TrackGoroutine("check")()
+			defer cm.checksWait.Done()
+			defer cm.finishCheck(check.Name())
+			cm.runCheck(check)
+		}(check)
 	}
 }
+
+func (cm *CanaryManager) runCheck(check services.CheckService) {
+	result := cm.state.RunCheck(check, cm.canaryConfig.CheckTimeoutFor(check.Name()), cm.logger)
+	cm.adaptCheckInterval(check, result.Err)
+	if health, changed := cm.state.ObserveCheckHealth(check.Name(), result.Err, cm.canaryConfig.Health); changed {
+		cm.logger.Warn().
+			Str("check", check.Name()).
+			Str("state", string(health.State)).
+			Int("consecutive_failures", health.ConsecutiveFailures).
+			Msg("Check health changed")
+	}
+	if cm.callbacks.OnCheck != nil {
+		cm.callbacks.OnCheck(result)
+	}
+}
+
+// normalCheckInterval returns the configured interval of the check, the checks without one run on
+// every reconcile interval
+func (cm *CanaryManager) normalCheckInterval(check services.CheckService) time.Duration {
+	if periodic, ok := check.(services.PeriodicCheck); ok {
+		return periodic.Interval()
+	}
+	return cm.canaryConfig.ReconcileInterval
+}
+
+// checkInterval returns the effective interval of the check, shorter than the configured one
+// while it fails when adaptive intervals are enabled
+func (cm *CanaryManager) checkInterval(check services.CheckService) time.Duration {
+	cm.checksLock.Lock()
+	defer cm.checksLock.Unlock()
+	if interval, ok := cm.checksIntervals[check.Name()]; ok {
+		return interval
+	}
+	return cm.normalCheckInterval(check)
+}
+
+// adaptCheckInterval moves a failing check to the retry interval, backing off on every further
+// failure up to the cap, and back to its configured interval after enough successes
+func (cm *CanaryManager) adaptCheckInterval(check services.CheckService, err error) {
+	config := cm.canaryConfig.AdaptiveInterval
+	normal := cm.normalCheckInterval(check)
+	name := check.Name()
+
+	cm.checksLock.Lock()
+	defer cm.checksLock.Unlock()
+	interval, adapted := cm.checksIntervals[name]
+	switch {
+	case !config.Enabled:
+		interval = normal
+	case err != nil:
+		cm.checksSuccesses[name] = 0
+		if !adapted {
+			interval = config.RetryInterval
+		} else {
+			interval = time.Duration(float64(interval) * config.Backoff)
+		}
+		max := normal
+		if config.MaxInterval > 0 && config.MaxInterval < max {
+			max = config.MaxInterval
+		}
+		if interval > max {
+			interval = max
+		}
+	case adapted:
+		cm.checksSuccesses[name]++
+		if cm.checksSuccesses[name] >= config.Successes {
+			delete(cm.checksSuccesses, name)
+			interval = normal
+			adapted = false
+		}
+	default:
+		interval = normal
+	}
+
+	if config.Enabled && (err != nil || adapted) {
+		cm.checksIntervals[name] = interval
+	} else {
+		delete(cm.checksIntervals, name)
+	}
+	checksInterval.In(cm.state.Metrics()).WithLabelValues(name).Set(float64(interval.Milliseconds()))
+}
+
+// startCheck marks the check as running, returning false when it already is
+func (cm *CanaryManager) startCheck(name string) bool {
+	cm.checksLock.Lock()
+	defer cm.checksLock.Unlock()
+	if cm.checksRunning[name] {
+		return false
+	}
+	cm.checksRunning[name] = true
+	return true
+}
+
+func (cm *CanaryManager) finishCheck(name string) {
+	cm.checksLock.Lock()
+	defer cm.checksLock.Unlock()
+	delete(cm.checksRunning, name)
+}
+
+// startConsuming starts the consumer once, after the topic has been reconciled
+func (cm *CanaryManager) startConsuming() {
+	if cm.consuming {
+		return
+	}
+	// consumer will subscribe to the topic so all partitions (even if we have less brokers)
+	cm.consumerService.Consume(cm.callbacks.OnConsume)
+	cm.consuming = true
+}
+
+func (cm *CanaryManager) reconciled(result services.TopicReconcileResult, err error) {
+	if cm.callbacks.OnReconcile == nil {
+		return
+	}
+	cm.callbacks.OnReconcile(services.ReconcileResult{
+		TopicReconcileResult: result,
+		Timestamp:            time.Now(),
+		Err:                  err,
+	})
+}
+
+func (cm *CanaryManager) produced(results []services.ProduceResult) {
+	withSLO := false
+	for _, r := range results {
+		threshold := cm.canaryConfig.LatencySLO.ThresholdFor(r.Partition)
+		if r.Err == nil && threshold > 0 {
+			withSLO = true
+			cm.sloRecords.Add(1)
+			if r.Latency > threshold {
+				cm.sloBreaches.Add(1)
+				// the partition is described off the produce path, a slow describe doesn't delay
+				// the next records
+				cm.breachesWait.Add(1)
+				go func(r services.ProduceResult, threshold time.Duration) {
+					defer cm.state.TrackGoroutine("manager")()
+					defer cm.breachesWait.Done()
+					r.Breach = cm.latencyBreached(r, threshold)
+					cm.onProduce(r)
+				}(r, threshold)
+				continue
+			}
+		}
+		cm.onProduce(r)
+	}
+	if withSLO {
+		cm.exportSLOCompliance()
+	}
+}
+
+func (cm *CanaryManager) onProduce(result services.ProduceResult) {
+	if cm.callbacks.OnProduce != nil {
+		cm.callbacks.OnProduce(result)
+	}
+}
+
+// exportSLOCompliance exports the percentage of records within the latency SLO over each window
+func (cm *CanaryManager) exportSLOCompliance() {
+	for name, window := range sloWindows {
+		records := cm.sloRecords.Sum(window)
+		if records == 0 {
+			continue
+		}
+		breaches := cm.sloBreaches.Sum(window)
+		latencySLOCompliance.In(cm.state.Metrics()).WithLabelValues(name).Set(float64(records-breaches) * 100 / float64(records))
+	}
+}
+
+// latencyBreached describes the partition of a record exceeding its latency SLO, so its leader
+// and ISR are reported along with the breach
+func (cm *CanaryManager) latencyBreached(result services.ProduceResult, threshold time.Duration) *services.LatencyBreach {
+	ctx, cancel := cm.stageContext()
+	defer cancel()
+
+	breach := &services.LatencyBreach{Threshold: threshold}
+	partition, err := cm.topicService.DescribePartition(ctx, result.Partition)
+	if err != nil {
+		breach.Err = err
+		latencySLOBreaches.In(cm.state.Metrics()).WithLabelValues(strconv.Itoa(result.Partition), "unknown").Inc()
+		cm.logger.Warn().
+			Err(err).
+			Int("partition", result.Partition).
+			Dur("latency", result.Latency).
+			Dur("threshold", threshold).
+			Msg("Produce latency SLO breached, error describing the partition")
+		return breach
+	}
+
+	breach.Leader = partition.Leader
+	breach.Replicas = partition.Replicas
+	breach.ISR = partition.ISR
+	latencySLOBreaches.In(cm.state.Metrics()).WithLabelValues(strconv.Itoa(result.Partition), strconv.Itoa(partition.Leader)).Inc()
+	cm.logger.Warn().
+		Int("partition", result.Partition).
+		Dur("latency", result.Latency).
+		Dur("threshold", threshold).
+		Int("leader", partition.Leader).
+		Ints("replicas", partition.Replicas).
+		Ints("isr", partition.ISR).
+		Bool("under_replicated", len(partition.ISR) < len(partition.Replicas)).
+		Msg("Produce latency SLO breached")
+	return breach
+}
@@ -0,0 +1,190 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
+	"github.com/pecigonzalo/kafka-canary/pkg/services/servicestest"
+)
+
+// newTestManager returns a canary manager of fake services
+func newTestManager(config canary.Config, topic *servicestest.TopicService, callbacks services.Callbacks) *CanaryManager {
+	logger := zerolog.Nop()
+	return NewCanaryManager(services.NewState(config), config, topic, &servicestest.ProducerService{}, &servicestest.ConsumerService{},
+		&servicestest.ConnectionService{}, &servicestest.StatusService{}, nil, callbacks, &logger).(*CanaryManager)
+}
+
+// plainProducer hides the leaders tracking of the wrapped producer
+type plainProducer struct {
+	services.ProducerService
+}
+
+func TestRefreshProducer(t *testing.T) {
+	leaders := map[int32]int32{0: 1}
+
+	producer := &servicestest.ProducerService{}
+	var got map[int32]int32
+	producer.SetLeadersFunc = func(l map[int32]int32) { got = l }
+	cm := &CanaryManager{producerService: producer}
+	cm.refreshProducer(services.TopicReconcileResult{Leaders: leaders, RefreshProducerMetadata: true})
+	assert.Equal(t, leaders, got)
+	assert.Equal(t, 0, producer.Calls("Refresh"), "the producer refreshes on the leader changes itself")
+
+	producer = &servicestest.ProducerService{}
+	cm = &CanaryManager{producerService: plainProducer{producer}}
+	cm.refreshProducer(services.TopicReconcileResult{Leaders: leaders})
+	assert.Equal(t, 0, producer.Calls("Refresh"))
+	cm.refreshProducer(services.TopicReconcileResult{Leaders: leaders, RefreshProducerMetadata: true})
+	assert.Equal(t, 1, producer.Calls("Refresh"))
+	assert.Equal(t, 0, producer.Calls("SetLeaders"))
+}
+
+func TestProducedLatencySLO(t *testing.T) {
+	topic := &servicestest.TopicService{
+		DescribePartitionFunc: func(_ context.Context, partition int) (client.PartitionInfo, error) {
+			if partition == 2 {
+				return client.PartitionInfo{}, errors.New("connection refused")
+			}
+			return client.PartitionInfo{ID: partition, Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1}}, nil
+		},
+	}
+	var lock sync.Mutex
+	results := map[int]services.ProduceResult{}
+	cm := newTestManager(canary.Config{
+		LatencySLO: canary.SLOConfig{Threshold: 100 * time.Millisecond, Partitions: map[int]time.Duration{3: 0}},
+	}, topic, services.Callbacks{OnProduce: func(r services.ProduceResult) {
+		lock.Lock()
+		defer lock.Unlock()
+		// the failed record of partition 0 is kept apart
+		if r.Err != nil {
+			r.Partition = -1
+		}
+		results[r.Partition] = r
+	}})
+
+	cm.produced([]services.ProduceResult{
+		{Partition: 0, Latency: 50 * time.Millisecond},
+		{Partition: 1, Latency: 150 * time.Millisecond},
+		{Partition: 2, Latency: 150 * time.Millisecond},
+		// failed records and partitions without an SLO aren't accounted
+		{Partition: 0, Latency: time.Second, Err: errors.New("timeout")},
+		{Partition: 3, Latency: time.Second},
+	})
+	cm.breachesWait.Wait()
+	require.Len(t, results, 5)
+	assert.Nil(t, results[0].Breach, "within the SLO")
+	assert.Equal(t, &services.LatencyBreach{
+		Threshold: 100 * time.Millisecond, Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1},
+	}, results[1].Breach)
+	require.NotNil(t, results[2].Breach)
+	assert.EqualError(t, results[2].Breach.Err, "connection refused", "partition not described")
+	assert.Nil(t, results[-1].Breach)
+	assert.Nil(t, results[3].Breach)
+	assert.Equal(t, 2, topic.Calls("DescribePartition"))
+	assert.InDelta(t, 100.0/3, testutil.ToFloat64(latencySLOCompliance.In(cm.state.Metrics()).WithLabelValues("1m")), 0.01)
+}
+
+func TestReconcileStagesTimeout(t *testing.T) {
+	describing := make(chan struct{})
+	release := make(chan struct{})
+	topic := &servicestest.TopicService{
+		ReconcileFunc: func(ctx context.Context) (services.TopicReconcileResult, error) {
+			_, ok := ctx.Deadline()
+			assert.True(t, ok, "topic reconcile without a deadline")
+			return services.TopicReconcileResult{Assignments: []int{0}}, nil
+		},
+		DescribePartitionFunc: func(ctx context.Context, partition int) (client.PartitionInfo, error) {
+			close(describing)
+			<-release
+			return client.PartitionInfo{ID: partition, Leader: 1}, nil
+		},
+	}
+	var produced []services.ProduceResult
+	cm := newTestManager(canary.Config{
+		CheckTimeout: time.Minute,
+		LatencySLO:   canary.SLOConfig{Threshold: 100 * time.Millisecond},
+	}, topic, services.Callbacks{OnProduce: func(r services.ProduceResult) { produced = append(produced, r) }})
+	consumer := &servicestest.ConsumerService{LeadersFunc: func(ctx context.Context) (map[int]int, error) {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok, "leaders lookup without a deadline")
+		return nil, nil
+	}}
+	producer := &servicestest.ProducerService{SendFunc: func(ctx context.Context, assignments []int) []services.ProduceResult {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok, "produce without a deadline")
+		return []services.ProduceResult{{Partition: 0, Latency: time.Second}}
+	}}
+	cm.consumerService, cm.producerService = consumer, producer
+
+	// the reconcile returns while the breaching partition is still being described
+	cm.reconcile()
+	<-describing
+	assert.Empty(t, produced)
+	close(release)
+	cm.breachesWait.Wait()
+	require.Len(t, produced, 1)
+	assert.Equal(t, 1, produced[0].Breach.Leader)
+}
+
+func TestAdaptCheckInterval(t *testing.T) {
+	check := &servicestest.CheckService{IntervalFunc: func() time.Duration { return time.Minute }}
+	failure := errors.New("connection refused")
+	adaptive := canary.AdaptiveIntervalConfig{
+		Enabled:       true,
+		RetryInterval: 5 * time.Second,
+		Backoff:       2,
+		MaxInterval:   15 * time.Second,
+		Successes:     2,
+	}
+
+	cm := newTestManager(canary.Config{AdaptiveInterval: adaptive}, &servicestest.TopicService{}, services.Callbacks{})
+	assert.Equal(t, time.Minute, cm.checkInterval(check))
+	cm.adaptCheckInterval(check, nil)
+	assert.Equal(t, time.Minute, cm.checkInterval(check), "a passing check keeps its interval")
+
+	for _, want := range []time.Duration{5 * time.Second, 10 * time.Second, 15 * time.Second, 15 * time.Second} {
+		cm.adaptCheckInterval(check, failure)
+		assert.Equal(t, want, cm.checkInterval(check))
+	}
+	assert.Equal(t, 15000.0, testutil.ToFloat64(checksInterval.In(cm.state.Metrics()).WithLabelValues("fake")))
+
+	cm.adaptCheckInterval(check, nil)
+	assert.Equal(t, 15*time.Second, cm.checkInterval(check), "one success isn't a recovery")
+	cm.adaptCheckInterval(check, failure)
+	cm.adaptCheckInterval(check, nil)
+	assert.Equal(t, 15*time.Second, cm.checkInterval(check), "a failure resets the successes")
+	cm.adaptCheckInterval(check, nil)
+	assert.Equal(t, time.Minute, cm.checkInterval(check))
+	assert.Equal(t, 60000.0, testutil.ToFloat64(checksInterval.In(cm.state.Metrics()).WithLabelValues("fake")))
+
+	// without a max interval the backoff is capped by the check interval
+	adaptive.MaxInterval = 0
+	cm = newTestManager(canary.Config{AdaptiveInterval: adaptive}, &servicestest.TopicService{}, services.Callbacks{})
+	for i := 0; i < 5; i++ {
+		cm.adaptCheckInterval(check, failure)
+	}
+	assert.Equal(t, time.Minute, cm.checkInterval(check))
+
+	adaptive.Enabled = false
+	cm = newTestManager(canary.Config{AdaptiveInterval: adaptive}, &servicestest.TopicService{}, services.Callbacks{})
+	cm.adaptCheckInterval(check, failure)
+	assert.Equal(t, time.Minute, cm.checkInterval(check), "disabled adaptive intervals keep the interval")
+}
+
+func TestNormalCheckInterval(t *testing.T) {
+	cm := newTestManager(canary.Config{ReconcileInterval: 10 * time.Second}, &servicestest.TopicService{}, services.Callbacks{})
+	assert.Equal(t, time.Minute, cm.normalCheckInterval(&servicestest.CheckService{IntervalFunc: func() time.Duration { return time.Minute }}))
+	assert.Equal(t, 10*time.Second, cm.normalCheckInterval(struct{ services.CheckService }{&servicestest.CheckService{}}),
+		"the checks without an interval run on every reconcile")
+}
@@ -0,0 +1,399 @@
+// Package canary defines the configuration shared by the canary services
+package canary
+
+import "time"
+
+// Config contains the settings of the canary checks
+type Config struct {
+	Topic                       string                       `mapstructure:"topic"`
+	ClientID                    string                       `mapstructure:"client-id"`
+	ClientIDs                   map[string]string            `mapstructure:"client-ids"`
+	InstanceID                  string                       `mapstructure:"instance-id"`
+	IgnoreOtherInstances        bool                         `mapstructure:"ignore-other-instances"`
+	Headers                     map[string]string            `mapstructure:"headers"`
+	ReconcileInterval           time.Duration                `mapstructure:"reconcile-interval"`
+	StatusCheckInterval         time.Duration                `mapstructure:"status-check-interval"`
+	StatusTimeWindow            time.Duration                `mapstructure:"status-time-window"`
+	StatusStateFile             string                       `mapstructure:"status-state-file"`
+	BootstrapBackoffMaxAttempts int                          `mapstructure:"bootstrap-backoff-max-attempts"`
+	BootstrapBackoffScale       time.Duration                `mapstructure:"bootstrap-backoff-scale"`
+	ProducerLatencyBuckets      []float64                    `mapstructure:"producer-latency-buckets"`
+	EndToEndLatencyBuckets      []float64                    `mapstructure:"endtoend-latency-buckets"`
+	ConsumerGroupID             string                       `mapstructure:"consumer-group-id"`
+	CheckTimeout                time.Duration                `mapstructure:"check-timeout"`
+	CheckTimeouts               map[string]time.Duration     `mapstructure:"check-timeouts"`
+	PermissionsCheck            bool                         `mapstructure:"permissions-check"`
+	Health                      HealthConfig                 `mapstructure:"health"`
+	AdaptiveInterval            AdaptiveIntervalConfig       `mapstructure:"adaptive-interval"`
+	StallThreshold              time.Duration                `mapstructure:"stall-threshold"`
+	WarmUp                      time.Duration                `mapstructure:"warm-up"`
+	Backpressure                BackpressureConfig           `mapstructure:"backpressure"`
+	SequenceStateFile           string                       `mapstructure:"sequence-state-file"`
+	RecordVersion               int                          `mapstructure:"record-version"`
+	Encryption                  EncryptionConfig             `mapstructure:"encryption"`
+	Plugins                     []PluginConfig               `mapstructure:"plugins"`
+	Chaos                       ChaosConfig                  `mapstructure:"chaos"`
+	LatencySLO                  SLOConfig                    `mapstructure:"latency-slo"`
+	Coordination                CoordinationConfig           `mapstructure:"coordination"`
+	MessageSize                 MessageSizeConfig            `mapstructure:"message-size"`
+	OffsetTimestamp             OffsetTimestampConfig        `mapstructure:"offset-timestamp"`
+	GroupCoordinator            bool                         `mapstructure:"group-coordinator"`
+	InternalTopics              InternalTopicsConfig         `mapstructure:"internal-topics"`
+	TransactionCoordinator      TransactionCoordinatorConfig `mapstructure:"transaction-coordinator"`
+	ConsumerGroups              ConsumerGroupsConfig         `mapstructure:"consumer-groups"`
+	MetadataConsistency         MetadataConsistencyConfig    `mapstructure:"metadata-consistency"`
+	DeniedOperations            DeniedOperationsConfig       `mapstructure:"denied-operations"`
+	RestProxy                   RestProxyConfig              `mapstructure:"rest-proxy"`
+	EventLogSize                int                          `mapstructure:"event-log-size"`
+	DisabledChecks              []string                     `mapstructure:"disabled-checks"`
+	TopicConfig                 TopicConfigConfig            `mapstructure:"topic-config"`
+	CruiseControl               CruiseControlConfig          `mapstructure:"cruise-control"`
+	Comparison                  ComparisonConfig             `mapstructure:"comparison"`
+	AuditTopic                  string                       `mapstructure:"audit-topic"`
+	AdminRateLimit              float64                      `mapstructure:"admin-rate-limit"`
+	AdminRateBurst              int                          `mapstructure:"admin-rate-burst"`
+	Clock                       ClockConfig                  `mapstructure:"clock"`
+	Bandwidth                   BandwidthConfig              `mapstructure:"bandwidth"`
+	TopicPolicy                 TopicPolicyConfig            `mapstructure:"topic-policy"`
+	Replay                      ReplayConfig                 `mapstructure:"replay"`
+	Failover                    FailoverConfig               `mapstructure:"failover"`
+	Commit                      CommitConfig                 `mapstructure:"commit"`
+	DeadLetter                  DeadLetterConfig             `mapstructure:"dead-letter"`
+	Anomaly                     AnomalyConfig                `mapstructure:"anomaly"`
+	StaticMembership            StaticMembershipConfig       `mapstructure:"static-membership"`
+	AssignmentStrategies        []string                     `mapstructure:"assignment-strategies"`
+	RebalanceDelayThreshold     time.Duration                `mapstructure:"rebalance-delay-threshold"`
+	TopicVariables              map[string]string            `mapstructure:"topic-variables"`
+	Ownership                   OwnershipConfig              `mapstructure:"ownership"`
+	DirectLeader                DirectLeaderConfig           `mapstructure:"direct-leader"`
+}
+
+// ClientIDFor returns the client ID reported to the brokers by the given service, the canary
+// client ID unless overridden
+func (c Config) ClientIDFor(service string) string {
+	if id := c.ClientIDs[service]; id != "" {
+		return id
+	}
+	return c.ClientID
+}
+
+// CheckTimeoutFor returns the timeout of the given check, the check timeout unless overridden
+func (c Config) CheckTimeoutFor(check string) time.Duration {
+	if timeout, ok := c.CheckTimeouts[check]; ok {
+		return timeout
+	}
+	return c.CheckTimeout
+}
+
+// CheckEnabled returns false when the given check is disabled, whatever its own settings
+func (c Config) CheckEnabled(check string) bool {
+	for _, disabled := range c.DisabledChecks {
+		if disabled == check {
+			return false
+		}
+	}
+	return true
+}
+
+// TopicConfigConfig defines the desired canary topic config, checked for drift on every reconcile
+type TopicConfigConfig struct {
+	// entries added to or overriding the config the topic is created with
+	Desired   map[string]string `mapstructure:"desired"`
+	Remediate bool              `mapstructure:"remediate"`
+}
+
+// ComparisonConfig defines the check comparing the canary cluster with a second one, e.g. the
+// target of a migration, disabled without brokers
+type ComparisonConfig struct {
+	Brokers       []string      `mapstructure:"brokers"`
+	Topic         string        `mapstructure:"topic"`
+	Interval      time.Duration `mapstructure:"interval"`
+	Window        time.Duration `mapstructure:"window"`
+	LatencyBudget time.Duration `mapstructure:"latency-budget"`
+}
+
+// ConsumerGroupsConfig defines the check describing external consumer groups, disabled without
+// groups
+type ConsumerGroupsConfig struct {
+	Groups   []string      `mapstructure:"groups"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// DeniedOperationsConfig defines the operations the canary principal must be denied, disabled
+// without operations
+type DeniedOperationsConfig struct {
+	ProduceTopics  []string      `mapstructure:"produce-topics"`
+	DescribeGroups []string      `mapstructure:"describe-groups"`
+	Interval       time.Duration `mapstructure:"interval"`
+}
+
+// Enabled returns whether any operation is expected to be denied
+func (c DeniedOperationsConfig) Enabled() bool {
+	return len(c.ProduceTopics) > 0 || len(c.DescribeGroups) > 0
+}
+
+// AnomalyConfig defines the detection of the latencies deviating from their learned baseline
+type AnomalyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// recent latency over the baseline one flagged as an anomaly
+	Factor float64 `mapstructure:"factor"`
+	// samples the baseline and the recent latency are averaged over
+	BaselineSamples int `mapstructure:"baseline-samples"`
+	RecentSamples   int `mapstructure:"recent-samples"`
+}
+
+// DeadLetterConfig defines where the consumed records that can't be verified are captured, with
+// diagnostics, disabled without a file nor a topic
+type DeadLetterConfig struct {
+	// file the records are appended to as JSON lines
+	File  string `mapstructure:"file"`
+	Topic string `mapstructure:"topic"`
+	// records captured at most per interval
+	MaxRecords int           `mapstructure:"max-records"`
+	Interval   time.Duration `mapstructure:"interval"`
+}
+
+// CommitConfig defines how the canary consumer commits its offsets
+type CommitConfig struct {
+	// auto, interval, per-record or none, per-record when empty
+	Strategy string `mapstructure:"strategy"`
+	// period of the interval strategy commits
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// FailoverConfig defines the probe forcing leader elections on the canary partitions and
+// measuring how long they take to recover
+type FailoverConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// confirms the cluster isn't a production one, the probe refuses to run otherwise
+	NonProduction bool          `mapstructure:"non-production"`
+	Interval      time.Duration `mapstructure:"interval"`
+	// how long the election, each of the produce and consume recoveries and the restore may take,
+	// bounded by their share of the check timeout
+	RecoveryTimeout time.Duration `mapstructure:"recovery-timeout"`
+}
+
+// DirectLeaderConfig defines the check producing to and fetching from the partition leaders dialed
+// directly, bypassing the bootstrap brokers and the client metadata
+type DirectLeaderConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// OwnershipConfig defines the claim of the canary topic by this instance, refusing to run on a
+// topic claimed by another live instance
+type OwnershipConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// consumer group the claims are committed to, without members
+	GroupID string `mapstructure:"group-id"`
+	// how long a claim is held without being renewed
+	Lease time.Duration `mapstructure:"lease"`
+	// takes over the claim of another live instance, set with --force
+	Force bool `mapstructure:"-"`
+}
+
+// StaticMembershipConfig defines the check keeping a static member, with a group.instance.id, in a
+// dedicated consumer group
+type StaticMembershipConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// group of the static member, the canary one suffixed with -static when empty
+	GroupID string `mapstructure:"group-id"`
+	// group.instance.id of the static member, unique per canary, the canary instance ID when empty
+	InstanceID string        `mapstructure:"instance-id"`
+	Interval   time.Duration `mapstructure:"interval"`
+	// how long the member is kept while the canary restarts, within the brokers'
+	// group.min.session.timeout.ms and group.max.session.timeout.ms
+	SessionTimeout time.Duration `mapstructure:"session-timeout"`
+}
+
+// ReplayConfig defines the check rewinding a secondary consumer group and re-consuming the
+// recent canary records
+type ReplayConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	// how far back is the group rewound
+	Lookback time.Duration `mapstructure:"lookback"`
+	// secondary group, the canary one suffixed with -replay when empty
+	GroupID string `mapstructure:"group-id"`
+}
+
+// TopicPolicyConfig defines the policies the cluster topics are checked against on every reconcile,
+// the internal topics are exempt
+type TopicPolicyConfig struct {
+	// regexp the topic names must match, any name when empty
+	NamePattern string `mapstructure:"name-pattern"`
+	// lowest replication factor allowed, any when 0
+	MinReplicationFactor int `mapstructure:"min-replication-factor"`
+}
+
+// BandwidthConfig defines the probe writing a burst of records to a dedicated topic and reading
+// it back to measure the throughput
+type BandwidthConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Topic   string `mapstructure:"topic"`
+	// MiB written and read back by every burst
+	Volume     int           `mapstructure:"volume"`
+	RecordSize int           `mapstructure:"record-size"`
+	Interval   time.Duration `mapstructure:"interval"`
+	// MiB/s below which the probe fails, 0 to never fail
+	MinThroughput float64 `mapstructure:"min-throughput"`
+}
+
+// ClockConfig defines the local clock skew estimation, the NTP check is disabled without server
+type ClockConfig struct {
+	NTPServer     string        `mapstructure:"ntp-server"`
+	Interval      time.Duration `mapstructure:"interval"`
+	SkewThreshold time.Duration `mapstructure:"skew-threshold"`
+}
+
+// CruiseControlConfig defines the check polling Cruise Control for rebalances and anomalies,
+// disabled without URL
+type CruiseControlConfig struct {
+	URL      string        `mapstructure:"url"`
+	Username string        `mapstructure:"username"`
+	Password string        `mapstructure:"password"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// RestProxyConfig defines the check producing through a Confluent REST Proxy and consuming with
+// the native client, disabled without URL
+type RestProxyConfig struct {
+	URL      string        `mapstructure:"url"`
+	Topic    string        `mapstructure:"topic"`
+	Username string        `mapstructure:"username"`
+	Password string        `mapstructure:"password"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// TransactionCoordinatorConfig defines the check initializing a transactional producer ID
+type TransactionCoordinatorConfig struct {
+	Enabled         bool   `mapstructure:"enabled"`
+	TransactionalID string `mapstructure:"transactional-id"`
+}
+
+// InternalTopicsConfig defines the check reporting the health of the internal topics
+type InternalTopicsConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	Topics   []string      `mapstructure:"topics"`
+}
+
+// MetadataConsistencyConfig defines the check comparing the canary topic metadata returned by
+// each broker
+type MetadataConsistencyConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// OffsetTimestampConfig defines the check verifying the offsets returned by ListOffsets for a
+// timestamp, i.e. the time index of the canary partitions
+type OffsetTimestampConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	// how far in the past is the timestamp looked up
+	Lookback time.Duration `mapstructure:"lookback"`
+}
+
+// MessageSizeConfig defines the check producing records around the topic max.message.bytes
+type MessageSizeConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	// limit assumed when the topic doesn't override max.message.bytes, i.e. the broker one
+	MaxMessageBytes int `mapstructure:"max-message-bytes"`
+}
+
+// CoordinationConfig defines the coordinated mode, where the instances sharing a topic produce
+// to distinct partitions and measure the delivery latency of each other's records
+type CoordinationConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// zone of this instance, e.g. its availability zone
+	Zone string `mapstructure:"zone"`
+	// IDs of all the coordinated instances, in the same order on every instance
+	Instances []string `mapstructure:"instances"`
+}
+
+// Owns returns true when the given instance produces to the partition, partitions are spread
+// round-robin across the coordinated instances. Every partition is owned when disabled.
+func (c CoordinationConfig) Owns(instanceID string, partition int) bool {
+	if !c.Enabled {
+		return true
+	}
+	for i, instance := range c.Instances {
+		if instance == instanceID {
+			return partition%len(c.Instances) == i
+		}
+	}
+	return false
+}
+
+// SLOConfig defines the produce latency thresholds, breaching them triggers a describe of the
+// partition so its leader and ISR are attached to the breach
+type SLOConfig struct {
+	// threshold applied to the partitions without a specific one, 0 disables the SLO
+	Threshold  time.Duration         `mapstructure:"threshold"`
+	Partitions map[int]time.Duration `mapstructure:"partitions"`
+}
+
+// ThresholdFor returns the latency threshold of the given partition, 0 when there is none
+func (c SLOConfig) ThresholdFor(partition int) time.Duration {
+	if threshold, ok := c.Partitions[partition]; ok {
+		return threshold
+	}
+	return c.Threshold
+}
+
+// HealthConfig defines the hysteresis of the checks health state machine
+type HealthConfig struct {
+	// consecutive failures moving a check from degraded to failed
+	FailureThreshold int `mapstructure:"failure-threshold"`
+	// consecutive successes moving a failed check back to OK
+	RecoveryThreshold int `mapstructure:"recovery-threshold"`
+}
+
+// AdaptiveIntervalConfig defines the faster interval of the failing checks, shrinking the time to
+// detect their recovery
+type AdaptiveIntervalConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// interval after the first failure, multiplied by the backoff on every further failure
+	RetryInterval time.Duration `mapstructure:"retry-interval"`
+	Backoff       float64       `mapstructure:"backoff"`
+	// cap of the retry interval, the check interval when 0 or longer
+	MaxInterval time.Duration `mapstructure:"max-interval"`
+	// consecutive successes moving the check back to its interval
+	Successes int `mapstructure:"successes"`
+}
+
+// EncryptionConfig defines the AES-GCM key encrypting the canary record values, disabled when the
+// key is empty
+type EncryptionConfig struct {
+	// base64 encoded AES key of 16, 24 or 32 bytes
+	Key string `mapstructure:"key"`
+	// ID sent in the records header, so consumers detect records encrypted with another key
+	KeyID string `mapstructure:"key-id"`
+}
+
+// BackpressureConfig defines when producing pauses for a lagging canary consumer to catch up
+type BackpressureConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// produce intervals the consumer may fall behind before producing pauses
+	MaxLagIntervals int64 `mapstructure:"max-lag-intervals"`
+}
+
+// ChaosConfig defines the faults injected in the canary's own pipeline when enabled, meant to
+// verify alert rules fire on loss and latency. It must never be enabled on a canary relied upon.
+type ChaosConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	ProduceDelay    time.Duration `mapstructure:"produce-delay"`
+	ConsumeDelay    time.Duration `mapstructure:"consume-delay"`
+	DropAckRate     float64       `mapstructure:"drop-ack-rate"`
+	DropRecordRate  float64       `mapstructure:"drop-record-rate"`
+	SequenceGapRate float64       `mapstructure:"sequence-gap-rate"`
+}
+
+// PluginConfig defines an external executable run as a check on every reconcile
+type PluginConfig struct {
+	Name    string        `mapstructure:"name"`
+	Path    string        `mapstructure:"path"`
+	Args    []string      `mapstructure:"args"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
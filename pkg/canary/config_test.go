@@ -0,0 +1,32 @@
+package canary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoordinationOwns(t *testing.T) {
+	coordination := CoordinationConfig{Enabled: true, Instances: []string{"canary-a", "canary-b", "canary-c"}}
+	owners := map[int]string{0: "canary-a", 1: "canary-b", 2: "canary-c", 3: "canary-a", 7: "canary-b"}
+	for partition, owner := range owners {
+		for _, instance := range coordination.Instances {
+			assert.Equal(t, instance == owner, coordination.Owns(instance, partition), "%s owning partition %d", instance, partition)
+		}
+		assert.False(t, coordination.Owns("canary-d", partition), "instance not listed")
+	}
+
+	coordination.Enabled = false
+	assert.True(t, coordination.Owns("canary-d", 1), "every partition owned when disabled")
+}
+
+func TestClientIDFor(t *testing.T) {
+	config := Config{
+		ClientID:  "kafka-canary",
+		ClientIDs: map[string]string{"producer": "kafka-canary-producer", "consumer": ""},
+	}
+	assert.Equal(t, "kafka-canary-producer", config.ClientIDFor("producer"), "overridden")
+	assert.Equal(t, "kafka-canary", config.ClientIDFor("consumer"), "empty override")
+	assert.Equal(t, "kafka-canary", config.ClientIDFor("topic"), "not overridden")
+	assert.Empty(t, Config{}.ClientIDFor("topic"), "without client ID")
+}
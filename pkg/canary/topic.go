@@ -0,0 +1,64 @@
+package canary
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxTopicNameLength is the longest topic name the brokers accept
+const maxTopicNameLength = 249
+
+var (
+	topicNameChars       = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+	topicNamePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// ResolveTopic returns the canary topic name with its {name} placeholders replaced, e.g.
+// __kafka_canary_{cluster}_{zone}. A placeholder is replaced by the topic variable of that name,
+// otherwise {instance} and {zone} by the instance ID and coordination zone, otherwise by the
+// environment variable of that name. The result must be a valid Kafka topic name.
+func (c Config) ResolveTopic(lookupEnv func(string) (string, bool)) (string, error) {
+	var missing []string
+	topic := topicNamePlaceholder.ReplaceAllStringFunc(c.Topic, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if value, ok := c.TopicVariables[name]; ok {
+			return value
+		}
+		switch {
+		case name == "instance" && c.InstanceID != "":
+			return c.InstanceID
+		case name == "zone" && c.Coordination.Zone != "":
+			return c.Coordination.Zone
+		}
+		if value, ok := lookupEnv(name); ok {
+			return value
+		}
+		missing = append(missing, name)
+		return placeholder
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("topic %s: no value for %s", c.Topic, strings.Join(missing, ", "))
+	}
+	if err := ValidTopicName(topic); err != nil {
+		return "", fmt.Errorf("topic %s resolved to %q: %w", c.Topic, topic, err)
+	}
+	return topic, nil
+}
+
+// ValidTopicName returns an error unless the brokers accept the topic name: up to 249 ASCII
+// letters, digits, '.', '_' and '-', other than "." and ".."
+func ValidTopicName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("empty topic name")
+	case name == "." || name == "..":
+		return errors.New(`topic name can't be "." or ".."`)
+	case len(name) > maxTopicNameLength:
+		return fmt.Errorf("topic name longer than %d characters", maxTopicNameLength)
+	case !topicNameChars.MatchString(name):
+		return errors.New("topic name can only contain ASCII letters, digits, '.', '_' and '-'")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package canary
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTopic(t *testing.T) {
+	env := map[string]string{"cluster": "env-cluster", "ENV": "prod"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	tests := []struct {
+		name    string
+		config  Config
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", config: Config{Topic: "__kafka_canary"}, want: "__kafka_canary"},
+		{
+			name: "variables first",
+			config: Config{
+				Topic:          "__kafka_canary_{cluster}_{zone}",
+				TopicVariables: map[string]string{"cluster": "payments"},
+				Coordination:   CoordinationConfig{Zone: "eu-west-1a"},
+			},
+			want: "__kafka_canary_payments_eu-west-1a",
+		},
+		{
+			name:   "environment",
+			config: Config{Topic: "__kafka_canary_{cluster}.{ENV}.{instance}", InstanceID: "canary-0"},
+			want:   "__kafka_canary_env-cluster.prod.canary-0",
+		},
+		{name: "unresolved", config: Config{Topic: "__kafka_canary_{zone}"}, wantErr: true},
+		{
+			name:    "invalid characters",
+			config:  Config{Topic: "__kafka_canary_{cluster}", TopicVariables: map[string]string{"cluster": "a/b"}},
+			wantErr: true,
+		},
+		{name: "too long", config: Config{Topic: strings.Repeat("a", 250)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, err := tt.config.ResolveTopic(lookupEnv)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, topic)
+		})
+	}
+}
+
+func TestValidTopicName(t *testing.T) {
+	for _, name := range []string{"__kafka_canary", "a.b-c_D9", strings.Repeat("a", 249)} {
+		assert.NoError(t, ValidTopicName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "canary topic", "canäry", strings.Repeat("a", 250)} {
+		assert.Error(t, ValidTopicName(name), name)
+	}
+}
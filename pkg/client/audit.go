@@ -0,0 +1,88 @@
+package client
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"time"
+)
+
+// Outcomes of the audited operations
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditRecord describes a mutating admin operation sent to the cluster
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Operation string    `json:"operation"`
+	// the resource mutated, as type:name, e.g. topic:__kafka_canary
+	Resource string `json:"resource"`
+	Details  string `json:"details,omitempty"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Auditor records the mutating admin operations, it's called once the operation completed
+type Auditor func(AuditRecord)
+
+// Principal returns the principal the brokers authenticate the connections as, as far as it can be
+// told from the client side: the SASL user or the subject of the TLS client certificate
+func (c ConnectorConfig) Principal() string {
+	switch {
+	case c.SASL.Enabled && c.SASL.Mechanism == SASLMechanismAWSMSKIAM:
+		return "IAM:default-credentials"
+	case c.SASL.Enabled:
+		return "User:" + c.SASL.Username
+	case c.TLS.Enabled && c.TLS.CertPath != "":
+		if subject, err := certificateSubject(c.TLS.CertPath); err == nil {
+			return "User:" + subject
+		}
+	}
+	return "User:ANONYMOUS"
+}
+
+// certificateSubject returns the subject of the first certificate of the PEM file
+func certificateSubject(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			return "", os.ErrNotExist
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+		return cert.Subject.String(), nil
+	}
+}
+
+// audit passes the operation to the auditor, if any, with its outcome
+func (c *BrokerAdminClient) audit(operation, resource, details string, err *error) {
+	if c.config.Auditor == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:      time.Now(),
+		Principal: c.config.ConnectorConfig.Principal(),
+		Operation: operation,
+		Resource:  resource,
+		Details:   details,
+		Outcome:   AuditSuccess,
+	}
+	if *err != nil {
+		record.Outcome = AuditFailure
+		record.Error = (*err).Error()
+	}
+	c.config.Auditor(record)
+}
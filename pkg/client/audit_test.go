@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditReadOnlyMutation(t *testing.T) {
+	var records []AuditRecord
+	c := &BrokerAdminClient{config: BrokerAdminClientConfig{
+		ConnectorConfig: ConnectorConfig{SASL: SASLConfig{Enabled: true, Mechanism: SASLMechanismPlain, Username: "canary"}},
+		ReadOnly:        true,
+		Auditor:         func(record AuditRecord) { records = append(records, record) },
+	}}
+
+	// the mutation is refused before reaching the cluster, the attempt is still audited
+	err := c.CreateTopic(context.Background(), kafka.TopicConfig{Topic: "__kafka_canary", NumPartitions: 3, ReplicationFactor: 3})
+	require.Error(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "User:canary", records[0].Principal)
+	assert.Equal(t, "CreateTopics", records[0].Operation)
+	assert.Equal(t, "topic:__kafka_canary", records[0].Resource)
+	assert.Equal(t, AuditFailure, records[0].Outcome)
+	assert.Equal(t, err.Error(), records[0].Error)
+	assert.False(t, records[0].Time.IsZero())
+}
+
+func TestPrincipal(t *testing.T) {
+	assert.Equal(t, "User:ANONYMOUS", ConnectorConfig{}.Principal())
+	assert.Equal(t, "User:canary", ConnectorConfig{SASL: SASLConfig{Enabled: true, Mechanism: SASLMechanismScramSHA512, Username: "canary"}}.Principal())
+	// without a readable certificate the principal isn't known
+	assert.Equal(t, "User:ANONYMOUS", ConnectorConfig{TLS: TLSConfig{Enabled: true, CertPath: "missing.pem"}}.Principal())
+}
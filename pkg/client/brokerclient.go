@@ -12,6 +12,8 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
 )
 
 const (
@@ -32,7 +34,10 @@ const (
 var (
 	// ErrTopicDoesNotExist is returned by admin functions when a topic that should exist
 	// does not.
-	ErrTopicDoesNotExist = errors.New("topic does not exist")
+	ErrTopicDoesNotExist error = &kafkaerr.Error{
+		Class: kafkaerr.ClassTopic,
+		Err:   errors.New("topic does not exist"),
+	}
 )
 
 // BrokerAdminClient is a Client implementation that only uses broker APIs, without any
@@ -53,6 +58,8 @@ type BrokerAdminClientConfig struct {
 	ConnectorConfig
 	ReadOnly          bool
 	ExpectedClusterID string
+	// records the mutating operations when set
+	Auditor Auditor
 }
 
 // NewBrokerAdminClient constructs a new BrokerAdminClient instance.
@@ -388,7 +395,8 @@ func (c *BrokerAdminClient) UpdateTopicConfig(
 	name string,
 	configEntries []kafka.ConfigEntry,
 	overwrite bool,
-) ([]string, error) {
+) (updated []string, err error) {
+	defer c.audit("IncrementalAlterConfigs", "topic:"+name, fmt.Sprintf("%v", configEntries), &err)
 	if c.config.ReadOnly {
 		return nil, errors.New("cannot update topic config read-only mode")
 	}
@@ -414,7 +422,7 @@ func (c *BrokerAdminClient) UpdateTopicConfig(
 		return nil, err
 	}
 
-	updated := []string{}
+	updated = []string{}
 	for _, entry := range configEntries {
 		updated = append(updated, entry.ConfigName)
 	}
@@ -429,7 +437,8 @@ func (c *BrokerAdminClient) UpdateBrokerConfig(
 	id int,
 	configEntries []kafka.ConfigEntry,
 	overwrite bool,
-) ([]string, error) {
+) (updated []string, err error) {
+	defer c.audit("IncrementalAlterConfigs", fmt.Sprintf("broker:%d", id), fmt.Sprintf("%v", configEntries), &err)
 	if c.config.ReadOnly {
 		return nil, errors.New("cannot update broker config read-only mode")
 	}
@@ -455,7 +464,7 @@ func (c *BrokerAdminClient) UpdateBrokerConfig(
 		return nil, err
 	}
 
-	updated := []string{}
+	updated = []string{}
 	for _, entry := range configEntries {
 		updated = append(updated, entry.ConfigName)
 	}
@@ -506,7 +515,9 @@ func IncrementalAlterConfigsResponseResourcesError(resources []kafka.Incremental
 func (c *BrokerAdminClient) CreateTopic(
 	ctx context.Context,
 	config kafka.TopicConfig,
-) error {
+) (err error) {
+	defer c.audit("CreateTopics", "topic:"+config.Topic,
+		fmt.Sprintf("partitions=%d replication=%d config=%v", config.NumPartitions, config.ReplicationFactor, config.ConfigEntries), &err)
 	if c.config.ReadOnly {
 		return errors.New("cannot create topic in read-only mode")
 	}
@@ -533,7 +544,8 @@ func (c *BrokerAdminClient) AssignPartitions(
 	ctx context.Context,
 	topic string,
 	assignments []PartitionAssignment,
-) error {
+) (err error) {
+	defer c.audit("AlterPartitionReassignments", "topic:"+topic, fmt.Sprintf("%+v", assignments), &err)
 	if c.config.ReadOnly {
 		return errors.New("cannot assign partitions in read-only mode")
 	}
@@ -571,7 +583,8 @@ func (c *BrokerAdminClient) AddPartitions(
 	ctx context.Context,
 	topic string,
 	newAssignments []PartitionAssignment,
-) error {
+) (err error) {
+	defer c.audit("CreatePartitions", "topic:"+topic, fmt.Sprintf("%+v", newAssignments), &err)
 	if c.config.ReadOnly {
 		return errors.New("cannot add partitions in read-only mode")
 	}
@@ -625,7 +638,8 @@ func (c *BrokerAdminClient) RunLeaderElection(
 	ctx context.Context,
 	topic string,
 	partitions []int,
-) error {
+) (err error) {
+	defer c.audit("ElectLeaders", "topic:"+topic, fmt.Sprintf("partitions=%v", partitions), &err)
 	if c.config.ReadOnly {
 		return errors.New("cannot run leader election in read-only mode")
 	}
@@ -670,7 +684,7 @@ func (c *BrokerAdminClient) getMetadata(
 	resp, err := c.client.Metadata(ctx, &req)
 	c.logger.Debug().Msgf("Metadata response: %+v (%+v)", resp, err)
 
-	return resp, err
+	return resp, kafkaerr.Wrap(err)
 }
 
 func (c *BrokerAdminClient) getAPIVersions(ctx context.Context) (
@@ -682,7 +696,7 @@ func (c *BrokerAdminClient) getAPIVersions(ctx context.Context) (
 	resp, err := c.client.ApiVersions(ctx, &req)
 	c.logger.Debug().Msgf("API versions response: %+v (%+v)", resp, err)
 
-	return resp, err
+	return resp, kafkaerr.Wrap(err)
 }
 
 func brokerIDs(brokers []kafka.Broker) []int {
@@ -1,3 +1,4 @@
+// Package client provides the Kafka connector and admin client used by the canary services
 package client
 
 import (
@@ -0,0 +1,174 @@
+// Package clienttest provides a fake of the cluster admin client, so the projects wrapping the
+// canary can unit test against it without a Kafka cluster. A fake method calls the function field
+// of the same name when set and returns zero values otherwise, and every call is counted by method
+// name.
+package clienttest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+var _ client.Client = &Client{}
+
+// Client is a fake client.Client, it's safe for concurrent use as long as its function fields are
+type Client struct {
+	GetClusterIDFunc         func(ctx context.Context) (string, error)
+	GetBrokersFunc           func(ctx context.Context, ids []int) ([]client.BrokerInfo, error)
+	GetBrokerIDsFunc         func(ctx context.Context) ([]int, error)
+	GetConnectorFunc         func() *client.Connector
+	GetTopicsFunc            func(ctx context.Context, names []string, detailed bool) ([]client.TopicInfo, error)
+	GetTopicNamesFunc        func(ctx context.Context) ([]string, error)
+	GetTopicFunc             func(ctx context.Context, name string, detailed bool) (client.TopicInfo, error)
+	UpdateTopicConfigFunc    func(ctx context.Context, name string, configEntries []kafka.ConfigEntry, overwrite bool) ([]string, error)
+	UpdateBrokerConfigFunc   func(ctx context.Context, id int, configEntries []kafka.ConfigEntry, overwrite bool) ([]string, error)
+	CreateTopicFunc          func(ctx context.Context, config kafka.TopicConfig) error
+	AssignPartitionsFunc     func(ctx context.Context, topic string, assignments []client.PartitionAssignment) error
+	AddPartitionsFunc        func(ctx context.Context, topic string, newAssignments []client.PartitionAssignment) error
+	RunLeaderElectionFunc    func(ctx context.Context, topic string, partitions []int) error
+	GetSupportedFeaturesFunc func() client.SupportedFeatures
+	CloseFunc                func() error
+
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+func (c *Client) record(method string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	c.calls[method]++
+}
+
+// Calls returns the number of calls of the method, e.g. Calls("CreateTopic")
+func (c *Client) Calls(method string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.calls[method]
+}
+
+func (c *Client) GetClusterID(ctx context.Context) (string, error) {
+	c.record("GetClusterID")
+	if c.GetClusterIDFunc != nil {
+		return c.GetClusterIDFunc(ctx)
+	}
+	return "", nil
+}
+
+func (c *Client) GetBrokers(ctx context.Context, ids []int) ([]client.BrokerInfo, error) {
+	c.record("GetBrokers")
+	if c.GetBrokersFunc != nil {
+		return c.GetBrokersFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (c *Client) GetBrokerIDs(ctx context.Context) ([]int, error) {
+	c.record("GetBrokerIDs")
+	if c.GetBrokerIDsFunc != nil {
+		return c.GetBrokerIDsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (c *Client) GetConnector() *client.Connector {
+	c.record("GetConnector")
+	if c.GetConnectorFunc != nil {
+		return c.GetConnectorFunc()
+	}
+	return nil
+}
+
+func (c *Client) GetTopics(ctx context.Context, names []string, detailed bool) ([]client.TopicInfo, error) {
+	c.record("GetTopics")
+	if c.GetTopicsFunc != nil {
+		return c.GetTopicsFunc(ctx, names, detailed)
+	}
+	return nil, nil
+}
+
+func (c *Client) GetTopicNames(ctx context.Context) ([]string, error) {
+	c.record("GetTopicNames")
+	if c.GetTopicNamesFunc != nil {
+		return c.GetTopicNamesFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (c *Client) GetTopic(ctx context.Context, name string, detailed bool) (client.TopicInfo, error) {
+	c.record("GetTopic")
+	if c.GetTopicFunc != nil {
+		return c.GetTopicFunc(ctx, name, detailed)
+	}
+	return client.TopicInfo{}, nil
+}
+
+func (c *Client) UpdateTopicConfig(ctx context.Context, name string, configEntries []kafka.ConfigEntry, overwrite bool) ([]string, error) {
+	c.record("UpdateTopicConfig")
+	if c.UpdateTopicConfigFunc != nil {
+		return c.UpdateTopicConfigFunc(ctx, name, configEntries, overwrite)
+	}
+	return nil, nil
+}
+
+func (c *Client) UpdateBrokerConfig(ctx context.Context, id int, configEntries []kafka.ConfigEntry, overwrite bool) ([]string, error) {
+	c.record("UpdateBrokerConfig")
+	if c.UpdateBrokerConfigFunc != nil {
+		return c.UpdateBrokerConfigFunc(ctx, id, configEntries, overwrite)
+	}
+	return nil, nil
+}
+
+func (c *Client) CreateTopic(ctx context.Context, config kafka.TopicConfig) error {
+	c.record("CreateTopic")
+	if c.CreateTopicFunc != nil {
+		return c.CreateTopicFunc(ctx, config)
+	}
+	return nil
+}
+
+func (c *Client) AssignPartitions(ctx context.Context, topic string, assignments []client.PartitionAssignment) error {
+	c.record("AssignPartitions")
+	if c.AssignPartitionsFunc != nil {
+		return c.AssignPartitionsFunc(ctx, topic, assignments)
+	}
+	return nil
+}
+
+func (c *Client) AddPartitions(ctx context.Context, topic string, newAssignments []client.PartitionAssignment) error {
+	c.record("AddPartitions")
+	if c.AddPartitionsFunc != nil {
+		return c.AddPartitionsFunc(ctx, topic, newAssignments)
+	}
+	return nil
+}
+
+func (c *Client) RunLeaderElection(ctx context.Context, topic string, partitions []int) error {
+	c.record("RunLeaderElection")
+	if c.RunLeaderElectionFunc != nil {
+		return c.RunLeaderElectionFunc(ctx, topic, partitions)
+	}
+	return nil
+}
+
+func (c *Client) GetSupportedFeatures() client.SupportedFeatures {
+	c.record("GetSupportedFeatures")
+	if c.GetSupportedFeaturesFunc != nil {
+		return c.GetSupportedFeaturesFunc()
+	}
+	return client.SupportedFeatures{}
+}
+
+func (c *Client) Close() error {
+	c.record("Close")
+	if c.CloseFunc != nil {
+		return c.CloseFunc()
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package clienttest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+func TestClient(t *testing.T) {
+	var created []string
+	admin := &Client{
+		GetTopicFunc: func(context.Context, string, bool) (client.TopicInfo, error) {
+			return client.TopicInfo{}, kafka.UnknownTopicOrPartition
+		},
+		CreateTopicFunc: func(_ context.Context, config kafka.TopicConfig) error {
+			created = append(created, config.Topic)
+			return nil
+		},
+	}
+
+	_, err := admin.GetTopic(context.Background(), "__kafka_canary", false)
+	assert.ErrorIs(t, err, kafka.UnknownTopicOrPartition)
+	require.NoError(t, admin.CreateTopic(context.Background(), kafka.TopicConfig{Topic: "__kafka_canary"}))
+	assert.Equal(t, []string{"__kafka_canary"}, created)
+
+	ids, err := admin.GetBrokerIDs(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	assert.NoError(t, admin.Close())
+
+	assert.Equal(t, 1, admin.Calls("GetTopic"))
+	assert.Equal(t, 1, admin.Calls("CreateTopic"))
+	assert.Zero(t, admin.Calls("AddPartitions"))
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -16,6 +17,9 @@ import (
 	"github.com/segmentio/kafka-go/sasl/aws_msk_iam"
 	"github.com/segmentio/kafka-go/sasl/plain"
 	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/internal/ratelimit"
 )
 
 // SASLMechanism is the name of a SASL mechanism that will be used for client authentication.
@@ -33,6 +37,23 @@ type ConnectorConfig struct {
 	BrokerAddrs []string
 	TLS         TLSConfig
 	SASL        SASLConfig
+	Proxy       ProxyConfig
+	DNS         DNSConfig
+	IPFamily    IPFamily
+	// SourceAddrs are the IPs or interface names the connections are bound to, for hosts with
+	// several interfaces where the brokers are only reachable through one. Unbound when empty.
+	SourceAddrs []string
+	// ClientID is reported to the brokers in every request, the kafka-go default when empty.
+	ClientID string
+	// Service is the canary service the connector is for, e.g. producer, labelling its connection
+	// metrics.
+	Service string
+	// AdminLimiter limits the admin API calls of the client, shared by the connectors of a canary
+	// so they are limited together. Unlimited when nil.
+	AdminLimiter *ratelimit.TokenBucket
+	// Metrics is the registry of the canary the connection metrics are recorded in, they aren't
+	// exposed when nil.
+	Metrics *metrics.Registry
 }
 
 // TLSConfig stores the TLS-related configuration for a connection.
@@ -58,12 +79,15 @@ type Connector struct {
 	Config      ConnectorConfig
 	Dialer      *kafka.Dialer
 	KafkaClient *kafka.Client
+
+	stats *connectionStats
 }
 
 // NewConnector contructs a new Connector instance given the argument config.
 func NewConnector(config ConnectorConfig) (*Connector, error) {
 	connector := &Connector{
 		Config: config,
+		stats:  &connectionStats{},
 	}
 
 	var mechanismClient sasl.Mechanism
@@ -109,9 +133,7 @@ func NewConnector(config ConnectorConfig) (*Connector, error) {
 		}
 	}
 
-	if !config.TLS.Enabled {
-		connector.Dialer = kafka.DefaultDialer
-	} else {
+	if config.TLS.Enabled {
 		var certs []tls.Certificate
 		var caCertPool *x509.CertPool
 
@@ -143,25 +165,64 @@ func NewConnector(config ConnectorConfig) (*Connector, error) {
 			InsecureSkipVerify: config.TLS.SkipVerify,
 			ServerName:         config.TLS.ServerName,
 		}
-		connector.Dialer = &kafka.Dialer{
-			SASLMechanism: mechanismClient,
-			Timeout:       10 * time.Second,
-			TLS:           tlsConfig,
+		countHandshakes(tlsConfig, config.Service, connector.stats, config.Metrics)
+	}
+
+	netDialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  newResolver(config.DNS.Servers),
+	}
+	sources, err := sourceIPs(config.SourceAddrs)
+	if err != nil {
+		return nil, err
+	}
+	dial, err := familyDialFunc(config.IPFamily, config.Metrics, sourceDialFunc(netDialer, sources))
+	if err != nil {
+		return nil, err
+	}
+	if config.Proxy.URL != "" {
+		dial, err = NewProxyDialFunc(config.Proxy.URL, dial)
+		if err != nil {
+			return nil, err
 		}
 	}
+	// overrides are applied first so they also hold when dialing through a proxy
+	dial = overrideDialFunc(config.DNS.Overrides, dial)
+	dial = seedDialFunc(config.BrokerAddrs, config.Metrics, dial)
+	dial = countingDialFunc(config.Service, connector.stats, config.Metrics, dial)
+
+	connector.Dialer = &kafka.Dialer{
+		ClientID:      config.ClientID,
+		SASLMechanism: mechanismClient,
+		Timeout:       10 * time.Second,
+		TLS:           tlsConfig,
+		DialFunc:      dial,
+	}
 
+	transport := &kafka.Transport{
+		Dial:     dial,
+		SASL:     mechanismClient,
+		TLS:      tlsConfig,
+		ClientID: config.ClientID,
+	}
 	connector.KafkaClient = &kafka.Client{
-		Addr: kafka.TCP(config.BrokerAddrs...),
-		Transport: &kafka.Transport{
-			Dial: connector.Dialer.DialFunc,
-			SASL: mechanismClient,
-			TLS:  tlsConfig,
-		},
+		Addr:      SeedAddr(config.BrokerAddrs...),
+		Transport: &adminTransport{Transport: transport, limiter: config.AdminLimiter, metrics: config.Metrics},
 	}
 
 	return connector, nil
 }
 
+// Stats returns the totals of the broker connections opened by the connector, zero for the ones
+// not built with NewConnector
+func (c *Connector) Stats() ConnectionStats {
+	if c.stats == nil {
+		return ConnectionStats{}
+	}
+	return c.stats.snapshot()
+}
+
 // SASLNameToMechanism converts the argument SASL mechanism name string to a valid instance of
 // the SASLMechanism enum.
 func SASLNameToMechanism(name string) (SASLMechanism, error) {
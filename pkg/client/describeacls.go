@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// kafka-go doesn't implement DescribeAcls, its v1 messages are registered here
+func init() {
+	protocol.Register(&describeACLsRequest{}, &describeACLsResponse{})
+}
+
+// filter values matching any resource, pattern and permission
+const (
+	aclResourceAny   int8 = 1
+	aclPatternAny    int8 = 1
+	aclOperationAny  int8 = 1
+	aclPermissionAny int8 = 1
+)
+
+var (
+	aclResourceTypes = map[int8]string{2: "Topic", 3: "Group", 4: "Cluster", 5: "TransactionalId", 6: "DelegationToken", 7: "User"}
+	aclPatternTypes  = map[int8]string{3: "Literal", 4: "Prefixed"}
+	aclPermissions   = map[int8]string{2: "Deny", 3: "Allow"}
+	// ACLOperations names the ACL operations by code, they are also the bits of the authorized
+	// operations returned by Metadata and DescribeGroups
+	ACLOperations = map[int8]string{
+		2: "All", 3: "Read", 4: "Write", 5: "Create", 6: "Delete", 7: "Alter", 8: "Describe",
+		9: "ClusterAction", 10: "DescribeConfigs", 11: "AlterConfigs", 12: "IdempotentWrite",
+		13: "CreateTokens", 14: "DescribeTokens",
+	}
+)
+
+type describeACLsRequest struct {
+	ResourceTypeFilter int8   `kafka:"min=v1,max=v1"`
+	ResourceNameFilter string `kafka:"min=v1,max=v1,nullable"`
+	PatternTypeFilter  int8   `kafka:"min=v1,max=v1"`
+	PrincipalFilter    string `kafka:"min=v1,max=v1,nullable"`
+	HostFilter         string `kafka:"min=v1,max=v1,nullable"`
+	Operation          int8   `kafka:"min=v1,max=v1"`
+	PermissionType     int8   `kafka:"min=v1,max=v1"`
+}
+
+func (r *describeACLsRequest) ApiKey() protocol.ApiKey { return protocol.DescribeAcls }
+
+type describeACLsResponse struct {
+	ThrottleTimeMs int32                  `kafka:"min=v1,max=v1"`
+	ErrorCode      int16                  `kafka:"min=v1,max=v1"`
+	ErrorMessage   string                 `kafka:"min=v1,max=v1,nullable"`
+	Resources      []describeACLsResource `kafka:"min=v1,max=v1"`
+}
+
+func (r *describeACLsResponse) ApiKey() protocol.ApiKey { return protocol.DescribeAcls }
+
+type describeACLsResource struct {
+	ResourceType int8                  `kafka:"min=v1,max=v1"`
+	ResourceName string                `kafka:"min=v1,max=v1"`
+	PatternType  int8                  `kafka:"min=v1,max=v1"`
+	ACLs         []describeACLsBinding `kafka:"min=v1,max=v1"`
+}
+
+type describeACLsBinding struct {
+	Principal      string `kafka:"min=v1,max=v1"`
+	Host           string `kafka:"min=v1,max=v1"`
+	Operation      int8   `kafka:"min=v1,max=v1"`
+	PermissionType int8   `kafka:"min=v1,max=v1"`
+}
+
+// ACL is an access control entry of the cluster
+type ACL struct {
+	ResourceType string `json:"resourceType"`
+	ResourceName string `json:"resourceName"`
+	PatternType  string `json:"patternType"`
+	Host         string `json:"host"`
+	Operation    string `json:"operation"`
+	Permission   string `json:"permission"`
+}
+
+// DescribeACLs returns the ACLs of the principal, e.g. User:canary. It requires Describe on the
+// cluster, which the principal usually lacks.
+func (c *Connector) DescribeACLs(ctx context.Context, principal string) ([]ACL, error) {
+	response, err := c.KafkaClient.Transport.RoundTrip(ctx, c.KafkaClient.Addr, &describeACLsRequest{
+		ResourceTypeFilter: aclResourceAny,
+		PatternTypeFilter:  aclPatternAny,
+		PrincipalFilter:    principal,
+		Operation:          aclOperationAny,
+		PermissionType:     aclPermissionAny,
+	})
+	if err != nil {
+		return nil, kafkaerr.Wrap(err)
+	}
+	resp := response.(*describeACLsResponse)
+	if resp.ErrorCode != 0 {
+		return nil, kafkaerr.Wrap(kafka.Error(resp.ErrorCode))
+	}
+
+	acls := []ACL{}
+	for _, resource := range resp.Resources {
+		for _, binding := range resource.ACLs {
+			acls = append(acls, ACL{
+				ResourceType: aclResourceTypes[resource.ResourceType],
+				ResourceName: resource.ResourceName,
+				PatternType:  aclPatternTypes[resource.PatternType],
+				Host:         binding.Host,
+				Operation:    ACLOperations[binding.Operation],
+				Permission:   aclPermissions[binding.PermissionType],
+			})
+		}
+	}
+	return acls, nil
+}
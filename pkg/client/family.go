@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// IPFamily is the address family preferred when dialing brokers.
+type IPFamily string
+
+const (
+	// IPFamilyAuto races both families, in the order returned by the resolver (happy eyeballs).
+	IPFamilyAuto IPFamily = "auto"
+	IPFamilyIPv4 IPFamily = "ipv4"
+	IPFamilyIPv6 IPFamily = "ipv6"
+)
+
+var connections = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name:      "connections_total",
+	Namespace: metrics.Namespace,
+	Help:      "Total number of connections opened to the brokers, by address family",
+}, []string{"address", "family"})
+
+// familyDialFunc returns a DialFunc restricted to the given address family, counting the
+// family each connection ended up using
+func familyDialFunc(family IPFamily, registry *metrics.Registry, forward DialFunc) (DialFunc, error) {
+	var override string
+	switch family {
+	case "", IPFamilyAuto:
+	case IPFamilyIPv4:
+		override = "tcp4"
+	case IPFamilyIPv6:
+		override = "tcp6"
+	default:
+		return nil, fmt.Errorf(
+			"IP family '%s' is not valid; choices are auto, ipv4 and ipv6",
+			family,
+		)
+	}
+
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if override != "" && network == "tcp" {
+			network = override
+		}
+		conn, err := forward(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		connections.In(registry).WithLabelValues(address, string(addrFamily(conn.RemoteAddr()))).Inc()
+		return conn, nil
+	}, nil
+}
+
+// addrFamily returns the family of a connection address
+func addrFamily(addr net.Addr) IPFamily {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return "unknown"
+	}
+	if tcpAddr.IP.To4() != nil {
+		return IPFamilyIPv4
+	}
+	return IPFamilyIPv6
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// remoteConn is a connection only knowing its remote address
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr { return c.remote }
+
+// resolvingDialFunc returns a DialFunc resolving every host to the addresses, in their order,
+// and connecting to the first one of the dialed network
+func resolvingDialFunc(addrs ...string) DialFunc {
+	return func(_ context.Context, network string, address string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		p, _ := strconv.Atoi(port)
+		for _, addr := range addrs {
+			ip := net.ParseIP(addr)
+			v4 := ip.To4() != nil
+			if network == "tcp" || (network == "tcp4" && v4) || (network == "tcp6" && !v4) {
+				return remoteConn{remote: &net.TCPAddr{IP: ip, Port: p}}, nil
+			}
+		}
+		return nil, errors.New("no suitable address")
+	}
+}
+
+func TestFamilyDialFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  IPFamily
+		addrs   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "auto in the resolver order", family: IPFamilyAuto, addrs: []string{"fd00::1", "10.0.0.1"}, want: "fd00::1"},
+		{name: "auto with IPv4 first", family: IPFamilyAuto, addrs: []string{"10.0.0.1", "fd00::1"}, want: "10.0.0.1"},
+		{name: "unset is auto", addrs: []string{"fd00::1", "10.0.0.1"}, want: "fd00::1"},
+		{name: "IPv4 only", family: IPFamilyIPv4, addrs: []string{"fd00::1", "10.0.0.1"}, want: "10.0.0.1"},
+		{name: "IPv6 only", family: IPFamilyIPv6, addrs: []string{"10.0.0.1", "fd00::1"}, want: "fd00::1"},
+		{name: "IPv6 only without IPv6 address", family: IPFamilyIPv6, addrs: []string{"10.0.0.1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := metrics.NewRegistry()
+			dial, err := familyDialFunc(tt.family, registry, resolvingDialFunc(tt.addrs...))
+			require.NoError(t, err)
+
+			conn, err := dial(context.Background(), "tcp", "broker:9092")
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, 0, testutil.CollectAndCount(connections.In(registry)), "failed dials aren't counted")
+				return
+			}
+			require.NoError(t, err)
+			remote := conn.RemoteAddr().(*net.TCPAddr)
+			assert.Equal(t, tt.want, remote.IP.String())
+
+			family := IPFamilyIPv6
+			if remote.IP.To4() != nil {
+				family = IPFamilyIPv4
+			}
+			assert.Equal(t, 1.0, testutil.ToFloat64(connections.In(registry).WithLabelValues("broker:9092", string(family))))
+		})
+	}
+}
+
+func TestFamilyDialFuncInvalid(t *testing.T) {
+	_, err := familyDialFunc("ipv5", metrics.NewRegistry(), resolvingDialFunc())
+	assert.ErrorContains(t, err, "choices are auto, ipv4 and ipv6")
+}
+
+func TestAddrFamily(t *testing.T) {
+	assert.Equal(t, IPFamilyIPv4, addrFamily(&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}))
+	assert.Equal(t, IPFamilyIPv4, addrFamily(&net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1")}), "IPv4-mapped")
+	assert.Equal(t, IPFamilyIPv6, addrFamily(&net.TCPAddr{IP: net.ParseIP("fd00::1")}))
+	assert.Equal(t, IPFamily("unknown"), addrFamily(&net.UnixAddr{Name: "/tmp/kafka.sock"}))
+}
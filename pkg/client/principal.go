@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Sources of the resolved principal
+const (
+	PrincipalSourceSASL        = "sasl"
+	PrincipalSourceIAM         = "iam"
+	PrincipalSourceCertificate = "tls-certificate"
+	PrincipalSourceAnonymous   = "anonymous"
+)
+
+// ResolvePrincipal returns the principal the brokers authenticate the connections as and where it
+// was resolved from. Unlike Principal it looks up the AWS identity the IAM credentials resolve to,
+// falling back to the static principal on error.
+func (c ConnectorConfig) ResolvePrincipal(ctx context.Context) (string, string, error) {
+	switch {
+	case c.SASL.Enabled && c.SASL.Mechanism == SASLMechanismAWSMSKIAM:
+		sess, err := session.NewSession()
+		if err != nil {
+			return c.Principal(), PrincipalSourceIAM, err
+		}
+		identity, err := sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return c.Principal(), PrincipalSourceIAM, err
+		}
+		return "IAM:" + *identity.Arn, PrincipalSourceIAM, nil
+	case c.SASL.Enabled:
+		return c.Principal(), PrincipalSourceSASL, nil
+	case c.TLS.Enabled && c.TLS.CertPath != "":
+		subject, err := certificateSubject(c.TLS.CertPath)
+		if err != nil {
+			return c.Principal(), PrincipalSourceCertificate, err
+		}
+		return "User:" + subject, PrincipalSourceCertificate, nil
+	}
+	return c.Principal(), PrincipalSourceAnonymous, nil
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePrincipal(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    ConnectorConfig
+		principal string
+		source    string
+	}{
+		{
+			name:      "sasl",
+			config:    ConnectorConfig{SASL: SASLConfig{Enabled: true, Mechanism: SASLMechanismPlain, Username: "canary"}},
+			principal: "User:canary",
+			source:    PrincipalSourceSASL,
+		},
+		{
+			name:      "anonymous",
+			config:    ConnectorConfig{},
+			principal: "User:ANONYMOUS",
+			source:    PrincipalSourceAnonymous,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, source, err := tt.config.ResolvePrincipal(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.principal, principal)
+			assert.Equal(t, tt.source, source)
+		})
+	}
+}
+
+func TestResolvePrincipalCertificateError(t *testing.T) {
+	config := ConnectorConfig{TLS: TLSConfig{Enabled: true, CertPath: "/nonexistent.pem"}}
+	_, source, err := config.ResolvePrincipal(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, PrincipalSourceCertificate, source)
+}
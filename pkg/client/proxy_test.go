@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProxyDialFuncInvalid(t *testing.T) {
+	dial := (&net.Dialer{}).DialContext
+	for _, proxyURL := range []string{"ftp://proxy:21", "socks5://", "://proxy"} {
+		_, err := NewProxyDialFunc(proxyURL, dial)
+		assert.Error(t, err, proxyURL)
+	}
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	requests := make(chan *http.Request, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		requests <- req
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\nhello")
+	}()
+
+	dial, err := NewProxyDialFunc("http://user:secret@"+listener.Addr().String(), (&net.Dialer{}).DialContext)
+	require.NoError(t, err)
+
+	conn, err := dial(context.Background(), "tcp", "broker:9092")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := <-requests
+	assert.Equal(t, http.MethodConnect, req.Method)
+	assert.Equal(t, "broker:9092", req.Host)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", req.Header.Get("Proxy-Authorization"))
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestHTTPConnectProxyRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n")
+	}()
+
+	dial, err := NewProxyDialFunc("http://"+listener.Addr().String(), (&net.Dialer{}).DialContext)
+	require.NoError(t, err)
+
+	_, err = dial(context.Background(), "tcp", "broker:9092")
+	assert.ErrorContains(t, err, "403")
+}
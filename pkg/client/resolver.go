@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DNSConfig stores the name resolution configuration for broker connections.
+type DNSConfig struct {
+	// Servers queried instead of the system resolver, as host or host:port (port 53 by default).
+	Servers []string
+	// Overrides maps advertised broker hostnames to the address dialed instead, either a host or
+	// a host:port. TLS verification and SNI still use the advertised hostname.
+	Overrides map[string]string
+}
+
+// newResolver returns a resolver querying the given DNS servers in turn, or nil to use the
+// system resolver when none are given
+func newResolver(servers []string) *net.Resolver {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	addrs := make([]string, len(servers))
+	for i, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		addrs[i] = server
+	}
+
+	var next uint32
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			i := atomic.AddUint32(&next, 1) - 1
+			return dialer.DialContext(ctx, network, addrs[int(i)%len(addrs)])
+		},
+	}
+}
+
+// overrideDialFunc returns a DialFunc replacing the overridden hostnames before dialing with
+// the forward one
+func overrideDialFunc(overrides map[string]string, forward DialFunc) DialFunc {
+	if len(overrides) == 0 {
+		return forward
+	}
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return forward(ctx, network, overrideAddress(overrides, address))
+	}
+}
+
+func overrideAddress(overrides map[string]string, address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	override, ok := overrides[host]
+	if !ok {
+		return address
+	}
+	if _, _, err := net.SplitHostPort(override); err == nil {
+		return override
+	}
+	return net.JoinHostPort(override, port)
+}
@@ -0,0 +1,27 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrideAddress(t *testing.T) {
+	overrides := map[string]string{
+		"b-1.kafka.internal": "10.0.1.15",
+		"b-2.kafka.internal": "localhost:19092",
+	}
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"b-1.kafka.internal:9092", "10.0.1.15:9092"},
+		{"b-2.kafka.internal:9092", "localhost:19092"},
+		{"b-3.kafka.internal:9092", "b-3.kafka.internal:9092"},
+		{"invalid", "invalid"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, overrideAddress(overrides, tt.address), tt.address)
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var (
+	brokerDials = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "broker_dials_total",
+		Namespace: metrics.Namespace,
+		Help:      "Total number of connections opened to the brokers, by service",
+	}, []string{"service"})
+
+	tlsHandshakes = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "tls_handshakes_total",
+		Namespace: metrics.Namespace,
+		Help:      "Total number of TLS handshakes with the brokers, by service and whether the session was resumed",
+	}, []string{"service", "resumed"})
+)
+
+// ConnectionStats are the totals of the broker connections opened by a connector. Dials growing
+// with every produce or fetch round mean the connections aren't reused, e.g. closed by a load
+// balancer idle timeout in between.
+type ConnectionStats struct {
+	Dials         uint64
+	TLSHandshakes uint64
+	// TLS handshakes resuming a previous session, which skip the certificate exchange
+	TLSResumed uint64
+}
+
+// connectionStats counts the connections of a connector, it's safe for concurrent use
+type connectionStats struct {
+	dials         uint64
+	tlsHandshakes uint64
+	tlsResumed    uint64
+}
+
+func (s *connectionStats) snapshot() ConnectionStats {
+	return ConnectionStats{
+		Dials:         atomic.LoadUint64(&s.dials),
+		TLSHandshakes: atomic.LoadUint64(&s.tlsHandshakes),
+		TLSResumed:    atomic.LoadUint64(&s.tlsResumed),
+	}
+}
+
+// countingDialFunc returns a DialFunc counting the connections opened for the service
+func countingDialFunc(service string, stats *connectionStats, registry *metrics.Registry, forward DialFunc) DialFunc {
+	dials := brokerDials.In(registry).WithLabelValues(service)
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		conn, err := forward(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddUint64(&stats.dials, 1)
+		dials.Inc()
+		return conn, nil
+	}
+}
+
+// countHandshakes enables TLS session resumption on the config, as the Java clients do, and counts
+// the handshakes of the service by whether they resumed a session. The connections are verified
+// as before, VerifyConnection runs after the usual verification and on resumed sessions too.
+func countHandshakes(config *tls.Config, service string, stats *connectionStats, registry *metrics.Registry) {
+	config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		atomic.AddUint64(&stats.tlsHandshakes, 1)
+		if state.DidResume {
+			atomic.AddUint64(&stats.tlsResumed, 1)
+		}
+		tlsHandshakes.In(registry).WithLabelValues(service, strconv.FormatBool(state.DidResume)).Inc()
+		return nil
+	}
+}
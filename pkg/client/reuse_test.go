@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionStats(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	stats := &connectionStats{}
+	dial := countingDialFunc("producer", stats, nil, (&net.Dialer{}).DialContext)
+	config := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	// the test certificate is issued for example.com
+	config.ServerName = "example.com"
+	countHandshakes(config, "producer", stats, nil)
+
+	for i := 0; i < 2; i++ {
+		conn, err := dial(context.Background(), "tcp", server.Listener.Addr().String())
+		require.NoError(t, err)
+		tlsConn := tls.Client(conn, config)
+		// reading the response also reads the session ticket sent after the handshake
+		_, err = tlsConn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		require.NoError(t, err)
+		_, err = io.ReadAll(tlsConn)
+		require.NoError(t, err)
+		tlsConn.Close()
+	}
+
+	// the second connection resumed the session of the first one
+	assert.Equal(t, ConnectionStats{Dials: 2, TLSHandshakes: 2, TLSResumed: 1}, stats.snapshot())
+}
+
+func TestConnectorStatsWithoutNewConnector(t *testing.T) {
+	assert.Equal(t, ConnectionStats{}, (&Connector{}).Stats())
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// seedBackoff is how long a seed that failed to connect is skipped for, while other seeds remain
+const seedBackoff = 30 * time.Second
+
+// seedNetwork is the network of the bootstrap seeds dials. The brokers of the metadata are dialed
+// over tcp, even when they're the seeds themselves, so the leaders are never skipped.
+const seedNetwork = "tcp+seed"
+
+var seedDials = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name:      "bootstrap_seed_dials_total",
+	Namespace: metrics.Namespace,
+	Help:      "Total number of dials of the bootstrap seeds, by seed and result (success, failure or skipped)",
+}, []string{"seed", "result"})
+
+// seedAddr is the address of the bootstrap seeds, telling the kafka-go transports to dial them
+// over the seed network
+type seedAddr []string
+
+// SeedAddr returns the address of the bootstrap seeds for the transports of the connectors
+func SeedAddr(seeds ...string) net.Addr {
+	return seedAddr(seeds)
+}
+
+func (a seedAddr) Network() string {
+	networks := make([]string, len(a))
+	for i := range a {
+		networks[i] = seedNetwork
+	}
+	return strings.Join(networks, ",")
+}
+
+func (a seedAddr) String() string {
+	return strings.Join(a, ",")
+}
+
+// seedDialer rotates through the bootstrap seeds. kafka-go tries the seeds in turn within a
+// single dial timeout, so a seed that hangs uses it up: dials are capped to a share of the time
+// left, and a seed that failed recently is skipped while others may still work, so the clients
+// move on to the next seed instead of failing the whole reconcile. Every dial resolves the seed
+// hostname again, picking up DNS changes.
+type seedDialer struct {
+	seeds   map[string]bool
+	forward DialFunc
+	metrics *metrics.Registry
+
+	lock     sync.Mutex
+	failedAt map[string]time.Time
+	now      func() time.Time
+}
+
+// seedDialFunc returns a DialFunc rotating through the given bootstrap seeds when dialed over the
+// seed network, the other dials are forwarded as is
+func seedDialFunc(seeds []string, registry *metrics.Registry, forward DialFunc) DialFunc {
+	d := &seedDialer{
+		seeds:    make(map[string]bool, len(seeds)),
+		forward:  forward,
+		metrics:  registry,
+		failedAt: map[string]time.Time{},
+		now:      time.Now,
+	}
+	for _, seed := range seeds {
+		d.seeds[seed] = true
+	}
+	return d.dial
+}
+
+func (d *seedDialer) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	if network != seedNetwork {
+		return d.forward(ctx, network, address)
+	}
+	network = "tcp"
+	if len(d.seeds) < 2 || !d.seeds[address] {
+		return d.forward(ctx, network, address)
+	}
+
+	available, skip := d.available(address)
+	if skip {
+		seedDials.In(d.metrics).WithLabelValues(address, "skipped").Inc()
+		return nil, fmt.Errorf("bootstrap seed %s failed less than %s ago, skipped", address, seedBackoff)
+	}
+	// leave time for the other seeds still available
+	if deadline, ok := ctx.Deadline(); ok && available > 1 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(available))
+		defer cancel()
+	}
+
+	conn, err := d.forward(ctx, network, address)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err != nil {
+		d.failedAt[address] = d.now()
+		seedDials.In(d.metrics).WithLabelValues(address, "failure").Inc()
+		return nil, err
+	}
+	delete(d.failedAt, address)
+	seedDials.In(d.metrics).WithLabelValues(address, "success").Inc()
+	return conn, nil
+}
+
+// available returns the number of seeds which didn't fail recently, and whether the given seed
+// should be skipped because it failed recently while others didn't
+func (d *seedDialer) available(address string) (int, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := d.now()
+	available := 0
+	for seed := range d.seeds {
+		if failedAt, ok := d.failedAt[seed]; !ok || now.Sub(failedAt) >= seedBackoff {
+			available++
+		}
+	}
+	failedAt, failed := d.failedAt[address]
+	return available, failed && now.Sub(failedAt) < seedBackoff && available > 0
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedDialFunc(t *testing.T) {
+	var dialed []string
+	down := map[string]bool{"seed-1:9092": true}
+	forward := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if down[address] {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	dial := seedDialFunc([]string{"seed-1:9092", "seed-2:9092"}, nil, forward)
+
+	_, err := dial(context.Background(), seedNetwork, "seed-1:9092")
+	require.Error(t, err)
+	conn, err := dial(context.Background(), seedNetwork, "seed-2:9092")
+	require.NoError(t, err)
+	conn.Close()
+
+	// the failed seed is skipped while the other one works
+	_, err = dial(context.Background(), seedNetwork, "seed-1:9092")
+	require.Error(t, err)
+	assert.Equal(t, []string{"seed-1:9092", "seed-2:9092"}, dialed)
+
+	// addresses other than the seeds are dialed as is
+	conn, err = dial(context.Background(), seedNetwork, "broker-3:9092")
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestSeedDialFuncLeader(t *testing.T) {
+	var networks []string
+	down := true
+	forward := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		networks = append(networks, network)
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	dial := seedDialFunc([]string{"seed-1:9092", "seed-2:9092"}, nil, forward)
+
+	_, err := dial(context.Background(), seedNetwork, "seed-1:9092")
+	require.Error(t, err)
+
+	// the seed is also the leader of a partition, dialed over tcp once it's back
+	down = false
+	conn, err := dial(context.Background(), "tcp", "seed-1:9092")
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"tcp", "tcp"}, networks, "the seed network is dialed over tcp")
+}
+
+func TestSeedDialFuncSingleSeed(t *testing.T) {
+	dials := 0
+	forward := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dials++
+		return nil, errors.New("connection refused")
+	}
+	dial := seedDialFunc([]string{"seed-1:9092"}, nil, forward)
+
+	for i := 0; i < 2; i++ {
+		_, err := dial(context.Background(), seedNetwork, "seed-1:9092")
+		require.Error(t, err)
+	}
+	assert.Equal(t, 2, dials, "the only seed is never skipped")
+}
+
+func TestSeedAddr(t *testing.T) {
+	addr := SeedAddr("seed-1:9092", "seed-2:9092")
+	assert.Equal(t, seedNetwork+","+seedNetwork, addr.Network())
+	assert.Equal(t, "seed-1:9092,seed-2:9092", addr.String())
+}
+
+func TestSeedDialFuncAllDown(t *testing.T) {
+	dials := 0
+	forward := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dials++
+		return nil, errors.New("connection refused")
+	}
+	dial := seedDialFunc([]string{"seed-1:9092", "seed-2:9092"}, nil, forward)
+
+	for i := 0; i < 2; i++ {
+		_, err := dial(context.Background(), seedNetwork, "seed-1:9092")
+		require.Error(t, err)
+		_, err = dial(context.Background(), seedNetwork, "seed-2:9092")
+		require.Error(t, err)
+	}
+	// no seed is skipped when all of them failed
+	assert.Equal(t, 4, dials)
+}
+
+func TestSeedDialFuncTimeoutShare(t *testing.T) {
+	var timeout time.Duration
+	forward := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		deadline, _ := ctx.Deadline()
+		timeout = time.Until(deadline)
+		return nil, errors.New("timeout")
+	}
+	dial := seedDialFunc([]string{"seed-1:9092", "seed-2:9092", "seed-3:9092"}, nil, forward)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, _ = dial(ctx, seedNetwork, "seed-1:9092")
+	assert.InDelta(t, time.Second, timeout, float64(100*time.Millisecond))
+}
+
+func TestSeedAddrTransport(t *testing.T) {
+	var networks []string
+	forward := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		networks = append(networks, network)
+		return nil, errors.New("connection refused")
+	}
+	var dialed []string
+	dial := seedDialFunc([]string{"seed-1:9092", "seed-2:9092"}, nil, forward)
+	transport := &kafka.Transport{Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialed = append(dialed, network)
+		return dial(ctx, network, address)
+	}}
+	defer transport.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := (&kafka.Client{Addr: SeedAddr("seed-1:9092", "seed-2:9092"), Transport: transport}).Metadata(ctx, &kafka.MetadataRequest{})
+	require.Error(t, err)
+	assert.Equal(t, []string{seedNetwork, seedNetwork}, dialed, "the transport dials the seeds over the seed network")
+	assert.Equal(t, []string{"tcp", "tcp"}, networks)
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// sourceIPs resolves the source addresses, IPs or interface names, to the IPs connections are
+// bound to. The link-local addresses of the interfaces are skipped, they need a zone to be used.
+func sourceIPs(addrs []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		iface, err := net.InterfaceByName(addr)
+		if err != nil {
+			return nil, fmt.Errorf("source address %q is neither an IP nor an interface: %w", addr, err)
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("error listing the addresses of interface %s: %w", addr, err)
+		}
+		found := false
+		for _, ifaceAddr := range ifaceAddrs {
+			ipNet, ok := ifaceAddr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, ipNet.IP)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("interface %s has no usable address", addr)
+		}
+	}
+	return ips, nil
+}
+
+// sourceDialFunc returns a DialFunc binding the connections to the source IPs, trying them in
+// turn until one connects. The IPs of the other family than the dialed network are skipped, and
+// the dialer resolves hostnames to addresses of the family of the IP bound.
+func sourceDialFunc(dialer *net.Dialer, ips []net.IP) DialFunc {
+	if len(ips) == 0 {
+		return dialer.DialContext
+	}
+	dialers := make([]*net.Dialer, len(ips))
+	for i, ip := range ips {
+		bound := *dialer
+		bound.LocalAddr = &net.TCPAddr{IP: ip}
+		dialers[i] = &bound
+	}
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		var errs []error
+		for i, d := range dialers {
+			ipv4 := ips[i].To4() != nil
+			if (network == "tcp4" && !ipv4) || (network == "tcp6" && ipv4) {
+				continue
+			}
+			conn, err := d.DialContext(ctx, network, address)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("no source address of network %s to dial %s from", network, address)
+		}
+		if len(errs) == 1 {
+			return nil, errs[0]
+		}
+		// the last error is wrapped, so it's still classified
+		return nil, fmt.Errorf("error dialing %s from every source address, %v: %w", address, errs[:len(errs)-1], errs[len(errs)-1])
+	}
+}
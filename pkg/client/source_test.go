@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceIPs(t *testing.T) {
+	ips, err := sourceIPs([]string{"10.20.0.5", "fd00:20::5"})
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.20.0.5"), net.ParseIP("fd00:20::5")}, ips)
+
+	_, err = sourceIPs([]string{"no-such-interface0"})
+	assert.Error(t, err)
+}
+
+func TestSourceDialFunc(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dial := sourceDialFunc(&net.Dialer{}, []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")})
+	// the IPv6 source is skipped for an IPv4 network
+	conn, err := dial(context.Background(), "tcp4", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.True(t, conn.LocalAddr().(*net.TCPAddr).IP.Equal(net.ParseIP("127.0.0.1")))
+
+	dial = sourceDialFunc(&net.Dialer{}, []net.IP{net.ParseIP("::1")})
+	_, err = dial(context.Background(), "tcp4", listener.Addr().String())
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/internal/ratelimit"
+)
+
+var (
+	adminCallsThrottled = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "admin_calls_throttled_total",
+		Namespace: metrics.Namespace,
+		Help:      "Total number of admin API calls delayed by the admin rate limit, by API",
+	}, []string{"api"})
+
+	adminRequestDuration = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "admin_request_duration_seconds",
+		Namespace: metrics.Namespace,
+		Help:      "Duration of the admin API calls in seconds, rate limit delays excluded, by API",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"api"})
+)
+
+// adminTransport times the admin API calls, e.g. metadata, describes and alters, so control plane
+// slowness is visible apart from the data plane. With a limiter it delays the calls over the
+// limit, so a tight retry loop can't hammer the controller. The data path (produce and fetch) and
+// the connection handshakes are neither timed nor limited.
+type adminTransport struct {
+	*kafka.Transport
+	// nil without admin rate limit
+	limiter *ratelimit.TokenBucket
+	metrics *metrics.Registry
+}
+
+func (t *adminTransport) RoundTrip(ctx context.Context, addr net.Addr, req kafka.Request) (kafka.Response, error) {
+	if !isAdminAPI(req.ApiKey()) {
+		return t.Transport.RoundTrip(ctx, addr, req)
+	}
+	api := req.ApiKey().String()
+	if t.limiter != nil && !t.limiter.Allow() {
+		adminCallsThrottled.In(t.metrics).WithLabelValues(api).Inc()
+		if err := t.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	resp, err := t.Transport.RoundTrip(ctx, addr, req)
+	adminRequestDuration.In(t.metrics).WithLabelValues(api).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// isAdminAPI returns true for the APIs subject to the admin rate limit
+func isAdminAPI(key protocol.ApiKey) bool {
+	switch key {
+	case protocol.Produce, protocol.Fetch, protocol.ApiVersions, protocol.SaslHandshake, protocol.SaslAuthenticate:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/listoffsets"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/internal/ratelimit"
+)
+
+func TestAdminAPIs(t *testing.T) {
+	assert.True(t, isAdminAPI(protocol.Metadata))
+	assert.True(t, isAdminAPI(protocol.CreateTopics))
+	assert.True(t, isAdminAPI(protocol.IncrementalAlterConfigs))
+	assert.False(t, isAdminAPI(protocol.Produce))
+	assert.False(t, isAdminAPI(protocol.Fetch))
+	assert.False(t, isAdminAPI(protocol.SaslAuthenticate))
+}
+
+func TestAdminTransportRateLimit(t *testing.T) {
+	limiter := ratelimit.NewTokenBucket(0.001, 1)
+	limiter.Allow()
+	transport := &adminTransport{Transport: &kafka.Transport{}, limiter: limiter, metrics: metrics.NewRegistry()}
+
+	// the call over the limit waits for a token, until the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := testutil.ToFloat64(adminCallsThrottled.In(transport.metrics).WithLabelValues("Metadata"))
+	_, err := transport.RoundTrip(ctx, kafka.TCP("localhost:9092"), &metadata.Request{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, before+1, testutil.ToFloat64(adminCallsThrottled.In(transport.metrics).WithLabelValues("Metadata")))
+}
+
+func TestAdminTransportDuration(t *testing.T) {
+	transport := &adminTransport{Transport: &kafka.Transport{}, metrics: metrics.NewRegistry()}
+
+	// the failed calls are timed too, the unlimited transport doesn't wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := testutil.CollectAndCount(adminRequestDuration.In(transport.metrics))
+	_, err := transport.RoundTrip(ctx, kafka.TCP("localhost:9092"), &listoffsets.Request{})
+	assert.Error(t, err)
+	assert.Equal(t, before+1, testutil.CollectAndCount(adminRequestDuration.In(transport.metrics)))
+}
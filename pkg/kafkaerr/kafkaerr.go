@@ -0,0 +1,241 @@
+// Package kafkaerr defines a taxonomy of the errors returned when talking to Kafka, so failures
+// can be told apart (e.g. auth failures vs capacity problems) both in code and in metrics labels.
+package kafkaerr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Class is the class of an error, used as the "error_class" label on failure metrics
+type Class string
+
+const (
+	ClassNone        Class = "none"
+	ClassAuth        Class = "auth"
+	ClassAuthz       Class = "authz"
+	ClassTimeout     Class = "timeout"
+	ClassNotLeader   Class = "not_leader"
+	ClassQuota       Class = "quota"
+	ClassReplication Class = "replication"
+	ClassCoordinator Class = "coordinator"
+	ClassTopic       Class = "topic"
+	ClassRecord      Class = "record"
+	ClassNetwork     Class = "network"
+	ClassCanceled    Class = "canceled"
+	ClassUnknown     Class = "unknown"
+)
+
+var (
+	// ErrAuth is matched by errors.Is for authentication failures (SASL, TLS)
+	ErrAuth = &Error{Class: ClassAuth}
+	// ErrAuthz is matched by errors.Is for authorization failures (ACLs)
+	ErrAuthz = &Error{Class: ClassAuthz}
+	// ErrTimeout is matched by errors.Is for request and dial timeouts
+	ErrTimeout = &Error{Class: ClassTimeout}
+	// ErrNotLeader is matched by errors.Is for errors caused by stale leadership metadata
+	ErrNotLeader = &Error{Class: ClassNotLeader}
+	// ErrQuota is matched by errors.Is for throttling and quota violations
+	ErrQuota = &Error{Class: ClassQuota}
+	// ErrReplication is matched by errors.Is for errors caused by missing in-sync replicas
+	ErrReplication = &Error{Class: ClassReplication}
+	// ErrCoordinator is matched by errors.Is for group and transaction coordinator errors
+	ErrCoordinator = &Error{Class: ClassCoordinator}
+	// ErrTopic is matched by errors.Is for unknown or invalid topics and partitions
+	ErrTopic = &Error{Class: ClassTopic}
+	// ErrRecord is matched by errors.Is for records rejected because of their size or content
+	ErrRecord = &Error{Class: ClassRecord}
+	// ErrNetwork is matched by errors.Is for connection errors
+	ErrNetwork = &Error{Class: ClassNetwork}
+)
+
+// Error wraps an error together with its class
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return string(e.Class) + " error"
+	}
+	return string(e.Class) + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches any Error of the same class, e.g. errors.Is(err, kafkaerr.ErrAuth)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Class == e.Class
+}
+
+// Wrap returns the error wrapped in an Error with its class, or nil if the error is nil
+func Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Class: ClassOf(err), Err: err}
+}
+
+// ClassOf returns the class of the error, ClassNone if the error is nil
+func ClassOf(err error) Class {
+	if err == nil {
+		return ClassNone
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		for _, e := range writeErrors {
+			if e != nil {
+				return ClassOf(e)
+			}
+		}
+	}
+
+	var kafkaError kafka.Error
+	if errors.As(err, &kafkaError) {
+		return classOfKafkaError(kafkaError)
+	}
+
+	var tooLarge kafka.MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return ClassRecord
+	}
+
+	var (
+		certificateError  x509.CertificateInvalidError
+		unknownAuthority  x509.UnknownAuthorityError
+		hostnameError     x509.HostnameError
+		recordHeaderError tls.RecordHeaderError
+		netError          net.Error
+		dnsError          *net.DNSError
+		operationError    *net.OpError
+	)
+	switch {
+	case errors.As(err, &certificateError),
+		errors.As(err, &unknownAuthority),
+		errors.As(err, &hostnameError),
+		errors.As(err, &recordHeaderError):
+		return ClassAuth
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, os.ErrDeadlineExceeded),
+		errors.Is(err, syscall.ETIMEDOUT):
+		return ClassTimeout
+	case errors.As(err, &netError) && netError.Timeout():
+		return ClassTimeout
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE),
+		errors.As(err, &dnsError),
+		errors.As(err, &operationError):
+		return ClassNetwork
+	}
+
+	return ClassUnknown
+}
+
+// CodeOf returns the Kafka protocol error code name of the error, e.g. "NOT_ENOUGH_REPLICAS", and
+// false if the error isn't a Kafka protocol error
+func CodeOf(err error) (string, bool) {
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		for _, e := range writeErrors {
+			if e != nil {
+				return CodeOf(e)
+			}
+		}
+	}
+
+	var kafkaError kafka.Error
+	if !errors.As(err, &kafkaError) {
+		return "", false
+	}
+	title := kafkaError.Title()
+	if title == "" {
+		return strconv.Itoa(int(kafkaError)), true
+	}
+	return strings.ToUpper(strings.ReplaceAll(title, " ", "_")), true
+}
+
+func classOfKafkaError(err kafka.Error) Class {
+	switch err {
+	case kafka.SASLAuthenticationFailed,
+		kafka.UnsupportedSASLMechanism,
+		kafka.IllegalSASLState,
+		kafka.UnacceptableCredential,
+		kafka.DelegationTokenExpired:
+		return ClassAuth
+	case kafka.TopicAuthorizationFailed,
+		kafka.GroupAuthorizationFailed,
+		kafka.ClusterAuthorizationFailed,
+		kafka.TransactionalIDAuthorizationFailed,
+		kafka.DelegationTokenAuthorizationFailed,
+		kafka.BrokerAuthorizationFailed:
+		return ClassAuthz
+	case kafka.RequestTimedOut:
+		return ClassTimeout
+	case kafka.NotLeaderForPartition,
+		kafka.LeaderNotAvailable,
+		kafka.FencedLeaderEpoch,
+		kafka.UnknownLeaderEpoch,
+		kafka.NotController,
+		kafka.PreferredLeaderNotAvailable,
+		kafka.EligibleLeadersNotAvailable:
+		return ClassNotLeader
+	case kafka.ThrottlingQuotaExceeded:
+		return ClassQuota
+	case kafka.NotEnoughReplicas,
+		kafka.NotEnoughReplicasAfterAppend,
+		kafka.ReplicaNotAvailable,
+		kafka.KafkaStorageError:
+		return ClassReplication
+	case kafka.GroupCoordinatorNotAvailable,
+		kafka.NotCoordinatorForGroup,
+		kafka.GroupLoadInProgress,
+		kafka.RebalanceInProgress,
+		kafka.ConcurrentTransactions,
+		kafka.TransactionCoordinatorFenced:
+		return ClassCoordinator
+	case kafka.UnknownTopicOrPartition,
+		kafka.InvalidTopic,
+		kafka.InvalidPartitionNumber,
+		kafka.UnknownTopicID:
+		return ClassTopic
+	case kafka.MessageSizeTooLarge,
+		kafka.RecordListTooLarge,
+		kafka.InvalidMessage,
+		kafka.InvalidMessageSize,
+		kafka.InvalidRecord:
+		return ClassRecord
+	case kafka.NetworkException,
+		kafka.BrokerNotAvailable:
+		return ClassNetwork
+	}
+	return ClassUnknown
+}
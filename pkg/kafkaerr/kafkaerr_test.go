@@ -0,0 +1,76 @@
+package kafkaerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassOf(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected Class
+	}{
+		{nil, ClassNone},
+		{errors.New("foobar"), ClassUnknown},
+		{kafka.SASLAuthenticationFailed, ClassAuth},
+		{kafka.TopicAuthorizationFailed, ClassAuthz},
+		{kafka.GroupAuthorizationFailed, ClassAuthz},
+		{kafka.RequestTimedOut, ClassTimeout},
+		{kafka.NotLeaderForPartition, ClassNotLeader},
+		{kafka.ThrottlingQuotaExceeded, ClassQuota},
+		{kafka.NotEnoughReplicas, ClassReplication},
+		{kafka.UnknownTopicOrPartition, ClassTopic},
+		{kafka.MessageSizeTooLarge, ClassRecord},
+		{fmt.Errorf("wrapped: %w", kafka.NotEnoughReplicasAfterAppend), ClassReplication},
+		{kafka.WriteErrors{nil, kafka.TopicAuthorizationFailed}, ClassAuthz},
+		{context.DeadlineExceeded, ClassTimeout},
+		{context.Canceled, ClassCanceled},
+		{io.EOF, ClassNetwork},
+		{syscall.ECONNREFUSED, ClassNetwork},
+		{&Error{Class: ClassQuota, Err: errors.New("foobar")}, ClassQuota},
+	}
+
+	for _, tst := range cases {
+		assert.Equal(t, tst.expected, ClassOf(tst.err), "for case: %v", tst.err)
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+		ok       bool
+	}{
+		{nil, "", false},
+		{errors.New("foobar"), "", false},
+		{kafka.NotEnoughReplicas, "NOT_ENOUGH_REPLICAS", true},
+		{fmt.Errorf("wrapped: %w", kafka.RequestTimedOut), "REQUEST_TIMED_OUT", true},
+		{Wrap(kafka.NotLeaderForPartition), "NOT_LEADER_FOR_PARTITION", true},
+		{kafka.WriteErrors{nil, kafka.TopicAuthorizationFailed}, "TOPIC_AUTHORIZATION_FAILED", true},
+	}
+
+	for _, tst := range cases {
+		code, ok := CodeOf(tst.err)
+		assert.Equal(t, tst.expected, code, "for case: %v", tst.err)
+		assert.Equal(t, tst.ok, ok, "for case: %v", tst.err)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	assert.Nil(t, Wrap(nil))
+
+	err := Wrap(kafka.SASLAuthenticationFailed)
+	assert.True(t, errors.Is(err, ErrAuth))
+	assert.False(t, errors.Is(err, ErrAuthz))
+	assert.True(t, errors.Is(err, kafka.SASLAuthenticationFailed))
+
+	// wrapping twice keeps the original class
+	assert.Equal(t, err, Wrap(err))
+}
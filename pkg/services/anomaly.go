@@ -0,0 +1,111 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+var (
+	latencyBaselineGauge = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "latency_baseline",
+		Namespace: metricsNamespace,
+		Help:      "Learned latency baseline in milliseconds, by kind of latency",
+	}, []string{"kind"})
+
+	latencyAnomaly = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "latency_anomaly",
+		Namespace: metricsNamespace,
+		Help:      "Whether the recent latency deviates from the learned baseline by more than the anomaly factor (1), by kind of latency",
+	}, []string{"kind"})
+
+	latencyAnomalies = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "latency_anomalies_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of latency anomalies detected, by kind of latency",
+	}, []string{"kind"})
+)
+
+// latencyDetector learns a latency baseline with a slow exponentially weighted moving average and
+// flags the recent latency, a fast one, deviating from it by more than the anomaly factor. It
+// catches the slow degradations staying under the static thresholds.
+type latencyDetector struct {
+	state  *State
+	kind   string
+	config canary.AnomalyConfig
+	logger *zerolog.Logger
+
+	lock sync.Mutex
+	// samples observed, the detection starts after a tenth of the baseline ones
+	samples   int
+	baseline  float64
+	recent    float64
+	anomalous bool
+}
+
+// newLatencyDetector returns the detector of the kind of latency, nil when anomaly detection is
+// disabled
+func newLatencyDetector(state *State, kind string, config canary.AnomalyConfig, logger *zerolog.Logger) *latencyDetector {
+	if !config.Enabled {
+		return nil
+	}
+	return &latencyDetector{state: state, kind: kind, config: config, logger: logger}
+}
+
+// observe adds a latency sample, updating the anomaly state
+func (d *latencyDetector) observe(latency time.Duration) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	sample := float64(latency.Milliseconds())
+	if d.samples == 0 {
+		d.baseline, d.recent = sample, sample
+	}
+	d.samples++
+	d.baseline += ewmaWeight(d.config.BaselineSamples) * (sample - d.baseline)
+	d.recent += ewmaWeight(d.config.RecentSamples) * (sample - d.recent)
+	latencyBaselineGauge.In(d.state.metrics).WithLabelValues(d.kind).Set(d.baseline)
+	if d.samples < d.config.BaselineSamples/10 || d.baseline <= 0 {
+		return
+	}
+
+	anomalous := d.recent > d.baseline*d.config.Factor
+	if anomalous == d.anomalous {
+		return
+	}
+	d.anomalous = anomalous
+	if anomalous {
+		latencyAnomaly.In(d.state.metrics).WithLabelValues(d.kind).Set(1)
+		latencyAnomalies.In(d.state.metrics).WithLabelValues(d.kind).Inc()
+		d.logger.Warn().
+			Str("kind", d.kind).
+			Float64("recent", d.recent).
+			Float64("baseline", d.baseline).
+			Msg("Latency anomaly detected")
+		d.state.recordEvent(EventWarning, "anomaly", "%s latency of %.0fms deviates from the %.0fms baseline", d.kind, d.recent, d.baseline)
+		return
+	}
+	latencyAnomaly.In(d.state.metrics).WithLabelValues(d.kind).Set(0)
+	d.logger.Info().
+		Str("kind", d.kind).
+		Float64("recent", d.recent).
+		Float64("baseline", d.baseline).
+		Msg("Latency back to the baseline")
+	d.state.recordEvent(EventInfo, "anomaly", "%s latency of %.0fms back to the %.0fms baseline", d.kind, d.recent, d.baseline)
+}
+
+// ewmaWeight returns the weight of a new sample in a moving average over about that many samples
+func ewmaWeight(samples int) float64 {
+	if samples < 1 {
+		samples = 1
+	}
+	return 2 / (float64(samples) + 1)
+}
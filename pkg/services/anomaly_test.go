@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestLatencyDetector(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	assert.Nil(t, newLatencyDetector(st, "test", canary.AnomalyConfig{}, &logger))
+
+	d := newLatencyDetector(st, "test", canary.AnomalyConfig{Enabled: true, Factor: 2, BaselineSamples: 100, RecentSamples: 5}, &logger)
+	anomalies := testutil.ToFloat64(latencyAnomalies.In(st.metrics).WithLabelValues("test"))
+
+	// a stable latency is learned as the baseline, no anomaly before the warm-up samples
+	for i := 0; i < 200; i++ {
+		d.observe(20 * time.Millisecond)
+	}
+	assert.InDelta(t, 20, testutil.ToFloat64(latencyBaselineGauge.In(st.metrics).WithLabelValues("test")), 0.1)
+	assert.Equal(t, 0.0, testutil.ToFloat64(latencyAnomaly.In(st.metrics).WithLabelValues("test")))
+
+	// a latency well under any static threshold but 3 times the baseline is an anomaly
+	for i := 0; i < 10; i++ {
+		d.observe(60 * time.Millisecond)
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(latencyAnomaly.In(st.metrics).WithLabelValues("test")))
+	assert.Equal(t, anomalies+1, testutil.ToFloat64(latencyAnomalies.In(st.metrics).WithLabelValues("test")))
+
+	// it ends once the latency is back to the baseline
+	for i := 0; i < 10; i++ {
+		d.observe(20 * time.Millisecond)
+	}
+	assert.Equal(t, 0.0, testutil.ToFloat64(latencyAnomaly.In(st.metrics).WithLabelValues("test")))
+	assert.Equal(t, anomalies+1, testutil.ToFloat64(latencyAnomalies.In(st.metrics).WithLabelValues("test")))
+}
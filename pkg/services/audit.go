@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// timeout of the writes to the audit topic
+const auditWriteTimeout = 10 * time.Second
+
+var (
+	auditRecords = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "audit_records_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of mutating admin operations audited, by operation and outcome",
+	}, []string{"operation", "outcome"})
+
+	auditTopicErrors = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "audit_topic_errors_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of audit records that couldn't be written to the audit topic",
+	})
+)
+
+// auditLog is where the mutating admin operations of a canary are recorded
+type auditLog struct {
+	lock   sync.Mutex
+	logger *zerolog.Logger
+	// writes the audit records to the audit topic, nil without one
+	writer *kafka.Writer
+}
+
+// OpenAuditLog starts recording the mutating admin operations of the canary to the log and, when
+// configured, to the audit topic
+func (st *State) OpenAuditLog(canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) error {
+	var writer *kafka.Writer
+	if canaryConfig.AuditTopic != "" {
+		connector, err := client.NewConnector(connectorConfig)
+		if err != nil {
+			return err
+		}
+		writer = &kafka.Writer{
+			Addr:         connector.KafkaClient.Addr,
+			Transport:    connector.KafkaClient.Transport,
+			Topic:        canaryConfig.AuditTopic,
+			RequiredAcks: kafka.RequireAll,
+		}
+	}
+
+	a := &st.audit
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.closeWriter()
+	a.logger = logger
+	a.writer = writer
+	return nil
+}
+
+// CloseAuditLog closes the audit topic writer, the operations are still logged
+func (st *State) CloseAuditLog() {
+	st.audit.lock.Lock()
+	defer st.audit.lock.Unlock()
+	st.audit.closeWriter()
+}
+
+func (a *auditLog) closeWriter() {
+	if a.writer == nil {
+		return
+	}
+	if err := a.writer.Close(); err != nil && a.logger != nil {
+		a.logger.Error().Err(err).Msg("Error closing the audit topic writer")
+	}
+	a.writer = nil
+}
+
+// auditOperation records a mutating admin operation, it's the auditor of the canary admin clients.
+// The operations are logged whatever the log level.
+func (st *State) auditOperation(record client.AuditRecord) {
+	auditRecords.In(st.metrics).WithLabelValues(record.Operation, record.Outcome).Inc()
+
+	a := &st.audit
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.logger == nil {
+		return
+	}
+	a.logger.Log().
+		Bool("audit", true).
+		Time("time", record.Time).
+		Str("principal", record.Principal).
+		Str("operation", record.Operation).
+		Str("resource", record.Resource).
+		Str("details", record.Details).
+		Str("outcome", record.Outcome).
+		Str("error", record.Error).
+		Msg("Admin operation")
+	if a.writer == nil {
+		return
+	}
+
+	value, err := json.Marshal(record)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), auditWriteTimeout)
+		defer cancel()
+		err = a.writer.WriteMessages(ctx, kafka.Message{Key: []byte(record.Resource), Value: value})
+	}
+	if err != nil {
+		st.countKafkaError("Produce", err)
+		auditTopicErrors.In(st.metrics).Inc()
+		a.logger.Error().Err(err).Str("topic", a.writer.Topic).Msg("Error writing the audit record")
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// fakeAuditTransport records the keys and values of the records produced to the audit topic,
+// failing the produce requests with the error when set
+type fakeAuditTransport struct {
+	err    kafka.Error
+	keys   []string
+	values [][]byte
+}
+
+func (t *fakeAuditTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		return &metadata.Response{
+			Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "broker", Port: 9092}},
+			Topics: []metadata.ResponseTopic{{
+				Name:       req.TopicNames[0],
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0, LeaderID: 1}},
+			}},
+		}, nil
+	case *produce.Request:
+		record, err := req.Topics[0].Partitions[0].RecordSet.Records.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := io.ReadAll(record.Key)
+		value, _ := io.ReadAll(record.Value)
+		t.keys = append(t.keys, string(key))
+		t.values = append(t.values, value)
+		return &produce.Response{Topics: []produce.ResponseTopic{{
+			Topic:      req.Topics[0].Topic,
+			Partitions: []produce.ResponsePartition{{Partition: 0, ErrorCode: int16(t.err)}},
+		}}}, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func testAuditRecord() client.AuditRecord {
+	return client.AuditRecord{
+		Time:      time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC),
+		Principal: "User:canary",
+		Operation: "CreateTopics",
+		Resource:  "topic:__kafka_canary",
+		Details:   "partitions=3 replication_factor=3",
+		Outcome:   client.AuditSuccess,
+	}
+}
+
+func TestAuditLogFile(t *testing.T) {
+	st := newTestState()
+	var out bytes.Buffer
+	// audited whatever the log level
+	logger := zerolog.New(&out).Level(zerolog.ErrorLevel)
+	require.NoError(t, st.OpenAuditLog(canary.Config{}, client.ConnectorConfig{}, &logger))
+	defer st.CloseAuditLog()
+	assert.Nil(t, st.audit.writer, "no writer without audit topic")
+
+	st.auditOperation(testAuditRecord())
+	var logged map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &logged))
+	assert.Equal(t, true, logged["audit"])
+	assert.Equal(t, "User:canary", logged["principal"])
+	assert.Equal(t, "CreateTopics", logged["operation"])
+	assert.Equal(t, "topic:__kafka_canary", logged["resource"])
+	assert.Equal(t, "partitions=3 replication_factor=3", logged["details"])
+	assert.Equal(t, client.AuditSuccess, logged["outcome"])
+	assert.Equal(t, "Admin operation", logged["message"])
+	assert.Equal(t, 1.0, testutil.ToFloat64(auditRecords.In(st.metrics).WithLabelValues("CreateTopics", client.AuditSuccess)))
+}
+
+func TestAuditLogTopic(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	require.NoError(t, st.OpenAuditLog(canary.Config{AuditTopic: "__kafka_canary_audit"}, client.ConnectorConfig{BrokerAddrs: []string{"broker:9092"}}, &logger))
+	defer st.CloseAuditLog()
+	require.NotNil(t, st.audit.writer)
+	transport := &fakeAuditTransport{}
+	st.audit.writer.Transport = transport
+	st.audit.writer.BatchTimeout = time.Millisecond
+
+	record := testAuditRecord()
+	st.auditOperation(record)
+	require.Len(t, transport.values, 1)
+	assert.Equal(t, []string{"topic:__kafka_canary"}, transport.keys, "keyed by resource")
+	var written client.AuditRecord
+	require.NoError(t, json.Unmarshal(transport.values[0], &written))
+	assert.Equal(t, record, written)
+	assert.Equal(t, 0.0, testutil.ToFloat64(auditTopicErrors.In(st.metrics)))
+
+	// the failed writes are counted, the operation is still logged
+	transport.err = kafka.TopicAuthorizationFailed
+	st.auditOperation(record)
+	assert.Equal(t, 1.0, testutil.ToFloat64(auditTopicErrors.In(st.metrics)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(auditRecords.In(st.metrics).WithLabelValues("CreateTopics", client.AuditSuccess)))
+
+	// closed with the canary, the operations are only logged afterwards
+	st.CloseAuditLog()
+	assert.Nil(t, st.audit.writer)
+	st.auditOperation(record)
+	assert.Len(t, transport.values, 2)
+}
+
+func TestAuditAdminOperation(t *testing.T) {
+	st := newTestState()
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	require.NoError(t, st.OpenAuditLog(canary.Config{AuditTopic: "__kafka_canary_audit"}, client.ConnectorConfig{BrokerAddrs: []string{"broker:9092"}}, &logger))
+	defer st.CloseAuditLog()
+	transport := &fakeAuditTransport{}
+	st.audit.writer.Transport = transport
+	st.audit.writer.BatchTimeout = time.Millisecond
+
+	// a refused admin operation, as audited by the admin clients
+	record := testAuditRecord()
+	record.Outcome = client.AuditFailure
+	record.Error = "[29] Topic Authorization Failed"
+	st.auditOperation(record)
+
+	var logged map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &logged))
+	assert.Equal(t, client.AuditFailure, logged["outcome"])
+	assert.Equal(t, "[29] Topic Authorization Failed", logged["error"])
+
+	require.Len(t, transport.values, 1)
+	var written map[string]interface{}
+	require.NoError(t, json.Unmarshal(transport.values[0], &written))
+	assert.Equal(t, map[string]interface{}{
+		"time":      "2023-03-01T12:00:00Z",
+		"principal": "User:canary",
+		"operation": "CreateTopics",
+		"resource":  "topic:__kafka_canary",
+		"details":   "partitions=3 replication_factor=3",
+		"outcome":   client.AuditFailure,
+		"error":     "[29] Topic Authorization Failed",
+	}, written)
+	assert.Equal(t, 1.0, testutil.ToFloat64(auditRecords.In(st.metrics).WithLabelValues("CreateTopics", client.AuditFailure)))
+}
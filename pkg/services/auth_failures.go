@@ -0,0 +1,75 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	authFailuresTotal = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "auth_failures_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of authentication (SASL, TLS) and authorization (ACL) failures, by service or check",
+	}, []string{"service", "class"})
+
+	authFailing = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "auth_failing",
+		Namespace: metricsNamespace,
+		Help:      "Whether the last failure of a service or check was an authentication or authorization one (1)",
+	}, []string{"service", "class"})
+)
+
+// authFailures are the services and checks of a canary whose last failure was an auth one, and
+// that failure
+type authFailures struct {
+	lock    sync.RWMutex
+	failing map[string]error
+}
+
+// observeAuthFailure records the result of the service, flagging it while it fails authenticating
+// or being authorized, so an expired credential doesn't look like a broker outage
+func (st *State) observeAuthFailure(service string, err error) {
+	class := kafkaerr.ClassOf(err)
+	auth := class == kafkaerr.ClassAuth || class == kafkaerr.ClassAuthz
+
+	a := &st.auth
+	a.lock.RLock()
+	previous, failing := a.failing[service]
+	a.lock.RUnlock()
+	if !auth && !failing {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if failing {
+		authFailing.In(st.metrics).WithLabelValues(service, string(kafkaerr.ClassOf(previous))).Set(0)
+	}
+	if !auth {
+		delete(a.failing, service)
+		st.recordEvent(EventInfo, service, "authentication and authorization succeeding again")
+		return
+	}
+	if !failing {
+		st.recordEvent(EventError, service, "%s failure: %v", class, err)
+	}
+	a.failing[service] = err
+	authFailuresTotal.In(st.metrics).WithLabelValues(service, string(class)).Inc()
+	authFailing.In(st.metrics).WithLabelValues(service, string(class)).Set(1)
+}
+
+// AuthFailures returns the services and checks whose last failure was an authentication or
+// authorization one, and that failure
+func (st *State) AuthFailures() map[string]string {
+	st.auth.lock.RLock()
+	defer st.auth.lock.RUnlock()
+	failures := make(map[string]string, len(st.auth.failing))
+	for service, err := range st.auth.failing {
+		failures[service] = err.Error()
+	}
+	return failures
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveAuthFailure(t *testing.T) {
+	st := newTestState()
+	st.observeAuthFailure("producer", errors.New("broker down"))
+	assert.Empty(t, st.AuthFailures())
+
+	st.markDegraded("producer", kafka.SASLAuthenticationFailed)
+	assert.Contains(t, st.AuthFailures(), "producer")
+	assert.Equal(t, 1.0, testutil.ToFloat64(authFailing.In(st.metrics).WithLabelValues("producer", "auth")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(authFailuresTotal.In(st.metrics).WithLabelValues("producer", "auth")))
+
+	// the credential works but the ACL is missing
+	st.markDegraded("producer", kafka.TopicAuthorizationFailed)
+	assert.Equal(t, 0.0, testutil.ToFloat64(authFailing.In(st.metrics).WithLabelValues("producer", "auth")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(authFailing.In(st.metrics).WithLabelValues("producer", "authz")))
+
+	st.markHealthy("producer")
+	assert.Empty(t, st.AuthFailures())
+	assert.Equal(t, 0.0, testutil.ToFloat64(authFailing.In(st.metrics).WithLabelValues("producer", "authz")))
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	bandwidthThroughput = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "bandwidth_probe_bytes_per_second",
+		Namespace: metricsNamespace,
+		Help:      "Throughput achieved by the last bandwidth probe burst, by direction",
+	}, []string{"direction"})
+
+	bandwidthDuration = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "bandwidth_probe_duration",
+		Namespace: metricsNamespace,
+		Help:      "Time taken by the last bandwidth probe burst in milliseconds, by direction",
+	}, []string{"direction"})
+
+	bandwidthBytes = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "bandwidth_probe_bytes_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of record bytes moved by the bandwidth probe, by direction",
+	}, []string{"direction"})
+)
+
+// bandwidthService writes a burst of records to a dedicated topic and reads it back, measuring the
+// achieved throughput each way. The latency canary moves too little data to see capacity shrink.
+type bandwidthService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+
+	// value of every record, random so compression doesn't inflate the throughput
+	payload []byte
+}
+
+func NewBandwidthService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	if canaryConfig.Bandwidth.RecordSize <= 0 || canaryConfig.Bandwidth.Volume <= 0 {
+		return nil, errors.New("the bandwidth probe volume and record size must be positive")
+	}
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, canaryConfig.Bandwidth.RecordSize)
+	if _, err := rand.Read(payload); err != nil {
+		return nil, err
+	}
+
+	return &bandwidthService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+		payload:      payload,
+	}, nil
+}
+
+func (s *bandwidthService) Name() string {
+	return "bandwidth"
+}
+
+func (s *bandwidthService) Interval() time.Duration {
+	return s.canaryConfig.Bandwidth.Interval
+}
+
+func (s *bandwidthService) Check(ctx context.Context) error {
+	partitions, err := s.partitions(ctx)
+	if err != nil {
+		return err
+	}
+	start, err := s.lastOffsets(ctx, partitions)
+	if err != nil {
+		return err
+	}
+
+	written, elapsed, err := s.write(ctx)
+	if err != nil {
+		return err
+	}
+	produce := s.observe("produce", written, elapsed)
+
+	end, err := s.lastOffsets(ctx, partitions)
+	if err != nil {
+		return err
+	}
+	read, elapsed, err := s.read(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	consume := s.observe("consume", read, elapsed)
+
+	s.logger.Debug().
+		Int64("bytes", written).
+		Float64("produce_bytes_per_second", produce).
+		Float64("consume_bytes_per_second", consume).
+		Msg("Bandwidth probe burst done")
+
+	if threshold := s.canaryConfig.Bandwidth.MinThroughput * (1 << 20); threshold > 0 && (produce < threshold || consume < threshold) {
+		return fmt.Errorf("bandwidth probe throughput of %.1f MiB/s produce and %.1f MiB/s consume below %.1f MiB/s",
+			produce/(1<<20), consume/(1<<20), s.canaryConfig.Bandwidth.MinThroughput)
+	}
+	return nil
+}
+
+func (s *bandwidthService) Close() {}
+
+// observe exports the bytes moved in the direction and returns the throughput
+func (s *bandwidthService) observe(direction string, bytes int64, elapsed time.Duration) float64 {
+	throughput := float64(bytes) / elapsed.Seconds()
+	bandwidthThroughput.In(s.state.metrics).WithLabelValues(direction).Set(throughput)
+	bandwidthDuration.In(s.state.metrics).WithLabelValues(direction).Set(float64(elapsed.Milliseconds()))
+	bandwidthBytes.In(s.state.metrics).WithLabelValues(direction).Add(float64(bytes))
+	return throughput
+}
+
+// write produces the configured volume spread over the topic partitions, returning the bytes
+// written and the time it took
+func (s *bandwidthService) write(ctx context.Context) (int64, time.Duration, error) {
+	config := s.canaryConfig.Bandwidth
+	batchBytes := int64(1 << 20)
+	if size := int64(config.RecordSize + recordOverhead); size > batchBytes {
+		batchBytes = size
+	}
+	writer := &kafka.Writer{
+		Addr:         s.connector.KafkaClient.Addr,
+		Transport:    s.connector.KafkaClient.Transport,
+		Topic:        config.Topic,
+		Balancer:     &kafka.RoundRobin{},
+		BatchBytes:   batchBytes,
+		BatchTimeout: time.Millisecond,
+		RequiredAcks: kafka.RequireAll,
+	}
+	defer writer.Close()
+
+	messages := make([]kafka.Message, (config.Volume<<20)/config.RecordSize)
+	for i := range messages {
+		messages[i] = kafka.Message{
+			Value:   s.payload,
+			Headers: []kafka.Header{{Key: CheckHeader, Value: []byte(s.Name())}},
+		}
+	}
+	start := time.Now()
+	if err := writer.WriteMessages(ctx, messages...); err != nil {
+		s.state.countKafkaError("Produce", err)
+		return 0, 0, kafkaerr.Wrap(err)
+	}
+	return int64(len(messages) * len(s.payload)), time.Since(start), nil
+}
+
+// read fetches the partitions between the given offsets concurrently, returning the record bytes
+// read and the time it took
+func (s *bandwidthService) read(ctx context.Context, start, end map[int]int64) (int64, time.Duration, error) {
+	began := time.Now()
+	var (
+		lock  sync.Mutex
+		total int64
+		errs  []error
+		wg    sync.WaitGroup
+	)
+	for partition := range end {
+		if end[partition] <= start[partition] {
+			continue
+		}
+		wg.Add(1)
+		go func(partition int) {
+			defer wg.Done()
+			read, err := s.readPartition(ctx, partition, start[partition], end[partition])
+			lock.Lock()
+			defer lock.Unlock()
+			total += read
+			if err != nil {
+				errs = append(errs, fmt.Errorf("partition %d: %w", partition, err))
+			}
+		}(partition)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return 0, 0, fmt.Errorf("error reading back the bandwidth probe burst: %v", errs)
+	}
+	return total, time.Since(began), nil
+}
+
+// readPartition fetches the partition records from start until end
+func (s *bandwidthService) readPartition(ctx context.Context, partition int, start, end int64) (int64, error) {
+	var read int64
+	offset := start
+	for offset < end {
+		fetched, err := s.connector.KafkaClient.Fetch(ctx, &kafka.FetchRequest{
+			Topic:     s.canaryConfig.Bandwidth.Topic,
+			Partition: partition,
+			Offset:    offset,
+			MinBytes:  1,
+			MaxBytes:  8 << 20,
+			MaxWait:   time.Second,
+		})
+		if err == nil {
+			err = fetched.Error
+		}
+		if err != nil {
+			s.state.countKafkaError("Fetch", err)
+			return read, kafkaerr.Wrap(err)
+		}
+		for offset < end {
+			record, err := fetched.Records.ReadRecord()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return read, err
+			}
+			// the first batch returned can start before the requested offset
+			if record.Offset < offset {
+				continue
+			}
+			if record.Value != nil {
+				n, err := io.Copy(io.Discard, record.Value)
+				if err != nil {
+					return read, err
+				}
+				read += n
+			}
+			offset = record.Offset + 1
+		}
+	}
+	return read, nil
+}
+
+// partitions returns the partitions of the bandwidth probe topic
+func (s *bandwidthService) partitions(ctx context.Context) ([]int, error) {
+	topic := s.canaryConfig.Bandwidth.Topic
+	metadata, err := s.connector.KafkaClient.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		s.state.countKafkaError("Metadata", err)
+		return nil, kafkaerr.Wrap(err)
+	}
+	var partitions []int
+	for _, t := range metadata.Topics {
+		if t.Error != nil {
+			s.state.countKafkaError("Metadata", t.Error)
+			return nil, kafkaerr.Wrap(t.Error)
+		}
+		for _, p := range t.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("bandwidth probe topic %s has no partitions", topic)
+	}
+	return partitions, nil
+}
+
+// lastOffsets returns the end offsets of the partitions of the bandwidth probe topic
+func (s *bandwidthService) lastOffsets(ctx context.Context, partitions []int) (map[int]int64, error) {
+	topic := s.canaryConfig.Bandwidth.Topic
+	requests := make([]kafka.OffsetRequest, 0, len(partitions))
+	for _, partition := range partitions {
+		requests = append(requests, kafka.LastOffsetOf(partition))
+	}
+	resp, err := s.connector.KafkaClient.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		s.state.countKafkaError("ListOffsets", err)
+		return nil, kafkaerr.Wrap(err)
+	}
+	offsets := make(map[int]int64, len(partitions))
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			s.state.countKafkaError("ListOffsets", p.Error)
+			return nil, kafkaerr.Wrap(p.Error)
+		}
+		offsets[p.Partition] = p.LastOffset
+	}
+	return offsets, nil
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+func TestNewBandwidthServiceInvalid(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	_, err := NewBandwidthService(st, canary.Config{Bandwidth: canary.BandwidthConfig{Volume: 64}}, client.ConnectorConfig{}, &logger)
+	assert.Error(t, err)
+}
+
+func TestBandwidthObserve(t *testing.T) {
+	st := newTestState()
+	s := &bandwidthService{state: st}
+	throughput := s.observe("produce", 64<<20, 2*time.Second)
+	assert.Equal(t, float64(32<<20), throughput)
+	assert.Equal(t, float64(32<<20), testutil.ToFloat64(bandwidthThroughput.In(st.metrics).WithLabelValues("produce")))
+	assert.Equal(t, 2000.0, testutil.ToFloat64(bandwidthDuration.In(st.metrics).WithLabelValues("produce")))
+	assert.Equal(t, float64(64<<20), testutil.ToFloat64(bandwidthBytes.In(st.metrics).WithLabelValues("produce")))
+}
@@ -0,0 +1,93 @@
+// Package services defines an interface for canary services and related implementations
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// InstanceHeader is the header carrying the instance ID of the canary producing a record
+const InstanceHeader = "kafka-canary-instance"
+
+// ZoneHeader is the header carrying the zone of the canary producing a record
+const ZoneHeader = "kafka-canary-zone"
+
+// PodHeader is the header carrying the Kubernetes pod of the canary producing a record
+const PodHeader = "kafka-canary-pod"
+
+// NodeHeader is the header carrying the Kubernetes node of the canary producing a record
+const NodeHeader = "kafka-canary-node"
+
+const (
+	// LegacyRecordVersion is the plain JSON encoding, without magic byte and version
+	LegacyRecordVersion = 0
+	// CurrentRecordVersion is the latest encoding version: the magic byte, the version and the JSON
+	CurrentRecordVersion = 1
+
+	// recordMagic starts the versioned record values, plain JSON ones start with '{'
+	recordMagic byte = 0xCA
+)
+
+// ErrUnsupportedRecordVersion is returned when decoding a record written by a newer canary
+type ErrUnsupportedRecordVersion struct {
+	Version int
+}
+
+func (e *ErrUnsupportedRecordVersion) Error() string {
+	return fmt.Sprintf("unsupported canary record version %d", e.Version)
+}
+
+// isUnsupportedRecordVersion returns true when the error is an ErrUnsupportedRecordVersion
+func isUnsupportedRecordVersion(err error) bool {
+	var unsupported *ErrUnsupportedRecordVersion
+	return errors.As(err, &unsupported)
+}
+
+// CanaryMessage defines the payload of a canary message
+type CanaryMessage struct {
+	ProducerID string `json:"producerId"`
+	MessageID  int    `json:"messageId"`
+	Sequence   int64  `json:"sequence,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// NewCanaryMessage decodes a canary record value, either framed with its encoding version or
+// the plain JSON written by the canaries predating versions
+func NewCanaryMessage(bytes []byte) (CanaryMessage, error) {
+	var cm CanaryMessage
+	version := LegacyRecordVersion
+	if len(bytes) >= 2 && bytes[0] == recordMagic {
+		version = int(bytes[1])
+		bytes = bytes[2:]
+	}
+	if version > CurrentRecordVersion {
+		return cm, &ErrUnsupportedRecordVersion{Version: version}
+	}
+	// the JSON written by the canaries is decoded without allocating
+	if decodeCanaryMessage(bytes, &cm) {
+		return cm, nil
+	}
+	var decoded CanaryMessage
+	err := json.Unmarshal(bytes, &decoded)
+	return decoded, err
+}
+
+// Encode returns the record value with the given encoding version, the legacy one being the
+// plain JSON understood by every canary
+func (cm CanaryMessage) Encode(version int) []byte {
+	buf := make([]byte, 0, 96+len(cm.ProducerID))
+	if version != LegacyRecordVersion {
+		buf = append(buf, recordMagic, byte(version))
+	}
+	return cm.appendJSON(buf)
+}
+
+func (cm CanaryMessage) JSON() string {
+	return string(cm.appendJSON(nil))
+}
+
+func (cm CanaryMessage) String() string {
+	return fmt.Sprintf("{ProducerID:%s, MessageID:%d, Sequence:%d, Timestamp:%d}",
+		cm.ProducerID, cm.MessageID, cm.Sequence, cm.Timestamp)
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// bound of the producer IDs interned, beyond it the decoded ones are allocated
+const maxInternedProducerIDs = 1024
+
+var (
+	producerIDsLock sync.RWMutex
+	// producer IDs decoded so far, a handful of canaries share a topic
+	producerIDs = map[string]string{}
+)
+
+// appendJSON appends the JSON encoding of the message, byte for byte the one of encoding/json,
+// without allocating unless the producer ID needs escaping
+func (cm CanaryMessage) appendJSON(buf []byte) []byte {
+	if !isPlainJSONString(cm.ProducerID) {
+		data, _ := json.Marshal(cm)
+		return append(buf, data...)
+	}
+	buf = append(buf, `{"producerId":"`...)
+	buf = append(buf, cm.ProducerID...)
+	buf = append(buf, `","messageId":`...)
+	buf = strconv.AppendInt(buf, int64(cm.MessageID), 10)
+	if cm.Sequence != 0 {
+		buf = append(buf, `,"sequence":`...)
+		buf = strconv.AppendInt(buf, cm.Sequence, 10)
+	}
+	buf = append(buf, `,"timestamp":`...)
+	buf = strconv.AppendInt(buf, cm.Timestamp, 10)
+	return append(buf, '}')
+}
+
+// isPlainJSONString returns true when encoding/json writes the string as is between quotes
+func isPlainJSONString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeCanaryMessage decodes the JSON written by the canaries without allocating, returning
+// false for anything else (escaped strings, unknown fields, nulls, etc.) to be decoded by
+// encoding/json instead
+func decodeCanaryMessage(data []byte, cm *CanaryMessage) bool {
+	d := jsonDecoder{data: data}
+	if !d.consume('{') {
+		return false
+	}
+	if d.consume('}') {
+		return d.end()
+	}
+	for {
+		key, ok := d.string()
+		if !ok || !d.consume(':') {
+			return false
+		}
+		switch string(key) {
+		case "producerId":
+			value, ok := d.string()
+			if !ok {
+				return false
+			}
+			cm.ProducerID = internProducerID(value)
+		case "messageId":
+			value, ok := d.int()
+			if !ok || int64(int(value)) != value {
+				return false
+			}
+			cm.MessageID = int(value)
+		case "sequence":
+			if cm.Sequence, ok = d.int(); !ok {
+				return false
+			}
+		case "timestamp":
+			if cm.Timestamp, ok = d.int(); !ok {
+				return false
+			}
+		default:
+			return false
+		}
+		if d.consume(',') {
+			continue
+		}
+		return d.consume('}') && d.end()
+	}
+}
+
+// internProducerID returns the producer ID as a string, shared by all the records of a producer
+func internProducerID(value []byte) string {
+	producerIDsLock.RLock()
+	id, ok := producerIDs[string(value)]
+	producerIDsLock.RUnlock()
+	if ok {
+		return id
+	}
+
+	id = string(value)
+	producerIDsLock.Lock()
+	if len(producerIDs) < maxInternedProducerIDs {
+		producerIDs[id] = id
+	}
+	producerIDsLock.Unlock()
+	return id
+}
+
+// jsonDecoder reads the tokens of a flat JSON object
+type jsonDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *jsonDecoder) skipSpaces() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume reads the given delimiter
+func (d *jsonDecoder) consume(c byte) bool {
+	d.skipSpaces()
+	if d.pos < len(d.data) && d.data[d.pos] == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// end returns true when only spaces are left
+func (d *jsonDecoder) end() bool {
+	d.skipSpaces()
+	return d.pos == len(d.data)
+}
+
+// string reads a string without escape sequences, returning its bytes
+func (d *jsonDecoder) string() ([]byte, bool) {
+	if !d.consume('"') {
+		return nil, false
+	}
+	start := d.pos
+	for ; d.pos < len(d.data); d.pos++ {
+		switch c := d.data[d.pos]; {
+		case c == '"':
+			d.pos++
+			return d.data[start : d.pos-1], true
+		case c == '\\' || c < 0x20:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// int reads an integer, failing on overflow
+func (d *jsonDecoder) int() (int64, bool) {
+	d.skipSpaces()
+	negative := d.pos < len(d.data) && d.data[d.pos] == '-'
+	if negative {
+		d.pos++
+	}
+	start := d.pos
+	var value uint64
+	for ; d.pos < len(d.data) && d.data[d.pos] >= '0' && d.data[d.pos] <= '9'; d.pos++ {
+		digit := uint64(d.data[d.pos] - '0')
+		if value > (1<<63-digit)/10 {
+			return 0, false
+		}
+		value = value*10 + digit
+	}
+	if d.pos == start || (d.pos-start > 1 && d.data[start] == '0') {
+		return 0, false
+	}
+	if value == 1<<63 && !negative {
+		return 0, false
+	}
+	if negative {
+		return -int64(value), true
+	}
+	return int64(value), true
+}
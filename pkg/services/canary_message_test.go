@@ -0,0 +1,99 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanaryMessageEncoding(t *testing.T) {
+	message := CanaryMessage{ProducerID: "canary", MessageID: 42, Timestamp: 1600000000000, Sequence: 7}
+
+	for _, version := range []int{LegacyRecordVersion, CurrentRecordVersion} {
+		decoded, err := NewCanaryMessage(message.Encode(version))
+		require.NoError(t, err, "version %d", version)
+		assert.Equal(t, message, decoded, "version %d", version)
+	}
+
+	// records written by canaries predating the versions
+	decoded, err := NewCanaryMessage([]byte(message.JSON()))
+	require.NoError(t, err)
+	assert.Equal(t, message, decoded)
+}
+
+func TestCanaryMessageUnsupportedVersion(t *testing.T) {
+	message := CanaryMessage{ProducerID: "canary", MessageID: 1}
+
+	_, err := NewCanaryMessage(message.Encode(CurrentRecordVersion + 1))
+	var unsupported *ErrUnsupportedRecordVersion
+	require.True(t, errors.As(err, &unsupported))
+	assert.Equal(t, CurrentRecordVersion+1, unsupported.Version)
+
+	_, err = NewCanaryMessage([]byte("garbage"))
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &unsupported))
+}
+
+func TestCanaryMessageJSONCompatibility(t *testing.T) {
+	messages := []CanaryMessage{
+		{ProducerID: "canary", MessageID: 42, Timestamp: 1600000000000, Sequence: 7},
+		{ProducerID: "canary", MessageID: 1, Timestamp: 1600000000000},
+		{ProducerID: "", MessageID: -1, Timestamp: -5},
+		{ProducerID: "quote\"d <html> & ünïcode", MessageID: 1, Timestamp: 1},
+	}
+	for _, message := range messages {
+		expected, err := json.Marshal(message)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), message.JSON())
+	}
+}
+
+func TestCanaryMessageDecoding(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected CanaryMessage
+		err      bool
+	}{
+		{`{"producerId":"canary","messageId":1,"sequence":2,"timestamp":3}`, CanaryMessage{ProducerID: "canary", MessageID: 1, Sequence: 2, Timestamp: 3}, false},
+		{` { "timestamp" : 3 , "producerId" : "canary" } `, CanaryMessage{ProducerID: "canary", Timestamp: 3}, false},
+		{`{}`, CanaryMessage{}, false},
+		// decoded by encoding/json
+		{`{"producerId":"can\u0061ry","messageId":1,"timestamp":3}`, CanaryMessage{ProducerID: "canary", MessageID: 1, Timestamp: 3}, false},
+		{`{"producerId":"canary","messageId":1,"timestamp":3,"other":true}`, CanaryMessage{ProducerID: "canary", MessageID: 1, Timestamp: 3}, false},
+		{`{"ProducerID":"canary","messageId":null,"timestamp":3}`, CanaryMessage{ProducerID: "canary", Timestamp: 3}, false},
+		{`{"producerId":"canary","messageId":1.5}`, CanaryMessage{}, true},
+		{`{"producerId":"canary","timestamp":99999999999999999999}`, CanaryMessage{}, true},
+		{`{"producerId":"canary"`, CanaryMessage{}, true},
+		{`{"producerId":"canary"} trailing`, CanaryMessage{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			decoded, err := NewCanaryMessage([]byte(tt.value))
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, decoded)
+		})
+	}
+}
+
+func BenchmarkCanaryMessageEncode(b *testing.B) {
+	message := CanaryMessage{ProducerID: "canary", MessageID: 42, Timestamp: 1600000000000, Sequence: 7}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		message.Encode(CurrentRecordVersion)
+	}
+}
+
+func BenchmarkCanaryMessageDecode(b *testing.B) {
+	value := CanaryMessage{ProducerID: "canary", MessageID: 42, Timestamp: 1600000000000, Sequence: 7}.Encode(CurrentRecordVersion)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = NewCanaryMessage(value)
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+var (
+	// errInjectedFault is reported for failures forged by the chaos mode
+	errInjectedFault = errors.New("fault injected by the canary chaos mode")
+
+	chaosFaultsInjected = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "chaos_faults_injected_total",
+		Namespace: metricsNamespace,
+		Help:      "The total number of faults injected by the chaos mode",
+	}, []string{"fault"})
+)
+
+// chaos injects faults in the canary's own pipeline, so operators can verify their alerts fire.
+// A nil *chaos injects nothing.
+type chaos struct {
+	config  canary.ChaosConfig
+	metrics *metrics.Registry
+	mu      sync.Mutex
+	rand    *rand.Rand
+}
+
+func newChaos(config canary.ChaosConfig, registry *metrics.Registry) *chaos {
+	if !config.Enabled {
+		return nil
+	}
+	return &chaos{
+		config:  config,
+		metrics: registry,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())), // nolint: gosec
+	}
+}
+
+func (c *chaos) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < probability
+}
+
+func (c *chaos) inject(fault string) {
+	chaosFaultsInjected.In(c.metrics).WithLabelValues(fault).Inc()
+}
+
+// produceDelay sleeps before producing a record
+func (c *chaos) produceDelay() {
+	if c == nil || c.config.ProduceDelay <= 0 {
+		return
+	}
+	c.inject("produce_delay")
+	time.Sleep(c.config.ProduceDelay)
+}
+
+// consumeDelay sleeps before accounting a consumed record, inflating the end-to-end latency
+func (c *chaos) consumeDelay() {
+	if c == nil || c.config.ConsumeDelay <= 0 {
+		return
+	}
+	c.inject("consume_delay")
+	time.Sleep(c.config.ConsumeDelay)
+}
+
+// dropAck returns an error to report a successfully produced record as failed
+func (c *chaos) dropAck() error {
+	if c == nil || !c.chance(c.config.DropAckRate) {
+		return nil
+	}
+	c.inject("drop_ack")
+	return errInjectedFault
+}
+
+// dropRecord returns true if a consumed record should be discarded as if it was lost
+func (c *chaos) dropRecord() bool {
+	if c == nil || !c.chance(c.config.DropRecordRate) {
+		return false
+	}
+	c.inject("drop_record")
+	return true
+}
+
+// sequenceGap returns how many message IDs should be skipped to forge a gap in the sequence
+func (c *chaos) sequenceGap() int {
+	if c == nil || !c.chance(c.config.SequenceGapRate) {
+		return 0
+	}
+	c.inject("sequence_gap")
+	return 1
+}
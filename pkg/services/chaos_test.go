@@ -0,0 +1,82 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestChaosDisabled(t *testing.T) {
+	st := newTestState()
+	c := newChaos(canary.ChaosConfig{Enabled: false, DropAckRate: 1, DropRecordRate: 1, SequenceGapRate: 1}, st.metrics)
+	assert.Nil(t, c)
+	assert.NoError(t, c.dropAck())
+	assert.False(t, c.dropRecord())
+	assert.Equal(t, 0, c.sequenceGap())
+	c.produceDelay()
+	c.consumeDelay()
+}
+
+func TestChaosFaults(t *testing.T) {
+	st := newTestState()
+	always := newChaos(canary.ChaosConfig{Enabled: true, DropAckRate: 1, DropRecordRate: 1, SequenceGapRate: 1}, st.metrics)
+	never := newChaos(canary.ChaosConfig{Enabled: true}, st.metrics)
+	injected := func(fault string) float64 {
+		return testutil.ToFloat64(chaosFaultsInjected.In(st.metrics).WithLabelValues(fault))
+	}
+
+	before := injected("drop_ack")
+	assert.NoError(t, never.dropAck())
+	assert.ErrorIs(t, always.dropAck(), errInjectedFault)
+	assert.Equal(t, before+1, injected("drop_ack"))
+
+	before = injected("drop_record")
+	assert.False(t, never.dropRecord())
+	assert.True(t, always.dropRecord())
+	assert.Equal(t, before+1, injected("drop_record"))
+
+	before = injected("sequence_gap")
+	assert.Equal(t, 0, never.sequenceGap())
+	assert.Equal(t, 1, always.sequenceGap())
+	assert.Equal(t, before+1, injected("sequence_gap"))
+}
+
+func TestChaosDelays(t *testing.T) {
+	st := newTestState()
+	c := newChaos(canary.ChaosConfig{Enabled: true, ProduceDelay: 20 * time.Millisecond, ConsumeDelay: 20 * time.Millisecond}, st.metrics)
+	for _, fault := range []struct {
+		name  string
+		delay func()
+	}{
+		{"produce_delay", c.produceDelay},
+		{"consume_delay", c.consumeDelay},
+	} {
+		before := testutil.ToFloat64(chaosFaultsInjected.In(st.metrics).WithLabelValues(fault.name))
+		start := time.Now()
+		fault.delay()
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, fault.name)
+		assert.Equal(t, before+1, testutil.ToFloat64(chaosFaultsInjected.In(st.metrics).WithLabelValues(fault.name)))
+	}
+
+	before := testutil.ToFloat64(chaosFaultsInjected.In(st.metrics).WithLabelValues("produce_delay"))
+	newChaos(canary.ChaosConfig{Enabled: true}, st.metrics).produceDelay()
+	assert.Equal(t, before, testutil.ToFloat64(chaosFaultsInjected.In(st.metrics).WithLabelValues("produce_delay")), "no delay configured")
+}
+
+func TestConsumerChaosDropRecord(t *testing.T) {
+	s := newTestConsumer(t)
+	st := s.state
+	s.chaos = newChaos(canary.ChaosConfig{Enabled: true, DropRecordRate: 1}, st.metrics)
+	record := testRecords(1)[0]
+	key := consumedSequenceKey("canary-0", record.Partition)
+	s.handle(record, nil)
+	assert.Equal(t, int64(0), s.sequences.get(key), "dropped record verified")
+
+	s.chaos = nil
+	s.handle(record, nil)
+	assert.Equal(t, int64(1), s.sequences.get(key))
+}
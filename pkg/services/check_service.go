@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	checksRun = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "check_total",
+		Namespace: metricsNamespace,
+		Help:      "The total number of additional checks run",
+	}, []string{"check"})
+
+	checksFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "check_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "The total number of additional checks failed",
+	}, []string{"check", "error_class"})
+
+	checksLatency = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "check_latency",
+		Namespace: metricsNamespace,
+		Help:      "Additional checks latency in milliseconds",
+		Buckets:   []float64{10, 50, 100, 500, 1000, 5000, 10000, 30000},
+	}, []string{"check"})
+)
+
+// RunCheck runs the check within the given timeout, recording the standard check metrics
+func (st *State) RunCheck(check CheckService, timeout time.Duration, logger *zerolog.Logger) CheckResult {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := kafkaerr.Wrap(safeCheck(ctx, check, logger))
+	duration := time.Since(start)
+
+	labels := prometheus.Labels{
+		"check": check.Name(),
+	}
+	checksRun.In(st.metrics).With(labels).Inc()
+	checksLatency.In(st.metrics).With(labels).Observe(float64(duration.Milliseconds()))
+	if err != nil {
+		checksFailed.In(st.metrics).With(prometheus.Labels{
+			"check":       check.Name(),
+			"error_class": string(kafkaerr.ClassOf(err)),
+		}).Inc()
+		logger.Error().Err(err).Str("check", check.Name()).Msg("Check failed")
+	} else {
+		logger.Debug().Str("check", check.Name()).Dur("duration", duration).Msg("Check succeeded")
+	}
+
+	return CheckResult{
+		Name:      check.Name(),
+		Timestamp: start,
+		Latency:   duration,
+		Err:       err,
+	}
+}
+
+// safeCheck runs the check, turning a panic into its error so it doesn't crash the canary
+func safeCheck(ctx context.Context, check CheckService, logger *zerolog.Logger) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error().
+				Str("check", check.Name()).
+				Interface("panic", r).
+				Bytes("stack", debug.Stack()).
+				Msg("Check panicked")
+			err = fmt.Errorf("check panicked: %v", r)
+		}
+	}()
+	return check.Check(ctx)
+}
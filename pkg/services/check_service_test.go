@@ -0,0 +1,23 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+type panickingCheck struct{}
+
+func (panickingCheck) Name() string                    { return "panicking" }
+func (panickingCheck) Check(ctx context.Context) error { panic("boom") }
+func (panickingCheck) Close()                          {}
+
+func TestRunCheckPanic(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	result := st.RunCheck(panickingCheck{}, 0, &logger)
+	assert.ErrorContains(t, result.Err, "check panicked: boom")
+	assert.Equal(t, "panicking", result.Name)
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/internal/ntp"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// sources of the local clock skew estimates
+const (
+	clockSourceNTP           = "ntp"
+	clockSourceLogAppendTime = "log_append_time"
+)
+
+var (
+	clockSkew = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "clock_skew",
+		Namespace: metricsNamespace,
+		Help:      "Estimated skew of the local clock in milliseconds, positive when ahead, by reference clock",
+	}, []string{"source"})
+
+	clockSkewExceeded = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "clock_skew_exceeded",
+		Namespace: metricsNamespace,
+		Help:      "Whether the estimated local clock skew exceeds the threshold (1), making the end-to-end latency unreliable",
+	})
+
+	recordsLatencyClockSkewed = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "records_consumed_latency_clock_skewed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of end-to-end latencies observed while the local clock skew exceeded the threshold",
+	})
+)
+
+// clockSkews are the local clock skew estimates of a canary
+type clockSkews struct {
+	lock      sync.Mutex
+	threshold time.Duration
+	// last estimate by source
+	skews map[string]time.Duration
+	// set while an estimate exceeds the threshold, read on every record consumed
+	skewed int32
+}
+
+// SetClockSkewThreshold sets the local clock skew beyond which the latencies are flagged, 0 to
+// never flag them
+func (st *State) SetClockSkewThreshold(threshold time.Duration) {
+	c := &st.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.threshold = threshold
+	c.skews = map[string]time.Duration{}
+	clockSkew.In(st.metrics).Reset()
+	clockSkewExceeded.In(st.metrics).Set(0)
+	atomic.StoreInt32(&c.skewed, 0)
+}
+
+// ClockSkewed returns true while the local clock skew estimate exceeds the threshold
+func (st *State) ClockSkewed() bool {
+	return atomic.LoadInt32(&st.clock.skewed) == 1
+}
+
+// observeClockSkew records the local clock skew estimated against the source
+func (st *State) observeClockSkew(source string, skew time.Duration) {
+	c := &st.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	clockSkew.In(st.metrics).WithLabelValues(source).Set(float64(skew.Milliseconds()))
+	c.skews[source] = skew
+
+	exceeded := false
+	for _, skew := range c.skews {
+		if c.threshold > 0 && (skew > c.threshold || skew < -c.threshold) {
+			exceeded = true
+		}
+	}
+	switch {
+	case exceeded && atomic.SwapInt32(&c.skewed, 1) == 0:
+		clockSkewExceeded.In(st.metrics).Set(1)
+		st.recordEvent(EventWarning, "clock", "local clock skew of %v against %s exceeds %v, the latencies are unreliable", skew, source, c.threshold)
+	case !exceeded && atomic.SwapInt32(&c.skewed, 0) == 1:
+		clockSkewExceeded.In(st.metrics).Set(0)
+		st.recordEvent(EventInfo, "clock", "local clock skew back within %v", c.threshold)
+	}
+}
+
+// clockService estimates the local clock skew from an NTP server, the end-to-end latency of the
+// records produced by other instances depending on the clocks agreeing
+type clockService struct {
+	state        *State
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewClockService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	return &clockService{
+		state:        state,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *clockService) Name() string {
+	return "clock"
+}
+
+func (s *clockService) Interval() time.Duration {
+	return s.canaryConfig.Clock.Interval
+}
+
+func (s *clockService) Check(ctx context.Context) error {
+	response, err := ntp.Query(ctx, s.canaryConfig.Clock.NTPServer)
+	if err != nil {
+		return fmt.Errorf("error querying NTP server %s: %w", s.canaryConfig.Clock.NTPServer, err)
+	}
+	skew := -response.Offset
+	s.state.observeClockSkew(clockSourceNTP, skew)
+	s.logger.Debug().
+		Dur("skew", skew).
+		Dur("rtt", response.RTT).
+		Uint8("stratum", response.Stratum).
+		Msg("Queried NTP server")
+
+	if threshold := s.canaryConfig.Clock.SkewThreshold; threshold > 0 && (skew > threshold || skew < -threshold) {
+		return fmt.Errorf("local clock skew of %v exceeds %v", skew, threshold)
+	}
+	return nil
+}
+
+func (s *clockService) Close() {}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveClockSkew(t *testing.T) {
+	st := newTestState()
+	st.SetClockSkewThreshold(100 * time.Millisecond)
+
+	st.observeClockSkew(clockSourceNTP, 50*time.Millisecond)
+	assert.False(t, st.ClockSkewed())
+	assert.Equal(t, 50.0, testutil.ToFloat64(clockSkew.In(st.metrics).WithLabelValues(clockSourceNTP)))
+
+	// any source beyond the threshold flags the latencies
+	st.observeClockSkew(clockSourceLogAppendTime, -150*time.Millisecond)
+	assert.True(t, st.ClockSkewed())
+	assert.Equal(t, 1.0, testutil.ToFloat64(clockSkewExceeded.In(st.metrics)))
+	assert.Contains(t, string(statusText(Status{ClockSkewed: st.ClockSkewed()})), "kafka_canary_status_clock_skewed 1")
+
+	st.observeClockSkew(clockSourceNTP, 0)
+	assert.True(t, st.ClockSkewed())
+	st.observeClockSkew(clockSourceLogAppendTime, 20*time.Millisecond)
+	assert.False(t, st.ClockSkewed())
+	assert.Equal(t, 0.0, testutil.ToFloat64(clockSkewExceeded.In(st.metrics)))
+}
+
+func TestObserveClockSkewDisabled(t *testing.T) {
+	st := newTestState()
+	st.SetClockSkewThreshold(0)
+	st.observeClockSkew(clockSourceNTP, time.Hour)
+	assert.False(t, st.ClockSkewed())
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+	"github.com/pecigonzalo/kafka-canary/pkg/services/util"
+)
+
+// labels of the compared clusters, a being the canary one
+var comparedClusters = []string{"a", "b"}
+
+var (
+	comparisonLatency = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "cluster_comparison_latency",
+		Namespace: metricsNamespace,
+		Help:      "Latency of the compared clusters round trips in milliseconds, until produced or consumed",
+		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000, 5000},
+	}, []string{"cluster", "stage"})
+
+	comparisonAvailability = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "cluster_comparison_availability",
+		Namespace: metricsNamespace,
+		Help:      "Percentage of the compared clusters round trips succeeding over the comparison window",
+	}, []string{"cluster"})
+
+	comparisonLatencyAvg = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "cluster_comparison_latency_avg",
+		Namespace: metricsNamespace,
+		Help:      "Average end-to-end latency of the compared clusters round trips over the comparison window in milliseconds",
+	}, []string{"cluster"})
+
+	comparisonAvailabilityDelta = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "cluster_comparison_availability_delta",
+		Namespace: metricsNamespace,
+		Help:      "Availability of cluster b minus the one of cluster a over the comparison window, in percentage points",
+	})
+
+	comparisonLatencyDelta = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "cluster_comparison_latency_delta",
+		Namespace: metricsNamespace,
+		Help:      "Average end-to-end latency of cluster b minus the one of cluster a over the comparison window in milliseconds",
+	})
+
+	comparisonWithinBudget = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "cluster_comparison_within_budget",
+		Namespace: metricsNamespace,
+		Help:      "Whether cluster b is at least as available as cluster a and slower by at most the latency budget (1)",
+	})
+)
+
+// comparedCluster is one of the clusters compared, with its round trips over the window
+type comparedCluster struct {
+	name      string
+	connector *client.Connector
+	attempts  *util.SlidingWindow
+	successes *util.SlidingWindow
+	// end-to-end latency of the successful round trips in milliseconds
+	latency *util.SlidingWindow
+}
+
+// clusterComparisonService runs the same round trip, a record produced and fetched back, on the
+// canary cluster and a second one, exporting how the second one compares, e.g. to tell whether a
+// migration target is at least as good as the current cluster
+type clusterComparisonService struct {
+	state        *State
+	clusters     []*comparedCluster
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewClusterComparisonService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	// the second cluster shares the client security settings of the canary one
+	comparedConfig := connectorConfig
+	comparedConfig.BrokerAddrs = canaryConfig.Comparison.Brokers
+
+	s := &clusterComparisonService{
+		state:        state,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}
+	for i, config := range []client.ConnectorConfig{connectorConfig, comparedConfig} {
+		connector, err := client.NewConnector(config)
+		if err != nil {
+			return nil, err
+		}
+		s.clusters = append(s.clusters, newComparedCluster(comparedClusters[i], connector, canaryConfig.Comparison.Window))
+	}
+	return s, nil
+}
+
+func newComparedCluster(name string, connector *client.Connector, window time.Duration) *comparedCluster {
+	return &comparedCluster{
+		name:      name,
+		connector: connector,
+		attempts:  util.NewSlidingWindow(window, 10*time.Second),
+		successes: util.NewSlidingWindow(window, 10*time.Second),
+		latency:   util.NewSlidingWindow(window, 10*time.Second),
+	}
+}
+
+func (s *clusterComparisonService) Name() string {
+	return "cluster_comparison"
+}
+
+func (s *clusterComparisonService) Interval() time.Duration {
+	return s.canaryConfig.Comparison.Interval
+}
+
+func (s *clusterComparisonService) Check(ctx context.Context) error {
+	// both round trips run at once so they see the same client conditions
+	errs := make([]error, len(s.clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range s.clusters {
+		wg.Add(1)
+		go func(i int, cluster *comparedCluster) {
+			defer wg.Done()
+			errs[i] = s.roundTrip(ctx, cluster)
+		}(i, cluster)
+	}
+	wg.Wait()
+	s.compare()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", s.clusters[i].name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error comparing the clusters: %v", failed)
+	}
+	return nil
+}
+
+func (s *clusterComparisonService) Close() {}
+
+// roundTrip produces a record to the first partition of the comparison topic and fetches it back,
+// accounting the result to the cluster
+func (s *clusterComparisonService) roundTrip(ctx context.Context, cluster *comparedCluster) error {
+	cluster.attempts.Add(1)
+	topic := s.topic()
+	value := []byte(fmt.Sprintf("%s-%d", s.Name(), time.Now().UnixNano()))
+
+	start := time.Now()
+	produced, err := cluster.connector.KafkaClient.Produce(ctx, &kafka.ProduceRequest{
+		Topic:        topic,
+		RequiredAcks: kafka.RequireAll,
+		Records: kafka.NewRecordReader(kafka.Record{
+			Value:   kafka.NewBytes(value),
+			Headers: []kafka.Header{{Key: CheckHeader, Value: []byte(s.Name())}},
+		}),
+	})
+	if err == nil {
+		err = produced.Error
+	}
+	if err != nil {
+		s.state.countKafkaError("Produce", err)
+		return kafkaerr.Wrap(err)
+	}
+	comparisonLatency.In(s.state.metrics).WithLabelValues(cluster.name, "produce").Observe(float64(time.Since(start).Milliseconds()))
+
+	fetched, err := cluster.connector.KafkaClient.Fetch(ctx, &kafka.FetchRequest{
+		Topic:    topic,
+		Offset:   produced.BaseOffset,
+		MinBytes: 1,
+		MaxBytes: 1 << 20,
+		MaxWait:  time.Second,
+	})
+	if err == nil {
+		err = fetched.Error
+	}
+	if err != nil {
+		s.state.countKafkaError("Fetch", err)
+		return kafkaerr.Wrap(err)
+	}
+	// the first batch returned can start before the requested offset
+	for {
+		record, err := fetched.Records.ReadRecord()
+		if err != nil {
+			return fmt.Errorf("reading record at offset %d: %w", produced.BaseOffset, err)
+		}
+		if record.Offset < produced.BaseOffset {
+			continue
+		}
+		var consumed []byte
+		if record.Value != nil {
+			if consumed, err = io.ReadAll(record.Value); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(consumed, value) {
+			return fmt.Errorf("record at offset %d isn't the one produced", produced.BaseOffset)
+		}
+		break
+	}
+
+	latency := time.Since(start).Milliseconds()
+	comparisonLatency.In(s.state.metrics).WithLabelValues(cluster.name, "end_to_end").Observe(float64(latency))
+	cluster.successes.Add(1)
+	cluster.latency.Add(uint64(latency))
+	return nil
+}
+
+// compare exports the availability and the average latency of the clusters over the window, and
+// how the second one compares
+func (s *clusterComparisonService) compare() {
+	window := s.canaryConfig.Comparison.Window
+	availability := make([]float64, len(s.clusters))
+	latency := make([]float64, len(s.clusters))
+	for i, cluster := range s.clusters {
+		attempts := cluster.attempts.Sum(window)
+		successes := cluster.successes.Sum(window)
+		if attempts > 0 {
+			availability[i] = 100 * float64(successes) / float64(attempts)
+		}
+		if successes > 0 {
+			latency[i] = float64(cluster.latency.Sum(window)) / float64(successes)
+		}
+		comparisonAvailability.In(s.state.metrics).WithLabelValues(cluster.name).Set(availability[i])
+		comparisonLatencyAvg.In(s.state.metrics).WithLabelValues(cluster.name).Set(latency[i])
+	}
+
+	availabilityDelta := availability[1] - availability[0]
+	latencyDelta := latency[1] - latency[0]
+	comparisonAvailabilityDelta.In(s.state.metrics).Set(availabilityDelta)
+	comparisonLatencyDelta.In(s.state.metrics).Set(latencyDelta)
+	if availabilityDelta >= 0 && latencyDelta <= float64(s.canaryConfig.Comparison.LatencyBudget.Milliseconds()) {
+		comparisonWithinBudget.In(s.state.metrics).Set(1)
+	} else {
+		comparisonWithinBudget.In(s.state.metrics).Set(0)
+	}
+}
+
+// topic returns the topic of the round trips, the canary topic unless configured
+func (s *clusterComparisonService) topic() string {
+	if s.canaryConfig.Comparison.Topic != "" {
+		return s.canaryConfig.Comparison.Topic
+	}
+	return s.canaryConfig.Topic
+}
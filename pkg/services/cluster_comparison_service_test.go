@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestClusterComparison(t *testing.T) {
+	st := newTestState()
+	config := canary.Config{Comparison: canary.ComparisonConfig{Window: time.Hour, LatencyBudget: 5 * time.Millisecond}}
+	s := &clusterComparisonService{
+		state:        st,
+		canaryConfig: &config,
+		clusters: []*comparedCluster{
+			newComparedCluster("a", nil, time.Hour),
+			newComparedCluster("b", nil, time.Hour),
+		},
+	}
+	roundTrips := func(cluster *comparedCluster, attempts, successes, latency uint64) {
+		cluster.attempts.Add(attempts)
+		cluster.successes.Add(successes)
+		cluster.latency.Add(latency)
+	}
+
+	// b is as available and 4ms slower on average, within the budget
+	roundTrips(s.clusters[0], 10, 10, 100)
+	roundTrips(s.clusters[1], 10, 10, 140)
+	s.compare()
+	assert.Equal(t, 100.0, testutil.ToFloat64(comparisonAvailability.In(st.metrics).WithLabelValues("b")))
+	assert.Equal(t, 14.0, testutil.ToFloat64(comparisonLatencyAvg.In(st.metrics).WithLabelValues("b")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(comparisonAvailabilityDelta.In(st.metrics)))
+	assert.Equal(t, 4.0, testutil.ToFloat64(comparisonLatencyDelta.In(st.metrics)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(comparisonWithinBudget.In(st.metrics)))
+
+	// b failed a round trip
+	roundTrips(s.clusters[0], 10, 10, 100)
+	roundTrips(s.clusters[1], 10, 9, 126)
+	s.compare()
+	assert.Equal(t, 95.0, testutil.ToFloat64(comparisonAvailability.In(st.metrics).WithLabelValues("b")))
+	assert.Equal(t, -5.0, testutil.ToFloat64(comparisonAvailabilityDelta.In(st.metrics)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(comparisonWithinBudget.In(st.metrics)))
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// clusterInfo is the cluster info seen by the last topic reconcile of a canary
+type clusterInfo struct {
+	lock sync.RWMutex
+	// snapshot of the info, and the info it was marshaled from, read by the status
+	snapshot []byte
+	value    ClusterInfo
+}
+
+// ClusterInfo contains the brokers and the canary topic layout seen by the last topic reconcile
+type ClusterInfo struct {
+	UpdatedAt  time.Time          `json:"updatedAt"`
+	Controller int                `json:"controller"`
+	Brokers    []ClusterBroker    `json:"brokers"`
+	Topic      string             `json:"topic"`
+	Partitions []ClusterPartition `json:"partitions"`
+	Leaders    map[int]int        `json:"leaders"`
+}
+
+// ClusterBroker describes a broker of the cluster
+type ClusterBroker struct {
+	ID         int    `json:"id"`
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	Rack       string `json:"rack,omitempty"`
+	Controller bool   `json:"controller"`
+}
+
+// ClusterPartition describes a partition of the canary topic
+type ClusterPartition struct {
+	ID       int   `json:"id"`
+	Leader   int   `json:"leader"`
+	Replicas []int `json:"replicas"`
+	ISR      []int `json:"isr"`
+}
+
+// ClusterInfoHandler serves the last cluster info snapshot as JSON
+func (st *State) ClusterInfoHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		st.cluster.lock.RLock()
+		snapshot := st.cluster.snapshot
+		st.cluster.lock.RUnlock()
+
+		if snapshot == nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		_, _ = rw.Write(snapshot)
+	})
+}
+
+// pruneTopology deletes the series of the partitions and brokers gone since the previous reconcile
+func (s *topicService) pruneTopology(info ClusterInfo) {
+	partitions := make([]int, 0, len(info.Partitions))
+	for _, p := range info.Partitions {
+		partitions = append(partitions, p.ID)
+	}
+	brokers := make([]int, 0, len(info.Brokers))
+	for _, b := range info.Brokers {
+		brokers = append(brokers, b.ID)
+	}
+	gonePartitions, goneBrokers := s.state.pruneTopology(partitions, brokers)
+	if len(gonePartitions) > 0 || len(goneBrokers) > 0 {
+		s.logger.Info().
+			Ints("partitions", gonePartitions).
+			Ints("brokers", goneBrokers).
+			Msg("Deleted the metrics of the partitions and brokers gone")
+		s.state.recordEvent(EventInfo, "topic", "partitions %v and brokers %v gone from the cluster", gonePartitions, goneBrokers)
+	}
+}
+
+// updateClusterInfo describes the brokers and the canary topic in a single metadata request
+func (s *topicService) updateClusterInfo(ctx context.Context) {
+	metadata, err := s.admin.GetConnector().KafkaClient.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: []string{s.canaryConfig.Topic},
+	})
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("Error describing the cluster, the cluster info is stale")
+		return
+	}
+
+	info := ClusterInfo{
+		UpdatedAt:  s.now(),
+		Controller: metadata.Controller.ID,
+		Brokers:    make([]ClusterBroker, 0, len(metadata.Brokers)),
+		Topic:      s.canaryConfig.Topic,
+		Partitions: []ClusterPartition{},
+		Leaders:    map[int]int{},
+	}
+	for _, b := range metadata.Brokers {
+		info.Brokers = append(info.Brokers, ClusterBroker{
+			ID:         b.ID,
+			Host:       b.Host,
+			Port:       b.Port,
+			Rack:       b.Rack,
+			Controller: b.ID == metadata.Controller.ID,
+		})
+	}
+	sort.Slice(info.Brokers, func(i, j int) bool { return info.Brokers[i].ID < info.Brokers[j].ID })
+
+	for _, topic := range metadata.Topics {
+		for _, p := range topic.Partitions {
+			partition := ClusterPartition{ID: p.ID, Leader: p.Leader.ID, Replicas: []int{}, ISR: []int{}}
+			for _, r := range p.Replicas {
+				partition.Replicas = append(partition.Replicas, r.ID)
+			}
+			for _, r := range p.Isr {
+				partition.ISR = append(partition.ISR, r.ID)
+			}
+			info.Partitions = append(info.Partitions, partition)
+			info.Leaders[p.ID] = p.Leader.ID
+		}
+	}
+	sort.Slice(info.Partitions, func(i, j int) bool { return info.Partitions[i].ID < info.Partitions[j].ID })
+	s.pruneTopology(info)
+	s.state.updateFailureDomains(info)
+
+	snapshot, err := json.Marshal(info)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Marshal cluster info")
+		return
+	}
+	s.state.cluster.lock.Lock()
+	s.state.cluster.snapshot = snapshot
+	s.state.cluster.value = info
+	s.state.cluster.lock.Unlock()
+}
+
+// lastClusterInfo returns the cluster info seen by the last topic reconcile, false until there is one
+func (st *State) lastClusterInfo() (ClusterInfo, bool) {
+	st.cluster.lock.RLock()
+	defer st.cluster.lock.RUnlock()
+	return st.cluster.value, st.cluster.snapshot != nil
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// clusterAdmin is an admin client describing the cluster through the transport
+type clusterAdmin struct {
+	fakeAdmin
+	transport kafka.RoundTripper
+}
+
+func (a *clusterAdmin) GetConnector() *client.Connector {
+	return &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: a.transport}}
+}
+
+// clusterTransport describes a cluster of three brokers with broker 2 as the controller, the
+// canary topic having two partitions
+type clusterTransport struct {
+	err error
+}
+
+func (t clusterTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if _, ok := req.(*metadata.Request); !ok {
+		return nil, errors.New("unsupported request")
+	}
+	return &metadata.Response{
+		ControllerID: 2,
+		Brokers: []metadata.ResponseBroker{
+			{NodeID: 3, Host: "broker-3", Port: 9092, Rack: "zone-c"},
+			{NodeID: 1, Host: "broker-1", Port: 9092, Rack: "zone-a"},
+			{NodeID: 2, Host: "broker-2", Port: 9092, Rack: "zone-b"},
+		},
+		Topics: []metadata.ResponseTopic{{
+			Name: "__kafka_canary",
+			Partitions: []metadata.ResponsePartition{
+				{PartitionIndex: 1, LeaderID: 2, ReplicaNodes: []int32{2, 3, 1}, IsrNodes: []int32{2, 3}},
+				{PartitionIndex: 0, LeaderID: 1, ReplicaNodes: []int32{1, 2, 3}, IsrNodes: []int32{1, 2, 3}},
+			},
+		}},
+	}, nil
+}
+
+func TestClusterInfo(t *testing.T) {
+	st := newTestState()
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		st.ClusterInfoHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/clusterinfo", nil))
+		return recorder
+	}
+
+	// nothing reconciled yet
+	assert.Equal(t, http.StatusServiceUnavailable, serve().Code)
+	_, ok := st.lastClusterInfo()
+	assert.False(t, ok)
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	logger := zerolog.Nop()
+	admin := &clusterAdmin{transport: clusterTransport{}}
+	s := newTopicService(st, canary.Config{Topic: "__kafka_canary"}, &logger, nil, func() time.Time { return now }, newTestTopicMetrics())
+	s.admin = admin
+	s.updateClusterInfo(context.Background())
+
+	want := ClusterInfo{
+		UpdatedAt:  now,
+		Controller: 2,
+		Brokers: []ClusterBroker{
+			{ID: 1, Host: "broker-1", Port: 9092, Rack: "zone-a"},
+			{ID: 2, Host: "broker-2", Port: 9092, Rack: "zone-b", Controller: true},
+			{ID: 3, Host: "broker-3", Port: 9092, Rack: "zone-c"},
+		},
+		Topic: "__kafka_canary",
+		Partitions: []ClusterPartition{
+			{ID: 0, Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1, 2, 3}},
+			{ID: 1, Leader: 2, Replicas: []int{2, 3, 1}, ISR: []int{2, 3}},
+		},
+		Leaders: map[int]int{0: 1, 1: 2},
+	}
+	recorder := serve()
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var info ClusterInfo
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &info))
+	assert.Equal(t, want, info)
+	last, ok := st.lastClusterInfo()
+	assert.True(t, ok)
+	assert.Equal(t, want, last)
+
+	// a failed describe keeps the stale snapshot
+	admin.transport = clusterTransport{err: errors.New("connection refused")}
+	now = now.Add(time.Minute)
+	s.updateClusterInfo(context.Background())
+	last, _ = st.lastClusterInfo()
+	assert.Equal(t, want.UpdatedAt, last.UpdatedAt)
+	assert.Equal(t, http.StatusOK, serve().Code)
+}
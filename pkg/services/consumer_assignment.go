@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol/describegroups"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// Partition assignment strategies of the canary consumer, named after the group protocols like the
+// Java client ones
+const (
+	AssignRange             = "range"
+	AssignRoundRobin        = "roundrobin"
+	AssignCooperativeSticky = "cooperative-sticky"
+)
+
+var consumerAssignmentStrategy = metrics.NewGaugeVec(prometheus.GaugeOpts{
+	Name:      "consumer_assignment_strategy",
+	Namespace: metricsNamespace,
+	Help:      "Partition assignment strategy negotiated by the canary consumer group, 1 for the current one",
+}, []string{"strategy"})
+
+// groupBalancers returns the balancers of the priority-ordered assignment strategies, the kafka-go
+// defaults when empty. The kafka-go consumer only rebalances eagerly, revoking every partition, so
+// the cooperative strategy is refused rather than advertised without its protocol.
+func groupBalancers(strategies []string) ([]kafka.GroupBalancer, error) {
+	balancers := make([]kafka.GroupBalancer, 0, len(strategies))
+	for _, strategy := range strategies {
+		switch strategy {
+		case AssignRange:
+			balancers = append(balancers, kafka.RangeGroupBalancer{})
+		case AssignRoundRobin:
+			balancers = append(balancers, kafka.RoundRobinGroupBalancer{})
+		case AssignCooperativeSticky:
+			return nil, fmt.Errorf("assignment strategy %s needs incremental cooperative rebalancing, which the canary consumer doesn't support", strategy)
+		default:
+			return nil, fmt.Errorf("unknown assignment strategy %q, expected %s or %s", strategy, AssignRange, AssignRoundRobin)
+		}
+	}
+	if len(balancers) == 0 {
+		return nil, nil
+	}
+	return balancers, nil
+}
+
+// updateAssignmentStrategy describes the group and exports the assignment strategy it negotiated,
+// none while it has no member
+func (st *State) updateAssignmentStrategy(ctx context.Context, kafkaClient *kafka.Client, group string) (string, error) {
+	response, err := kafkaClient.Transport.RoundTrip(ctx, kafkaClient.Addr, &describegroups.Request{Groups: []string{group}})
+	if err != nil {
+		return "", kafkaerr.Wrap(err)
+	}
+	groups := response.(*describegroups.Response).Groups
+	if len(groups) != 1 {
+		return "", fmt.Errorf("group %s not described", group)
+	}
+	if groups[0].ErrorCode != 0 {
+		return "", kafkaerr.Wrap(kafka.Error(groups[0].ErrorCode))
+	}
+
+	strategy := groups[0].ProtocolData
+	consumerAssignmentStrategy.In(st.metrics).Reset()
+	if strategy != "" {
+		consumerAssignmentStrategy.In(st.metrics).WithLabelValues(strategy).Set(1)
+	}
+	return strategy, nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/describegroups"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupBalancers(t *testing.T) {
+	balancers, err := groupBalancers([]string{AssignRoundRobin, AssignRange})
+	require.NoError(t, err)
+	assert.Equal(t, []kafka.GroupBalancer{kafka.RoundRobinGroupBalancer{}, kafka.RangeGroupBalancer{}}, balancers)
+
+	balancers, err = groupBalancers(nil)
+	require.NoError(t, err)
+	assert.Nil(t, balancers)
+
+	_, err = groupBalancers([]string{AssignCooperativeSticky, AssignRange})
+	assert.Error(t, err)
+	_, err = groupBalancers([]string{"sticky"})
+	assert.Error(t, err)
+}
+
+// fakeDescribeGroupsTransport describes every group with the protocol
+type fakeDescribeGroupsTransport struct {
+	protocol string
+}
+
+func (t fakeDescribeGroupsTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	groups := req.(*describegroups.Request).Groups
+	return &describegroups.Response{Groups: []describegroups.ResponseGroup{
+		{GroupID: groups[0], GroupState: "Stable", ProtocolType: "consumer", ProtocolData: t.protocol},
+	}}, nil
+}
+
+func TestUpdateAssignmentStrategy(t *testing.T) {
+	st := newTestState()
+	kafkaClient := &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: fakeDescribeGroupsTransport{AssignRoundRobin}}
+
+	strategy, err := st.updateAssignmentStrategy(context.Background(), kafkaClient, "kafka-canary-group")
+	require.NoError(t, err)
+	assert.Equal(t, AssignRoundRobin, strategy)
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerAssignmentStrategy.In(st.metrics).WithLabelValues(AssignRoundRobin)))
+
+	// the previous strategy is forgotten once renegotiated
+	kafkaClient.Transport = fakeDescribeGroupsTransport{AssignRange}
+	_, err = st.updateAssignmentStrategy(context.Background(), kafkaClient, "kafka-canary-group")
+	require.NoError(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(consumerAssignmentStrategy.In(st.metrics)))
+}
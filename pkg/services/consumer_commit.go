@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// Commit strategies of the canary consumer
+const (
+	// CommitAuto commits the records as soon as they are fetched, before they are verified, like
+	// the clients auto committing do
+	CommitAuto = "auto"
+	// CommitInterval commits the last verified record of each partition periodically
+	CommitInterval = "interval"
+	// CommitPerRecord commits every record once verified
+	CommitPerRecord = "per-record"
+	// CommitNone never commits, the consumer restarts from the end of the partitions
+	CommitNone = "none"
+)
+
+var (
+	consumerCommitLatency = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "consumer_commit_latency",
+		Namespace: metricsNamespace,
+		Help:      "Offset commit latency of the canary consumer in milliseconds, by commit strategy",
+		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000, 5000},
+	}, []string{"strategy"})
+
+	consumerCommitFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "consumer_commit_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of failed offset commits of the canary consumer, by commit strategy",
+	}, []string{"strategy"})
+
+	consumerCommitViolations = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "consumer_commit_violations_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of committed offsets found ahead of what the commit strategy allows, by commit strategy",
+	}, []string{"strategy"})
+)
+
+// validCommitStrategy returns an error for an unknown commit strategy
+func validCommitStrategy(strategy string) error {
+	switch strategy {
+	case CommitAuto, CommitInterval, CommitPerRecord, CommitNone:
+		return nil
+	}
+	return fmt.Errorf("unknown commit strategy %q, expected one of %s, %s, %s or %s",
+		strategy, CommitAuto, CommitInterval, CommitPerRecord, CommitNone)
+}
+
+// committer commits the consumed offsets following the commit strategy. Every interval it
+// verifies the offsets committed for the group, as the broker reports them, aren't ahead of the
+// last record the strategy allows: the last verified one, or the last fetched one when auto
+// committing.
+type committer struct {
+	state    *State
+	strategy string
+	interval time.Duration
+	commit   func(ctx context.Context, messages ...kafka.Message) error
+	// committed returns the offsets committed for the partitions, -1 when none, nil skips the
+	// verification
+	committed func(ctx context.Context, partitions []int) (map[int]int64, error)
+	logger    *zerolog.Logger
+
+	lock sync.Mutex
+	// last fetched and verified offsets by partition
+	fetched  map[int]int64
+	verified map[int]int64
+	// partitions fetched since the previous verification, the ones moved to another member of the
+	// group aren't verified against the records of this one
+	active map[int]bool
+	// last verified record by partition, committed on the next interval
+	pending map[int]kafka.Message
+}
+
+func newCommitter(state *State, strategy string, interval time.Duration, commit func(ctx context.Context, messages ...kafka.Message) error, committed func(ctx context.Context, partitions []int) (map[int]int64, error), logger *zerolog.Logger) *committer {
+	return &committer{
+		state:     state,
+		strategy:  strategy,
+		interval:  interval,
+		commit:    commit,
+		committed: committed,
+		logger:    logger,
+		fetched:   map[int]int64{},
+		verified:  map[int]int64{},
+		active:    map[int]bool{},
+		pending:   map[int]kafka.Message{},
+	}
+}
+
+// onFetched is called with every record fetched, before it's verified
+func (c *committer) onFetched(ctx context.Context, message kafka.Message) {
+	c.lock.Lock()
+	c.fetched[message.Partition] = message.Offset
+	c.active[message.Partition] = true
+	c.lock.Unlock()
+	if c.strategy == CommitAuto {
+		c.commitMessages(ctx, message)
+	}
+}
+
+// onVerified is called with every record once verified
+func (c *committer) onVerified(ctx context.Context, message kafka.Message) {
+	c.lock.Lock()
+	c.verified[message.Partition] = message.Offset
+	if c.strategy == CommitInterval {
+		c.pending[message.Partition] = message
+	}
+	c.lock.Unlock()
+	if c.strategy == CommitPerRecord {
+		c.commitMessages(ctx, message)
+	}
+}
+
+// run commits the pending records and verifies the committed offsets every interval until the
+// context is done
+func (c *committer) run(ctx context.Context) {
+	if c.strategy == CommitNone {
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.strategy == CommitInterval {
+				c.flush(ctx)
+			}
+			c.verify(ctx)
+		}
+	}
+}
+
+// verify counts the committed offsets of the partitions fetched since the previous verification
+// ahead of what the strategy allows. A committed offset is the one of the next record to consume,
+// one past the last record committed.
+func (c *committer) verify(ctx context.Context) {
+	if c.committed == nil {
+		return
+	}
+	c.lock.Lock()
+	partitions := make([]int, 0, len(c.active))
+	for partition := range c.active {
+		partitions = append(partitions, partition)
+	}
+	c.active = map[int]bool{}
+	c.lock.Unlock()
+	if len(partitions) == 0 {
+		return
+	}
+
+	committed, err := c.committed(ctx, partitions)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.state.countKafkaError("OffsetFetch", err)
+			c.logger.Warn().Err(err).Msg("Error fetching the committed offsets")
+		}
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	allowed := c.verified
+	if c.strategy == CommitAuto {
+		allowed = c.fetched
+	}
+	for partition, offset := range committed {
+		if offset < 0 {
+			continue
+		}
+		last, ok := allowed[partition]
+		if ok && offset <= last+1 {
+			continue
+		}
+		consumerCommitViolations.In(c.state.metrics).WithLabelValues(c.strategy).Inc()
+		c.logger.Error().
+			Str("strategy", c.strategy).
+			Int("partition", partition).
+			Int64("committed", offset).
+			Int64("allowed", last+1).
+			Msg("Committed offset ahead of the commit strategy")
+	}
+}
+
+// flush commits the pending records
+func (c *committer) flush(ctx context.Context) {
+	c.lock.Lock()
+	messages := make([]kafka.Message, 0, len(c.pending))
+	for _, message := range c.pending {
+		messages = append(messages, message)
+	}
+	c.pending = map[int]kafka.Message{}
+	c.lock.Unlock()
+	if len(messages) > 0 {
+		c.commitMessages(ctx, messages...)
+	}
+}
+
+// commitMessages commits the records
+func (c *committer) commitMessages(ctx context.Context, messages ...kafka.Message) {
+	start := time.Now()
+	err := c.commit(ctx, messages...)
+	if err != nil && ctx.Err() == nil {
+		consumerCommitFailed.In(c.state.metrics).WithLabelValues(c.strategy).Inc()
+		c.state.countKafkaError("OffsetCommit", err)
+		c.logger.Warn().Err(err).Str("strategy", c.strategy).Msg("Error committing the consumed offsets")
+		return
+	}
+	if err == nil {
+		consumerCommitLatency.In(c.state.metrics).WithLabelValues(c.strategy).Observe(float64(time.Since(start).Milliseconds()))
+	}
+}
+
+// groupOffsets returns the function fetching the offsets committed for the group on the topic
+func groupOffsets(kafkaClient *kafka.Client, group, topic string) func(ctx context.Context, partitions []int) (map[int]int64, error) {
+	return func(ctx context.Context, partitions []int) (map[int]int64, error) {
+		resp, err := kafkaClient.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+			GroupID: group,
+			Topics:  map[string][]int{topic: partitions},
+		})
+		if err == nil {
+			err = resp.Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		offsets := make(map[int]int64, len(partitions))
+		for _, partition := range resp.Topics[topic] {
+			if partition.Error != nil {
+				return nil, partition.Error
+			}
+			offsets[partition.Partition] = partition.CommittedOffset
+		}
+		return offsets, nil
+	}
+}
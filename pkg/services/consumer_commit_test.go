@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitter(t *testing.T) {
+	st := newTestState()
+	messages := []kafka.Message{{Partition: 0, Offset: 10}, {Partition: 1, Offset: 5}, {Partition: 0, Offset: 11}}
+	tests := []struct {
+		strategy string
+		// offsets committed by partition, in order
+		want map[int][]int64
+	}{
+		{strategy: CommitAuto, want: map[int][]int64{0: {10, 11}, 1: {5}}},
+		{strategy: CommitPerRecord, want: map[int][]int64{0: {10, 11}, 1: {5}}},
+		{strategy: CommitInterval, want: map[int][]int64{0: {11}, 1: {5}}},
+		{strategy: CommitNone, want: map[int][]int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			committed := map[int][]int64{}
+			commit := func(_ context.Context, messages ...kafka.Message) error {
+				for _, m := range messages {
+					committed[m.Partition] = append(committed[m.Partition], m.Offset)
+				}
+				return nil
+			}
+			logger := zerolog.Nop()
+			violations := testutil.ToFloat64(consumerCommitViolations.In(st.metrics).WithLabelValues(tt.strategy))
+			c := newCommitter(st, tt.strategy, time.Second, commit, nil, &logger)
+			for _, message := range messages {
+				c.onFetched(context.Background(), message)
+				c.onVerified(context.Background(), message)
+			}
+			// the interval commits are flushed by the closing consumer
+			c.flush(context.Background())
+
+			assert.Equal(t, tt.want, committed)
+			assert.Equal(t, violations, testutil.ToFloat64(consumerCommitViolations.In(st.metrics).WithLabelValues(tt.strategy)))
+		})
+	}
+}
+
+func TestCommitterVerify(t *testing.T) {
+	st := newTestState()
+	tests := []struct {
+		name      string
+		strategy  string
+		committed map[int]int64
+		err       error
+		// partitions whose committed offset is ahead of the strategy
+		violations float64
+	}{
+		{name: "one past the last verified", strategy: CommitPerRecord, committed: map[int]int64{0: 6, 1: 4}},
+		{name: "nothing committed", strategy: CommitPerRecord, committed: map[int]int64{0: -1, 1: -1}},
+		{name: "ahead of the verified", strategy: CommitPerRecord, committed: map[int]int64{0: 8, 1: 4}, violations: 1},
+		{name: "auto ahead of the verified", strategy: CommitAuto, committed: map[int]int64{0: 8, 1: 4}},
+		{name: "auto ahead of the fetched", strategy: CommitAuto, committed: map[int]int64{0: 9, 1: 4}, violations: 1},
+		{name: "fetch error", strategy: CommitInterval, err: kafka.GroupCoordinatorNotAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var asked []int
+			committed := func(_ context.Context, partitions []int) (map[int]int64, error) {
+				asked = append(asked, partitions...)
+				return tt.committed, tt.err
+			}
+			logger := zerolog.Nop()
+			c := newCommitter(st, tt.strategy, time.Second, func(context.Context, ...kafka.Message) error { return nil }, committed, &logger)
+			before := testutil.ToFloat64(consumerCommitViolations.In(st.metrics).WithLabelValues(tt.strategy))
+
+			// partition 0 is fetched up to offset 7 but only verified up to 5
+			for offset := int64(4); offset <= 7; offset++ {
+				c.onFetched(context.Background(), kafka.Message{Partition: 0, Offset: offset})
+				if offset <= 5 {
+					c.onVerified(context.Background(), kafka.Message{Partition: 0, Offset: offset})
+				}
+			}
+			c.onFetched(context.Background(), kafka.Message{Partition: 1, Offset: 3})
+			c.onVerified(context.Background(), kafka.Message{Partition: 1, Offset: 3})
+			c.verify(context.Background())
+
+			assert.ElementsMatch(t, []int{0, 1}, asked)
+			assert.Equal(t, before+tt.violations, testutil.ToFloat64(consumerCommitViolations.In(st.metrics).WithLabelValues(tt.strategy)))
+
+			// the partitions not fetched since aren't verified again, e.g. moved to another member
+			asked = nil
+			c.verify(context.Background())
+			assert.Empty(t, asked)
+		})
+	}
+}
+
+func TestValidCommitStrategy(t *testing.T) {
+	assert.NoError(t, validCommitStrategy(CommitInterval))
+	assert.Error(t, validCommitStrategy("sometimes"))
+}
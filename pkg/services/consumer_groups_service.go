@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// consumer group states reported by DescribeGroups
+var groupStates = []string{"Stable", "PreparingRebalance", "CompletingRebalance", "Empty", "Dead"}
+
+var (
+	consumerGroupMembers = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "consumer_group_members",
+		Namespace: metricsNamespace,
+		Help:      "Number of members of the described consumer groups",
+	}, []string{"group"})
+
+	consumerGroupState = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "consumer_group_state",
+		Namespace: metricsNamespace,
+		Help:      "State of the described consumer groups, 1 for the current state",
+	}, []string{"group", "state"})
+
+	consumerGroupLag = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "consumer_group_lag",
+		Namespace: metricsNamespace,
+		Help:      "Records between the committed and the last offsets summed over the partitions assigned to the described consumer groups",
+	}, []string{"group"})
+)
+
+// consumerGroupsService describes business-critical consumer groups, exporting their members,
+// state and lag so the canary doubles as a lag exporter for key pipelines
+type consumerGroupsService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewConsumerGroupsService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consumerGroupsService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *consumerGroupsService) Name() string {
+	return "consumer_groups"
+}
+
+func (s *consumerGroupsService) Interval() time.Duration {
+	return s.canaryConfig.ConsumerGroups.Interval
+}
+
+func (s *consumerGroupsService) Check(ctx context.Context) error {
+	resp, err := s.connector.KafkaClient.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{
+		GroupIDs: s.canaryConfig.ConsumerGroups.Groups,
+	})
+	if err != nil {
+		s.state.countKafkaError("DescribeGroups", err)
+		return kafkaerr.Wrap(err)
+	}
+
+	var failed []string
+	for _, group := range resp.Groups {
+		if group.Error != nil {
+			s.state.countKafkaError("DescribeGroups", group.Error)
+			failed = append(failed, fmt.Sprintf("%s (%v)", group.GroupID, group.Error))
+			continue
+		}
+
+		consumerGroupMembers.In(s.state.metrics).WithLabelValues(group.GroupID).Set(float64(len(group.Members)))
+		for _, state := range groupStates {
+			value := 0.0
+			if state == group.GroupState {
+				value = 1
+			}
+			consumerGroupState.In(s.state.metrics).WithLabelValues(group.GroupID, state).Set(value)
+		}
+
+		// only the assigned partitions are known, there is no lag without members
+		assigned := map[string][]int{}
+		for _, member := range group.Members {
+			for _, topic := range member.MemberAssignments.Topics {
+				assigned[topic.Topic] = append(assigned[topic.Topic], topic.Partitions...)
+			}
+		}
+		if len(assigned) == 0 {
+			continue
+		}
+		lag, err := s.lag(ctx, group.GroupID, assigned)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", group.GroupID, err))
+			continue
+		}
+		consumerGroupLag.In(s.state.metrics).WithLabelValues(group.GroupID).Set(float64(lag))
+
+		s.logger.Debug().
+			Str("group", group.GroupID).
+			Str("state", group.GroupState).
+			Int("members", len(group.Members)).
+			Int64("lag", lag).
+			Msg("Described consumer group")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("error describing consumer groups: %v", failed)
+	}
+	return nil
+}
+
+func (s *consumerGroupsService) Close() {}
+
+// lag returns the records between the committed and the last offsets summed over the given
+// partitions, the partitions without a committed offset are skipped
+func (s *consumerGroupsService) lag(ctx context.Context, group string, partitions map[string][]int) (int64, error) {
+	committed, err := s.connector.KafkaClient.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: group,
+		Topics:  partitions,
+	})
+	if err == nil {
+		err = committed.Error
+	}
+	if err != nil {
+		s.state.countKafkaError("OffsetFetch", err)
+		return 0, kafkaerr.Wrap(err)
+	}
+
+	requests := map[string][]kafka.OffsetRequest{}
+	for topic, ids := range partitions {
+		for _, id := range ids {
+			requests[topic] = append(requests[topic], kafka.LastOffsetOf(id))
+		}
+	}
+	last, err := s.connector.KafkaClient.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: requests})
+	if err != nil {
+		s.state.countKafkaError("ListOffsets", err)
+		return 0, kafkaerr.Wrap(err)
+	}
+	lastOffsets := map[string]map[int]int64{}
+	for topic, offsets := range last.Topics {
+		lastOffsets[topic] = map[int]int64{}
+		for _, p := range offsets {
+			if p.Error != nil {
+				s.state.countKafkaError("ListOffsets", p.Error)
+				return 0, kafkaerr.Wrap(p.Error)
+			}
+			lastOffsets[topic][p.Partition] = p.LastOffset
+		}
+	}
+
+	var lag int64
+	for topic, offsets := range committed.Topics {
+		for _, p := range offsets {
+			if p.Error != nil || p.CommittedOffset < 0 {
+				continue
+			}
+			if end, ok := lastOffsets[topic][p.Partition]; ok && end > p.CommittedOffset {
+				lag += end - p.CommittedOffset
+			}
+		}
+	}
+	return lag, nil
+}
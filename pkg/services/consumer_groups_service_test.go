@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/describegroups"
+	"github.com/segmentio/kafka-go/protocol/listoffsets"
+	"github.com/segmentio/kafka-go/protocol/offsetfetch"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// memberAssignment encodes a consumer protocol assignment of the topic partitions
+func memberAssignment(topic string, partitions ...int32) []byte {
+	var b bytes.Buffer
+	write := func(v interface{}) { _ = binary.Write(&b, binary.BigEndian, v) }
+	write(int16(0))
+	write(int32(1))
+	write(int16(len(topic)))
+	b.WriteString(topic)
+	write(int32(len(partitions)))
+	write(partitions)
+	// no user data
+	write(int32(0))
+	return b.Bytes()
+}
+
+// fakeGroupsTransport describes the groups, their committed offsets and the last offsets of the
+// orders topic partitions
+type fakeGroupsTransport struct {
+	groups         map[string]describegroups.ResponseGroup
+	committed      map[int32]int64
+	offsetFetchErr kafka.Error
+	last           map[int32]int64
+}
+
+func (t fakeGroupsTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *describegroups.Request:
+		resp := &describegroups.Response{}
+		for _, id := range req.Groups {
+			resp.Groups = append(resp.Groups, t.groups[id])
+		}
+		return resp, nil
+	case *offsetfetch.Request:
+		resp := &offsetfetch.Response{ErrorCode: int16(t.offsetFetchErr)}
+		for _, topic := range req.Topics {
+			partitions := []offsetfetch.ResponsePartition{}
+			for _, p := range topic.PartitionIndexes {
+				partitions = append(partitions, offsetfetch.ResponsePartition{PartitionIndex: p, CommittedOffset: t.committed[p]})
+			}
+			resp.Topics = append(resp.Topics, offsetfetch.ResponseTopic{Name: topic.Name, Partitions: partitions})
+		}
+		return resp, nil
+	case *listoffsets.Request:
+		resp := &listoffsets.Response{}
+		for _, topic := range req.Topics {
+			partitions := []listoffsets.ResponsePartition{}
+			for _, p := range topic.Partitions {
+				partitions = append(partitions, listoffsets.ResponsePartition{Partition: p.Partition, Timestamp: p.Timestamp, Offset: t.last[p.Partition]})
+			}
+			resp.Topics = append(resp.Topics, listoffsets.ResponseTopic{Topic: topic.Topic, Partitions: partitions})
+		}
+		return resp, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func TestConsumerGroupsCheck(t *testing.T) {
+	st := newTestState()
+	transport := fakeGroupsTransport{
+		groups: map[string]describegroups.ResponseGroup{
+			"orders": {GroupID: "orders", GroupState: "Stable", Members: []describegroups.ResponseGroupMember{
+				{MemberID: "consumer-1", MemberAssignment: memberAssignment("orders", 0)},
+				{MemberID: "consumer-2", MemberAssignment: memberAssignment("orders", 1, 2)},
+			}},
+			"payments": {GroupID: "payments", GroupState: "Empty"},
+		},
+		// partition 2 has no committed offset yet
+		committed: map[int32]int64{0: 90, 1: 40, 2: -1},
+		last:      map[int32]int64{0: 100, 1: 45, 2: 30},
+	}
+	logger := zerolog.Nop()
+	newCheck := func(groups ...string) *consumerGroupsService {
+		return &consumerGroupsService{
+			state:        st,
+			connector:    &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: transport}},
+			canaryConfig: &canary.Config{ConsumerGroups: canary.ConsumerGroupsConfig{Groups: groups}},
+			logger:       &logger,
+		}
+	}
+
+	assert.NoError(t, newCheck("orders", "payments").Check(context.Background()))
+	assert.Equal(t, 2.0, testutil.ToFloat64(consumerGroupMembers.In(st.metrics).WithLabelValues("orders")))
+	assert.Equal(t, 15.0, testutil.ToFloat64(consumerGroupLag.In(st.metrics).WithLabelValues("orders")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerGroupState.In(st.metrics).WithLabelValues("orders", "Stable")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(consumerGroupState.In(st.metrics).WithLabelValues("orders", "Empty")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(consumerGroupMembers.In(st.metrics).WithLabelValues("payments")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerGroupState.In(st.metrics).WithLabelValues("payments", "Empty")))
+	assert.Equal(t, 1, testutil.CollectAndCount(consumerGroupLag.In(st.metrics)), "no lag without members")
+
+	// the groups failing are reported, the others still described
+	transport.groups["billing"] = describegroups.ResponseGroup{GroupID: "billing", ErrorCode: int16(kafka.GroupAuthorizationFailed)}
+	transport.groups["payments"] = describegroups.ResponseGroup{GroupID: "payments", GroupState: "PreparingRebalance"}
+	err := newCheck("billing", "payments").Check(context.Background())
+	assert.ErrorContains(t, err, "error describing consumer groups: [billing (")
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerGroupState.In(st.metrics).WithLabelValues("payments", "PreparingRebalance")))
+
+	transport.offsetFetchErr = kafka.GroupAuthorizationFailed
+	err = newCheck("orders").Check(context.Background())
+	assert.ErrorContains(t, err, "error describing consumer groups: [orders (")
+}
@@ -0,0 +1,476 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	recordsConsumed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "records_consumed_total",
+		Namespace: metricsNamespace,
+		Help:      "The total number of records consumed",
+	}, []string{"clientid", "partition"})
+
+	recordsConsumerFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "consumer_error_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of errors reported by the consumer",
+	}, []string{"clientid", "error_class"})
+
+	// refreshConsumerMetadataError = promauto.NewCounterVec(prometheus.CounterOpts{
+	// 	Name:      "consumer_refresh_metadata_error_total",
+	// 	Namespace: metricsNamespace,
+	// 	Help:      "Total number of errors while refreshing consumer metadata",
+	// }, []string{"clientid"})
+)
+
+type consumerService struct {
+	state           *State
+	client          client.Client
+	consumer        *kafka.Reader
+	canaryConfig    *canary.Config
+	connectorConfig client.ConnectorConfig
+	// reference to the function for cancelling the Sarama consumer group context
+	// in order to ending the session and allowing a rejoin with rebalancing
+	cancel context.CancelFunc
+	chaos  *chaos
+	// last sequence verified by source instance and partition
+	sequences *sequenceStore
+	sampler   RecordsSampler
+	cipher    *recordCipher
+	commits   *committer
+	// captures the unverifiable records, nil when disabled
+	deadLetters *deadLetters
+	// nil without anomaly detection
+	anomalies  *latencyDetector
+	rebalances *rebalanceImpact
+	logger     *zerolog.Logger
+	// caches of the per-record strings and metrics, only used by the consume goroutine so records
+	// are verified without allocating
+	partitions   map[int]*consumedPartition
+	sources      map[string]string
+	sequenceKeys map[sequenceSource]string
+	// topology generation of the cached partition metrics
+	generation uint64
+	// closed by both the consume goroutine leaving and the canary manager stopping
+	closeOnce sync.Once
+}
+
+// consumedPartition holds the metrics of a partition consumed
+type consumedPartition struct {
+	latency  prometheus.Observer
+	consumed prometheus.Counter
+}
+
+// sequenceSource identifies a partition sequence verified by the consumer
+type sequenceSource struct {
+	instance  string
+	partition int
+}
+
+// bound of the cached source instances, beyond it their strings are allocated
+const maxCachedSources = 1024
+
+func NewConsumerService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (ConsumerService, error) {
+	if canaryConfig.Commit.Strategy == "" {
+		canaryConfig.Commit.Strategy = CommitPerRecord
+	}
+	if err := validCommitStrategy(canaryConfig.Commit.Strategy); err != nil {
+		return nil, err
+	}
+	if canaryConfig.Commit.Strategy == CommitInterval && canaryConfig.Commit.Interval <= 0 {
+		return nil, errors.New("the interval commit strategy needs a positive commit interval")
+	}
+	balancers, err := groupBalancers(canaryConfig.AssignmentStrategies)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Msg("Created consumer service connector")
+
+	cipher, err := newRecordCipher(canaryConfig.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters, err := newDeadLetters(state, canaryConfig, connectorConfig, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// opened last, it's closed with the service
+	sequences, err := openSequenceStore(canaryConfig.SequenceStateFile, sequenceScope(canaryConfig, connectorConfig), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:        connectorConfig.BrokerAddrs,
+		Dialer:         connector.Dialer,
+		GroupID:        canaryConfig.ConsumerGroupID,
+		GroupBalancers: balancers,
+		Topic:          canaryConfig.Topic,
+		MinBytes:       10e3, // 10KB
+		MaxBytes:       10e6, // 10MB
+		StartOffset:    kafka.LastOffset,
+	})
+	logger.Info().Msg("Created consumer service reader")
+	commits := newCommitter(state, canaryConfig.Commit.Strategy, canaryConfig.Commit.Interval, consumer.CommitMessages,
+		groupOffsets(connector.KafkaClient, canaryConfig.ConsumerGroupID, canaryConfig.Topic), logger)
+
+	return &consumerService{
+		state:           state,
+		consumer:        consumer,
+		canaryConfig:    &canaryConfig,
+		connectorConfig: connectorConfig,
+		chaos:           newChaos(canaryConfig.Chaos, state.metrics),
+		sequences:       sequences,
+		cipher:          cipher,
+		deadLetters:     deadLetters,
+		anomalies:       newLatencyDetector(state, "end_to_end", canaryConfig.Anomaly, logger),
+		rebalances:      newRebalanceImpact(state, canaryConfig.RebalanceDelayThreshold, logger),
+		commits:         commits,
+		logger:          logger,
+		partitions:      map[int]*consumedPartition{},
+		sources:         map[string]string{},
+		sequenceKeys:    map[sequenceSource]string{},
+	}, nil
+}
+
+func (s *consumerService) Consume(handler func(ConsumeResult)) {
+	// creating new context with cancellation, for exiting Consume when metadata refresh is needed
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.exportStats(ctx)
+	go s.commits.run(ctx)
+	go func() {
+		defer s.state.TrackGoroutine("consumer")()
+		defer s.Close()
+		for {
+			message, err := s.consumer.FetchMessage(ctx)
+			if err != nil {
+				s.state.countKafkaError("Fetch", err)
+				partition := s.consumer.Config().Partition
+
+				class := kafkaerr.ClassOf(err)
+				if class == kafkaerr.ClassNetwork || class == kafkaerr.ClassTimeout {
+					// These errors are recoverable, just try again
+					s.logger.Warn().Err(err).Int("partition", partition).Msg("Got connection error reading from partition, retrying")
+					continue
+				} else {
+					if ctx.Err() != nil {
+						s.logger.Info().Msg("Consumer Groups context cancelled")
+						return
+					}
+					s.logger.Error().Err(err).Str("topic", s.canaryConfig.Topic).Msg("Error consuming topic")
+					s.state.markDegraded("consumer", err)
+					labels := prometheus.Labels{
+						"clientid":    s.canaryConfig.ClientID,
+						"error_class": string(class),
+					}
+					recordsConsumerFailed.In(s.state.metrics).With(labels).Inc()
+					if handler != nil {
+						handler(ConsumeResult{Partition: partition, Err: kafkaerr.Wrap(err)})
+					}
+					continue
+				}
+			}
+			s.logger.Debug().Msg("Read canary message")
+
+			if ctx.Err() != nil {
+				s.logger.Info().Msg("Consumer Groups context cancelled")
+				return
+			}
+			s.commits.onFetched(ctx, message)
+			s.handle(message, handler)
+			// committed once handled unless auto committing, so records are verified at least
+			// once across restarts
+			s.commits.onVerified(ctx, message)
+		}
+	}()
+}
+
+// handle verifies and measures a canary record
+func (s *consumerService) handle(message kafka.Message, handler func(ConsumeResult)) {
+	if _, ok := headerBytes(message, CheckHeader); ok {
+		// records produced by checks, e.g. the message size one
+		return
+	}
+	if s.fromOtherInstance(message) {
+		recordsDropped.In(s.state.metrics).WithLabelValues("other_instance").Inc()
+		return
+	}
+	value := message.Value
+	if keyID, ok := headerValue(message, KeyIDHeader); ok {
+		opened, err := s.cipher.open(keyID, value)
+		if errors.Is(err, ErrUnknownKey) {
+			// encrypted by a canary with another key, e.g. during a key rotation
+			s.logger.Debug().Str("key_id", keyID).Int("partition", message.Partition).Msg("Skipping canary record")
+			recordsDropped.In(s.state.metrics).WithLabelValues("unknown_key").Inc()
+			return
+		}
+		if err != nil {
+			s.logger.Err(err).
+				Int("partition", message.Partition).
+				Int64("offset", message.Offset).
+				Msg("Error decrypting canary message")
+			recordsDropped.In(s.state.metrics).WithLabelValues("decryption_failed").Inc()
+			s.deadLetters.capture(message, "decryption_failed", err)
+			return
+		}
+		value = opened
+	}
+	canaryMessage, err := NewCanaryMessage(value)
+	if err != nil && isUnsupportedRecordVersion(err) {
+		// written by a newer canary during a rolling upgrade, not a corrupted record
+		s.logger.Debug().Err(err).Int("partition", message.Partition).Msg("Skipping canary record")
+		recordsDropped.In(s.state.metrics).WithLabelValues("unsupported_version").Inc()
+		return
+	}
+	if err != nil {
+		s.logger.Err(err).
+			Int("partition", message.Partition).
+			Int64("offset", message.Offset).
+			Msg("Error creating new canary message")
+		recordsDropped.In(s.state.metrics).WithLabelValues("unparseable").Inc()
+		s.deadLetters.capture(message, "unparseable", err)
+		return
+	}
+	s.state.markHealthy("consumer")
+	if s.chaos.dropRecord() {
+		return
+	}
+	s.state.markConsumed(message.Partition)
+	source := s.source(message)
+	s.verifySequence(source, message.Partition, canaryMessage.Sequence)
+	s.chaos.consumeDelay()
+
+	now := time.Now()
+	timestamp := now.UnixMilli()
+	duration := timestamp - canaryMessage.Timestamp
+	partition := s.partition(message.Partition)
+	if s.canaryConfig.Coordination.Enabled && source != s.canaryConfig.InstanceID {
+		sourceZone, _ := headerValue(message, ZoneHeader)
+		s.state.recordsCrossZoneLatency.With(prometheus.Labels{
+			"source_instance": source,
+			"source_zone":     sourceZone,
+			"zone":            s.canaryConfig.Coordination.Zone,
+		}).Observe(float64(duration))
+	} else {
+		partition.latency.Observe(float64(duration))
+		s.state.observeIntervalLatency(duration)
+	}
+	if s.state.ClockSkewed() {
+		recordsLatencyClockSkewed.In(s.state.metrics).Inc()
+	} else {
+		// the latencies measured with a skewed clock would skew the baseline
+		s.anomalies.observe(time.Duration(duration) * time.Millisecond)
+	}
+	s.rebalances.consumed(time.UnixMilli(canaryMessage.Timestamp), time.Duration(duration)*time.Millisecond, now)
+	partition.consumed.Inc()
+	s.state.markFirstRecord(s.logger)
+	// the records of the other instances were produced, and counted, by them
+	if source == s.canaryConfig.InstanceID {
+		atomic.AddUint64(&s.state.recordsCount.consumed, 1)
+	}
+	if s.sampler != nil {
+		s.sampler.RecordsConsumed(1)
+	}
+	if handler != nil {
+		handler(ConsumeResult{
+			Partition: message.Partition,
+			Offset:    message.Offset,
+			MessageID: canaryMessage.MessageID,
+			Sequence:  canaryMessage.Sequence,
+			Timestamp: time.UnixMilli(canaryMessage.Timestamp),
+			Latency:   time.Duration(duration) * time.Millisecond,
+			Instance:  source,
+		})
+	}
+	s.logger.Info().
+		Int64("duration", duration).
+		Int("partition", message.Partition).
+		Int64("offset", message.Offset).
+		Bytes("message", message.Value).
+		Msg("Read message")
+}
+
+// SetRecordsSampler sets the sampler of the records read, it has to be set before consuming
+func (s *consumerService) SetRecordsSampler(sampler RecordsSampler) {
+	s.sampler = sampler
+}
+
+// verifySequence counts the records missing before the given one, or the record itself when it
+// was already seen, in the sequence of the partition produced by the source instance
+func (s *consumerService) verifySequence(source string, partition int, sequence int64) {
+	// records of canaries predating sequences
+	if sequence == 0 {
+		return
+	}
+	key, ok := s.sequenceKeys[sequenceSource{source, partition}]
+	if !ok {
+		key = consumedSequenceKey(source, partition)
+		s.sequenceKeys[sequenceSource{source, partition}] = key
+	}
+	lost, duplicate := s.sequences.verify(key, sequence)
+	if !duplicate && lost == 0 {
+		return
+	}
+	labels := prometheus.Labels{"partition": strconv.Itoa(partition)}
+	switch {
+	case duplicate:
+		recordsDuplicated.In(s.state.metrics).With(labels).Inc()
+		s.logger.Warn().
+			Str("source", source).
+			Int("partition", partition).
+			Int64("sequence", sequence).
+			Msg("Duplicate record consumed")
+	case lost > 0:
+		recordsLost.In(s.state.metrics).With(labels).Add(float64(lost))
+		s.state.observeRollLost(lost)
+		s.rebalances.lost(lost)
+		s.logger.Error().
+			Str("source", source).
+			Int("partition", partition).
+			Int64("sequence", sequence).
+			Int64("lost", lost).
+			Msg("Records missing before the consumed one")
+	}
+}
+
+// fromOtherInstance returns true when the record wasn't produced by this canary instance and
+// those records are ignored, they never are in coordinated mode
+func (s *consumerService) fromOtherInstance(message kafka.Message) bool {
+	if !s.canaryConfig.IgnoreOtherInstances || s.canaryConfig.Coordination.Enabled {
+		return false
+	}
+	instance, ok := headerBytes(message, InstanceHeader)
+	// records without the header come from canaries predating instance IDs
+	return !ok || string(instance) != s.canaryConfig.InstanceID
+}
+
+// partition returns the metrics of the consumed partition
+func (s *consumerService) partition(id int) *consumedPartition {
+	// the series of the partitions gone were deleted, the cached ones aren't exported anymore
+	if generation := s.state.topologyGeneration(); generation != s.generation {
+		s.partitions = map[int]*consumedPartition{}
+		s.generation = generation
+	}
+	if partition, ok := s.partitions[id]; ok {
+		return partition
+	}
+	labels := prometheus.Labels{
+		"clientid":  s.canaryConfig.ClientID,
+		"partition": strconv.Itoa(id),
+	}
+	partition := &consumedPartition{
+		latency:  s.state.recordsEndToEndLatency.With(labels),
+		consumed: recordsConsumed.In(s.state.metrics).With(labels),
+	}
+	s.partitions[id] = partition
+	return partition
+}
+
+// source returns the instance which produced the record, empty when unknown
+func (s *consumerService) source(message kafka.Message) string {
+	value, ok := headerBytes(message, InstanceHeader)
+	if !ok {
+		return ""
+	}
+	if source, ok := s.sources[string(value)]; ok {
+		return source
+	}
+	source := string(value)
+	if len(s.sources) < maxCachedSources {
+		s.sources[source] = source
+	}
+	return source
+}
+
+// headerValue returns the value of the given record header
+func headerValue(message kafka.Message, key string) (string, bool) {
+	value, ok := headerBytes(message, key)
+	return string(value), ok
+}
+
+// headerBytes returns the value of the given record header without copying it
+func headerBytes(message kafka.Message, key string) ([]byte, bool) {
+	for _, header := range message.Headers {
+		if header.Key == key {
+			return header.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (s *consumerService) Refresh() {
+	// TODO: Implement
+	s.logger.Info().Msg("Producer refreshing metadata")
+}
+
+func (s *consumerService) Leaders(ctx context.Context) (map[int]int, error) {
+	if s.client == nil {
+		a, err := client.NewBrokerAdminClient(ctx, client.BrokerAdminClientConfig{
+			ConnectorConfig: s.connectorConfig,
+			Auditor:         s.state.auditOperation,
+		}, s.logger)
+		if err != nil {
+			return map[int]int{}, err
+		}
+		s.client = a
+	}
+
+	topic, err := s.client.GetTopic(ctx, s.canaryConfig.Topic, false)
+	if err != nil {
+		return map[int]int{}, nil
+	}
+
+	leaders := make(map[int]int, len(topic.Partitions))
+
+	for _, p := range topic.Partitions {
+		leaders[p.ID] = p.Leader
+	}
+
+	return leaders, nil
+}
+
+func (s *consumerService) Close() {
+	s.closeOnce.Do(s.close)
+}
+
+func (s *consumerService) close() {
+	s.logger.Info().Msg("Closing consumer")
+	// the records verified since the last interval are committed before leaving the group
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	s.commits.flush(ctx)
+	cancel()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	err := s.consumer.Close()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Error closing the kafka consumer")
+		s.state.markDegraded("consumer", err)
+	}
+	s.deadLetters.close()
+	s.sequences.close()
+	s.logger.Info().Msg("Consumer closed")
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+// newTestConsumer returns a consumer handling records without a reader
+func newTestConsumer(tb testing.TB) *consumerService {
+	st := newTestState()
+	logger := zerolog.Nop()
+	sequences, err := openSequenceStore("", tb.Name(), &logger)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &consumerService{
+		state:        st,
+		canaryConfig: &canary.Config{ClientID: "canary"},
+		sequences:    sequences,
+		rebalances:   newRebalanceImpact(st, time.Second, &logger),
+		logger:       &logger,
+		partitions:   map[int]*consumedPartition{},
+		sources:      map[string]string{},
+		sequenceKeys: map[sequenceSource]string{},
+	}
+}
+
+// testRecords returns canary records of a partition with consecutive sequences
+func testRecords(n int) []kafka.Message {
+	messages := make([]kafka.Message, n)
+	for i := range messages {
+		message := CanaryMessage{ProducerID: "canary", MessageID: i + 1, Sequence: int64(i + 1), Timestamp: 1600000000000}
+		messages[i] = kafka.Message{
+			Partition: 1,
+			Value:     message.Encode(CurrentRecordVersion),
+			Headers:   []kafka.Header{{Key: InstanceHeader, Value: []byte("canary-0")}},
+		}
+	}
+	return messages
+}
+
+// consumeRecords handles the records in a loop, restarting their sequence on every pass
+func consumeRecords(s *consumerService, messages []kafka.Message, n int) {
+	for i := 0; i < n; i++ {
+		if i%len(messages) == 0 {
+			s.sequences.lock.Lock()
+			delete(s.sequences.sequences, "consumed/canary-0/1")
+			s.sequences.lock.Unlock()
+		}
+		s.handle(messages[i%len(messages)], nil)
+	}
+}
+
+// allocation budgets of the hot paths, run for every record at high canary rates
+func TestAllocationBudgets(t *testing.T) {
+	message := CanaryMessage{ProducerID: "canary", MessageID: 1, Sequence: 1, Timestamp: 1600000000000}
+	value := message.Encode(CurrentRecordVersion)
+	s := newTestConsumer(t)
+	messages := testRecords(64)
+	status := newTestStatusService()
+
+	tests := []struct {
+		name   string
+		budget float64
+		run    func()
+	}{
+		{"encode", 1, func() { message.Encode(CurrentRecordVersion) }},
+		{"decode", 0, func() { _, _ = NewCanaryMessage(value) }},
+		{"consume", 0, func() { consumeRecords(s, messages, 1) }},
+		{"ingest", 0, func() { status.RecordsConsumed(1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// warm the caches up
+			tt.run()
+			assert.LessOrEqual(t, testing.AllocsPerRun(100, tt.run), tt.budget)
+		})
+	}
+}
+
+func TestConsumerHandle(t *testing.T) {
+	s := newTestConsumer(t)
+	var results []ConsumeResult
+	for _, message := range testRecords(3) {
+		s.handle(message, func(r ConsumeResult) { results = append(results, r) })
+	}
+
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, "canary-0", results[2].Instance)
+		assert.Equal(t, int64(3), results[2].Sequence)
+		assert.Equal(t, 1, results[2].Partition)
+	}
+	assert.Equal(t, int64(3), s.sequences.get("consumed/canary-0/1"))
+}
+
+func BenchmarkConsumerHandle(b *testing.B) {
+	s := newTestConsumer(b)
+	messages := testRecords(1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	consumeRecords(s, messages, b.N)
+}
+
+func TestConsumerCloseOnce(t *testing.T) {
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	commits := 0
+	s := newTestConsumer(t)
+	st := s.state
+	s.logger = &logger
+	s.consumer = kafka.NewReader(kafka.ReaderConfig{Brokers: []string{"broker:9092"}, Topic: "canary"})
+	s.commits = newCommitter(st, CommitInterval, time.Second, func(context.Context, ...kafka.Message) error {
+		commits++
+		return nil
+	}, nil, &logger)
+	s.commits.onVerified(context.Background(), kafka.Message{Partition: 0, Offset: 1})
+
+	// by the consume goroutine leaving, then by the canary manager stopping
+	s.Close()
+	s.Close()
+
+	assert.Equal(t, 1, commits)
+	assert.Equal(t, 1, strings.Count(out.String(), "Consumer closed"))
+}
+
+func TestConsumerFromOtherInstance(t *testing.T) {
+	st := newTestState()
+	record := func(headers ...kafka.Header) kafka.Message {
+		return kafka.Message{Headers: headers}
+	}
+	own := kafka.Header{Key: InstanceHeader, Value: []byte("canary-0")}
+	other := kafka.Header{Key: InstanceHeader, Value: []byte("canary-1")}
+	tests := []struct {
+		name    string
+		config  canary.Config
+		message kafka.Message
+		want    bool
+	}{
+		{"other instances kept", canary.Config{InstanceID: "canary-0"}, record(other), false},
+		{"own record", canary.Config{InstanceID: "canary-0", IgnoreOtherInstances: true}, record(own), false},
+		{"other instance ignored", canary.Config{InstanceID: "canary-0", IgnoreOtherInstances: true}, record(other), true},
+		{"record without instance ignored", canary.Config{InstanceID: "canary-0", IgnoreOtherInstances: true}, record(), true},
+		{
+			"other instance kept in coordinated mode",
+			canary.Config{InstanceID: "canary-0", IgnoreOtherInstances: true, Coordination: canary.CoordinationConfig{Enabled: true}},
+			record(other),
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &consumerService{state: st, canaryConfig: &tt.config}
+			assert.Equal(t, tt.want, s.fromOtherInstance(tt.message))
+		})
+	}
+}
+
+func TestConsumerCrossZoneLatency(t *testing.T) {
+	s := newTestConsumer(t)
+	st := s.state
+	s.canaryConfig = &canary.Config{
+		ClientID:     "canary",
+		InstanceID:   "canary-1",
+		Coordination: canary.CoordinationConfig{Enabled: true, Zone: "zone-b", Instances: []string{"canary-0", "canary-1"}},
+	}
+	var results []ConsumeResult
+	handler := func(r ConsumeResult) { results = append(results, r) }
+
+	// a record of this instance measures the end-to-end latency
+	message := testRecords(1)[0]
+	message.Headers = []kafka.Header{{Key: InstanceHeader, Value: []byte("canary-1")}}
+	s.handle(message, handler)
+	assert.Equal(t, 0, testutil.CollectAndCount(st.recordsCrossZoneLatency))
+
+	message = testRecords(1)[0]
+	message.Headers = append(message.Headers, kafka.Header{Key: ZoneHeader, Value: []byte("zone-a")})
+	s.handle(message, handler)
+	assert.Equal(t, 1, testutil.CollectAndCount(st.recordsCrossZoneLatency))
+	assert.Equal(t, 1, testutil.CollectAndCount(st.recordsCrossZoneLatency.MustCurryWith(prometheus.Labels{
+		"source_instance": "canary-0", "source_zone": "zone-a", "zone": "zone-b",
+	})))
+	require.Len(t, results, 2)
+	assert.Equal(t, "canary-1", results[0].Instance)
+	assert.Equal(t, "canary-0", results[1].Instance)
+}
+
+func TestConsumerSkipsCheckRecords(t *testing.T) {
+	s := newTestConsumer(t)
+	var results []ConsumeResult
+	handler := func(r ConsumeResult) { results = append(results, r) }
+
+	message := testRecords(1)[0]
+	message.Headers = append(message.Headers, kafka.Header{Key: CheckHeader, Value: []byte("message_size")})
+	s.handle(message, handler)
+	assert.Empty(t, results, "record produced by a check")
+
+	s.handle(testRecords(1)[0], handler)
+	assert.Len(t, results, 1)
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// executor state of Cruise Control while it isn't moving anything
+const cruiseControlIdle = "NO_TASK_IN_PROGRESS"
+
+var (
+	cruiseControlRebalanceActive = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "cruisecontrol_rebalance_active",
+		Namespace: metricsNamespace,
+		Help:      "Whether Cruise Control is executing a rebalance (1), e.g. moving replicas or leaders",
+	})
+
+	cruiseControlAnomalyActive = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "cruisecontrol_anomaly_active",
+		Namespace: metricsNamespace,
+		Help:      "Whether Cruise Control is self-healing an anomaly (1)",
+	})
+)
+
+// Rebalancing returns true while Cruise Control was last seen executing a rebalance
+func (st *State) Rebalancing() bool {
+	return atomic.LoadInt32(&st.rebalancing) == 1
+}
+
+// cruiseControlService polls the Cruise Control state for ongoing rebalances and anomalies, so the
+// latency regressions they cause can be told apart from the cluster ones
+type cruiseControlService struct {
+	state        *State
+	http         *http.Client
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+
+	// executor state and anomaly seen on the previous run
+	executor string
+	anomaly  string
+}
+
+func NewCruiseControlService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	return &cruiseControlService{
+		state:        state,
+		http:         &http.Client{},
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+		executor:     cruiseControlIdle,
+	}, nil
+}
+
+func (s *cruiseControlService) Name() string {
+	return "cruise_control"
+}
+
+func (s *cruiseControlService) Interval() time.Duration {
+	return s.canaryConfig.CruiseControl.Interval
+}
+
+func (s *cruiseControlService) Check(ctx context.Context) error {
+	state, err := s.describeState(ctx)
+	if err != nil {
+		return err
+	}
+
+	executor := state.ExecutorState.State
+	active := executor != cruiseControlIdle
+	if active {
+		cruiseControlRebalanceActive.In(s.state.metrics).Set(1)
+		atomic.StoreInt32(&s.state.rebalancing, 1)
+	} else {
+		cruiseControlRebalanceActive.In(s.state.metrics).Set(0)
+		atomic.StoreInt32(&s.state.rebalancing, 0)
+	}
+	switch {
+	case active && s.executor == cruiseControlIdle:
+		s.logger.Info().Str("state", executor).Msg("Cruise Control rebalance started")
+		s.state.recordEvent(EventWarning, s.Name(), "rebalance started (%s)", executor)
+	case !active && s.executor != cruiseControlIdle:
+		s.logger.Info().Msg("Cruise Control rebalance finished")
+		s.state.recordEvent(EventInfo, s.Name(), "rebalance finished")
+	}
+	s.executor = executor
+
+	anomaly := state.AnomalyDetectorState.OngoingSelfHealingAnomaly
+	if anomaly == "None" {
+		anomaly = ""
+	}
+	if anomaly != "" {
+		cruiseControlAnomalyActive.In(s.state.metrics).Set(1)
+	} else {
+		cruiseControlAnomalyActive.In(s.state.metrics).Set(0)
+	}
+	if anomaly != "" && anomaly != s.anomaly {
+		s.logger.Info().Str("anomaly", anomaly).Msg("Cruise Control self-healing an anomaly")
+		s.state.recordEvent(EventWarning, s.Name(), "self-healing anomaly %s", anomaly)
+	}
+	s.anomaly = anomaly
+	return nil
+}
+
+func (s *cruiseControlService) Close() {
+	s.http.CloseIdleConnections()
+}
+
+// cruiseControlState is the part of the Cruise Control state response the check reads
+type cruiseControlState struct {
+	ExecutorState struct {
+		State string `json:"state"`
+	} `json:"ExecutorState"`
+	AnomalyDetectorState struct {
+		OngoingSelfHealingAnomaly string `json:"ongoingSelfHealingAnomaly"`
+	} `json:"AnomalyDetectorState"`
+}
+
+// describeState returns the executor and anomaly detector state of Cruise Control
+func (s *cruiseControlService) describeState(ctx context.Context) (cruiseControlState, error) {
+	config := s.canaryConfig.CruiseControl
+	endpoint := strings.TrimSuffix(config.URL, "/") + "/kafkacruisecontrol/state?substates=executor,anomaly_detector&json=true"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return cruiseControlState{}, err
+	}
+	if config.Username != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return cruiseControlState{}, fmt.Errorf("error querying the Cruise Control state: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return cruiseControlState{}, fmt.Errorf("Cruise Control state returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var state cruiseControlState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return cruiseControlState{}, fmt.Errorf("error decoding the Cruise Control state: %w", err)
+	}
+	if state.ExecutorState.State == "" {
+		return cruiseControlState{}, errors.New("Cruise Control state without executor state")
+	}
+	return state, nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestCruiseControlRebalance(t *testing.T) {
+	st := newTestState()
+	state := `{"ExecutorState":{"state":"INTER_BROKER_REPLICA_MOVEMENT_TASK_IN_PROGRESS"},"AnomalyDetectorState":{"ongoingSelfHealingAnomaly":"None"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/kafkacruisecontrol/state", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("json"))
+		_, _ = w.Write([]byte(state))
+	}))
+	defer server.Close()
+
+	logger := zerolog.Nop()
+	s := &cruiseControlService{
+		state:        st,
+		http:         server.Client(),
+		canaryConfig: &canary.Config{CruiseControl: canary.CruiseControlConfig{URL: server.URL}},
+		logger:       &logger,
+		executor:     cruiseControlIdle,
+	}
+
+	require.NoError(t, s.Check(context.Background()))
+	assert.True(t, st.Rebalancing())
+	assert.Equal(t, 1.0, testutil.ToFloat64(cruiseControlRebalanceActive.In(st.metrics)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(cruiseControlAnomalyActive.In(st.metrics)))
+
+	state = `{"ExecutorState":{"state":"NO_TASK_IN_PROGRESS"},"AnomalyDetectorState":{"ongoingSelfHealingAnomaly":"GOAL_VIOLATION"}}`
+	require.NoError(t, s.Check(context.Background()))
+	assert.False(t, st.Rebalancing())
+	assert.Equal(t, 0.0, testutil.ToFloat64(cruiseControlRebalanceActive.In(st.metrics)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(cruiseControlAnomalyActive.In(st.metrics)))
+
+	state = `{"version":1}`
+	assert.Error(t, s.Check(context.Background()))
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// timeout of the writes to the dead-letter topic
+const deadLetterWriteTimeout = 10 * time.Second
+
+var (
+	deadLetterRecords = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "dead_letter_records_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of unverifiable consumed records captured, by reason",
+	}, []string{"reason"})
+
+	deadLetterSkipped = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "dead_letter_skipped_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of unverifiable consumed records not captured because of the capture limit",
+	})
+
+	deadLetterErrors = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "dead_letter_errors_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of unverifiable consumed records that couldn't be captured",
+	})
+)
+
+// DeadLetter is an unverifiable consumed record with the diagnostics of why it couldn't be
+// verified, as captured
+type DeadLetter struct {
+	CapturedAt time.Time         `json:"capturedAt"`
+	Instance   string            `json:"instance,omitempty"`
+	Reason     string            `json:"reason"`
+	Error      string            `json:"error"`
+	Topic      string            `json:"topic"`
+	Partition  int               `json:"partition"`
+	Offset     int64             `json:"offset"`
+	Time       time.Time         `json:"time"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// raw key and value, base64 encoded in JSON
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value"`
+}
+
+// deadLetters captures the unverifiable consumed records to a local file and a dead-letter topic,
+// at most the configured number per interval so a corrupted partition doesn't flood them
+type deadLetters struct {
+	state    *State
+	config   canary.DeadLetterConfig
+	instance string
+	file     *os.File
+	writer   *kafka.Writer
+	logger   *zerolog.Logger
+
+	lock sync.Mutex
+	// start of the current capture interval and the records captured during it
+	intervalStart time.Time
+	captured      int
+}
+
+// newDeadLetters returns the dead-letter capture, nil when neither a file nor a topic is configured
+func newDeadLetters(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (*deadLetters, error) {
+	config := canaryConfig.DeadLetter
+	if config.File == "" && config.Topic == "" {
+		return nil, nil
+	}
+	if config.MaxRecords <= 0 || config.Interval <= 0 {
+		return nil, errors.New("the dead-letter capture needs a positive max records and interval")
+	}
+	d := &deadLetters{
+		state:    state,
+		config:   config,
+		instance: canaryConfig.InstanceID,
+		logger:   logger,
+	}
+	if config.File != "" {
+		file, err := os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		d.file = file
+	}
+	if config.Topic != "" {
+		connector, err := client.NewConnector(connectorConfig)
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+		d.writer = &kafka.Writer{
+			Addr:         connector.KafkaClient.Addr,
+			Transport:    connector.KafkaClient.Transport,
+			Topic:        config.Topic,
+			RequiredAcks: kafka.RequireAll,
+		}
+	}
+	return d, nil
+}
+
+// capture records the unverifiable message unless the capture limit of the interval is reached
+func (d *deadLetters) capture(message kafka.Message, reason string, cause error) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	if now.Sub(d.intervalStart) >= d.config.Interval {
+		d.intervalStart, d.captured = now, 0
+	}
+	if d.captured >= d.config.MaxRecords {
+		deadLetterSkipped.In(d.state.metrics).Inc()
+		return
+	}
+	d.captured++
+
+	letter := DeadLetter{
+		CapturedAt: now,
+		Instance:   d.instance,
+		Reason:     reason,
+		Error:      cause.Error(),
+		Topic:      message.Topic,
+		Partition:  message.Partition,
+		Offset:     message.Offset,
+		Time:       message.Time,
+		Key:        message.Key,
+		Value:      message.Value,
+	}
+	if len(message.Headers) > 0 {
+		letter.Headers = make(map[string]string, len(message.Headers))
+		for _, h := range message.Headers {
+			letter.Headers[h.Key] = string(h.Value)
+		}
+	}
+	value, err := json.Marshal(letter)
+	if err == nil && d.file != nil {
+		_, err = d.file.Write(append(value, '\n'))
+	}
+	if err == nil && d.writer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), deadLetterWriteTimeout)
+		err = d.writer.WriteMessages(ctx, kafka.Message{Value: value})
+		cancel()
+		d.state.countKafkaError("Produce", err)
+	}
+	if err != nil {
+		deadLetterErrors.In(d.state.metrics).Inc()
+		d.logger.Error().Err(err).Int("partition", message.Partition).Int64("offset", message.Offset).Msg("Error capturing the unverifiable record")
+		return
+	}
+	deadLetterRecords.In(d.state.metrics).WithLabelValues(reason).Inc()
+}
+
+// close closes the capture file and topic writer
+func (d *deadLetters) close() {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.file != nil {
+		if err := d.file.Close(); err != nil {
+			d.logger.Error().Err(err).Msg("Error closing the dead-letter file")
+		}
+		d.file = nil
+	}
+	if d.writer != nil {
+		if err := d.writer.Close(); err != nil {
+			d.logger.Error().Err(err).Msg("Error closing the dead-letter topic writer")
+		}
+		d.writer = nil
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+func TestDeadLetters(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	d, err := newDeadLetters(st, canary.Config{}, client.ConnectorConfig{}, &logger)
+	require.NoError(t, err)
+	assert.Nil(t, d, "disabled without a file nor a topic")
+	// a disabled capture can still be used
+	d.capture(kafka.Message{}, "unparseable", errors.New("invalid character"))
+	d.close()
+
+	_, err = newDeadLetters(st, canary.Config{DeadLetter: canary.DeadLetterConfig{File: "dead-letters.jsonl"}}, client.ConnectorConfig{}, &logger)
+	assert.Error(t, err, "unbounded capture")
+
+	file := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	d, err = newDeadLetters(st, canary.Config{
+		InstanceID: "canary-0",
+		DeadLetter: canary.DeadLetterConfig{File: file, MaxRecords: 2, Interval: time.Hour},
+	}, client.ConnectorConfig{}, &logger)
+	require.NoError(t, err)
+	skipped := testutil.ToFloat64(deadLetterSkipped.In(st.metrics))
+	for offset := int64(0); offset < 3; offset++ {
+		d.capture(kafka.Message{
+			Topic:     "__kafka_canary",
+			Partition: 1,
+			Offset:    offset,
+			Headers:   []kafka.Header{{Key: KeyIDHeader, Value: []byte("key-1")}},
+			Value:     []byte("{corrupted"),
+		}, "unparseable", errors.New("unexpected end of JSON input"))
+	}
+	d.close()
+	assert.Equal(t, skipped+1, testutil.ToFloat64(deadLetterSkipped.In(st.metrics)))
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter DeadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &letter))
+		letters = append(letters, letter)
+	}
+	require.Len(t, letters, 2)
+	assert.Equal(t, "canary-0", letters[0].Instance)
+	assert.Equal(t, "unparseable", letters[0].Reason)
+	assert.Equal(t, "unexpected end of JSON input", letters[0].Error)
+	assert.Equal(t, int64(1), letters[1].Offset)
+	assert.Equal(t, []byte("{corrupted"), letters[1].Value)
+	assert.Equal(t, map[string]string{KeyIDHeader: "key-1"}, letters[1].Headers)
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var (
+	serviceDegraded = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "service_degraded",
+		Namespace: metricsNamespace,
+		Help:      "Whether a canary service is degraded (1) or healthy (0)",
+	}, []string{"service"})
+)
+
+// degradedServices are the degraded services of a canary and the error that degraded them
+type degradedServices struct {
+	lock     sync.RWMutex
+	services map[string]string
+	// services whose degraded flag was exported at least once
+	exported map[string]bool
+}
+
+// markDegraded flags the service as degraded because of the given error, the canary keeps running
+func (st *State) markDegraded(service string, err error) {
+	st.observeAuthFailure(service, err)
+	st.observeFailureClass(service, err)
+	d := &st.degraded
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, degraded := d.services[service]; !degraded {
+		st.recordEvent(EventError, service, "degraded: %v", err)
+	}
+	d.services[service] = err.Error()
+	d.exported[service] = true
+	serviceDegraded.In(st.metrics).WithLabelValues(service).Set(1)
+}
+
+// markHealthy clears the degraded flag of the service. It's called for every record consumed, so
+// it only takes the write lock when the flag changes.
+func (st *State) markHealthy(service string) {
+	d := &st.degraded
+	d.lock.RLock()
+	_, degraded := d.services[service]
+	exported := d.exported[service]
+	d.lock.RUnlock()
+	if !degraded && exported {
+		return
+	}
+
+	st.observeAuthFailure(service, nil)
+	st.observeFailureClass(service, nil)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, degraded := d.services[service]; degraded {
+		st.recordEvent(EventInfo, service, "recovered")
+	}
+	delete(d.services, service)
+	d.exported[service] = true
+	serviceDegraded.In(st.metrics).WithLabelValues(service).Set(0)
+}
+
+// DegradedServices returns the currently degraded services and the error that degraded them
+func (st *State) DegradedServices() map[string]string {
+	st.degraded.lock.RLock()
+	defer st.degraded.lock.RUnlock()
+	services := make(map[string]string, len(st.degraded.services))
+	for service, reason := range st.degraded.services {
+		services[service] = reason
+	}
+	return services
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDegradedServices(t *testing.T) {
+	type step struct {
+		service string
+		// degraded with the error when set, healthy otherwise
+		err error
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  map[string]string
+		// exported degraded flag of each service
+		gauges map[string]float64
+	}{
+		{
+			name:   "enter",
+			steps:  []step{{"producer", errors.New("produce failed")}},
+			want:   map[string]string{"producer": "produce failed"},
+			gauges: map[string]float64{"producer": 1},
+		},
+		{
+			name:   "latest error kept",
+			steps:  []step{{"producer", errors.New("produce failed")}, {"producer", errors.New("still failing")}},
+			want:   map[string]string{"producer": "still failing"},
+			gauges: map[string]float64{"producer": 1},
+		},
+		{
+			name:   "leave",
+			steps:  []step{{"producer", errors.New("produce failed")}, {"producer", nil}},
+			want:   map[string]string{},
+			gauges: map[string]float64{"producer": 0},
+		},
+		{
+			name:   "healthy exported once seen",
+			steps:  []step{{"consumer", nil}},
+			want:   map[string]string{},
+			gauges: map[string]float64{"consumer": 0},
+		},
+		{
+			name: "services apart",
+			steps: []step{
+				{"producer", errors.New("produce failed")},
+				{"consumer", errors.New("fetch failed")},
+				{"producer", nil},
+			},
+			want:   map[string]string{"consumer": "fetch failed"},
+			gauges: map[string]float64{"producer": 0, "consumer": 1},
+		},
+		{
+			name:   "enter again",
+			steps:  []step{{"producer", errors.New("produce failed")}, {"producer", nil}, {"producer", errors.New("failed again")}},
+			want:   map[string]string{"producer": "failed again"},
+			gauges: map[string]float64{"producer": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestState()
+			for _, step := range tt.steps {
+				if step.err != nil {
+					st.markDegraded(step.service, step.err)
+				} else {
+					st.markHealthy(step.service)
+				}
+			}
+			assert.Equal(t, tt.want, st.DegradedServices())
+			gauge := serviceDegraded.In(st.metrics)
+			assert.Equal(t, len(tt.gauges), testutil.CollectAndCount(gauge))
+			for service, value := range tt.gauges {
+				assert.Equal(t, value, testutil.ToFloat64(gauge.WithLabelValues(service)), service)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// errOperationAllowed is returned when an operation expected to be denied succeeds
+var errOperationAllowed = errors.New("operation allowed")
+
+var deniedOperationAllowed = metrics.NewGaugeVec(prometheus.GaugeOpts{
+	Name:      "denied_operation_allowed",
+	Namespace: metricsNamespace,
+	Help:      "Operations the canary principal must be denied, 1 when the last attempt was allowed",
+}, []string{"operation", "resource"})
+
+// deniedOperationsService attempts operations the canary principal lacks the ACLs for, checking
+// they are denied, a continuous regression test of the cluster authorization
+type deniedOperationsService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewDeniedOperationsService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deniedOperationsService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *deniedOperationsService) Name() string {
+	return "denied_operations"
+}
+
+func (s *deniedOperationsService) Interval() time.Duration {
+	return s.canaryConfig.DeniedOperations.Interval
+}
+
+func (s *deniedOperationsService) Check(ctx context.Context) error {
+	var failed []string
+	verify := func(operation, resource string, err error) {
+		err = expectDenied(err)
+		allowed := 0.0
+		if errors.Is(err, errOperationAllowed) {
+			allowed = 1
+		}
+		deniedOperationAllowed.In(s.state.metrics).WithLabelValues(operation, resource).Set(allowed)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s %s (%v)", operation, resource, err))
+		}
+	}
+
+	for _, topic := range s.canaryConfig.DeniedOperations.ProduceTopics {
+		verify("produce", topic, s.produce(ctx, topic))
+	}
+	for _, group := range s.canaryConfig.DeniedOperations.DescribeGroups {
+		verify("describe_group", group, s.describeGroup(ctx, group))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("error verifying denied operations: %v", failed)
+	}
+	return nil
+}
+
+func (s *deniedOperationsService) Close() {}
+
+// produce writes a record to the first partition of the topic
+func (s *deniedOperationsService) produce(ctx context.Context, topic string) error {
+	resp, err := s.connector.KafkaClient.Produce(ctx, &kafka.ProduceRequest{
+		Topic:        topic,
+		RequiredAcks: kafka.RequireOne,
+		Records: kafka.NewRecordReader(kafka.Record{
+			Value:   kafka.NewBytes([]byte(s.Name())),
+			Headers: []kafka.Header{{Key: CheckHeader, Value: []byte(s.Name())}},
+		}),
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	return err
+}
+
+// describeGroup describes the consumer group
+func (s *deniedOperationsService) describeGroup(ctx context.Context, group string) error {
+	resp, err := s.connector.KafkaClient.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{
+		GroupIDs: []string{group},
+	})
+	if err != nil {
+		return err
+	}
+	for _, g := range resp.Groups {
+		if g.Error != nil {
+			return g.Error
+		}
+	}
+	return nil
+}
+
+// expectDenied returns nil when the operation failed for lacking ACLs, errOperationAllowed when
+// it succeeded and the error otherwise, the operation couldn't be verified. The errors aren't
+// counted in the Kafka errors, where the expected denials would be mistaken for ACL issues.
+func expectDenied(err error) error {
+	switch {
+	case err == nil:
+		return errOperationAllowed
+	case kafkaerr.ClassOf(err) == kafkaerr.ClassAuthz:
+		return nil
+	default:
+		return kafkaerr.Wrap(err)
+	}
+}
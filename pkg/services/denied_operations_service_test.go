@@ -0,0 +1,21 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+func TestExpectDenied(t *testing.T) {
+	assert.NoError(t, expectDenied(kafka.TopicAuthorizationFailed))
+	assert.NoError(t, expectDenied(kafka.GroupAuthorizationFailed))
+	assert.ErrorIs(t, expectDenied(nil), errOperationAllowed)
+
+	err := expectDenied(kafka.RequestTimedOut)
+	assert.False(t, errors.Is(err, errOperationAllowed))
+	assert.ErrorIs(t, err, kafkaerr.ErrTimeout)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// largest record fetched back by the direct leader check
+const directLeaderMaxBytes = 1 << 20
+
+var (
+	directLeaderLatency = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "direct_leader_latency",
+		Namespace: metricsNamespace,
+		Help:      "Produce and fetch latency against the partition leader dialed directly in milliseconds, by phase and broker",
+		Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000, 5000},
+	}, []string{"phase", "broker"})
+
+	directLeaderFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "direct_leader_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of failed produces and fetches against the partition leader dialed directly, by phase, broker and error class",
+	}, []string{"phase", "broker", "error_class"})
+
+	directLeaderUp = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "direct_leader_up",
+		Namespace: metricsNamespace,
+		Help:      "Whether the last produce and fetch against each partition the broker leads, dialed directly, succeeded (1)",
+	}, []string{"broker"})
+)
+
+// directLeaderService produces a record to every canary partition and fetches it back over a
+// connection dialed straight to the partition leader, found in the cluster info of the last
+// topic reconcile. It bypasses the bootstrap brokers and the client metadata, so a broken
+// leader broker can be told apart from broken metadata or bootstrap routing: the leader is fine
+// when this check passes while the canary producer or consumer fail.
+type directLeaderService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewDirectLeaderService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &directLeaderService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *directLeaderService) Name() string {
+	return "direct_leader"
+}
+
+func (s *directLeaderService) Interval() time.Duration {
+	return s.canaryConfig.DirectLeader.Interval
+}
+
+func (s *directLeaderService) Check(ctx context.Context) error {
+	info, ok := s.state.lastClusterInfo()
+	if !ok {
+		return errors.New("no partition leaders known before the first topic reconcile")
+	}
+	brokers := make(map[int]ClusterBroker, len(info.Brokers))
+	for _, broker := range info.Brokers {
+		brokers[broker.ID] = broker
+	}
+
+	// a broker is up once every partition it leads was produced to and fetched from
+	up := map[int]bool{}
+	var failed []string
+	for _, partition := range info.Partitions {
+		broker, ok := brokers[partition.Leader]
+		if !ok {
+			failed = append(failed, fmt.Sprintf("partition %d has no leader", partition.ID))
+			continue
+		}
+		err := s.roundTrip(ctx, partition.ID, broker)
+		if _, seen := up[broker.ID]; !seen || err != nil {
+			up[broker.ID] = err == nil
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("partition %d on broker %d: %v", partition.ID, broker.ID, err))
+		}
+	}
+	for id, ok := range up {
+		value := 0.0
+		if ok {
+			value = 1
+		}
+		directLeaderUp.In(s.state.metrics).WithLabelValues(strconv.Itoa(id)).Set(value)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("direct leader round trips failed: %v", failed)
+	}
+	return nil
+}
+
+func (s *directLeaderService) Close() {}
+
+// roundTrip produces a record to the partition on a connection to its leader, and fetches it back
+func (s *directLeaderService) roundTrip(ctx context.Context, partition int, broker ClusterBroker) error {
+	label := strconv.Itoa(broker.ID)
+	conn, err := s.connector.Dialer.DialPartition(ctx, "tcp", "", kafka.Partition{
+		Topic:  s.canaryConfig.Topic,
+		ID:     partition,
+		Leader: kafka.Broker{ID: broker.ID, Host: broker.Host, Port: broker.Port, Rack: broker.Rack},
+	})
+	if err != nil {
+		return s.fail("dial", label, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	start := time.Now()
+	_, _, offset, _, err := conn.WriteCompressedMessagesAt(nil, kafka.Message{
+		Value:   []byte(s.Name()),
+		Headers: []kafka.Header{{Key: CheckHeader, Value: []byte(s.Name())}},
+	})
+	if err != nil {
+		return s.fail("produce", label, err)
+	}
+	directLeaderLatency.In(s.state.metrics).WithLabelValues("produce", label).Observe(float64(time.Since(start).Milliseconds()))
+
+	start = time.Now()
+	if _, err := conn.Seek(offset, kafka.SeekAbsolute); err != nil {
+		return s.fail("fetch", label, err)
+	}
+	message, err := conn.ReadMessage(directLeaderMaxBytes)
+	if err != nil {
+		return s.fail("fetch", label, err)
+	}
+	if message.Offset != offset {
+		return s.fail("fetch", label, fmt.Errorf("fetched offset %d instead of %d", message.Offset, offset))
+	}
+	directLeaderLatency.In(s.state.metrics).WithLabelValues("fetch", label).Observe(float64(time.Since(start).Milliseconds()))
+	return nil
+}
+
+// fail counts the failure of the phase against the broker
+func (s *directLeaderService) fail(phase, broker string, err error) error {
+	switch phase {
+	case "produce":
+		s.state.countKafkaError("Produce", err)
+	case "fetch":
+		s.state.countKafkaError("Fetch", err)
+	}
+	directLeaderFailed.In(s.state.metrics).WithLabelValues(phase, broker, string(kafkaerr.ClassOf(err))).Inc()
+	s.logger.Warn().Err(err).Str("phase", phase).Str("broker", broker).Msg("Direct leader round trip failed")
+	return kafkaerr.Wrap(err)
+}
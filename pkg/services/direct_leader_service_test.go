@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+func TestDirectLeaderServiceUnreachableLeader(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	check, err := NewDirectLeaderService(st, canary.Config{Topic: "__kafka_canary"}, client.ConnectorConfig{}, &logger)
+	require.NoError(t, err)
+
+	// before the first topic reconcile no leader is known
+	assert.Error(t, check.Check(context.Background()))
+
+	// a port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().(*net.TCPAddr)
+	require.NoError(t, listener.Close())
+
+	st.cluster.lock.Lock()
+	st.cluster.snapshot = []byte("{}")
+	st.cluster.value = ClusterInfo{
+		Brokers:    []ClusterBroker{{ID: 1, Host: "127.0.0.1", Port: addr.Port}},
+		Partitions: []ClusterPartition{{ID: 0, Leader: 1}, {ID: 1, Leader: 2}},
+	}
+	st.cluster.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Error(t, check.Check(ctx))
+	assert.Equal(t, 0.0, testutil.ToFloat64(directLeaderUp.In(st.metrics).WithLabelValues("1")))
+	assert.Equal(t, 1, testutil.CollectAndCount(directLeaderFailed.In(st.metrics)))
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+// KeyIDHeader is the header carrying the ID of the key encrypting a record value
+const KeyIDHeader = "kafka-canary-key-id"
+
+// ErrUnknownKey is returned when opening a record encrypted with another key than the configured one
+var ErrUnknownKey = errors.New("canary record encrypted with an unknown key")
+
+// recordCipher encrypts the canary record values with AES-GCM, for clusters where no plaintext may
+// be written, even synthetic
+type recordCipher struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+// newRecordCipher returns the cipher of the configured key, nil when encryption is disabled
+func newRecordCipher(config canary.EncryptionConfig) (*recordCipher, error) {
+	if config.Key == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the record cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the record cipher: %w", err)
+	}
+	return &recordCipher{keyID: config.KeyID, aead: aead}, nil
+}
+
+// seal encrypts the value, prefixed with its random nonce. The key ID is authenticated so a record
+// can't be passed off as encrypted with another key.
+func (c *recordCipher) seal(value []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(value)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, value, []byte(c.keyID)), nil
+}
+
+// open decrypts and verifies a value sealed with the given key ID
+func (c *recordCipher) open(keyID string, value []byte) ([]byte, error) {
+	if c == nil || keyID != c.keyID {
+		return nil, ErrUnknownKey
+	}
+	if len(value) < c.aead.NonceSize() {
+		return nil, errors.New("canary record too short to be encrypted")
+	}
+	nonce, ciphertext := value[:c.aead.NonceSize()], value[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, []byte(keyID))
+}
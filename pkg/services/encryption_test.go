@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestRecordCipher(t *testing.T) {
+	cipher, err := newRecordCipher(canary.EncryptionConfig{Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", KeyID: "2024-01"})
+	require.NoError(t, err)
+
+	value := CanaryMessage{ProducerID: "canary", MessageID: 1, Timestamp: 1600000000000}.Encode(CurrentRecordVersion)
+	sealed, err := cipher.seal(value)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "canary")
+
+	opened, err := cipher.open("2024-01", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, value, opened)
+
+	_, err = cipher.open("2023-12", sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = cipher.open("2024-01", sealed)
+	assert.Error(t, err)
+}
+
+func TestRecordCipherDisabled(t *testing.T) {
+	cipher, err := newRecordCipher(canary.EncryptionConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, cipher)
+
+	_, err = cipher.open("2024-01", []byte("sealed"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestRecordCipherInvalidKey(t *testing.T) {
+	_, err := newRecordCipher(canary.EncryptionConfig{Key: "c2hvcnQ="})
+	assert.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventSeverity is the severity of an event
+type EventSeverity string
+
+const (
+	// EventInfo is the severity of the expected events, e.g. a reconcile action or a recovery
+	EventInfo EventSeverity = "info"
+	// EventWarning is the severity of the events degrading the canary results
+	EventWarning EventSeverity = "warning"
+	// EventError is the severity of the failures
+	EventError EventSeverity = "error"
+)
+
+// defaultEventLogSize is the number of events kept until the event log is sized
+const defaultEventLogSize = 100
+
+// Event is a significant event of the canary, e.g. a service degraded or a reconcile action
+type Event struct {
+	Time     time.Time     `json:"time"`
+	Severity EventSeverity `json:"severity"`
+	Source   string        `json:"source"`
+	Message  string        `json:"message"`
+}
+
+// eventLog is a ring buffer of the last events of a canary
+type eventLog struct {
+	lock sync.RWMutex
+	// the oldest event at next once full
+	events []Event
+	size   int
+	next   int
+}
+
+func newEventLog(size int) eventLog {
+	return eventLog{events: make([]Event, 0, size), size: size}
+}
+
+// SetEventLogSize sets the number of events kept, the most recent ones are kept when shrinking
+// and 0 disables the event log
+func (st *State) SetEventLogSize(size int) {
+	l := &st.events
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	kept := l.ordered()
+	if len(kept) > size {
+		kept = kept[len(kept)-size:]
+	}
+	l.events = append(make([]Event, 0, size), kept...)
+	l.size = size
+	l.next = 0
+	if size > 0 {
+		l.next = len(l.events) % size
+	}
+}
+
+// ClearEvents empties the event log, keeping its size
+func (st *State) ClearEvents() {
+	l := &st.events
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events = l.events[:0]
+	l.next = 0
+}
+
+// recordEvent adds an event to the event log, replacing the oldest one when full
+func (st *State) recordEvent(severity EventSeverity, source, format string, args ...interface{}) {
+	l := &st.events
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.size == 0 {
+		return
+	}
+	event := Event{
+		Time:     time.Now(),
+		Severity: severity,
+		Source:   source,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if len(l.events) < l.size {
+		l.events = append(l.events, event)
+	} else {
+		l.events[l.next] = event
+	}
+	l.next = (l.next + 1) % l.size
+}
+
+// Events returns the events in the event log, oldest first
+func (st *State) Events() []Event {
+	st.events.lock.RLock()
+	defer st.events.lock.RUnlock()
+	return st.events.ordered()
+}
+
+func (l *eventLog) ordered() []Event {
+	ordered := make([]Event, 0, len(l.events))
+	if len(l.events) < l.size {
+		return append(ordered, l.events...)
+	}
+	ordered = append(ordered, l.events[l.next:]...)
+	return append(ordered, l.events[:l.next]...)
+}
+
+// EventsHandler serves the event log as JSON, oldest event first
+func (st *State) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		body, err := json.Marshal(st.Events())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		_, _ = rw.Write(body)
+	})
+}
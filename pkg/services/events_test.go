@@ -0,0 +1,66 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLog(t *testing.T) {
+	st := newTestState()
+	st.SetEventLogSize(3)
+
+	for _, message := range []string{"first", "second", "third", "fourth"} {
+		st.recordEvent(EventInfo, "test", message)
+	}
+	messages := func() []string {
+		var messages []string
+		for _, event := range st.Events() {
+			messages = append(messages, event.Message)
+		}
+		return messages
+	}
+	// the oldest event is replaced once full
+	assert.Equal(t, []string{"second", "third", "fourth"}, messages())
+
+	// shrinking keeps the most recent events
+	st.SetEventLogSize(2)
+	assert.Equal(t, []string{"third", "fourth"}, messages())
+	st.recordEvent(EventInfo, "test", "fifth")
+	assert.Equal(t, []string{"fourth", "fifth"}, messages())
+
+	st.SetEventLogSize(0)
+	st.recordEvent(EventInfo, "test", "dropped")
+	assert.Empty(t, st.Events())
+}
+
+func TestDegradedEvents(t *testing.T) {
+	st := newTestState()
+	st.SetEventLogSize(10)
+
+	// only the transitions are recorded
+	st.markDegraded("events_test", errors.New("broken"))
+	st.markDegraded("events_test", errors.New("still broken"))
+	st.markHealthy("events_test")
+	st.markHealthy("events_test")
+
+	events := st.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, EventError, events[0].Severity)
+	assert.Equal(t, "degraded: broken", events[0].Message)
+	assert.Equal(t, EventInfo, events[1].Severity)
+	assert.Equal(t, "recovered", events[1].Message)
+
+	rec := httptest.NewRecorder()
+	st.EventsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var served []Event
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Len(t, served, 2)
+	assert.Equal(t, "events_test", served[0].Source)
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+const (
+	// pause between the failover probe attempts while the partition recovers
+	failoverRetryBackoff = 100 * time.Millisecond
+	// phases of a probe sharing the check timeout: the election, the produce and the consume
+	// recoveries and the restore of the preferred leader
+	failoverPhases = 4
+)
+
+var (
+	failoverRecoveryTime = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "failover_recovery_time",
+		Namespace: metricsNamespace,
+		Help:      "Time from a forced leader election until the partition is produced to or consumed from again in milliseconds",
+		Buckets:   []float64{100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000},
+	}, []string{"phase"})
+
+	failoverProbes = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "failover_probes_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of forced leader failovers, by result",
+	}, []string{"result"})
+)
+
+// failoverService moves the leadership of a canary partition to another in-sync replica and
+// measures how long producing and consuming take to recover. It makes the preferred leader
+// election of broker restarts observable on demand, and must only run against non-production
+// clusters.
+type failoverService struct {
+	state        *State
+	connector    *client.Connector
+	admin        client.Client
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+
+	// index of the next partition failed over, the partitions take turns
+	next int
+}
+
+func NewFailoverService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	if !canaryConfig.Failover.NonProduction {
+		return nil, errors.New("the failover probe disrupts the canary partitions, confirm the cluster isn't a production one with canary.failover.non-production")
+	}
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failoverService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *failoverService) Name() string {
+	return "failover"
+}
+
+func (s *failoverService) Interval() time.Duration {
+	return s.canaryConfig.Failover.Interval
+}
+
+func (s *failoverService) Check(ctx context.Context) error {
+	admin, err := s.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	topic, err := admin.GetTopic(ctx, s.canaryConfig.Topic, false)
+	if err != nil {
+		return kafkaerr.Wrap(err)
+	}
+	partition, replicas, ok := s.pick(topic.Partitions)
+	if !ok {
+		return fmt.Errorf("no partition of topic %s has another in-sync replica to fail over to", s.canaryConfig.Topic)
+	}
+
+	err = s.failover(ctx, partition, replicas)
+	// the original preferred leader is restored even when the recovery failed, or the check
+	// was cancelled
+	restoreCtx, cancel := context.WithTimeout(context.Background(), s.phaseTimeout(ctx, 1))
+	defer cancel()
+	if restoreErr := s.elect(restoreCtx, partition.ID, partition.Replicas); restoreErr != nil {
+		s.logger.Error().Err(restoreErr).Int("partition", partition.ID).Msg("Error restoring the preferred leader after the failover probe")
+		s.state.recordEvent(EventError, s.Name(), "preferred leader of partition %d not restored: %v", partition.ID, restoreErr)
+	}
+
+	if err != nil {
+		failoverProbes.In(s.state.metrics).WithLabelValues("failed").Inc()
+		return err
+	}
+	failoverProbes.In(s.state.metrics).WithLabelValues("recovered").Inc()
+	return nil
+}
+
+func (s *failoverService) Close() {
+	if s.admin == nil {
+		return
+	}
+	if err := s.admin.Close(); err != nil {
+		s.logger.Error().Err(err).Msg("Error closing the failover probe admin client")
+	}
+	s.admin = nil
+}
+
+// pick returns the next partition with another in-sync replica and its replicas reordered so
+// that replica is the preferred leader
+func (s *failoverService) pick(partitions []client.PartitionInfo) (client.PartitionInfo, []int, bool) {
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].ID < partitions[j].ID })
+	for i := range partitions {
+		partition := partitions[(s.next+i)%len(partitions)]
+		if replicas, ok := failoverReplicas(partition); ok {
+			s.next = (s.next + i + 1) % len(partitions)
+			return partition, replicas, true
+		}
+	}
+	return client.PartitionInfo{}, nil, false
+}
+
+// failoverReplicas returns the partition replicas reordered so the first in-sync replica other
+// than the leader is the preferred one
+func failoverReplicas(partition client.PartitionInfo) ([]int, bool) {
+	inSync := make(map[int]bool, len(partition.ISR))
+	for _, id := range partition.ISR {
+		inSync[id] = true
+	}
+	for i, id := range partition.Replicas {
+		if id == partition.Leader || !inSync[id] {
+			continue
+		}
+		replicas := append([]int{id}, partition.Replicas[:i]...)
+		return append(replicas, partition.Replicas[i+1:]...), true
+	}
+	return nil, false
+}
+
+// failover moves the partition leadership to the first of the replicas and observes the time
+// until a record is produced to and consumed from the partition again
+func (s *failoverService) failover(ctx context.Context, partition client.PartitionInfo, replicas []int) error {
+	start := time.Now()
+	electCtx, cancel := context.WithTimeout(ctx, s.phaseTimeout(ctx, failoverPhases))
+	defer cancel()
+	if err := s.elect(electCtx, partition.ID, replicas); err != nil {
+		return err
+	}
+	s.logger.Info().
+		Int("partition", partition.ID).
+		Int("from", partition.Leader).
+		Int("to", replicas[0]).
+		Msg("Failed over the partition leader")
+	s.state.recordEvent(EventInfo, s.Name(), "leader of partition %d failed over from broker %d to %d", partition.ID, partition.Leader, replicas[0])
+
+	produceCtx, cancel := context.WithTimeout(ctx, s.phaseTimeout(ctx, failoverPhases-1))
+	defer cancel()
+	offset, err := s.produce(produceCtx, partition.ID)
+	if err != nil {
+		return fmt.Errorf("partition %d not produced to after failover: %w", partition.ID, err)
+	}
+	produced := time.Since(start)
+	failoverRecoveryTime.In(s.state.metrics).WithLabelValues("produce").Observe(float64(produced.Milliseconds()))
+
+	consumeCtx, cancel := context.WithTimeout(ctx, s.phaseTimeout(ctx, failoverPhases-2))
+	defer cancel()
+	if err := s.consume(consumeCtx, partition.ID, offset); err != nil {
+		return fmt.Errorf("partition %d not consumed from after failover: %w", partition.ID, err)
+	}
+	consumed := time.Since(start)
+	failoverRecoveryTime.In(s.state.metrics).WithLabelValues("consume").Observe(float64(consumed.Milliseconds()))
+
+	s.logger.Debug().
+		Int("partition", partition.ID).
+		Dur("produce_recovery", produced).
+		Dur("consume_recovery", consumed).
+		Msg("Partition recovered from the failover")
+	return nil
+}
+
+// phaseTimeout returns how long the next phase of the probe may take: the recovery timeout, but
+// no more than an even share of what is left of the check timeout between the phases left, so
+// slow phases don't leave the restore of the preferred leader without time
+func (s *failoverService) phaseTimeout(ctx context.Context, phasesLeft int) time.Duration {
+	timeout := s.canaryConfig.Failover.RecoveryTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if share := time.Until(deadline) / time.Duration(phasesLeft); share < timeout {
+			timeout = share
+		}
+	}
+	return timeout
+}
+
+// elect makes the first of the replicas the preferred leader of the partition and runs a
+// preferred leader election, waiting until it leads the partition
+func (s *failoverService) elect(ctx context.Context, partition int, replicas []int) error {
+	// the replicas don't change, only their order, so there is no data to move
+	err := s.admin.AssignPartitions(ctx, s.canaryConfig.Topic, []client.PartitionAssignment{{ID: partition, Replicas: replicas}})
+	if err != nil {
+		return kafkaerr.Wrap(err)
+	}
+	for {
+		current, err := s.partition(ctx, partition)
+		if err != nil {
+			return err
+		}
+		switch {
+		case current.Leader == replicas[0]:
+			return nil
+		case len(current.Replicas) > 0 && current.Replicas[0] == replicas[0]:
+			// the election is only possible once the reassignment is applied
+			if err := s.admin.RunLeaderElection(ctx, s.canaryConfig.Topic, []int{partition}); err != nil {
+				s.logger.Debug().Err(err).Int("partition", partition).Msg("Preferred leader election failed, retrying")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("broker %d not leading partition %d: %w", replicas[0], partition, ctx.Err())
+		case <-time.After(failoverRetryBackoff):
+		}
+	}
+}
+
+// partition returns the current state of the canary partition
+func (s *failoverService) partition(ctx context.Context, id int) (client.PartitionInfo, error) {
+	topic, err := s.admin.GetTopic(ctx, s.canaryConfig.Topic, false)
+	if err != nil {
+		return client.PartitionInfo{}, kafkaerr.Wrap(err)
+	}
+	for _, p := range topic.Partitions {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return client.PartitionInfo{}, fmt.Errorf("partition %d not found in topic %s", id, s.canaryConfig.Topic)
+}
+
+// produce retries producing a record to the partition until it succeeds, returning its offset
+func (s *failoverService) produce(ctx context.Context, partition int) (int64, error) {
+	for {
+		resp, err := s.connector.KafkaClient.Produce(ctx, &kafka.ProduceRequest{
+			Topic:        s.canaryConfig.Topic,
+			Partition:    partition,
+			RequiredAcks: kafka.RequireAll,
+			Records: kafka.NewRecordReader(kafka.Record{
+				Time:    time.Now(),
+				Value:   kafka.NewBytes([]byte(s.Name())),
+				Headers: []kafka.Header{{Key: CheckHeader, Value: []byte(s.Name())}},
+			}),
+		})
+		if err == nil {
+			err = resp.Error
+		}
+		if err == nil {
+			return resp.BaseOffset, nil
+		}
+		s.state.countKafkaError("Produce", err)
+		select {
+		case <-ctx.Done():
+			return 0, kafkaerr.Wrap(err)
+		case <-time.After(failoverRetryBackoff):
+		}
+	}
+}
+
+// consume retries fetching the partition until the record at the offset is read
+func (s *failoverService) consume(ctx context.Context, partition int, offset int64) error {
+	for {
+		fetched, err := s.connector.KafkaClient.Fetch(ctx, &kafka.FetchRequest{
+			Topic:     s.canaryConfig.Topic,
+			Partition: partition,
+			Offset:    offset,
+			MinBytes:  1,
+			MaxBytes:  1 << 20,
+			MaxWait:   failoverRetryBackoff,
+		})
+		if err == nil {
+			err = fetched.Error
+		}
+		if err == nil {
+			for {
+				record, err := fetched.Records.ReadRecord()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if record.Offset >= offset {
+					return nil
+				}
+			}
+		} else {
+			s.state.countKafkaError("Fetch", err)
+		}
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return kafkaerr.Wrap(err)
+		case <-time.After(failoverRetryBackoff):
+		}
+	}
+}
+
+// adminClient returns the admin client, creating it if needed
+func (s *failoverService) adminClient(ctx context.Context) (client.Client, error) {
+	if s.admin == nil {
+		a, err := client.NewBrokerAdminClient(ctx, client.BrokerAdminClientConfig{
+			ConnectorConfig: s.connector.Config,
+			Auditor:         s.state.auditOperation,
+		}, s.logger)
+		if err != nil {
+			return nil, kafkaerr.Wrap(err)
+		}
+		s.admin = a
+	}
+	return s.admin, nil
+}
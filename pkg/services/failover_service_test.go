@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+func TestFailoverReplicas(t *testing.T) {
+	// the out of sync replica 2 is skipped
+	replicas, ok := failoverReplicas(client.PartitionInfo{Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1, 3}})
+	require.True(t, ok)
+	assert.Equal(t, []int{3, 1, 2}, replicas)
+
+	_, ok = failoverReplicas(client.PartitionInfo{Leader: 1, Replicas: []int{1, 2}, ISR: []int{1}})
+	assert.False(t, ok)
+}
+
+func TestFailoverPhaseTimeout(t *testing.T) {
+	s := &failoverService{canaryConfig: &canary.Config{Failover: canary.FailoverConfig{RecoveryTimeout: 20 * time.Second}}}
+	assert.Equal(t, 20*time.Second, s.phaseTimeout(context.Background(), failoverPhases), "without check timeout")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// the phases left share the check timeout evenly
+	assert.InDelta(t, 7.5, s.phaseTimeout(ctx, failoverPhases).Seconds(), 0.1)
+	assert.InDelta(t, 15, s.phaseTimeout(ctx, 2).Seconds(), 0.1)
+	assert.Equal(t, 20*time.Second, s.phaseTimeout(ctx, 1), "bounded by the recovery timeout")
+}
+
+func TestFailoverPickRotates(t *testing.T) {
+	st := newTestState()
+	partitions := []client.PartitionInfo{
+		{ID: 2, Leader: 3, Replicas: []int{3, 1}, ISR: []int{3, 1}},
+		{ID: 0, Leader: 1, Replicas: []int{1, 2}, ISR: []int{1, 2}},
+		{ID: 1, Leader: 2, Replicas: []int{2, 3}, ISR: []int{2}},
+	}
+	s := &failoverService{state: st, canaryConfig: &canary.Config{}}
+
+	var picked []int
+	for i := 0; i < 3; i++ {
+		partition, _, ok := s.pick(partitions)
+		require.True(t, ok)
+		picked = append(picked, partition.ID)
+	}
+	// partition 1 has no other in-sync replica
+	assert.Equal(t, []int{0, 2, 0}, picked)
+}
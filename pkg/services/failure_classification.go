@@ -0,0 +1,121 @@
+package services
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// ProbableCause is the likely cause of the canary failures, derived from the classes of the errors
+// of the failing services and checks
+type ProbableCause string
+
+const (
+	CauseAuth       ProbableCause = "auth"
+	CauseNetwork    ProbableCause = "network"
+	CauseQuorum     ProbableCause = "quorum"
+	CauseLeader     ProbableCause = "leader"
+	CauseQuota      ProbableCause = "quota"
+	CauseClientSide ProbableCause = "client-side"
+	CauseUnknown    ProbableCause = "unknown"
+)
+
+// causes in order of precedence when as many services and checks point to each, the more specific
+// first: an expired credential also shows up as timeouts, not the other way around
+var causes = []ProbableCause{CauseAuth, CauseQuorum, CauseLeader, CauseQuota, CauseNetwork, CauseClientSide}
+
+// checkCauses are the causes of the checks whose failure points to one whatever its error class
+var checkCauses = map[string]ProbableCause{
+	"internal_topics":         CauseQuorum,
+	"direct_leader":           CauseLeader,
+	"group_coordinator":       CauseLeader,
+	"transaction_coordinator": CauseLeader,
+	"clock":                   CauseClientSide,
+}
+
+// FailureClassification summarizes why the canary is failing, so a page comes with a probable
+// cause rather than just a red canary
+type FailureClassification struct {
+	Cause ProbableCause
+	// failing services and checks by the class of their last error
+	Failing map[string]kafkaerr.Class
+}
+
+// failureClasses are the services and checks of a canary whose last run failed, and the class of
+// that failure
+type failureClasses struct {
+	lock    sync.RWMutex
+	classes map[string]kafkaerr.Class
+}
+
+// observeFailureClass records the class of the last failure of the service, clearing it when
+// the service succeeds
+func (st *State) observeFailureClass(service string, err error) {
+	f := &st.failures
+	f.lock.RLock()
+	_, failing := f.classes[service]
+	f.lock.RUnlock()
+	if err == nil && !failing {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err == nil {
+		delete(f.classes, service)
+		return
+	}
+	f.classes[service] = kafkaerr.ClassOf(err)
+}
+
+// ClassifyFailures returns the probable cause of the current failures, nil when nothing fails
+func (st *State) ClassifyFailures() *FailureClassification {
+	st.failures.lock.RLock()
+	defer st.failures.lock.RUnlock()
+	if len(st.failures.classes) == 0 {
+		return nil
+	}
+	failing := make(map[string]kafkaerr.Class, len(st.failures.classes))
+	for service, class := range st.failures.classes {
+		failing[service] = class
+	}
+	return &FailureClassification{Cause: probableCause(failing), Failing: failing}
+}
+
+// probableCause returns the cause most of the failing services and checks point to
+func probableCause(failing map[string]kafkaerr.Class) ProbableCause {
+	votes := map[ProbableCause]int{}
+	for service, class := range failing {
+		if cause, ok := causeOf(class); ok {
+			votes[cause]++
+		} else if cause, ok := checkCauses[service]; ok {
+			votes[cause]++
+		}
+	}
+	if len(votes) == 0 {
+		return CauseUnknown
+	}
+	ranked := append([]ProbableCause(nil), causes...)
+	sort.SliceStable(ranked, func(i, j int) bool { return votes[ranked[i]] > votes[ranked[j]] })
+	return ranked[0]
+}
+
+// causeOf returns the cause an error class points to, false for the classes telling nothing
+func causeOf(class kafkaerr.Class) (ProbableCause, bool) {
+	switch class {
+	case kafkaerr.ClassAuth, kafkaerr.ClassAuthz:
+		return CauseAuth, true
+	case kafkaerr.ClassNetwork, kafkaerr.ClassTimeout:
+		return CauseNetwork, true
+	case kafkaerr.ClassReplication:
+		return CauseQuorum, true
+	case kafkaerr.ClassNotLeader, kafkaerr.ClassCoordinator:
+		return CauseLeader, true
+	case kafkaerr.ClassQuota:
+		return CauseQuota, true
+	case kafkaerr.ClassRecord, kafkaerr.ClassTopic, kafkaerr.ClassCanceled:
+		return CauseClientSide, true
+	}
+	return "", false
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+func TestProbableCause(t *testing.T) {
+	tests := []struct {
+		name     string
+		failing  map[string]kafkaerr.Class
+		expected ProbableCause
+	}{
+		{"expired credential", map[string]kafkaerr.Class{"producer": kafkaerr.ClassAuth}, CauseAuth},
+		{"missing ACL", map[string]kafkaerr.Class{"consumer_groups": kafkaerr.ClassAuthz}, CauseAuth},
+		{"missing replicas", map[string]kafkaerr.Class{"producer": kafkaerr.ClassReplication, "consumer": kafkaerr.ClassTimeout}, CauseQuorum},
+		{"mostly timing out", map[string]kafkaerr.Class{"producer": kafkaerr.ClassTimeout, "consumer": kafkaerr.ClassNetwork, "topic": kafkaerr.ClassNotLeader}, CauseNetwork},
+		{"leader moved", map[string]kafkaerr.Class{"producer": kafkaerr.ClassNotLeader}, CauseLeader},
+		{"throttled", map[string]kafkaerr.Class{"bandwidth": kafkaerr.ClassQuota}, CauseQuota},
+		{"oversized record", map[string]kafkaerr.Class{"message_size": kafkaerr.ClassRecord}, CauseClientSide},
+		{"failing check", map[string]kafkaerr.Class{"internal_topics": kafkaerr.ClassUnknown}, CauseQuorum},
+		{"unclassified", map[string]kafkaerr.Class{"plugin_smoke": kafkaerr.ClassUnknown}, CauseUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, probableCause(tt.failing))
+		})
+	}
+}
+
+func TestClassifyFailures(t *testing.T) {
+	st := newTestState()
+	st.markDegraded("classified_producer", kafka.NotEnoughReplicas)
+	st.observeFailureClass("classified_check", context.DeadlineExceeded)
+	classification := st.ClassifyFailures()
+	require.NotNil(t, classification)
+	assert.Equal(t, kafkaerr.ClassReplication, classification.Failing["classified_producer"])
+	assert.Equal(t, kafkaerr.ClassTimeout, classification.Failing["classified_check"])
+
+	st.markHealthy("classified_producer")
+	st.observeFailureClass("classified_check", errors.New("check failed"))
+	classification = st.ClassifyFailures()
+	require.NotNil(t, classification)
+	assert.NotContains(t, classification.Failing, "classified_producer")
+	assert.Equal(t, kafkaerr.ClassUnknown, classification.Failing["classified_check"])
+}
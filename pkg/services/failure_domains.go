@@ -0,0 +1,244 @@
+package services
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// rack of the brokers without one in their metadata
+const unknownRack = "unknown"
+
+var (
+	rackRecordsProduced = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "rack_records_produced_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records produced, by rack of the partition leader at the time",
+	}, []string{"rack"})
+
+	rackRecordsProducedFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "rack_records_produced_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records failed to be produced, by rack of the partition leader at the time",
+	}, []string{"rack"})
+
+	rackBrokers = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "rack_brokers",
+		Namespace: metricsNamespace,
+		Help:      "Number of brokers in the rack, as seen by the last topic reconcile",
+	}, []string{"rack"})
+
+	rackAggregationsLock sync.RWMutex
+	// per-broker metrics additionally exported summed by rack
+	rackAggregations []rackAggregation
+)
+
+// failureDomains are the racks of the brokers and of the canary topic partition leaders seen by
+// the last reconcile of a canary
+type failureDomains struct {
+	lock       sync.RWMutex
+	brokers    map[int]string
+	partitions map[int]string
+}
+
+func init() {
+	AggregateByRack(brokerTimestampSkew, "broker", "rack_broker_timestamp_skew",
+		"Difference between the broker LogAppendTime and the producer timestamp in milliseconds, by rack")
+	AggregateByRack(metadataDivergence, "broker", "rack_metadata_divergent_partitions",
+		"Number of canary topic partitions whose leader, or existence, differs from the majority in the metadata of the rack brokers, summed")
+	AggregateByRack(metadataPartitions, "broker", "rack_metadata_partitions",
+		"Number of canary topic partitions in the metadata of the rack brokers, summed")
+}
+
+// rackAggregation is a metric labeled by broker ID exported summed by rack
+type rackAggregation struct {
+	vec   metrics.Definition
+	label string
+	desc  *prometheus.Desc
+}
+
+// AggregateByRack additionally exports the metric whose label is a broker ID under the given name,
+// summed over the brokers of each rack. The broker racks being stable, the counters stay monotonic.
+func AggregateByRack(vec metrics.Definition, label, name, help string) {
+	rackAggregationsLock.Lock()
+	defer rackAggregationsLock.Unlock()
+	rackAggregations = append(rackAggregations, rackAggregation{
+		vec:   vec,
+		label: label,
+		desc:  prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, []string{"rack"}, nil),
+	})
+}
+
+// updateFailureDomains records the racks of the brokers and the partition leaders
+func (st *State) updateFailureDomains(info ClusterInfo) {
+	racks := make(map[int]string, len(info.Brokers))
+	brokers := map[string]int{}
+	for _, b := range info.Brokers {
+		rack := b.Rack
+		if rack == "" {
+			rack = unknownRack
+		}
+		racks[b.ID] = rack
+		brokers[rack]++
+	}
+	partitions := make(map[int]string, len(info.Partitions))
+	for _, p := range info.Partitions {
+		if rack, ok := racks[p.Leader]; ok {
+			partitions[p.ID] = rack
+		}
+	}
+
+	d := &st.domains
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	gauge := rackBrokers.In(st.metrics)
+	for rack := range rackSet(d.brokers) {
+		if _, ok := brokers[rack]; !ok {
+			gauge.DeleteLabelValues(rack)
+		}
+	}
+	for rack, count := range brokers {
+		gauge.WithLabelValues(rack).Set(float64(count))
+	}
+	d.brokers, d.partitions = racks, partitions
+}
+
+func rackSet(racks map[int]string) map[string]bool {
+	set := map[string]bool{}
+	for _, rack := range racks {
+		set[rack] = true
+	}
+	return set
+}
+
+// partitionRack returns the rack of the partition leader, unknown before the first reconcile
+func (st *State) partitionRack(partition int) string {
+	st.domains.lock.RLock()
+	defer st.domains.lock.RUnlock()
+	if rack, ok := st.domains.partitions[partition]; ok {
+		return rack
+	}
+	return unknownRack
+}
+
+// observeRackProduce accounts a produce result to the rack of the partition leader
+func (st *State) observeRackProduce(partition int, latency time.Duration, err error) {
+	rack := st.partitionRack(partition)
+	rackRecordsProduced.In(st.metrics).WithLabelValues(rack).Inc()
+	if err != nil {
+		rackRecordsProducedFailed.In(st.metrics).WithLabelValues(rack).Inc()
+		return
+	}
+	st.rackRecordsProducedLatency.WithLabelValues(rack).Observe(float64(latency.Milliseconds()))
+}
+
+// rackCollector exports the per-broker metrics of a canary summed by rack on scrape
+type rackCollector struct {
+	state *State
+}
+
+// Describe describes nothing, the aggregations being added after the registration the collector is unchecked
+func (rackCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c rackCollector) Collect(ch chan<- prometheus.Metric) {
+	rackAggregationsLock.RLock()
+	aggregations := rackAggregations
+	rackAggregationsLock.RUnlock()
+	d := &c.state.domains
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, aggregation := range aggregations {
+		for _, metric := range aggregation.collect(aggregation.vec.CollectorIn(c.state.metrics), d.brokers) {
+			ch <- metric
+		}
+	}
+}
+
+// rackSum is the sum of the series of a rack
+type rackSum struct {
+	kind    dto.MetricType
+	value   float64
+	count   uint64
+	buckets map[float64]uint64
+}
+
+// collect returns the series of the metric summed by the rack of their broker, the series of
+// brokers without a known rack being skipped
+func (a rackAggregation) collect(vec prometheus.Collector, racks map[int]string) []prometheus.Metric {
+	collected := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(collected)
+		close(collected)
+	}()
+
+	sums := map[string]*rackSum{}
+	for metric := range collected {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+		rack, ok := "", false
+		for _, label := range m.GetLabel() {
+			if label.GetName() == a.label {
+				if id, err := strconv.Atoi(label.GetValue()); err == nil {
+					rack, ok = racks[id]
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		sum := sums[rack]
+		if sum == nil {
+			sum = &rackSum{buckets: map[float64]uint64{}}
+			sums[rack] = sum
+		}
+		switch {
+		case m.Counter != nil:
+			sum.kind = dto.MetricType_COUNTER
+			sum.value += m.Counter.GetValue()
+		case m.Gauge != nil:
+			sum.kind = dto.MetricType_GAUGE
+			sum.value += m.Gauge.GetValue()
+		case m.Histogram != nil:
+			sum.kind = dto.MetricType_HISTOGRAM
+			sum.value += m.Histogram.GetSampleSum()
+			sum.count += m.Histogram.GetSampleCount()
+			for _, b := range m.Histogram.GetBucket() {
+				sum.buckets[b.GetUpperBound()] += b.GetCumulativeCount()
+			}
+		}
+	}
+
+	names := make([]string, 0, len(sums))
+	for rack := range sums {
+		names = append(names, rack)
+	}
+	sort.Strings(names)
+	result := make([]prometheus.Metric, 0, len(sums))
+	for _, rack := range names {
+		sum := sums[rack]
+		var metric prometheus.Metric
+		var err error
+		switch sum.kind {
+		case dto.MetricType_COUNTER:
+			metric, err = prometheus.NewConstMetric(a.desc, prometheus.CounterValue, sum.value, rack)
+		case dto.MetricType_GAUGE:
+			metric, err = prometheus.NewConstMetric(a.desc, prometheus.GaugeValue, sum.value, rack)
+		case dto.MetricType_HISTOGRAM:
+			metric, err = prometheus.NewConstHistogram(a.desc, sum.count, sum.value, sum.buckets, rack)
+		default:
+			continue
+		}
+		if err == nil {
+			result = append(result, metric)
+		}
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateByRack(t *testing.T) {
+	st := newTestState()
+	st.updateFailureDomains(ClusterInfo{
+		Brokers: []ClusterBroker{{ID: 1, Rack: "a"}, {ID: 2, Rack: "a"}, {ID: 3, Rack: "b"}, {ID: 4}},
+	})
+	assert.Equal(t, 2.0, testutil.ToFloat64(rackBrokers.In(st.metrics).WithLabelValues("a")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rackBrokers.In(st.metrics).WithLabelValues(unknownRack)))
+
+	partitions := metadataPartitions.In(st.metrics)
+	partitions.WithLabelValues("1").Set(3)
+	partitions.WithLabelValues("2").Set(2)
+	partitions.WithLabelValues("3").Set(1)
+	// not in the cluster anymore
+	partitions.WithLabelValues("5").Set(7)
+	expected := `
+# HELP kafka_canary_rack_metadata_partitions Number of canary topic partitions in the metadata of the rack brokers, summed
+# TYPE kafka_canary_rack_metadata_partitions gauge
+kafka_canary_rack_metadata_partitions{rack="a"} 5
+kafka_canary_rack_metadata_partitions{rack="b"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(rackCollector{st}, strings.NewReader(expected), "kafka_canary_rack_metadata_partitions"))
+
+	skews := brokerTimestampSkew.In(st.metrics)
+	skews.WithLabelValues("1").Observe(10)
+	skews.WithLabelValues("2").Observe(20)
+	skews.WithLabelValues("3").Observe(5)
+	skew := rackAggregation{vec: brokerTimestampSkew, label: "broker", desc: rackAggregations[0].desc}
+	metrics := skew.collect(skews, st.domains.brokers)
+	require.Len(t, metrics, 2)
+	var m dto.Metric
+	require.NoError(t, metrics[0].Write(&m))
+	assert.Equal(t, "a", m.GetLabel()[0].GetValue())
+	assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+	assert.Equal(t, 30.0, m.GetHistogram().GetSampleSum())
+}
+
+func TestObserveRackProduce(t *testing.T) {
+	st := newTestState()
+	st.updateFailureDomains(ClusterInfo{
+		Brokers:    []ClusterBroker{{ID: 1, Rack: "a"}, {ID: 2, Rack: "b"}},
+		Partitions: []ClusterPartition{{ID: 0, Leader: 1}, {ID: 1, Leader: 2}},
+	})
+
+	st.observeRackProduce(0, time.Millisecond, nil)
+	st.observeRackProduce(1, 0, assert.AnError)
+	// before the first reconcile seeing the partition
+	st.observeRackProduce(2, time.Millisecond, nil)
+	assert.Equal(t, 1.0, testutil.ToFloat64(rackRecordsProduced.In(st.metrics).WithLabelValues("a")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rackRecordsProducedFailed.In(st.metrics).WithLabelValues("b")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rackRecordsProduced.In(st.metrics).WithLabelValues(unknownRack)))
+}
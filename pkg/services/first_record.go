@@ -0,0 +1,34 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var timeToFirstRecord = metrics.NewGauge(prometheus.GaugeOpts{
+	Name:      "time_to_first_record_seconds",
+	Namespace: metricsNamespace,
+	Help:      "Seconds from the canary creation until its first canary record was verified, 0 until then",
+})
+
+// firstRecord is when a canary was created and whether it verified a record since
+type firstRecord struct {
+	start time.Time
+	once  sync.Once
+}
+
+// markFirstRecord exports the time from the canary creation to its first verified record, how
+// quickly a fresh client gets through the metadata, authentication, group join and fetch. Only
+// the first call of the canary counts.
+func (st *State) markFirstRecord(logger *zerolog.Logger) {
+	st.firstRecord.once.Do(func() {
+		elapsed := time.Since(st.firstRecord.start)
+		timeToFirstRecord.In(st.metrics).Set(elapsed.Seconds())
+		logger.Info().Dur("time_to_first_record", elapsed).Msg("First canary record verified")
+	})
+}
@@ -0,0 +1,23 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkFirstRecord(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	st.markFirstRecord(&logger)
+	first := testutil.ToFloat64(timeToFirstRecord.In(st.metrics))
+	assert.Positive(t, first)
+
+	// the later records don't move it
+	time.Sleep(10 * time.Millisecond)
+	st.markFirstRecord(&logger)
+	assert.Equal(t, first, testutil.ToFloat64(timeToFirstRecord.In(st.metrics)))
+}
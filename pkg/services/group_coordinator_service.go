@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	groupCoordinatorLatency = metrics.NewHistogram(prometheus.HistogramOpts{
+		Name:      "group_coordinator_latency",
+		Namespace: metricsNamespace,
+		Help:      "FindCoordinator latency for the canary consumer group in milliseconds",
+		Buckets:   []float64{5, 10, 50, 100, 500, 1000, 5000},
+	})
+
+	groupCoordinatorFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "group_coordinator_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of failed FindCoordinator requests for the canary consumer group",
+	}, []string{"error_class"})
+
+	groupCoordinator = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "group_coordinator",
+		Namespace: metricsNamespace,
+		Help:      "ID of the broker coordinating the canary consumer group",
+	})
+)
+
+// groupCoordinatorService looks up the coordinator of the canary consumer group, discovery
+// problems often precede wider consumer outages
+type groupCoordinatorService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewGroupCoordinatorService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &groupCoordinatorService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *groupCoordinatorService) Name() string {
+	return "group_coordinator"
+}
+
+func (s *groupCoordinatorService) Check(ctx context.Context) error {
+	start := time.Now()
+	resp, err := s.connector.KafkaClient.FindCoordinator(ctx, &kafka.FindCoordinatorRequest{
+		Key:     s.canaryConfig.ConsumerGroupID,
+		KeyType: kafka.CoordinatorKeyTypeConsumer,
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		s.state.countKafkaError("FindCoordinator", err)
+		groupCoordinatorFailed.In(s.state.metrics).WithLabelValues(string(kafkaerr.ClassOf(err))).Inc()
+		return kafkaerr.Wrap(err)
+	}
+	groupCoordinatorLatency.In(s.state.metrics).Observe(float64(time.Since(start).Milliseconds()))
+	groupCoordinator.In(s.state.metrics).Set(float64(resp.Coordinator.NodeID))
+
+	strategy, err := s.state.updateAssignmentStrategy(ctx, s.connector.KafkaClient, s.canaryConfig.ConsumerGroupID)
+	if err != nil {
+		s.state.countKafkaError("DescribeGroups", err)
+		return err
+	}
+
+	s.logger.Debug().
+		Str("group", s.canaryConfig.ConsumerGroupID).
+		Int("coordinator", resp.Coordinator.NodeID).
+		Str("assignment_strategy", strategy).
+		Msg("Found group coordinator")
+	return nil
+}
+
+func (s *groupCoordinatorService) Close() {}
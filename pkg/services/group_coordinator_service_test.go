@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/describegroups"
+	"github.com/segmentio/kafka-go/protocol/findcoordinator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// fakeFindCoordinatorTransport answers the FindCoordinator requests with the coordinator or the
+// error code, blocking until the request is done when hang is set, and the DescribeGroups ones
+// with the group assignment strategy
+type fakeFindCoordinatorTransport struct {
+	coordinator int32
+	code        kafka.Error
+	hang        bool
+	keys        []string
+}
+
+func (t *fakeFindCoordinatorTransport) RoundTrip(ctx context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *findcoordinator.Request:
+		t.keys = append(t.keys, req.Key)
+		if t.hang {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &findcoordinator.Response{ErrorCode: int16(t.code), NodeID: t.coordinator, Host: "broker", Port: 9092}, nil
+	case *describegroups.Request:
+		return &describegroups.Response{Groups: []describegroups.ResponseGroup{
+			{GroupID: req.Groups[0], GroupState: "Stable", ProtocolType: "consumer", ProtocolData: "range"},
+		}}, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func TestGroupCoordinatorCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport *fakeFindCoordinatorTransport
+		timeout   time.Duration
+		wantClass kafkaerr.Class
+	}{
+		{name: "coordinator found", transport: &fakeFindCoordinatorTransport{coordinator: 2}},
+		{
+			name:      "coordinator not available",
+			transport: &fakeFindCoordinatorTransport{code: kafka.GroupCoordinatorNotAvailable},
+			wantClass: kafkaerr.ClassCoordinator,
+		},
+		{
+			name:      "timeout",
+			transport: &fakeFindCoordinatorTransport{hang: true},
+			timeout:   10 * time.Millisecond,
+			wantClass: kafkaerr.ClassTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestState()
+			logger := zerolog.Nop()
+			s := &groupCoordinatorService{
+				state:        st,
+				connector:    &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: tt.transport}},
+				canaryConfig: &canary.Config{ConsumerGroupID: "kafka-canary-group"},
+				logger:       &logger,
+			}
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			err := s.Check(ctx)
+			assert.Equal(t, []string{"kafka-canary-group"}, tt.transport.keys)
+			if tt.wantClass == "" {
+				require.NoError(t, err)
+				assert.Equal(t, 1, testutil.CollectAndCount(groupCoordinatorLatency.In(st.metrics)))
+				assert.Equal(t, 2.0, testutil.ToFloat64(groupCoordinator.In(st.metrics)))
+				assert.Equal(t, 1.0, testutil.ToFloat64(consumerAssignmentStrategy.In(st.metrics).WithLabelValues("range")))
+				assert.Equal(t, 0, testutil.CollectAndCount(groupCoordinatorFailed.In(st.metrics)))
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.wantClass, kafkaerr.ClassOf(err))
+			assert.Equal(t, 1.0, testutil.ToFloat64(groupCoordinatorFailed.In(st.metrics).WithLabelValues(string(tt.wantClass))))
+			// the coordinator isn't exported without a successful lookup
+			assert.Equal(t, 0.0, testutil.ToFloat64(groupCoordinator.In(st.metrics)))
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+// HealthState is the state of a check in its health state machine
+type HealthState string
+
+const (
+	// HealthOK is the state of a check succeeding
+	HealthOK HealthState = "OK"
+	// HealthDegraded is the state of a check failing fewer times in a row than the failure threshold
+	HealthDegraded HealthState = "DEGRADED"
+	// HealthFailed is the state of a check failing at least the failure threshold times in a row
+	HealthFailed HealthState = "FAILED"
+	// HealthRecovering is the state of a failed check succeeding fewer times in a row than the
+	// recovery threshold
+	HealthRecovering HealthState = "RECOVERING"
+	// HealthDisabled is the state of a check disabled by configuration, which never runs
+	HealthDisabled HealthState = "DISABLED"
+)
+
+var healthStates = []HealthState{HealthOK, HealthDegraded, HealthFailed, HealthRecovering, HealthDisabled}
+
+// severity returns the severity of the events moving a check to the state
+func (s HealthState) severity() EventSeverity {
+	switch s {
+	case HealthFailed:
+		return EventError
+	case HealthDegraded:
+		return EventWarning
+	default:
+		return EventInfo
+	}
+}
+
+var (
+	checkState = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "check_state",
+		Namespace: metricsNamespace,
+		Help:      "Health state of the additional checks, 1 for the current state",
+	}, []string{"check", "state"})
+)
+
+// checkHealths are the health of the checks of a canary run so far
+type checkHealths struct {
+	lock    sync.RWMutex
+	healths map[string]CheckHealth
+}
+
+// CheckHealth is the health of a check, only changing state after enough consecutive failures or
+// successes so a single transient error doesn't flap
+type CheckHealth struct {
+	State                HealthState
+	Since                time.Time
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	LastError            string `json:",omitempty"`
+}
+
+// next returns the health after a check run with the given error
+func (h CheckHealth) next(err error, config canary.HealthConfig, now time.Time) CheckHealth {
+	next := h
+	if err != nil {
+		next.ConsecutiveFailures++
+		next.ConsecutiveSuccesses = 0
+		next.LastError = err.Error()
+	} else {
+		next.ConsecutiveSuccesses++
+		next.ConsecutiveFailures = 0
+	}
+
+	switch {
+	case err != nil && h.State == HealthRecovering:
+		next.State = HealthFailed
+	case err != nil && next.ConsecutiveFailures >= config.FailureThreshold:
+		next.State = HealthFailed
+	case err != nil && h.State == HealthOK:
+		next.State = HealthDegraded
+	case err == nil && h.State == HealthDegraded:
+		next.State = HealthOK
+	case err == nil && (h.State == HealthFailed || h.State == HealthRecovering):
+		next.State = HealthRecovering
+		if next.ConsecutiveSuccesses >= config.RecoveryThreshold {
+			next.State = HealthOK
+		}
+	}
+	if next.State == HealthOK {
+		next.LastError = ""
+	}
+	if next.State != h.State {
+		next.Since = now
+	}
+	return next
+}
+
+// ObserveCheckHealth moves the check along its health state machine with the result of a run,
+// returning its new health and whether its state changed. The failures during the warm-up are
+// recorded without changing the state.
+func (st *State) ObserveCheckHealth(check string, err error, config canary.HealthConfig) (CheckHealth, bool) {
+	st.observeAuthFailure(check, err)
+	st.observeFailureClass(check, err)
+	st.checks.lock.Lock()
+	defer st.checks.lock.Unlock()
+	now := time.Now()
+	health, ok := st.checks.healths[check]
+	if !ok || health.State == HealthDisabled {
+		health = CheckHealth{State: HealthOK, Since: now}
+	}
+	previous := health
+	health = health.next(err, config, now)
+	if err != nil && st.WarmingUp() {
+		health.State, health.Since = previous.State, previous.Since
+	}
+	st.checks.healths[check] = health
+	st.exportCheckState(check, health.State)
+	if health.State != previous.State {
+		st.recordEvent(health.State.severity(), check, "health changed from %s to %s", previous.State, health.State)
+	}
+	return health, health.State != previous.State
+}
+
+// DisableChecks reports the given checks as disabled, the checks disabled previously and enabled
+// again start over from a healthy state on their next run
+func (st *State) DisableChecks(checks []string) {
+	st.checks.lock.Lock()
+	defer st.checks.lock.Unlock()
+	disabled := make(map[string]bool, len(checks))
+	for _, check := range checks {
+		disabled[check] = true
+		if health, ok := st.checks.healths[check]; ok && health.State == HealthDisabled {
+			continue
+		}
+		st.checks.healths[check] = CheckHealth{State: HealthDisabled, Since: time.Now()}
+		st.exportCheckState(check, HealthDisabled)
+	}
+	for check, health := range st.checks.healths {
+		if health.State == HealthDisabled && !disabled[check] {
+			delete(st.checks.healths, check)
+			checkState.In(st.metrics).DeletePartialMatch(prometheus.Labels{"check": check})
+		}
+	}
+}
+
+func (st *State) exportCheckState(check string, current HealthState) {
+	for _, state := range healthStates {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		checkState.In(st.metrics).WithLabelValues(check, string(state)).Set(value)
+	}
+}
+
+// CheckHealths returns the health of the checks run so far
+func (st *State) CheckHealths() map[string]CheckHealth {
+	st.checks.lock.RLock()
+	defer st.checks.lock.RUnlock()
+	healths := make(map[string]CheckHealth, len(st.checks.healths))
+	for check, health := range st.checks.healths {
+		healths[check] = health
+	}
+	return healths
+}
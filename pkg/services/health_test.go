@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestCheckHealthTransitions(t *testing.T) {
+	config := canary.HealthConfig{FailureThreshold: 3, RecoveryThreshold: 2}
+	failure := errors.New("timeout")
+
+	tests := []struct {
+		name     string
+		results  []error
+		expected HealthState
+	}{
+		{"succeeding", []error{nil, nil}, HealthOK},
+		{"transient failure", []error{failure}, HealthDegraded},
+		{"transient failure recovered", []error{failure, nil}, HealthOK},
+		{"failures under the threshold", []error{failure, failure}, HealthDegraded},
+		{"failures reaching the threshold", []error{failure, failure, failure}, HealthFailed},
+		{"recovering", []error{failure, failure, failure, nil}, HealthRecovering},
+		{"recovered", []error{failure, failure, failure, nil, nil}, HealthOK},
+		{"failing while recovering", []error{failure, failure, failure, nil, failure}, HealthFailed},
+		{"streak reset by a success", []error{failure, failure, nil, failure, failure}, HealthDegraded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := CheckHealth{State: HealthOK}
+			for _, err := range tt.results {
+				health = health.next(err, config, time.Now())
+			}
+			assert.Equal(t, tt.expected, health.State)
+		})
+	}
+}
+
+func TestCheckHealthSince(t *testing.T) {
+	config := canary.HealthConfig{FailureThreshold: 1, RecoveryThreshold: 1}
+	start := time.Unix(1600000000, 0)
+
+	health := CheckHealth{State: HealthOK, Since: start}
+	health = health.next(nil, config, start.Add(time.Minute))
+	assert.Equal(t, start, health.Since)
+
+	health = health.next(errors.New("timeout"), config, start.Add(2*time.Minute))
+	assert.Equal(t, HealthFailed, health.State)
+	assert.Equal(t, start.Add(2*time.Minute), health.Since)
+	assert.Equal(t, "timeout", health.LastError)
+
+	health = health.next(nil, config, start.Add(3*time.Minute))
+	assert.Equal(t, HealthOK, health.State)
+	assert.Empty(t, health.LastError)
+}
+
+func TestObserveCheckHealthWarmingUp(t *testing.T) {
+	st := newTestState()
+	config := canary.HealthConfig{FailureThreshold: 1, RecoveryThreshold: 1}
+	st.StartWarmUp(time.Minute)
+
+	health, changed := st.ObserveCheckHealth("warming_up", errors.New("timeout"), config)
+	assert.False(t, changed)
+	assert.Equal(t, HealthOK, health.State)
+	assert.Equal(t, 1, health.ConsecutiveFailures)
+	assert.Equal(t, "timeout", health.LastError)
+
+	st.StartWarmUp(0)
+	health, changed = st.ObserveCheckHealth("warming_up", errors.New("timeout"), config)
+	assert.True(t, changed)
+	assert.Equal(t, HealthFailed, health.State)
+}
+
+func TestDisableChecks(t *testing.T) {
+	st := newTestState()
+	config := canary.HealthConfig{FailureThreshold: 3, RecoveryThreshold: 2}
+
+	st.DisableChecks([]string{"disabled_test"})
+	assert.Equal(t, HealthDisabled, st.CheckHealths()["disabled_test"].State)
+
+	// enabled again, the check starts over on its next run
+	st.DisableChecks(nil)
+	_, ok := st.CheckHealths()["disabled_test"]
+	assert.False(t, ok)
+	health, _ := st.ObserveCheckHealth("disabled_test", nil, config)
+	assert.Equal(t, HealthOK, health.State)
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	internalTopicPartitions = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "internal_topic_partitions",
+		Namespace: metricsNamespace,
+		Help:      "Number of partitions of the internal topics",
+	}, []string{"topic"})
+
+	internalTopicUnderReplicated = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "internal_topic_under_replicated_partitions",
+		Namespace: metricsNamespace,
+		Help:      "Number of under-replicated partitions of the internal topics",
+	}, []string{"topic"})
+
+	internalTopicOffline = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "internal_topic_offline_partitions",
+		Namespace: metricsNamespace,
+		Help:      "Number of partitions without a leader of the internal topics",
+	}, []string{"topic"})
+)
+
+// internalTopicsService describes the internal topics (e.g. __consumer_offsets), whose
+// degradation affects all clients but isn't visible to the produce and consume checks
+type internalTopicsService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewInternalTopicsService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &internalTopicsService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *internalTopicsService) Name() string {
+	return "internal_topics"
+}
+
+func (s *internalTopicsService) Interval() time.Duration {
+	return s.canaryConfig.InternalTopics.Interval
+}
+
+func (s *internalTopicsService) Check(ctx context.Context) error {
+	metadata, err := s.connector.KafkaClient.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: s.canaryConfig.InternalTopics.Topics,
+	})
+	if err != nil {
+		s.state.countKafkaError("Metadata", err)
+		return kafkaerr.Wrap(err)
+	}
+
+	var unhealthy []string
+	for _, topic := range metadata.Topics {
+		if errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+			// e.g. __transaction_state until a transactional producer is used
+			s.logger.Debug().Str("topic", topic.Name).Msg("Internal topic does not exist")
+			internalTopicPartitions.In(s.state.metrics).DeleteLabelValues(topic.Name)
+			internalTopicUnderReplicated.In(s.state.metrics).DeleteLabelValues(topic.Name)
+			internalTopicOffline.In(s.state.metrics).DeleteLabelValues(topic.Name)
+			continue
+		}
+		if topic.Error != nil {
+			s.state.countKafkaError("Metadata", topic.Error)
+			return kafkaerr.Wrap(topic.Error)
+		}
+
+		var underReplicated, offline int
+		for _, p := range topic.Partitions {
+			s.state.countKafkaError("Metadata", p.Error)
+			// brokers unknown to the cluster, i.e. leader -1, have no host
+			if p.Leader.Host == "" || errors.Is(p.Error, kafka.LeaderNotAvailable) {
+				offline++
+			}
+			if len(p.Isr) < len(p.Replicas) {
+				underReplicated++
+			}
+		}
+
+		labels := prometheus.Labels{"topic": topic.Name}
+		internalTopicPartitions.In(s.state.metrics).With(labels).Set(float64(len(topic.Partitions)))
+		internalTopicUnderReplicated.In(s.state.metrics).With(labels).Set(float64(underReplicated))
+		internalTopicOffline.In(s.state.metrics).With(labels).Set(float64(offline))
+		if underReplicated > 0 || offline > 0 {
+			unhealthy = append(unhealthy, fmt.Sprintf(
+				"%s (%d under-replicated, %d offline)", topic.Name, underReplicated, offline,
+			))
+		}
+	}
+
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("unhealthy internal topics: %v", unhealthy)
+	}
+	return nil
+}
+
+func (s *internalTopicsService) Close() {}
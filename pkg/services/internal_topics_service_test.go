@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// fakeInternalTopicsTransport answers the metadata requests with three brokers and the topics
+type fakeInternalTopicsTransport struct {
+	topics []metadata.ResponseTopic
+}
+
+func (t fakeInternalTopicsTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	if _, ok := req.(*metadata.Request); !ok {
+		return nil, errors.New("unsupported request")
+	}
+	resp := &metadata.Response{Topics: t.topics}
+	for _, id := range []int32{1, 2, 3} {
+		resp.Brokers = append(resp.Brokers, metadata.ResponseBroker{NodeID: id, Host: "broker", Port: 9092})
+	}
+	return resp, nil
+}
+
+// internalPartition returns a partition of the three brokers with the leader and in-sync replicas
+func internalPartition(id int32, leader int32, isr ...int32) metadata.ResponsePartition {
+	return metadata.ResponsePartition{PartitionIndex: id, LeaderID: leader, ReplicaNodes: []int32{1, 2, 3}, IsrNodes: isr}
+}
+
+func TestInternalTopicsCheck(t *testing.T) {
+	const offsets, transactions = "__consumer_offsets", "__transaction_state"
+	healthy := func(name string) metadata.ResponseTopic {
+		return metadata.ResponseTopic{Name: name, Partitions: []metadata.ResponsePartition{
+			internalPartition(0, 1, 1, 2, 3),
+			internalPartition(1, 2, 1, 2, 3),
+		}}
+	}
+	type counts struct{ partitions, underReplicated, offline float64 }
+	tests := []struct {
+		name   string
+		topics []metadata.ResponseTopic
+		// exported counts of each topic, the topics missing here have no series
+		want    map[string]counts
+		wantErr string
+	}{
+		{
+			name:   "healthy",
+			topics: []metadata.ResponseTopic{healthy(offsets), healthy(transactions)},
+			want:   map[string]counts{offsets: {2, 0, 0}, transactions: {2, 0, 0}},
+		},
+		{
+			name: "under-replicated consumer offsets",
+			topics: []metadata.ResponseTopic{
+				{Name: offsets, Partitions: []metadata.ResponsePartition{
+					internalPartition(0, 1, 1, 2),
+					internalPartition(1, 2, 1, 2, 3),
+				}},
+				healthy(transactions),
+			},
+			want:    map[string]counts{offsets: {2, 1, 0}, transactions: {2, 0, 0}},
+			wantErr: "__consumer_offsets (1 under-replicated, 0 offline)",
+		},
+		{
+			name: "offline transaction state",
+			topics: []metadata.ResponseTopic{
+				healthy(offsets),
+				{Name: transactions, Partitions: []metadata.ResponsePartition{
+					internalPartition(0, -1),
+					{PartitionIndex: 1, LeaderID: 2, ReplicaNodes: []int32{1, 2, 3}, IsrNodes: []int32{1, 2, 3}, ErrorCode: int16(kafka.LeaderNotAvailable)},
+				}},
+			},
+			want:    map[string]counts{offsets: {2, 0, 0}, transactions: {2, 1, 2}},
+			wantErr: "__transaction_state (1 under-replicated, 2 offline)",
+		},
+		{
+			name: "missing transaction state",
+			topics: []metadata.ResponseTopic{
+				healthy(offsets),
+				{Name: transactions, ErrorCode: int16(kafka.UnknownTopicOrPartition)},
+			},
+			want: map[string]counts{offsets: {2, 0, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestState()
+			logger := zerolog.Nop()
+			s := &internalTopicsService{
+				state: st,
+				connector: &client.Connector{KafkaClient: &kafka.Client{
+					Addr:      kafka.TCP("broker:9092"),
+					Transport: fakeInternalTopicsTransport{tt.topics},
+				}},
+				canaryConfig: &canary.Config{InternalTopics: canary.InternalTopicsConfig{Topics: []string{offsets, transactions}}},
+				logger:       &logger,
+			}
+
+			err := s.Check(context.Background())
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+			partitions := internalTopicPartitions.In(st.metrics)
+			underReplicated := internalTopicUnderReplicated.In(st.metrics)
+			offline := internalTopicOffline.In(st.metrics)
+			assert.Equal(t, len(tt.want), testutil.CollectAndCount(partitions))
+			for topic, want := range tt.want {
+				assert.Equal(t, want.partitions, testutil.ToFloat64(partitions.WithLabelValues(topic)), topic)
+				assert.Equal(t, want.underReplicated, testutil.ToFloat64(underReplicated.WithLabelValues(topic)), topic)
+				assert.Equal(t, want.offline, testutil.ToFloat64(offline.WithLabelValues(topic)), topic)
+			}
+		})
+	}
+}
+
+func TestInternalTopicsCheckDeletesMissing(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	transport := &fakeInternalTopicsTransport{topics: []metadata.ResponseTopic{
+		{Name: "__transaction_state", Partitions: []metadata.ResponsePartition{internalPartition(0, 1, 1, 2, 3)}},
+	}}
+	s := &internalTopicsService{
+		state:        st,
+		connector:    &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: transport}},
+		canaryConfig: &canary.Config{InternalTopics: canary.InternalTopicsConfig{Topics: []string{"__transaction_state"}}},
+		logger:       &logger,
+	}
+	require.NoError(t, s.Check(context.Background()))
+	assert.Equal(t, 1, testutil.CollectAndCount(internalTopicPartitions.In(st.metrics)))
+
+	// the series of a topic gone aren't exported anymore
+	transport.topics = []metadata.ResponseTopic{{Name: "__transaction_state", ErrorCode: int16(kafka.UnknownTopicOrPartition)}}
+	require.NoError(t, s.Check(context.Background()))
+	assert.Equal(t, 0, testutil.CollectAndCount(internalTopicPartitions.In(st.metrics)))
+	assert.Equal(t, 0, testutil.CollectAndCount(internalTopicUnderReplicated.In(st.metrics)))
+	assert.Equal(t, 0, testutil.CollectAndCount(internalTopicOffline.In(st.metrics)))
+}
@@ -0,0 +1,21 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var kafkaErrors = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name:      "kafka_errors_total",
+	Namespace: metricsNamespace,
+	Help:      "Total number of Kafka protocol errors returned by the brokers, by API and error code",
+}, []string{"api", "error_code"})
+
+// countKafkaError counts the error returned by the given Kafka API, if it is a protocol error
+func (st *State) countKafkaError(api string, err error) {
+	if code, ok := kafkaerr.CodeOf(err); ok {
+		kafkaErrors.In(st.metrics).WithLabelValues(api, code).Inc()
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var (
+	intervalLatency = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "records_consumed_latency_interval",
+		Namespace: metricsNamespace,
+		Help:      "Minimum, average and maximum end-to-end latency in milliseconds of the records consumed over the last status check interval, by stat",
+	}, []string{"stat"})
+
+	intervalLatencyRecords = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "records_consumed_latency_interval_records",
+		Namespace: metricsNamespace,
+		Help:      "Number of records consumed over the last status check interval the latency summary is computed on",
+	})
+)
+
+// latencySummary is the end-to-end latency summary of a canary over the current interval
+type latencySummary struct {
+	lock     sync.Mutex
+	interval latencyInterval
+}
+
+// latencyInterval accumulates the end-to-end latencies of an interval in milliseconds
+type latencyInterval struct {
+	min, max, sum int64
+	count         int64
+}
+
+// observeIntervalLatency accounts the end-to-end latency of a record consumed, it's called for
+// every record so it doesn't allocate
+func (st *State) observeIntervalLatency(latency int64) {
+	st.latency.lock.Lock()
+	defer st.latency.lock.Unlock()
+	summary := &st.latency.interval
+	if summary.count == 0 || latency < summary.min {
+		summary.min = latency
+	}
+	if summary.count == 0 || latency > summary.max {
+		summary.max = latency
+	}
+	summary.sum += latency
+	summary.count++
+}
+
+// exportIntervalLatency exports the summary of the latencies since the previous call and starts a
+// new interval. Low-resolution monitoring systems can ingest these few gauges instead of the
+// histogram buckets; an interval without records has no min, avg nor max.
+func (st *State) exportIntervalLatency() {
+	st.latency.lock.Lock()
+	interval := st.latency.interval
+	st.latency.interval = latencyInterval{}
+	st.latency.lock.Unlock()
+
+	intervalLatencyRecords.In(st.metrics).Set(float64(interval.count))
+	latency := intervalLatency.In(st.metrics)
+	if interval.count == 0 {
+		latency.Reset()
+		return
+	}
+	latency.WithLabelValues("min").Set(float64(interval.min))
+	latency.WithLabelValues("avg").Set(float64(interval.sum) / float64(interval.count))
+	latency.WithLabelValues("max").Set(float64(interval.max))
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportIntervalLatency(t *testing.T) {
+	st := newTestState()
+	st.exportIntervalLatency()
+	for _, latency := range []int64{40, 10, 100} {
+		st.observeIntervalLatency(latency)
+	}
+	st.exportIntervalLatency()
+	assert.Equal(t, 10.0, testutil.ToFloat64(intervalLatency.In(st.metrics).WithLabelValues("min")))
+	assert.Equal(t, 50.0, testutil.ToFloat64(intervalLatency.In(st.metrics).WithLabelValues("avg")))
+	assert.Equal(t, 100.0, testutil.ToFloat64(intervalLatency.In(st.metrics).WithLabelValues("max")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(intervalLatencyRecords.In(st.metrics)))
+
+	// a new interval without records has no summary
+	st.exportIntervalLatency()
+	assert.Equal(t, 0, testutil.CollectAndCount(intervalLatency.In(st.metrics)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(intervalLatencyRecords.In(st.metrics)))
+}
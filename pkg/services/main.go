@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// ErrExpectedClusterSize defines the error raised when the expected cluster size is not met
+type ErrExpectedClusterSize struct{}
+
+func (e *ErrExpectedClusterSize) Error() string {
+	return "Current cluster size differs from the expected size"
+}
+
+type StatusService interface {
+	Open()
+	Close()
+	StatusHandler() http.Handler
+}
+
+type ConnectionService interface {
+	Open()
+	Close()
+}
+
+type TopicService interface {
+	Reconcile(ctx context.Context) (TopicReconcileResult, error)
+	DescribePartition(ctx context.Context, partition int) (client.PartitionInfo, error)
+	Close()
+}
+
+type ProducerService interface {
+	Send(ctx context.Context, partitionsAssignments []int) []ProduceResult
+	Refresh()
+	Close()
+}
+
+// LeadersAware is implemented by the producers tracking the partition leaders found by the topic
+// reconcile
+type LeadersAware interface {
+	SetLeaders(leaders map[int32]int32)
+}
+
+// RecordsSampler is implemented by the status services, ingesting the records produced and
+// consumed so the consuming percentage reflects the traffic
+type RecordsSampler interface {
+	RecordsProduced(n int)
+	RecordsConsumed(n int)
+}
+
+// RecordsSamplerAware is implemented by the producers and consumers feeding a records sampler
+type RecordsSamplerAware interface {
+	SetRecordsSampler(sampler RecordsSampler)
+}
+
+type ConsumerService interface {
+	Consume(handler func(ConsumeResult))
+	Refresh()
+	Leaders(context.Context) (map[int]int, error)
+	Close()
+}
+
+// Resettable is implemented by the services keeping in-memory baselines, dropped on a reset so
+// the canary starts over as if just started, e.g. after recreating a test cluster
+type Resettable interface {
+	Reset()
+}
+
+// CheckService is an additional check run by the canary manager on every reconcile
+type CheckService interface {
+	Name() string
+	Check(ctx context.Context) error
+	Close()
+}
+
+// PeriodicCheck is implemented by the checks run less often than on every reconcile
+type PeriodicCheck interface {
+	Interval() time.Duration
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+const (
+	// CheckHeader is the header marking the records produced by checks, which the consumer skips
+	CheckHeader = "kafka-canary-check"
+
+	// room left for the batch and record overhead when producing a record just under the limit
+	recordOverhead = 512
+)
+
+var messageSizeUnexpected = metrics.NewCounterVec(prometheus.CounterOpts{
+	Name:      "message_size_unexpected_total",
+	Namespace: metricsNamespace,
+	Help:      "Total number of records around max.message.bytes with an unexpected outcome",
+}, []string{"result"})
+
+// messageSizeService produces a record just under and one just over the canary topic
+// max.message.bytes, checking only the first one is accepted
+type messageSizeService struct {
+	state        *State
+	connector    *client.Connector
+	admin        client.Client
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewMessageSizeService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &messageSizeService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *messageSizeService) Name() string {
+	return "message_size"
+}
+
+func (s *messageSizeService) Interval() time.Duration {
+	return s.canaryConfig.MessageSize.Interval
+}
+
+func (s *messageSizeService) Check(ctx context.Context) error {
+	limit, err := s.maxMessageBytes(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the broker rejections are only returned when waiting for the acks
+	writer := &kafka.Writer{
+		Addr:         s.connector.KafkaClient.Addr,
+		Transport:    s.connector.KafkaClient.Transport,
+		Topic:        s.canaryConfig.Topic,
+		BatchBytes:   int64(limit + 2*recordOverhead),
+		BatchTimeout: time.Millisecond,
+		RequiredAcks: kafka.RequireAll,
+	}
+	defer writer.Close()
+
+	err = s.write(ctx, writer, limit-recordOverhead)
+	s.state.countKafkaError("Produce", err)
+	switch {
+	case isMessageTooLarge(err):
+		messageSizeUnexpected.In(s.state.metrics).WithLabelValues("valid_rejected").Inc()
+		return fmt.Errorf("record of %d bytes rejected with max.message.bytes %d: %w", limit-recordOverhead, limit, err)
+	case err != nil:
+		return kafkaerr.Wrap(err)
+	}
+
+	err = s.write(ctx, writer, limit+1)
+	switch {
+	case err == nil:
+		messageSizeUnexpected.In(s.state.metrics).WithLabelValues("oversize_accepted").Inc()
+		return fmt.Errorf("record of %d bytes accepted with max.message.bytes %d", limit+1, limit)
+	case !isMessageTooLarge(err):
+		s.state.countKafkaError("Produce", err)
+		return kafkaerr.Wrap(err)
+	}
+
+	s.logger.Debug().Int("max_message_bytes", limit).Msg("Message size limits enforced")
+	return nil
+}
+
+func (s *messageSizeService) Close() {
+	if s.admin == nil {
+		return
+	}
+	if err := s.admin.Close(); err != nil {
+		s.logger.Error().Err(err).Msg("Error closing the message size check admin client")
+	}
+	s.admin = nil
+}
+
+func (s *messageSizeService) write(ctx context.Context, writer *kafka.Writer, size int) error {
+	return writer.WriteMessages(ctx, kafka.Message{
+		Value:   bytes.Repeat([]byte{'x'}, size),
+		Headers: []kafka.Header{{Key: CheckHeader, Value: []byte(s.Name())}},
+	})
+}
+
+// maxMessageBytes returns the topic max.message.bytes, or the configured default when the
+// topic doesn't override it
+func (s *messageSizeService) maxMessageBytes(ctx context.Context) (int, error) {
+	if s.admin == nil {
+		a, err := client.NewBrokerAdminClient(ctx, client.BrokerAdminClientConfig{
+			ConnectorConfig: s.connector.Config,
+			Auditor:         s.state.auditOperation,
+		}, s.logger)
+		if err != nil {
+			return 0, kafkaerr.Wrap(err)
+		}
+		s.admin = a
+	}
+
+	topic, err := s.admin.GetTopic(ctx, s.canaryConfig.Topic, true)
+	if err != nil {
+		return 0, kafkaerr.Wrap(err)
+	}
+	value, ok := topic.Config["max.message.bytes"]
+	if !ok {
+		return s.canaryConfig.MessageSize.MaxMessageBytes, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// isMessageTooLarge returns true when the broker rejected the record for its size
+func isMessageTooLarge(err error) bool {
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		for _, e := range writeErrors {
+			if isMessageTooLarge(e) {
+				return true
+			}
+		}
+		return false
+	}
+	return errors.Is(err, kafka.MessageSizeTooLarge)
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// fakeProduceTransport serves the canary topic with a single partition, answering each produce
+// request with the next of the errors and recording the size of the records produced
+type fakeProduceTransport struct {
+	errs  []kafka.Error
+	sizes []int
+}
+
+func (t *fakeProduceTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		return &metadata.Response{
+			Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "broker", Port: 9092}},
+			Topics: []metadata.ResponseTopic{{
+				Name:       req.TopicNames[0],
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0, LeaderID: 1}},
+			}},
+		}, nil
+	case *produce.Request:
+		record, err := req.Topics[0].Partitions[0].RecordSet.Records.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		var code kafka.Error
+		if len(t.sizes) < len(t.errs) {
+			code = t.errs[len(t.sizes)]
+		}
+		t.sizes = append(t.sizes, record.Value.Len())
+		return &produce.Response{Topics: []produce.ResponseTopic{{
+			Topic:      req.Topics[0].Topic,
+			Partitions: []produce.ResponsePartition{{Partition: 0, ErrorCode: int16(code)}},
+		}}}, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func TestMessageSizeCheck(t *testing.T) {
+	st := newTestState()
+	topic := client.TopicInfo{Name: "canary", Config: map[string]string{"max.message.bytes": "4096"}}
+	tests := []struct {
+		name       string
+		errs       []kafka.Error
+		wantErr    string
+		unexpected string
+	}{
+		{name: "limits enforced", errs: []kafka.Error{0, kafka.MessageSizeTooLarge}},
+		{
+			name:       "oversize record accepted",
+			errs:       []kafka.Error{0, 0},
+			wantErr:    "record of 4097 bytes accepted with max.message.bytes 4096",
+			unexpected: "oversize_accepted",
+		},
+		{
+			name:       "valid record rejected",
+			errs:       []kafka.Error{kafka.MessageSizeTooLarge},
+			wantErr:    "record of 3584 bytes rejected with max.message.bytes 4096",
+			unexpected: "valid_rejected",
+		},
+		{name: "produce failing", errs: []kafka.Error{kafka.TopicAuthorizationFailed}, wantErr: "Topic Authorization Failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &fakeProduceTransport{errs: tt.errs}
+			logger := zerolog.Nop()
+			s := &messageSizeService{
+				state: st,
+				connector: &client.Connector{
+					Config:      client.ConnectorConfig{BrokerAddrs: []string{"broker:9092"}},
+					KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: transport},
+				},
+				admin:        &fakeAdmin{topics: []fakeTopicResult{{topic: topic}}},
+				canaryConfig: &canary.Config{Topic: "canary"},
+				logger:       &logger,
+			}
+			var before float64
+			if tt.unexpected != "" {
+				before = testutil.ToFloat64(messageSizeUnexpected.In(st.metrics).WithLabelValues(tt.unexpected))
+			}
+
+			err := s.Check(context.Background())
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				assert.Equal(t, []int{4096 - recordOverhead, 4097}, transport.sizes)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+			if tt.unexpected != "" {
+				assert.Equal(t, before+1, testutil.ToFloat64(messageSizeUnexpected.In(st.metrics).WithLabelValues(tt.unexpected)))
+			}
+		})
+	}
+}
+
+func TestMessageSizeMaxMessageBytes(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	admin := &fakeAdmin{topics: []fakeTopicResult{{topic: client.TopicInfo{Name: "canary"}}}}
+	s := &messageSizeService{
+		state:        st,
+		admin:        admin,
+		canaryConfig: &canary.Config{Topic: "canary", MessageSize: canary.MessageSizeConfig{MaxMessageBytes: 1048588}},
+		logger:       &logger,
+	}
+	limit, err := s.maxMessageBytes(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1048588, limit, "broker default")
+
+	admin.topics = []fakeTopicResult{{topic: client.TopicInfo{Name: "canary", Config: map[string]string{"max.message.bytes": "2048"}}}}
+	limit, err = s.maxMessageBytes(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2048, limit, "topic override")
+
+	admin.topics = []fakeTopicResult{{err: errors.New("connection refused")}}
+	_, err = s.maxMessageBytes(context.Background())
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestIsMessageTooLarge(t *testing.T) {
+	assert.True(t, isMessageTooLarge(kafka.MessageSizeTooLarge))
+	assert.True(t, isMessageTooLarge(kafka.WriteErrors{nil, kafka.MessageSizeTooLarge}))
+	assert.False(t, isMessageTooLarge(kafka.WriteErrors{kafka.NotLeaderForPartition}))
+	assert.False(t, isMessageTooLarge(errors.New("timeout")))
+	assert.False(t, isMessageTooLarge(nil))
+}
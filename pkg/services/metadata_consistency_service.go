@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	metadataDivergence = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "metadata_divergent_partitions",
+		Namespace: metricsNamespace,
+		Help:      "Number of canary topic partitions whose leader, or existence, in the broker metadata differs from the majority of the brokers",
+	}, []string{"broker"})
+
+	metadataPartitions = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "metadata_partitions",
+		Namespace: metricsNamespace,
+		Help:      "Number of canary topic partitions in the broker metadata",
+	}, []string{"broker"})
+)
+
+// metadataConsistencyService sends the canary topic metadata request to every broker and compares
+// their views, a broker serving stale metadata sends its clients to the wrong leaders
+type metadataConsistencyService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewMetadataConsistencyService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadataConsistencyService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *metadataConsistencyService) Name() string {
+	return "metadata_consistency"
+}
+
+func (s *metadataConsistencyService) Interval() time.Duration {
+	return s.canaryConfig.MetadataConsistency.Interval
+}
+
+func (s *metadataConsistencyService) Check(ctx context.Context) error {
+	views, failed, err := brokerViews(ctx, s.state, s.connector.KafkaClient, s.canaryConfig.Topic)
+	if err != nil {
+		return err
+	}
+	for broker, view := range views {
+		metadataPartitions.In(s.state.metrics).WithLabelValues(strconv.Itoa(broker)).Set(float64(len(view)))
+	}
+
+	var divergent []string
+	for broker, count := range divergentPartitions(views) {
+		metadataDivergence.In(s.state.metrics).WithLabelValues(strconv.Itoa(broker)).Set(float64(count))
+		if count > 0 {
+			divergent = append(divergent, fmt.Sprintf("%d (%d partitions)", broker, count))
+		}
+	}
+	sort.Strings(divergent)
+
+	if len(divergent) > 0 {
+		return fmt.Errorf("brokers with divergent metadata: %v", divergent)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error fetching metadata from brokers: %v", failed)
+	}
+	return nil
+}
+
+func (s *metadataConsistencyService) Close() {}
+
+// brokerViews sends the topic metadata request to every broker of the cluster, returning the
+// leaders of the topic partitions by broker and the brokers whose metadata couldn't be fetched
+func brokerViews(ctx context.Context, state *State, kafkaClient *kafka.Client, topic string) (map[int]map[int]int, []string, error) {
+	cluster, err := kafkaClient.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{}})
+	if err != nil {
+		state.countKafkaError("Metadata", err)
+		return nil, nil, kafkaerr.Wrap(err)
+	}
+
+	views := map[int]map[int]int{}
+	var failed []string
+	for _, broker := range cluster.Brokers {
+		view, err := brokerView(ctx, state, kafkaClient, broker, topic)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%d (%v)", broker.ID, err))
+			continue
+		}
+		views[broker.ID] = view
+	}
+	return views, failed, nil
+}
+
+// brokerView returns the leaders of the topic partitions in the broker metadata, -1 for the
+// partitions without a leader
+func brokerView(ctx context.Context, state *State, kafkaClient *kafka.Client, broker kafka.Broker, topic string) (map[int]int, error) {
+	metadata, err := kafkaClient.Metadata(ctx, &kafka.MetadataRequest{
+		Addr:   kafka.TCP(net.JoinHostPort(broker.Host, strconv.Itoa(broker.Port))),
+		Topics: []string{topic},
+	})
+	if err != nil {
+		state.countKafkaError("Metadata", err)
+		return nil, kafkaerr.Wrap(err)
+	}
+
+	view := map[int]int{}
+	for _, t := range metadata.Topics {
+		if t.Error != nil {
+			state.countKafkaError("Metadata", t.Error)
+			return nil, kafkaerr.Wrap(t.Error)
+		}
+		for _, p := range t.Partitions {
+			// the leader of a partition without one isn't among the brokers of the response
+			leader := p.Leader.ID
+			if p.Error != nil || p.Leader.Host == "" {
+				leader = -1
+			}
+			view[p.ID] = leader
+		}
+	}
+	return view, nil
+}
+
+// divergentPartitions returns, by broker, the number of partitions whose leader differs from the
+// one most brokers report, or that only some brokers know of
+func divergentPartitions(views map[int]map[int]int) map[int]int {
+	votes := map[int]map[int]int{}
+	for _, view := range views {
+		for partition, leader := range view {
+			if votes[partition] == nil {
+				votes[partition] = map[int]int{}
+			}
+			votes[partition][leader]++
+		}
+	}
+	majority := make(map[int]int, len(votes))
+	for partition, leaders := range votes {
+		best, bestVotes := 0, 0
+		for leader, n := range leaders {
+			// ties go to the lowest broker ID, so the result is stable
+			if n > bestVotes || (n == bestVotes && leader < best) {
+				best, bestVotes = leader, n
+			}
+		}
+		majority[partition] = best
+	}
+
+	divergent := make(map[int]int, len(views))
+	for broker, view := range views {
+		divergent[broker] = 0
+		for partition, leader := range majority {
+			if l, ok := view[partition]; !ok || l != leader {
+				divergent[broker]++
+			}
+		}
+	}
+	return divergent
+}
@@ -0,0 +1,19 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDivergentPartitions(t *testing.T) {
+	views := map[int]map[int]int{
+		1: {0: 1, 1: 2, 2: 3},
+		2: {0: 1, 1: 2, 2: 3},
+		// stale leader for partition 1 and partition 2 unknown
+		3: {0: 1, 1: 3},
+	}
+	assert.Equal(t, map[int]int{1: 0, 2: 0, 3: 2}, divergentPartitions(views))
+
+	assert.Empty(t, divergentPartitions(map[int]map[int]int{}))
+}
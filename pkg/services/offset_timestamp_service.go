@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	offsetForTimestampLatency = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "offset_for_timestamp_latency",
+		Namespace: metricsNamespace,
+		Help:      "ListOffsets by timestamp latency in milliseconds",
+		Buckets:   []float64{10, 50, 100, 500, 1000, 5000},
+	}, []string{"partition"})
+
+	offsetForTimestampMismatch = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "offset_for_timestamp_mismatch_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of offsets returned for a timestamp whose record is older than the timestamp",
+	}, []string{"partition"})
+)
+
+// offsetTimestampService looks up the canary partitions offsets for a past timestamp and checks
+// the records at those offsets aren't older, catching time index corruption
+type offsetTimestampService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewOffsetTimestampService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &offsetTimestampService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *offsetTimestampService) Name() string {
+	return "offset_for_timestamp"
+}
+
+func (s *offsetTimestampService) Interval() time.Duration {
+	return s.canaryConfig.OffsetTimestamp.Interval
+}
+
+func (s *offsetTimestampService) Check(ctx context.Context) error {
+	metadata, err := s.connector.KafkaClient.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: []string{s.canaryConfig.Topic},
+	})
+	if err != nil {
+		return kafkaerr.Wrap(err)
+	}
+
+	at := time.Now().Add(-s.canaryConfig.OffsetTimestamp.Lookback)
+	requests := []kafka.OffsetRequest{}
+	for _, topic := range metadata.Topics {
+		for _, p := range topic.Partitions {
+			requests = append(requests, kafka.TimeOffsetOf(p.ID, at))
+		}
+	}
+
+	start := time.Now()
+	resp, err := s.connector.KafkaClient.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{s.canaryConfig.Topic: requests},
+	})
+	if err != nil {
+		s.state.countKafkaError("ListOffsets", err)
+		return kafkaerr.Wrap(err)
+	}
+	latency := time.Since(start)
+
+	var mismatches int
+	for _, partition := range resp.Topics[s.canaryConfig.Topic] {
+		labels := prometheus.Labels{"partition": strconv.Itoa(partition.Partition)}
+		offsetForTimestampLatency.In(s.state.metrics).With(labels).Observe(float64(latency.Milliseconds()))
+		if partition.Error != nil {
+			s.state.countKafkaError("ListOffsets", partition.Error)
+			return kafkaerr.Wrap(partition.Error)
+		}
+
+		for offset := range partition.Offsets {
+			// no record was appended after the timestamp
+			if offset < 0 {
+				continue
+			}
+			timestamp, err := s.recordTimestamp(ctx, partition.Partition, offset)
+			if err != nil {
+				return kafkaerr.Wrap(err)
+			}
+			if timestamp.UnixMilli() < at.UnixMilli() {
+				offsetForTimestampMismatch.In(s.state.metrics).With(labels).Inc()
+				mismatches++
+				s.logger.Error().
+					Int("partition", partition.Partition).
+					Int64("offset", offset).
+					Time("requested", at).
+					Time("timestamp", timestamp).
+					Msg("Offset returned for timestamp points to an older record")
+			}
+		}
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("%d partitions returned offsets of records older than %s", mismatches, at)
+	}
+	return nil
+}
+
+func (s *offsetTimestampService) Close() {}
+
+// recordTimestamp fetches the record at the given offset and returns its timestamp
+func (s *offsetTimestampService) recordTimestamp(ctx context.Context, partition int, offset int64) (time.Time, error) {
+	resp, err := s.connector.KafkaClient.Fetch(ctx, &kafka.FetchRequest{
+		Topic:     s.canaryConfig.Topic,
+		Partition: partition,
+		Offset:    offset,
+		MinBytes:  1,
+		MaxBytes:  1 << 20,
+		MaxWait:   time.Second,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if resp.Error != nil {
+		s.state.countKafkaError("Fetch", resp.Error)
+		return time.Time{}, resp.Error
+	}
+
+	// the first batch returned can start before the requested offset
+	for {
+		record, err := resp.Records.ReadRecord()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reading record at offset %d of partition %d: %w", offset, partition, err)
+		}
+		if record.Offset >= offset {
+			return record.Time, nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/fetch"
+	"github.com/segmentio/kafka-go/protocol/listoffsets"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// fakeTimeIndexTransport serves a single partition canary topic, whose time index points to the
+// offset and whose log holds the records
+type fakeTimeIndexTransport struct {
+	offset    int64
+	listError kafka.Error
+	records   []protocol.Record
+}
+
+func (t fakeTimeIndexTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		return &metadata.Response{
+			Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "broker", Port: 9092}},
+			Topics: []metadata.ResponseTopic{{
+				Name:       req.TopicNames[0],
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0, LeaderID: 1}},
+			}},
+		}, nil
+	case *listoffsets.Request:
+		return &listoffsets.Response{Topics: []listoffsets.ResponseTopic{{
+			Topic: req.Topics[0].Topic,
+			Partitions: []listoffsets.ResponsePartition{{
+				Partition: 0, ErrorCode: int16(t.listError), Timestamp: req.Topics[0].Partitions[0].Timestamp, Offset: t.offset,
+			}},
+		}}}, nil
+	case *fetch.Request:
+		return &fetch.Response{Topics: []fetch.ResponseTopic{{
+			Topic: req.Topics[0].Topic,
+			Partitions: []fetch.ResponsePartition{{
+				Partition: 0,
+				RecordSet: protocol.RecordSet{Version: 2, Records: protocol.NewRecordReader(t.records...)},
+			}},
+		}}}, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func TestOffsetTimestampCheck(t *testing.T) {
+	st := newTestState()
+	now := time.Now()
+	tests := []struct {
+		name      string
+		transport fakeTimeIndexTransport
+		wantErr   string
+		mismatch  bool
+	}{
+		{
+			name: "record after the timestamp",
+			transport: fakeTimeIndexTransport{offset: 5, records: []protocol.Record{
+				// the batch starts before the offset
+				{Offset: 4, Time: now.Add(-2 * time.Hour)},
+				{Offset: 5, Time: now.Add(-30 * time.Minute)},
+			}},
+		},
+		{
+			name:      "record older than the timestamp",
+			transport: fakeTimeIndexTransport{offset: 5, records: []protocol.Record{{Offset: 5, Time: now.Add(-2 * time.Hour)}}},
+			wantErr:   "1 partitions returned offsets of records older than",
+			mismatch:  true,
+		},
+		{
+			name:      "record missing",
+			transport: fakeTimeIndexTransport{offset: 5, records: []protocol.Record{{Offset: 4, Time: now}}},
+			wantErr:   "reading record at offset 5 of partition 0",
+		},
+		{
+			name:      "partition error",
+			transport: fakeTimeIndexTransport{listError: kafka.NotLeaderForPartition},
+			wantErr:   "Not Leader For Partition",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			s := &offsetTimestampService{
+				state:     st,
+				connector: &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: tt.transport}},
+				canaryConfig: &canary.Config{
+					Topic:           "canary",
+					OffsetTimestamp: canary.OffsetTimestampConfig{Lookback: time.Hour},
+				},
+				logger: &logger,
+			}
+			mismatches := offsetForTimestampMismatch.In(st.metrics).WithLabelValues("0")
+			before := testutil.ToFloat64(mismatches)
+
+			err := s.Check(context.Background())
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+			if tt.mismatch {
+				assert.Equal(t, before+1, testutil.ToFloat64(mismatches))
+			} else {
+				assert.Equal(t, before, testutil.ToFloat64(mismatches))
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// ErrTopicOwned is returned when another live canary instance claimed the canary topic
+var ErrTopicOwned = errors.New("canary topic owned by another live canary instance")
+
+var (
+	topicOwnershipClaimed = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "topic_ownership_claimed",
+		Namespace: metricsNamespace,
+		Help:      "Whether this instance holds the canary topic ownership claim (1)",
+	})
+
+	topicOwnershipConflicts = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "topic_ownership_conflicts_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of canary topic ownership claims found held by another live instance",
+	})
+)
+
+// TopicClaim is the ownership claim of a canary topic, held by an instance until it expires
+type TopicClaim struct {
+	Instance string    `json:"instance"`
+	Expires  time.Time `json:"expires"`
+}
+
+// ownershipService claims the canary topic for this instance and renews the claim every third
+// of its lease, so two canaries misconfigured with the same topic don't corrupt each other's
+// statistics. The claim is the metadata of an offset committed for the topic first partition in
+// a dedicated consumer group without members, which needs no record in the topic and holds the
+// claims of every canary topic of the cluster.
+type ownershipService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+	now          func() time.Time
+}
+
+func NewOwnershipService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	if canaryConfig.Coordination.Enabled || canaryConfig.IgnoreOtherInstances {
+		return nil, errors.New("the canary topic ownership can't be claimed by instances sharing the topic on purpose")
+	}
+	if canaryConfig.Ownership.Lease <= 0 {
+		return nil, errors.New("the canary topic ownership needs a positive lease")
+	}
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ownershipService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+		now:          time.Now,
+	}, nil
+}
+
+func (s *ownershipService) Name() string {
+	return "ownership"
+}
+
+func (s *ownershipService) Interval() time.Duration {
+	return s.canaryConfig.Ownership.Lease / 3
+}
+
+// Check claims the topic unless another live instance holds the claim, taking it over when
+// forced. A topic that doesn't exist yet is owned by nobody, it's claimed once created.
+func (s *ownershipService) Check(ctx context.Context) error {
+	claim, err := s.current(ctx)
+	if err != nil {
+		return unclaimedTopic(err)
+	}
+
+	now := s.now()
+	if claim.Instance != "" && claim.Instance != s.canaryConfig.InstanceID && now.Before(claim.Expires) {
+		if !s.canaryConfig.Ownership.Force {
+			topicOwnershipClaimed.In(s.state.metrics).Set(0)
+			topicOwnershipConflicts.In(s.state.metrics).Inc()
+			s.state.recordEvent(EventError, s.Name(), "topic %s claimed by instance %s until %s", s.canaryConfig.Topic, claim.Instance, claim.Expires.Format(time.RFC3339))
+			return fmt.Errorf("%w: topic %s claimed by instance %s until %s", ErrTopicOwned, s.canaryConfig.Topic, claim.Instance, claim.Expires.Format(time.RFC3339))
+		}
+		s.logger.Warn().
+			Str("topic", s.canaryConfig.Topic).
+			Str("owner", claim.Instance).
+			Time("expires", claim.Expires).
+			Msg("Forcing the canary topic ownership claim of another live instance")
+		s.state.recordEvent(EventWarning, s.Name(), "topic %s claim of instance %s taken over", s.canaryConfig.Topic, claim.Instance)
+	}
+
+	if err := s.commit(ctx, TopicClaim{Instance: s.canaryConfig.InstanceID, Expires: now.Add(s.canaryConfig.Ownership.Lease)}); err != nil {
+		return unclaimedTopic(err)
+	}
+	topicOwnershipClaimed.In(s.state.metrics).Set(1)
+	return nil
+}
+
+// unclaimedTopic returns the error, nil when the topic doesn't exist yet
+func unclaimedTopic(err error) error {
+	if errors.Is(err, kafka.UnknownTopicOrPartition) {
+		return nil
+	}
+	return err
+}
+
+func (s *ownershipService) Close() {}
+
+// current returns the claim of the canary topic, empty when never claimed
+func (s *ownershipService) current(ctx context.Context) (TopicClaim, error) {
+	resp, err := s.connector.KafkaClient.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: s.canaryConfig.Ownership.GroupID,
+		Topics:  map[string][]int{s.canaryConfig.Topic: {0}},
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	if err == nil {
+		if partitions := resp.Topics[s.canaryConfig.Topic]; len(partitions) == 1 {
+			err = partitions[0].Error
+			if err == nil && partitions[0].Metadata != "" {
+				var claim TopicClaim
+				if err := json.Unmarshal([]byte(partitions[0].Metadata), &claim); err != nil {
+					return TopicClaim{}, fmt.Errorf("unreadable ownership claim of topic %s: %w", s.canaryConfig.Topic, err)
+				}
+				return claim, nil
+			}
+		}
+	}
+	if err != nil {
+		s.state.countKafkaError("OffsetFetch", err)
+		return TopicClaim{}, kafkaerr.Wrap(err)
+	}
+	return TopicClaim{}, nil
+}
+
+// commit stores the claim of the canary topic
+func (s *ownershipService) commit(ctx context.Context, claim TopicClaim) error {
+	metadata, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+	resp, err := s.connector.KafkaClient.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      s.canaryConfig.Ownership.GroupID,
+		GenerationID: -1,
+		Topics: map[string][]kafka.OffsetCommit{
+			s.canaryConfig.Topic: {{Partition: 0, Offset: 0, Metadata: string(metadata)}},
+		},
+	})
+	if err == nil {
+		if partitions := resp.Topics[s.canaryConfig.Topic]; len(partitions) == 1 {
+			err = partitions[0].Error
+		}
+	}
+	if err != nil {
+		s.state.countKafkaError("OffsetCommit", err)
+		return kafkaerr.Wrap(err)
+	}
+	return nil
+}
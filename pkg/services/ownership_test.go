@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/offsetcommit"
+	"github.com/segmentio/kafka-go/protocol/offsetfetch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// fakeOffsetsTransport keeps the committed offsets metadata of a group by topic, the topics
+// missing from the cluster failing with UNKNOWN_TOPIC_OR_PARTITION
+type fakeOffsetsTransport struct {
+	metadata map[string]string
+	missing  map[string]bool
+}
+
+func (t *fakeOffsetsTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *offsetfetch.Request:
+		resp := &offsetfetch.Response{}
+		for _, topic := range req.Topics {
+			partition := offsetfetch.ResponsePartition{CommittedOffset: -1, Metadata: t.metadata[topic.Name]}
+			if t.missing[topic.Name] {
+				partition.ErrorCode = int16(kafka.UnknownTopicOrPartition)
+			}
+			resp.Topics = append(resp.Topics, offsetfetch.ResponseTopic{Name: topic.Name, Partitions: []offsetfetch.ResponsePartition{partition}})
+		}
+		return resp, nil
+	case *offsetcommit.Request:
+		resp := &offsetcommit.Response{}
+		for _, topic := range req.Topics {
+			partition := offsetcommit.ResponsePartition{}
+			if t.missing[topic.Name] {
+				partition.ErrorCode = int16(kafka.UnknownTopicOrPartition)
+			} else {
+				t.metadata[topic.Name] = topic.Partitions[0].CommittedMetadata
+			}
+			resp.Topics = append(resp.Topics, offsetcommit.ResponseTopic{Name: topic.Name, Partitions: []offsetcommit.ResponsePartition{partition}})
+		}
+		return resp, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func TestOwnershipService(t *testing.T) {
+	st := newTestState()
+	transport := &fakeOffsetsTransport{metadata: map[string]string{}, missing: map[string]bool{}}
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	newService := func(instance string, force bool) *ownershipService {
+		logger := zerolog.Nop()
+		return &ownershipService{
+			state:     st,
+			connector: &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: transport}},
+			canaryConfig: &canary.Config{
+				Topic:      "__kafka_canary",
+				InstanceID: instance,
+				Ownership:  canary.OwnershipConfig{Enabled: true, GroupID: "kafka-canary-ownership", Lease: time.Minute, Force: force},
+			},
+			logger: &logger,
+			now:    func() time.Time { return now },
+		}
+	}
+	first, second := newService("canary-0", false), newService("canary-1", false)
+
+	// a topic not created yet has no owner
+	transport.missing["__kafka_canary"] = true
+	require.NoError(t, first.Check(context.Background()))
+	assert.Empty(t, transport.metadata)
+	delete(transport.missing, "__kafka_canary")
+
+	require.NoError(t, first.Check(context.Background()))
+	assert.JSONEq(t, `{"instance":"canary-0","expires":"2023-01-02T03:05:05Z"}`, transport.metadata["__kafka_canary"])
+
+	// a live claim of another instance is refused, renewed by its owner
+	assert.ErrorIs(t, second.Check(context.Background()), ErrTopicOwned)
+	now = now.Add(30 * time.Second)
+	require.NoError(t, first.Check(context.Background()))
+	now = now.Add(45 * time.Second)
+	assert.ErrorIs(t, second.Check(context.Background()), ErrTopicOwned)
+
+	// an expired one is taken over
+	now = now.Add(time.Minute)
+	require.NoError(t, second.Check(context.Background()))
+	assert.ErrorIs(t, first.Check(context.Background()), ErrTopicOwned)
+
+	// a forced instance takes a live claim over
+	require.NoError(t, newService("canary-0", true).Check(context.Background()))
+	assert.Contains(t, transport.metadata["__kafka_canary"], `"canary-0"`)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/describegroups"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// ACL operations, as bits of the authorized operations returned by the brokers
+const (
+	aclRead         = 3
+	aclWrite        = 4
+	aclCreate       = 5
+	aclDescribe     = 8
+	aclAlterConfigs = 11
+)
+
+var aclNames = map[int]string{
+	aclRead:         "Read",
+	aclWrite:        "Write",
+	aclCreate:       "Create",
+	aclAlterConfigs: "AlterConfigs",
+	aclDescribe:     "Describe",
+}
+
+// ErrPermissionsUnsupported is returned when the brokers don't report authorized operations,
+// i.e. before Kafka 2.3
+var ErrPermissionsUnsupported = errors.New("the brokers don't report authorized operations")
+
+// ErrMissingPermissions lists the permissions the canary principal lacks
+type ErrMissingPermissions struct {
+	Missing []string
+}
+
+func (e *ErrMissingPermissions) Error() string {
+	return "missing permissions: " + strings.Join(e.Missing, ", ")
+}
+
+// VerifyPermissions checks the canary principal has the operations it needs on the canary topic,
+// the cluster and the consumer group, using the authorized operations returned by the brokers, so
+// a missing ACL is reported precisely on startup instead of as generic failures mid-run. The
+// canary is flagged as degraded while permissions are missing.
+func (st *State) VerifyPermissions(ctx context.Context, canaryConfig canary.Config, connectorConfig client.ConnectorConfig) error {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return err
+	}
+	return st.verifyPermissions(ctx, canaryConfig, connector.KafkaClient)
+}
+
+// verifyPermissions checks the permissions of the principal of the given client
+func (st *State) verifyPermissions(ctx context.Context, canaryConfig canary.Config, kafkaClient *kafka.Client) error {
+	versions, err := kafkaClient.ApiVersions(ctx, &kafka.ApiVersionsRequest{})
+	if err == nil {
+		err = versions.Error
+	}
+	if err != nil {
+		return kafkaerr.Wrap(err)
+	}
+	if !supportsVersion(versions, protocol.Metadata, 8) || !supportsVersion(versions, protocol.DescribeGroups, 3) {
+		return ErrPermissionsUnsupported
+	}
+
+	var missing []string
+	topic := fmt.Sprintf("topic %q", canaryConfig.Topic)
+	response, err := kafkaClient.Transport.RoundTrip(ctx, kafkaClient.Addr, &metadata.Request{
+		TopicNames:                         []string{canaryConfig.Topic},
+		IncludeClusterAuthorizedOperations: true,
+		IncludeTopicAuthorizedOperations:   true,
+	})
+	if err != nil {
+		return kafkaerr.Wrap(err)
+	}
+	meta := response.(*metadata.Response)
+	for _, t := range meta.Topics {
+		switch code := kafka.Error(t.ErrorCode); code {
+		case 0:
+			missing = append(missing, missingOperations(t.TopicAuthorizedOperations, []int{aclDescribe, aclRead, aclWrite, aclAlterConfigs}, topic)...)
+		case kafka.UnknownTopicOrPartition:
+			// created on the first reconcile, Create on the topic itself can't be verified before
+			missing = append(missing, missingOperations(meta.ClusterAuthorizedOperations, []int{aclCreate}, "the cluster (or on "+topic+")")...)
+		case kafka.TopicAuthorizationFailed:
+			missing = append(missing, "Describe on "+topic)
+		default:
+			return kafkaerr.Wrap(code)
+		}
+	}
+
+	if canaryConfig.ConsumerGroupID != "" {
+		groupMissing, err := verifyGroupPermissions(ctx, kafkaClient, canaryConfig.ConsumerGroupID)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, groupMissing...)
+	}
+
+	if len(missing) > 0 {
+		err := &ErrMissingPermissions{Missing: missing}
+		st.markDegraded("permissions", err)
+		return err
+	}
+	st.markHealthy("permissions")
+	return nil
+}
+
+// verifyGroupPermissions returns the operations missing on the consumer group
+func verifyGroupPermissions(ctx context.Context, kafkaClient *kafka.Client, groupID string) ([]string, error) {
+	group := fmt.Sprintf("group %q", groupID)
+	response, err := kafkaClient.Transport.RoundTrip(ctx, kafkaClient.Addr, &describegroups.Request{
+		Groups:                      []string{groupID},
+		IncludeAuthorizedOperations: true,
+	})
+	if err != nil {
+		return nil, kafkaerr.Wrap(err)
+	}
+	var missing []string
+	for _, g := range response.(*describegroups.Response).Groups {
+		switch code := kafka.Error(g.ErrorCode); code {
+		case 0:
+			missing = append(missing, missingOperations(g.AuthorizedOperations, []int{aclDescribe, aclRead}, group)...)
+		case kafka.GroupAuthorizationFailed:
+			missing = append(missing, "Describe on "+group, "Read on "+group)
+		default:
+			return nil, kafkaerr.Wrap(code)
+		}
+	}
+	return missing, nil
+}
+
+// missingOperations returns the operations not authorized on the resource
+func missingOperations(authorized int32, operations []int, resource string) []string {
+	var missing []string
+	for _, operation := range operations {
+		if authorized&(1<<operation) == 0 {
+			missing = append(missing, fmt.Sprintf("%s on %s", aclNames[operation], resource))
+		}
+	}
+	return missing
+}
+
+func supportsVersion(versions *kafka.ApiVersionsResponse, api protocol.ApiKey, version int) bool {
+	for _, key := range versions.ApiKeys {
+		if key.ApiKey == int(api) {
+			return key.MaxVersion >= version
+		}
+	}
+	return false
+}
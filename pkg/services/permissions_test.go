@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/describegroups"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+// operations returns the authorized operations bits of the ACL operations
+func operations(acls ...int) int32 {
+	var authorized int32
+	for _, acl := range acls {
+		authorized |= 1 << acl
+	}
+	return authorized
+}
+
+// fakeACLTransport answers with the authorized operations of the canary principal
+type fakeACLTransport struct {
+	metadataVersion int16
+	topicError      kafka.Error
+	topic           int32
+	cluster         int32
+	groupError      kafka.Error
+	group           int32
+}
+
+func (t fakeACLTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *apiversions.Request:
+		return &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+			{ApiKey: int16(protocol.Metadata), MaxVersion: t.metadataVersion},
+			{ApiKey: int16(protocol.DescribeGroups), MaxVersion: 5},
+		}}, nil
+	case *metadata.Request:
+		return &metadata.Response{
+			ClusterAuthorizedOperations: t.cluster,
+			Topics: []metadata.ResponseTopic{{
+				Name: req.TopicNames[0], ErrorCode: int16(t.topicError), TopicAuthorizedOperations: t.topic,
+			}},
+		}, nil
+	case *describegroups.Request:
+		return &describegroups.Response{Groups: []describegroups.ResponseGroup{{
+			GroupID: req.Groups[0], ErrorCode: int16(t.groupError), AuthorizedOperations: t.group,
+		}}}, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func TestVerifyPermissions(t *testing.T) {
+	st := newTestState()
+	allTopic := operations(aclDescribe, aclRead, aclWrite, aclAlterConfigs)
+	allGroup := operations(aclDescribe, aclRead)
+	tests := []struct {
+		name        string
+		transport   fakeACLTransport
+		wantErr     error
+		wantMissing []string
+	}{
+		{
+			name:      "all granted",
+			transport: fakeACLTransport{metadataVersion: 9, topic: allTopic, group: allGroup},
+		},
+		{
+			name:      "brokers before Kafka 2.3",
+			transport: fakeACLTransport{metadataVersion: 7},
+			wantErr:   ErrPermissionsUnsupported,
+		},
+		{
+			name:        "topic operations missing",
+			transport:   fakeACLTransport{metadataVersion: 9, topic: operations(aclDescribe, aclRead), group: allGroup},
+			wantMissing: []string{`Write on topic "canary"`, `AlterConfigs on topic "canary"`},
+		},
+		{
+			name:        "topic to create",
+			transport:   fakeACLTransport{metadataVersion: 9, topicError: kafka.UnknownTopicOrPartition, group: allGroup},
+			wantMissing: []string{`Create on the cluster (or on topic "canary")`},
+		},
+		{
+			name:      "topic to create with Create on the cluster",
+			transport: fakeACLTransport{metadataVersion: 9, topicError: kafka.UnknownTopicOrPartition, cluster: operations(aclCreate), group: allGroup},
+		},
+		{
+			name:        "topic denied",
+			transport:   fakeACLTransport{metadataVersion: 9, topicError: kafka.TopicAuthorizationFailed, group: allGroup},
+			wantMissing: []string{`Describe on topic "canary"`},
+		},
+		{
+			name:        "group denied",
+			transport:   fakeACLTransport{metadataVersion: 9, topic: allTopic, groupError: kafka.GroupAuthorizationFailed},
+			wantMissing: []string{`Describe on group "canary-group"`, `Read on group "canary-group"`},
+		},
+		{
+			name:        "group read missing",
+			transport:   fakeACLTransport{metadataVersion: 9, topic: allTopic, group: operations(aclDescribe)},
+			wantMissing: []string{`Read on group "canary-group"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kafkaClient := &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: tt.transport}
+			err := st.verifyPermissions(context.Background(), canary.Config{Topic: "canary", ConsumerGroupID: "canary-group"}, kafkaClient)
+			_, degraded := st.DegradedServices()["permissions"]
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.wantMissing != nil:
+				var missing *ErrMissingPermissions
+				require.ErrorAs(t, err, &missing)
+				assert.Equal(t, tt.wantMissing, missing.Missing)
+				assert.True(t, degraded)
+			default:
+				assert.NoError(t, err)
+				assert.False(t, degraded)
+			}
+		})
+	}
+}
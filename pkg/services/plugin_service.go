@@ -0,0 +1,72 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// pluginService runs an external executable as a check.
+//
+// The executable gets the canary settings through the environment (KAFKA_CANARY_BROKERS,
+// KAFKA_CANARY_TOPIC and KAFKA_CANARY_CLIENT_ID) and reports success with a zero exit code.
+// Any output written to stderr is included in the error when the check fails.
+type pluginService struct {
+	state           *State
+	config          canary.PluginConfig
+	canaryConfig    *canary.Config
+	connectorConfig client.ConnectorConfig
+	logger          *zerolog.Logger
+}
+
+func NewPluginService(state *State, pluginConfig canary.PluginConfig, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) CheckService {
+	return &pluginService{
+		state:           state,
+		config:          pluginConfig,
+		canaryConfig:    &canaryConfig,
+		connectorConfig: connectorConfig,
+		logger:          logger,
+	}
+}
+
+func (s *pluginService) Name() string {
+	return "plugin_" + s.config.Name
+}
+
+func (s *pluginService) Check(ctx context.Context) error {
+	if s.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.config.Path, s.config.Args...)
+	cmd.Env = append(os.Environ(),
+		"KAFKA_CANARY_BROKERS="+strings.Join(s.connectorConfig.BrokerAddrs, ","),
+		"KAFKA_CANARY_TOPIC="+s.canaryConfig.Topic,
+		"KAFKA_CANARY_CLIENT_ID="+s.canaryConfig.ClientID,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	s.logger.Debug().
+		Str("plugin", s.config.Name).
+		Str("stdout", stdout.String()).
+		Msg("Plugin check output")
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w: %s", s.config.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (s *pluginService) Close() {}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// testPlugin writes a shell script plugin and returns its check
+func testPlugin(t *testing.T, script string, timeout time.Duration, logger *zerolog.Logger) CheckService {
+	st := newTestState()
+	path := filepath.Join(t.TempDir(), "plugin.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o700))
+	return NewPluginService(st,
+		canary.PluginConfig{Name: "test", Path: path, Args: []string{"--subject", "orders"}, Timeout: timeout},
+		canary.Config{Topic: "canary", ClientID: "canary-client"},
+		client.ConnectorConfig{BrokerAddrs: []string{"broker-1:9092", "broker-2:9092"}},
+		logger,
+	)
+}
+
+func TestPluginCheck(t *testing.T) {
+	logger := zerolog.Nop()
+	tests := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		wantErr string
+	}{
+		{name: "success", script: "exit 0"},
+		{name: "failure", script: "echo 'schema rejected' >&2; exit 3", wantErr: "plugin test: exit status 3: schema rejected"},
+		{name: "timeout", script: "exec sleep 5", timeout: 100 * time.Millisecond, wantErr: "plugin test: signal: killed"},
+		{
+			name: "environment and arguments",
+			script: `[ "$KAFKA_CANARY_BROKERS" = "broker-1:9092,broker-2:9092" ] || exit 1
+[ "$KAFKA_CANARY_TOPIC" = "canary" ] || exit 1
+[ "$KAFKA_CANARY_CLIENT_ID" = "canary-client" ] || exit 1
+[ "$*" = "--subject orders" ] || exit 1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testPlugin(t, tt.script, tt.timeout, &logger)
+			assert.Equal(t, "plugin_test", s.Name())
+			start := time.Now()
+			err := s.Check(context.Background())
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
+
+func TestPluginCheckCanceled(t *testing.T) {
+	logger := zerolog.Nop()
+	s := testPlugin(t, "exec sleep 5", 0, &logger)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, s.Check(ctx), "the check context bounds plugins without a timeout")
+}
+
+func TestPluginCheckStdout(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs).Level(zerolog.DebugLevel)
+	s := testPlugin(t, "echo 'produced 1 record'", 0, &logger)
+	require.NoError(t, s.Check(context.Background()))
+	assert.Contains(t, logs.String(), `"stdout":"produced 1 record\n"`, "stdout is logged at debug")
+
+	logs.Reset()
+	logger = zerolog.New(&logs).Level(zerolog.InfoLevel)
+	s = testPlugin(t, "echo 'produced 1 record'", 0, &logger)
+	require.NoError(t, s.Check(context.Background()))
+	assert.Empty(t, logs.String())
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/describegroups"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// PrincipalInfo describes the identity the canary connects as and what it's allowed to do
+type PrincipalInfo struct {
+	Principal string `json:"principal"`
+	// where the principal was resolved from, e.g. sasl or tls-certificate
+	Source string `json:"source"`
+	Error  string `json:"error,omitempty"`
+	// ACLs of the principal, listing them requires Describe on the cluster
+	ACLs      []client.ACL `json:"acls,omitempty"`
+	ACLsError string       `json:"aclsError,omitempty"`
+	// operations the brokers authorize on the cluster, the canary topic and group
+	AuthorizedOperations      map[string][]string `json:"authorizedOperations,omitempty"`
+	AuthorizedOperationsError string              `json:"authorizedOperationsError,omitempty"`
+}
+
+// DescribePrincipal resolves the principal of the connections and looks up its ACLs and
+// authorized operations, the lookups failing are reported in the info
+func DescribePrincipal(ctx context.Context, canaryConfig canary.Config, connectorConfig client.ConnectorConfig) PrincipalInfo {
+	var info PrincipalInfo
+	principal, source, err := connectorConfig.ResolvePrincipal(ctx)
+	info.Principal, info.Source = principal, source
+	if err != nil {
+		info.Error = err.Error()
+	}
+
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		info.ACLsError = err.Error()
+		info.AuthorizedOperationsError = err.Error()
+		return info
+	}
+	if info.ACLs, err = connector.DescribeACLs(ctx, principal); err != nil {
+		info.ACLsError = err.Error()
+	}
+	if info.AuthorizedOperations, err = authorizedOperations(ctx, connector.KafkaClient, canaryConfig); err != nil {
+		info.AuthorizedOperationsError = err.Error()
+	}
+	return info
+}
+
+// PrincipalHandler serves the principal info as JSON, looked up on every request
+func PrincipalHandler(canaryConfig canary.Config, connectorConfig client.ConnectorConfig) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		info := DescribePrincipal(ctx, canaryConfig, connectorConfig)
+		rw.Header().Add("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(info)
+	})
+}
+
+// authorizedOperations returns the operations the brokers authorize on the cluster, the canary
+// topic and the consumer group
+func authorizedOperations(ctx context.Context, kafkaClient *kafka.Client, canaryConfig canary.Config) (map[string][]string, error) {
+	versions, err := kafkaClient.ApiVersions(ctx, &kafka.ApiVersionsRequest{})
+	if err == nil {
+		err = versions.Error
+	}
+	if err != nil {
+		return nil, kafkaerr.Wrap(err)
+	}
+	if !supportsVersion(versions, protocol.Metadata, 8) || !supportsVersion(versions, protocol.DescribeGroups, 3) {
+		return nil, ErrPermissionsUnsupported
+	}
+
+	operations := map[string][]string{}
+	response, err := kafkaClient.Transport.RoundTrip(ctx, kafkaClient.Addr, &metadata.Request{
+		TopicNames:                         []string{canaryConfig.Topic},
+		IncludeClusterAuthorizedOperations: true,
+		IncludeTopicAuthorizedOperations:   true,
+	})
+	if err != nil {
+		return nil, kafkaerr.Wrap(err)
+	}
+	meta := response.(*metadata.Response)
+	operations["cluster"] = operationNames(meta.ClusterAuthorizedOperations)
+	for _, t := range meta.Topics {
+		if t.ErrorCode == 0 {
+			operations[fmt.Sprintf("topic %q", t.Name)] = operationNames(t.TopicAuthorizedOperations)
+		}
+	}
+
+	if canaryConfig.ConsumerGroupID != "" {
+		response, err := kafkaClient.Transport.RoundTrip(ctx, kafkaClient.Addr, &describegroups.Request{
+			Groups:                      []string{canaryConfig.ConsumerGroupID},
+			IncludeAuthorizedOperations: true,
+		})
+		if err != nil {
+			return nil, kafkaerr.Wrap(err)
+		}
+		for _, g := range response.(*describegroups.Response).Groups {
+			if g.ErrorCode == 0 {
+				operations[fmt.Sprintf("group %q", g.GroupID)] = operationNames(g.AuthorizedOperations)
+			}
+		}
+	}
+	return operations, nil
+}
+
+// operationNames returns the names of the operations set in the authorized operations bits
+func operationNames(authorized int32) []string {
+	codes := make([]int, 0, len(client.ACLOperations))
+	for code := range client.ACLOperations {
+		if authorized > 0 && authorized&(1<<code) != 0 {
+			codes = append(codes, int(code))
+		}
+	}
+	sort.Ints(codes)
+	names := make([]string, 0, len(codes))
+	for _, code := range codes {
+		names = append(names, client.ACLOperations[int8(code)])
+	}
+	return names
+}
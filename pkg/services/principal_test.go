@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// writeClientCertificate writes a self-signed client certificate of the subject and its key,
+// returning their paths
+func writeClientCertificate(t *testing.T, subject pkix.Name) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), 0o600))
+	return certPath, keyPath
+}
+
+func TestPrincipalHandler(t *testing.T) {
+	certPath, keyPath := writeClientCertificate(t, pkix.Name{CommonName: "canary", Organization: []string{"example"}})
+	// nothing listens there, the principal is resolved without the cluster
+	brokers := []string{"127.0.0.1:1"}
+	tests := []struct {
+		name      string
+		connector client.ConnectorConfig
+		principal string
+		source    string
+	}{
+		{
+			name: "sasl",
+			connector: client.ConnectorConfig{
+				BrokerAddrs: brokers,
+				SASL:        client.SASLConfig{Enabled: true, Mechanism: client.SASLMechanismScramSHA512, Username: "canary", Password: "secret"},
+			},
+			principal: "User:canary",
+			source:    client.PrincipalSourceSASL,
+		},
+		{
+			name: "mtls",
+			connector: client.ConnectorConfig{
+				BrokerAddrs: brokers,
+				TLS:         client.TLSConfig{Enabled: true, CertPath: certPath, KeyPath: keyPath},
+			},
+			principal: "User:CN=canary,O=example",
+			source:    client.PrincipalSourceCertificate,
+		},
+		{
+			name:      "anonymous",
+			connector: client.ConnectorConfig{BrokerAddrs: brokers},
+			principal: "User:ANONYMOUS",
+			source:    client.PrincipalSourceAnonymous,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := PrincipalHandler(canary.Config{Topic: "__kafka_canary", ConsumerGroupID: "kafka-canary"}, tt.connector)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/principal", nil))
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var info PrincipalInfo
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+			assert.Equal(t, tt.principal, info.Principal)
+			assert.Equal(t, tt.source, info.Source)
+			assert.Empty(t, info.Error)
+			// the lookups needing the cluster fail, and are reported apart
+			assert.NotEmpty(t, info.ACLsError)
+			assert.NotEmpty(t, info.AuthorizedOperationsError)
+			assert.Empty(t, info.ACLs)
+			assert.Empty(t, info.AuthorizedOperations)
+		})
+	}
+}
+
+func TestDescribePrincipalCertificateError(t *testing.T) {
+	info := DescribePrincipal(context.Background(), canary.Config{}, client.ConnectorConfig{
+		BrokerAddrs: []string{"127.0.0.1:1"},
+		TLS:         client.TLSConfig{Enabled: true, CertPath: filepath.Join(t.TempDir(), "missing.pem")},
+	})
+	assert.Equal(t, "User:ANONYMOUS", info.Principal, "the static principal")
+	assert.Equal(t, client.PrincipalSourceCertificate, info.Source)
+	assert.NotEmpty(t, info.Error)
+}
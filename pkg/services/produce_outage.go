@@ -0,0 +1,81 @@
+package services
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var (
+	produceFailureAttempts = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "produce_failure_episode_attempts",
+		Namespace: metricsNamespace,
+		Help:      "Consecutive failed produce attempts of the failure episodes of the partition, observed on recovery",
+		Buckets:   []float64{1, 2, 3, 5, 10, 20, 50, 100},
+	}, []string{"partition"})
+
+	produceFailureRecovery = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "produce_failure_recovery_seconds",
+		Namespace: metricsNamespace,
+		Help:      "Time from the first failed produce attempt of a failure episode of the partition until a record is produced again",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"partition"})
+)
+
+// produceEpisode is a run of consecutive failed produce attempts to a partition
+type produceEpisode struct {
+	start    time.Time
+	failures int
+}
+
+// produceEpisodes tracks the failure episodes of each partition, so the client-visible impact of a
+// broker restart is measured in attempts and time to recover rather than only in errors
+type produceEpisodes struct {
+	state    *State
+	lock     sync.Mutex
+	episodes map[int]*produceEpisode
+	logger   *zerolog.Logger
+}
+
+func newProduceEpisodes(state *State, logger *zerolog.Logger) *produceEpisodes {
+	return &produceEpisodes{
+		state:    state,
+		episodes: map[int]*produceEpisode{},
+		logger:   logger,
+	}
+}
+
+// observe records the outcome of a produce attempt to the partition, observing the episode it
+// ends on success
+func (e *produceEpisodes) observe(partition int, err error, now time.Time) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	episode, ok := e.episodes[partition]
+	if err != nil {
+		if !ok {
+			episode = &produceEpisode{start: now}
+			e.episodes[partition] = episode
+		}
+		episode.failures++
+		return
+	}
+	if !ok {
+		return
+	}
+	delete(e.episodes, partition)
+	recovery := now.Sub(episode.start)
+	label := strconv.Itoa(partition)
+	produceFailureAttempts.In(e.state.metrics).WithLabelValues(label).Observe(float64(episode.failures))
+	produceFailureRecovery.In(e.state.metrics).WithLabelValues(label).Observe(recovery.Seconds())
+	e.logger.Info().
+		Int("partition", partition).
+		Int("failures", episode.failures).
+		Dur("recovery", recovery).
+		Msg("Producing to the partition recovered")
+	e.state.recordEvent(EventInfo, "producer", "producing to partition %d recovered after %d failed attempts in %s", partition, episode.failures, recovery)
+}
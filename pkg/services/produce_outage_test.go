@@ -0,0 +1,38 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProduceEpisodes(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	e := newProduceEpisodes(st, &logger)
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	failure := errors.New("not leader for partition")
+
+	// successes outside of an episode observe nothing
+	e.observe(0, nil, start)
+	assert.Equal(t, 0, testutil.CollectAndCount(produceFailureAttempts.In(st.metrics)))
+
+	// partition 1 fails three times in a row, partition 2 keeps failing
+	e.observe(1, failure, start)
+	e.observe(2, failure, start)
+	e.observe(1, failure, start.Add(time.Second))
+	e.observe(1, failure, start.Add(2*time.Second))
+	e.observe(1, nil, start.Add(4*time.Second))
+	assert.Equal(t, 1, testutil.CollectAndCount(produceFailureAttempts.In(st.metrics)))
+	assert.Equal(t, 1, testutil.CollectAndCount(produceFailureRecovery.In(st.metrics)))
+	assert.Len(t, e.episodes, 1)
+
+	// the next failure of partition 1 starts a new episode
+	e.observe(1, failure, start.Add(5*time.Second))
+	assert.Equal(t, 1, e.episodes[1].failures)
+	assert.Equal(t, start.Add(5*time.Second), e.episodes[1].start)
+}
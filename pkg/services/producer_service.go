@@ -0,0 +1,422 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	recordsProduced = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "records_produced_total",
+		Namespace: metricsNamespace,
+		Help:      "The total number of records produced",
+	}, []string{"clientid", "partition"})
+
+	recordsProducedFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "records_produced_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "The total number of records failed to produce",
+	}, []string{"clientid", "partition", "error_class"})
+
+	producerPaused = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "producer_paused",
+		Namespace: metricsNamespace,
+		Help:      "Whether producing is paused (1) because the canary consumer is lagging",
+	})
+
+	producerMetadataRefreshes = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "producer_metadata_refreshes_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of producer metadata refreshes, by trigger",
+	}, []string{"trigger"})
+
+	producerStaleMetadataRetries = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "producer_stale_metadata_retries_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records retried after a metadata refresh because the partition leader moved, by result",
+	}, []string{"result"})
+
+	consumerLagIntervals = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "consumer_lag_intervals",
+		Namespace: metricsNamespace,
+		Help:      "Produce intervals the canary consumer is behind its own records, on the most lagging partition",
+	})
+
+	// refreshProducerMetadataError = promauto.NewCounterVec(prometheus.CounterOpts{
+	// 	Name:      "producer_refresh_metadata_error_total",
+	// 	Namespace: metricsNamespace,
+	// 	Help:      "Total number of errors while refreshing producer metadata",
+	// }, []string{"clientid"})
+)
+
+type producerService struct {
+	state           *State
+	client          *client.Connector
+	producer        *kafka.Writer
+	canaryConfig    *canary.Config
+	connectorConfig client.ConnectorConfig
+	logger          *zerolog.Logger
+	chaos           *chaos
+	// index of the next message to send
+	index int
+	// LogAppendTime of the records written, by partition
+	appendTimes     map[int]time.Time
+	appendTimesLock sync.Mutex
+	// static headers added to every record
+	headers []kafka.Header
+	// partition leaders found by the last topic reconcile
+	leaders map[int]int
+	// last sequence produced by partition
+	sequences *sequenceStore
+	sampler   RecordsSampler
+	cipher    *recordCipher
+	// set while producing is paused for the consumer to catch up
+	paused bool
+	// broker connections opened by the end of the previous round, nil before the first one
+	connections *client.ConnectionStats
+	// consecutive produce failures by partition
+	outages *produceEpisodes
+	// nil without anomaly detection
+	anomalies *latencyDetector
+}
+
+func NewProducerService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (ProducerService, error) {
+	client, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Msg("Created producer service client")
+
+	cipher, err := newRecordCipher(canaryConfig.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	// opened last, it's closed with the service
+	sequences, err := openSequenceStore(canaryConfig.SequenceStateFile, sequenceScope(canaryConfig, connectorConfig), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	producer := &kafka.Writer{
+		Addr:      client.KafkaClient.Addr,
+		Transport: client.KafkaClient.Transport,
+		Topic:     canaryConfig.Topic,
+	}
+	logger.Info().Msg("Created producer service writer")
+
+	if c := canaryConfig.Coordination; c.Enabled && !contains(c.Instances, canaryConfig.InstanceID) {
+		logger.Warn().
+			Str("instance", canaryConfig.InstanceID).
+			Strs("instances", c.Instances).
+			Msg("Coordinated mode enabled but the instance is not listed, it won't produce")
+	}
+
+	s := &producerService{
+		state:           state,
+		client:          client,
+		producer:        producer,
+		canaryConfig:    &canaryConfig,
+		connectorConfig: connectorConfig,
+		logger:          logger,
+		chaos:           newChaos(canaryConfig.Chaos, state.metrics),
+		appendTimes:     map[int]time.Time{},
+		headers:         staticHeaders(canaryConfig.Headers),
+		sequences:       sequences,
+		cipher:          cipher,
+		outages:         newProduceEpisodes(state, logger),
+		anomalies:       newLatencyDetector(state, "produce", canaryConfig.Anomaly, logger),
+	}
+	producer.Completion = s.completed
+	return s, nil
+}
+
+func (s *producerService) Send(ctx context.Context, partitionAssignments []int) []ProduceResult {
+	numPartitions := len(partitionAssignments)
+	results := make([]ProduceResult, 0, numPartitions)
+	if s.backpressured(numPartitions) {
+		return results
+	}
+	for i := 0; i < numPartitions; i++ {
+		if !s.canaryConfig.Coordination.Owns(s.canaryConfig.InstanceID, i) {
+			continue
+		}
+		value := s.newCanaryMessage(i)
+		msg := kafka.Message{
+			Partition: i,
+			Value:     value.Encode(s.canaryConfig.RecordVersion),
+		}
+		if s.canaryConfig.InstanceID != "" {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: InstanceHeader, Value: []byte(s.canaryConfig.InstanceID)})
+		}
+		if s.canaryConfig.Coordination.Zone != "" {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: ZoneHeader, Value: []byte(s.canaryConfig.Coordination.Zone)})
+		}
+		msg.Headers = append(msg.Headers, s.headers...)
+		if s.cipher != nil {
+			sealed, err := s.cipher.seal(msg.Value)
+			if err != nil {
+				s.logger.Error().Err(err).Int("partition", i).Msg("Error encrypting the canary record")
+				results = append(results, ProduceResult{
+					Partition: i,
+					MessageID: value.MessageID,
+					Sequence:  value.Sequence,
+					Timestamp: time.UnixMilli(value.Timestamp),
+					Err:       err,
+				})
+				continue
+			}
+			msg.Value = sealed
+			msg.Headers = append(msg.Headers, kafka.Header{Key: KeyIDHeader, Value: []byte(s.cipher.keyID)})
+		}
+		s.logger.Info().
+			Str("value", value.String()).
+			Int("partition", i).
+			Msgf("Sending message")
+
+		s.chaos.produceDelay()
+		err := s.state.writeWithRefresh(func() error {
+			err := s.producer.WriteMessages(ctx, msg)
+			s.state.countKafkaError("Produce", err)
+			return err
+		}, func() { s.refresh(refreshStaleMetadata) })
+		if err == nil {
+			err = s.chaos.dropAck()
+		}
+		err = kafkaerr.Wrap(err)
+		timestamp := time.Now().UnixMilli()
+		labels := prometheus.Labels{
+			"clientid":  s.canaryConfig.ClientID,
+			"partition": fmt.Sprintf("%v", i),
+		}
+		recordsProduced.In(s.state.metrics).With(labels).Inc()
+		if s.sampler != nil {
+			s.sampler.RecordsProduced(1)
+		}
+
+		result := ProduceResult{
+			Partition: i,
+			MessageID: value.MessageID,
+			Sequence:  value.Sequence,
+			Timestamp: time.UnixMilli(value.Timestamp),
+			Err:       err,
+		}
+		if err != nil {
+			s.logger.Warn().Err(err).Int("partition", i).Msg("Error sending message")
+			recordsProducedFailed.In(s.state.metrics).With(prometheus.Labels{
+				"clientid":    s.canaryConfig.ClientID,
+				"partition":   labels["partition"],
+				"error_class": string(kafkaerr.ClassOf(err)),
+			}).Inc()
+		} else {
+			duration := timestamp - value.Timestamp
+			s.logger.Info().
+				Int("partition", i).
+				Int64("duration", duration).
+				Msgf("Message sent")
+			s.state.recordsProducedLatency.With(labels).Observe(float64(duration))
+			result.Latency = time.Duration(duration) * time.Millisecond
+			s.anomalies.observe(result.Latency)
+			result.LogAppendTime = s.appendTime(i)
+			s.state.markProduced(i)
+			// only the acknowledged records can be consumed
+			atomic.AddUint64(&s.state.recordsCount.produced, 1)
+			// the sequence of a failed record is reused, so it's only a duplicate if it was written
+			s.sequences.set(producedSequenceKey(i), value.Sequence)
+		}
+		s.outages.observe(i, err, time.UnixMilli(timestamp))
+		s.state.observeRollProduce(i, result.Latency, err)
+		s.state.observeRackProduce(i, result.Latency, err)
+		results = append(results, result)
+	}
+	s.observeTimestampSkews(results)
+	s.state.exportWriterStats(s.producer.Stats())
+	connections := s.client.Stats()
+	s.state.exportConnectionStats(s.connections, connections)
+	s.connections = &connections
+	return results
+}
+
+// SetRecordsSampler sets the sampler of the records sent, it has to be set before sending
+func (s *producerService) SetRecordsSampler(sampler RecordsSampler) {
+	s.sampler = sampler
+}
+
+// Triggers of the producer metadata refreshes
+const (
+	// the topic reconcile found other partition leaders
+	refreshLeaderChange = "leader_change"
+	// a record was rejected by a broker no longer leading the partition
+	refreshStaleMetadata = "stale_metadata"
+)
+
+// Refresh drops the writer connections, so the partition leaders are looked up again instead of
+// waiting for the cached metadata to expire
+func (s *producerService) Refresh() {
+	s.refresh(refreshLeaderChange)
+}
+
+func (s *producerService) refresh(trigger string) {
+	s.logger.Info().Str("trigger", trigger).Msg("Producer refreshing metadata")
+	producerMetadataRefreshes.In(s.state.metrics).WithLabelValues(trigger).Inc()
+	if transport, ok := s.producer.Transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// writeWithRefresh writes a record, refreshing the metadata and retrying once right away when the
+// partition leader moved, like well-behaved clients do, before the write counts as failed
+func (st *State) writeWithRefresh(write func() error, refresh func()) error {
+	err := write()
+	if kafkaerr.ClassOf(err) != kafkaerr.ClassNotLeader {
+		return err
+	}
+	refresh()
+	err = write()
+	if err != nil {
+		producerStaleMetadataRetries.In(st.metrics).WithLabelValues("failed").Inc()
+	} else {
+		producerStaleMetadataRetries.In(st.metrics).WithLabelValues("succeeded").Inc()
+	}
+	return err
+}
+
+// SetLeaders updates the partition leaders known by the producer, refreshing the writer metadata
+// when they changed since the previous reconcile, so the records go to the new leaders right away
+func (s *producerService) SetLeaders(leaders map[int32]int32) {
+	previous := s.leaders
+	s.leaders = make(map[int]int, len(leaders))
+	changed := len(leaders) != len(previous)
+	for partition, leader := range leaders {
+		s.leaders[int(partition)] = int(leader)
+		if l, ok := previous[int(partition)]; !ok || l != int(leader) {
+			changed = true
+		}
+	}
+	if previous != nil && changed {
+		s.refresh(refreshLeaderChange)
+	}
+}
+
+func (s *producerService) Close() {
+	s.logger.Info().Msg("Closing producer")
+	err := s.producer.Close()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Error closing the kafka producer")
+		s.state.markDegraded("producer", err)
+	}
+	s.sequences.close()
+	s.logger.Info().Msg("Producer closed")
+}
+
+func (s *producerService) newCanaryMessage(partition int) CanaryMessage {
+	gap := s.chaos.sequenceGap()
+	s.index += 1 + gap
+	timestamp := time.Now().UnixMilli()
+	cm := CanaryMessage{
+		ProducerID: s.canaryConfig.ClientID,
+		MessageID:  s.index,
+		Sequence:   s.sequences.get(producedSequenceKey(partition)) + 1 + int64(gap),
+		Timestamp:  timestamp,
+	}
+	return cm
+}
+
+// backpressured returns true while producing is paused because the consumer fell more than the
+// configured intervals behind the records of this instance, so a saturated cluster doesn't
+// inflate the loss statistics. Producing resumes once the consumer is at most an interval behind.
+func (s *producerService) backpressured(numPartitions int) bool {
+	config := s.canaryConfig.Backpressure
+	if !config.Enabled {
+		return false
+	}
+
+	// a record is produced to every partition each interval, the sequences gap is the intervals
+	var lag int64
+	for i := 0; i < numPartitions; i++ {
+		consumed := s.sequences.get(consumedSequenceKey(s.canaryConfig.InstanceID, i))
+		// the partitions not consumed yet since the canary started are left to stall detection
+		if consumed == 0 {
+			continue
+		}
+		if behind := s.sequences.get(producedSequenceKey(i)) - consumed; behind > lag {
+			lag = behind
+		}
+	}
+	consumerLagIntervals.In(s.state.metrics).Set(float64(lag))
+
+	switch {
+	case !s.paused && lag > config.MaxLagIntervals:
+		s.paused = true
+		s.logger.Warn().Int64("lag_intervals", lag).Msg("Consumer lagging, pausing the producer")
+		s.state.recordEvent(EventWarning, "producer", "consumer %d intervals behind, producing paused", lag)
+	case s.paused && lag <= 1:
+		s.paused = false
+		s.logger.Info().Msg("Consumer caught up, resuming the producer")
+		s.state.recordEvent(EventInfo, "producer", "consumer caught up, producing resumed")
+	}
+	if s.paused {
+		producerPaused.In(s.state.metrics).Set(1)
+		atomic.StoreInt32(&s.state.producingPaused, 1)
+	} else {
+		producerPaused.In(s.state.metrics).Set(0)
+		atomic.StoreInt32(&s.state.producingPaused, 0)
+	}
+	return s.paused
+}
+
+// ProducerPaused returns true while producing is paused for the canary consumer to catch up
+func (st *State) ProducerPaused() bool {
+	return atomic.LoadInt32(&st.producingPaused) == 1
+}
+
+func producedSequenceKey(partition int) string {
+	return "produced/" + strconv.Itoa(partition)
+}
+
+// consumedSequencePrefix starts the keys of the consumed sequences
+const consumedSequencePrefix = "consumed/"
+
+// consumedSequenceKey returns the key of the sequence of the partition produced by the source
+// instance and verified by the consumer
+func consumedSequenceKey(source string, partition int) string {
+	return consumedSequencePrefix + source + "/" + strconv.Itoa(partition)
+}
+
+// staticHeaders returns the configured headers sorted by key
+func staticHeaders(headers map[string]string) []kafka.Header {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]kafka.Header, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, kafka.Header{Key: key, Value: []byte(headers[key])})
+	}
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestProducerBackpressure(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	sequences, err := openSequenceStore("", t.Name(), &logger)
+	require.NoError(t, err)
+	defer sequences.close()
+	s := &producerService{
+		state: st,
+		canaryConfig: &canary.Config{
+			InstanceID:   "canary-0",
+			Backpressure: canary.BackpressureConfig{Enabled: true, MaxLagIntervals: 3},
+		},
+		sequences: sequences,
+		logger:    &logger,
+	}
+	produce := func(partition int, sequence int64) {
+		sequences.set(producedSequenceKey(partition), sequence)
+	}
+	consume := func(partition int, sequence int64) {
+		sequences.set(consumedSequenceKey("canary-0", partition), sequence)
+	}
+
+	// nothing consumed yet
+	produce(0, 10)
+	assert.False(t, s.backpressured(2))
+
+	consume(0, 7)
+	produce(1, 10)
+	consume(1, 9)
+	assert.False(t, s.backpressured(2), "3 intervals behind")
+
+	produce(0, 11)
+	assert.True(t, s.backpressured(2), "4 intervals behind")
+
+	consume(0, 9)
+	assert.True(t, s.backpressured(2), "still 2 intervals behind")
+
+	consume(0, 10)
+	assert.False(t, s.backpressured(2), "caught up")
+}
+
+func TestWriteWithRefresh(t *testing.T) {
+	st := newTestState()
+	notLeader := kafka.WriteErrors{kafka.NotLeaderForPartition}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		writes    int
+		refreshes int
+	}{
+		{name: "written", errs: []error{nil}, writes: 1},
+		{name: "other error not retried", errs: []error{kafka.NotEnoughReplicas}, wantErr: kafka.NotEnoughReplicas, writes: 1},
+		{name: "retried after refresh", errs: []error{notLeader, nil}, writes: 2, refreshes: 1},
+		{name: "retried once", errs: []error{notLeader, notLeader}, wantErr: notLeader, writes: 2, refreshes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writes, refreshes := 0, 0
+			err := st.writeWithRefresh(func() error {
+				writes++
+				return tt.errs[writes-1]
+			}, func() { refreshes++ })
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.writes, writes)
+			assert.Equal(t, tt.refreshes, refreshes)
+		})
+	}
+}
+
+// idleTransport counts the writer connections dropped
+type idleTransport struct {
+	closed int
+}
+
+func (t *idleTransport) RoundTrip(context.Context, net.Addr, kafka.Request) (kafka.Response, error) {
+	return nil, nil
+}
+
+func (t *idleTransport) CloseIdleConnections() {
+	t.closed++
+}
+
+func TestProducerSetLeaders(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	transport := &idleTransport{}
+	s := &producerService{
+		state:    st,
+		producer: &kafka.Writer{Transport: transport},
+		logger:   &logger,
+	}
+	refreshes := producerMetadataRefreshes.In(st.metrics).WithLabelValues(refreshLeaderChange)
+	before := testutil.ToFloat64(refreshes)
+
+	s.SetLeaders(map[int32]int32{0: 1, 1: 2})
+	assert.Equal(t, 0, transport.closed, "first leaders")
+	assert.Equal(t, map[int]int{0: 1, 1: 2}, s.leaders)
+
+	s.SetLeaders(map[int32]int32{0: 1, 1: 2})
+	assert.Equal(t, 0, transport.closed, "same leaders")
+
+	s.SetLeaders(map[int32]int32{0: 1, 1: 3})
+	assert.Equal(t, 1, transport.closed, "leader moved")
+	assert.Equal(t, map[int]int{0: 1, 1: 3}, s.leaders)
+
+	s.SetLeaders(map[int32]int32{0: 1, 1: 3, 2: 2})
+	assert.Equal(t, 2, transport.closed, "partition added")
+
+	s.SetLeaders(map[int32]int32{0: 1, 1: 3})
+	assert.Equal(t, 3, transport.closed, "partition removed")
+	assert.Equal(t, before+3, testutil.ToFloat64(refreshes))
+}
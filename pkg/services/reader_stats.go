@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var (
+	readerDials = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "consumer_reader_dials_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of connections dialed by the consumer reader",
+	})
+
+	readerFetches = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "consumer_reader_fetches_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of fetch requests sent by the consumer reader",
+	})
+
+	readerMessages = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "consumer_reader_messages_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records fetched by the consumer reader",
+	})
+
+	readerBytes = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "consumer_reader_bytes_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of record bytes fetched by the consumer reader",
+	})
+
+	readerRebalances = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "consumer_reader_rebalances_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of consumer group rebalances seen by the consumer reader",
+	})
+
+	readerTimeouts = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "consumer_reader_timeouts_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of timeouts of the consumer reader",
+	})
+
+	readerErrors = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "consumer_reader_errors_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of errors of the consumer reader",
+	})
+
+	readerLag = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "consumer_reader_lag",
+		Namespace: metricsNamespace,
+		Help:      "Lag of the consumer reader as reported by kafka-go",
+	})
+
+	readerQueueLength = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "consumer_reader_queue_length",
+		Namespace: metricsNamespace,
+		Help:      "Number of records fetched by the consumer reader and not read yet",
+	})
+
+	readerFetchBytes = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "consumer_reader_fetch_bytes",
+		Namespace: metricsNamespace,
+		Help:      "Size of the consumer reader fetches in bytes",
+	}, []string{"stat"})
+
+	readerDialTime = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "consumer_reader_dial_time",
+		Namespace: metricsNamespace,
+		Help:      "Time spent dialing the brokers by the consumer reader in milliseconds",
+	}, []string{"stat"})
+
+	readerReadTime = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "consumer_reader_read_time",
+		Namespace: metricsNamespace,
+		Help:      "Time spent reading the fetch responses by the consumer reader in milliseconds",
+	}, []string{"stat"})
+
+	readerWaitTime = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "consumer_reader_wait_time",
+		Namespace: metricsNamespace,
+		Help:      "Time the consumer reader waited for the fetch responses in milliseconds",
+	}, []string{"stat"})
+)
+
+// exportReaderStats exports the reader stats since the previous call, kafka-go resets the
+// counters on every snapshot
+func (st *State) exportReaderStats(stats kafka.ReaderStats) {
+	readerDials.In(st.metrics).Add(float64(stats.Dials))
+	readerFetches.In(st.metrics).Add(float64(stats.Fetches))
+	readerMessages.In(st.metrics).Add(float64(stats.Messages))
+	readerBytes.In(st.metrics).Add(float64(stats.Bytes))
+	readerRebalances.In(st.metrics).Add(float64(stats.Rebalances))
+	readerTimeouts.In(st.metrics).Add(float64(stats.Timeouts))
+	readerErrors.In(st.metrics).Add(float64(stats.Errors))
+
+	readerLag.In(st.metrics).Set(float64(stats.Lag))
+	readerQueueLength.In(st.metrics).Set(float64(stats.QueueLength))
+	for stat, value := range map[string]int64{
+		"avg": stats.FetchBytes.Avg,
+		"min": stats.FetchBytes.Min,
+		"max": stats.FetchBytes.Max,
+	} {
+		readerFetchBytes.In(st.metrics).WithLabelValues(stat).Set(float64(value))
+	}
+	setDurationStats(readerDialTime.In(st.metrics), stats.DialTime)
+	setDurationStats(readerReadTime.In(st.metrics), stats.ReadTime)
+	setDurationStats(readerWaitTime.In(st.metrics), stats.WaitTime)
+}
+
+// exportStats exports the reader stats every reconcile interval until the context is done, and
+// opens the rebalance impact window when the group rebalanced since the previous stats. The
+// rebalances of the first stats are the initial join.
+func (s *consumerService) exportStats(ctx context.Context) {
+	defer s.state.TrackGoroutine("consumer_stats")()
+	ticker := time.NewTicker(s.canaryConfig.ReconcileInterval)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		select {
+		case now := <-ticker.C:
+			stats := s.consumer.Stats()
+			s.state.exportReaderStats(stats)
+			if stats.Rebalances > 0 && !last.IsZero() {
+				s.rebalances.rebalanced(last, now)
+			}
+			last = now
+		case <-ctx.Done():
+			return
+		}
+	}
+}
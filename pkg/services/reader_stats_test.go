@@ -0,0 +1,41 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportReaderStats(t *testing.T) {
+	st := newTestState()
+	fetches, rebalances, errs := testutil.ToFloat64(readerFetches.In(st.metrics)), testutil.ToFloat64(readerRebalances.In(st.metrics)), testutil.ToFloat64(readerErrors.In(st.metrics))
+	stats := kafka.ReaderStats{
+		Fetches:     5,
+		Rebalances:  1,
+		Lag:         42,
+		QueueLength: 3,
+		FetchBytes:  kafka.SummaryStats{Avg: 512, Min: 128, Max: 1024},
+		ReadTime:    kafka.DurationStats{Avg: 15 * time.Millisecond},
+	}
+
+	// kafka-go resets the counters on every snapshot, they are added up
+	st.exportReaderStats(stats)
+	st.exportReaderStats(stats)
+	assert.Equal(t, fetches+10, testutil.ToFloat64(readerFetches.In(st.metrics)))
+	assert.Equal(t, rebalances+2, testutil.ToFloat64(readerRebalances.In(st.metrics)))
+	assert.Equal(t, errs, testutil.ToFloat64(readerErrors.In(st.metrics)))
+	assert.Equal(t, 42.0, testutil.ToFloat64(readerLag.In(st.metrics)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(readerQueueLength.In(st.metrics)))
+	assert.Equal(t, 512.0, testutil.ToFloat64(readerFetchBytes.In(st.metrics).WithLabelValues("avg")))
+	assert.Equal(t, 128.0, testutil.ToFloat64(readerFetchBytes.In(st.metrics).WithLabelValues("min")))
+	assert.Equal(t, 1024.0, testutil.ToFloat64(readerFetchBytes.In(st.metrics).WithLabelValues("max")))
+	assert.Equal(t, 15.0, testutil.ToFloat64(readerReadTime.In(st.metrics).WithLabelValues("avg")))
+
+	// the gauges follow the last snapshot
+	st.exportReaderStats(kafka.ReaderStats{Errors: 1})
+	assert.Equal(t, errs+1, testutil.ToFloat64(readerErrors.In(st.metrics)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(readerLag.In(st.metrics)))
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var (
+	rebalanceImpactRecords = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "consumer_rebalance_impact_records",
+		Namespace: metricsNamespace,
+		Help:      "Records delayed or never consumed during each canary consumer group rebalance, by impact",
+		Buckets:   []float64{0, 1, 5, 10, 50, 100, 500, 1000},
+	}, []string{"impact"})
+
+	rebalanceImpactDuration = metrics.NewHistogram(prometheus.HistogramOpts{
+		Name:      "consumer_rebalance_impact_duration",
+		Namespace: metricsNamespace,
+		Help:      "Time from a canary consumer group rebalance until the records are consumed within the delay threshold again in milliseconds",
+		Buckets:   []float64{100, 500, 1000, 5000, 10000, 30000, 60000, 300000},
+	})
+)
+
+// rebalanceImpact accounts the records delayed or lost while the canary consumer group
+// rebalances. The rebalances are only seen in the reader stats, so the window opens at the
+// previous stats snapshot and closes with the first record produced after the rebalance was seen
+// and consumed within the delay threshold.
+type rebalanceImpact struct {
+	state     *State
+	threshold time.Duration
+	logger    *zerolog.Logger
+
+	lock sync.Mutex
+	// window start and when the rebalance was seen, zero while no rebalance is in progress
+	start    time.Time
+	detected time.Time
+	// records of the window
+	delayed    int64
+	unconsumed int64
+}
+
+func newRebalanceImpact(state *State, threshold time.Duration, logger *zerolog.Logger) *rebalanceImpact {
+	return &rebalanceImpact{state: state, threshold: threshold, logger: logger}
+}
+
+// rebalanced opens the window of a rebalance seen at now and started after since, a rebalance
+// seen within an open window extends it
+func (r *rebalanceImpact) rebalanced(since, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.start.IsZero() {
+		r.start = since
+	}
+	r.detected = now
+}
+
+// consumed accounts a record produced at the given time and consumed with the latency, closing
+// the window once the records are consumed within the threshold again
+func (r *rebalanceImpact) consumed(produced time.Time, latency time.Duration, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.start.IsZero() || produced.Before(r.start) {
+		return
+	}
+	if latency > r.threshold {
+		r.delayed++
+		return
+	}
+	if produced.Before(r.detected) {
+		return
+	}
+
+	duration := now.Sub(r.start)
+	rebalanceImpactRecords.In(r.state.metrics).WithLabelValues("delayed").Observe(float64(r.delayed))
+	rebalanceImpactRecords.In(r.state.metrics).WithLabelValues("unconsumed").Observe(float64(r.unconsumed))
+	rebalanceImpactDuration.In(r.state.metrics).Observe(float64(duration.Milliseconds()))
+	if r.delayed > 0 || r.unconsumed > 0 {
+		r.state.recordEvent(EventWarning, "consumer", "consumer group rebalance delayed %d records and lost %d over %s",
+			r.delayed, r.unconsumed, duration.Round(time.Millisecond))
+	}
+	r.logger.Info().
+		Int64("delayed", r.delayed).
+		Int64("unconsumed", r.unconsumed).
+		Dur("duration", duration).
+		Msg("Consumer group rebalance impact")
+	r.start, r.detected = time.Time{}, time.Time{}
+	r.delayed, r.unconsumed = 0, 0
+}
+
+// lost accounts the records found missing during the window
+func (r *rebalanceImpact) lost(records int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.start.IsZero() {
+		r.unconsumed += records
+	}
+}
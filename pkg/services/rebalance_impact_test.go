@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebalanceImpact(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	r := newRebalanceImpact(st, time.Second, &logger)
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// no window is open before a rebalance is seen
+	r.consumed(start, 5*time.Second, start.Add(5*time.Second))
+	r.lost(1)
+
+	r.rebalanced(start, start.Add(10*time.Second))
+	// produced before the window
+	r.consumed(start.Add(-time.Second), 5*time.Second, start.Add(4*time.Second))
+	// delayed by the rebalance
+	r.consumed(start.Add(time.Second), 8*time.Second, start.Add(9*time.Second))
+	r.consumed(start.Add(2*time.Second), 7*time.Second, start.Add(9*time.Second))
+	r.lost(3)
+	// produced before the rebalance was seen, the window stays open
+	r.consumed(start.Add(9*time.Second), 100*time.Millisecond, start.Add(9100*time.Millisecond))
+	assert.Equal(t, 0, testutil.CollectAndCount(rebalanceImpactRecords.In(st.metrics)))
+
+	// caught up
+	r.consumed(start.Add(11*time.Second), 100*time.Millisecond, start.Add(11100*time.Millisecond))
+	for impact, want := range map[string]float64{"delayed": 2, "unconsumed": 3} {
+		var m dto.Metric
+		require.NoError(t, rebalanceImpactRecords.In(st.metrics).WithLabelValues(impact).(prometheus.Histogram).Write(&m))
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount(), impact)
+		assert.Equal(t, want, m.GetHistogram().GetSampleSum(), impact)
+	}
+
+	// the window is closed
+	r.consumed(start.Add(12*time.Second), 5*time.Second, start.Add(17*time.Second))
+	r.lost(1)
+	assert.Equal(t, int64(0), r.delayed+r.unconsumed)
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	replayRecords = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "replay_records_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of canary records re-consumed by the replay check, by result",
+	}, []string{"result"})
+
+	replayMissing = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "replay_records_missing_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records missing from the sequences re-consumed by the replay check",
+	})
+
+	replayDuration = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "replay_duration",
+		Namespace: metricsNamespace,
+		Help:      "Time taken by the last replay in milliseconds",
+	})
+)
+
+// replayService rewinds a secondary consumer group back over the recent canary records and
+// re-consumes them, verifying they are all still readable and intact. Real consumers rely on
+// rereading for reprocessing, which the canary consumer reading the head never exercises.
+type replayService struct {
+	state        *State
+	connector    *client.Connector
+	cipher       *recordCipher
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewReplayService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := newRecordCipher(canaryConfig.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replayService{
+		state:        state,
+		connector:    connector,
+		cipher:       cipher,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *replayService) Name() string {
+	return "replay"
+}
+
+func (s *replayService) Interval() time.Duration {
+	return s.canaryConfig.Replay.Interval
+}
+
+func (s *replayService) Check(ctx context.Context) error {
+	start := time.Now()
+	from, to, err := s.offsets(ctx, start.Add(-s.canaryConfig.Replay.Lookback))
+	if err != nil {
+		return err
+	}
+	// the group is rewound like an operator resetting it to reprocess
+	if err := s.commit(ctx, from); err != nil {
+		return err
+	}
+
+	verifier := newReplayVerifier(s.state, s.cipher)
+	for partition, offset := range from {
+		if err := s.replay(ctx, verifier, partition, offset, to[partition]); err != nil {
+			return err
+		}
+	}
+	if err := s.commit(ctx, to); err != nil {
+		return err
+	}
+	replayDuration.In(s.state.metrics).Set(float64(time.Since(start).Milliseconds()))
+
+	s.logger.Debug().
+		Int64("replayed", verifier.replayed).
+		Int64("corrupted", verifier.corrupted).
+		Int64("missing", verifier.missing).
+		Msg("Replayed the canary records")
+	if verifier.corrupted > 0 || verifier.missing > 0 {
+		return fmt.Errorf("replay of the last %s found %d corrupted and %d missing records",
+			s.canaryConfig.Replay.Lookback, verifier.corrupted, verifier.missing)
+	}
+	return nil
+}
+
+func (s *replayService) Close() {}
+
+// groupID returns the secondary group rewound by the replay, derived from the canary one unless set
+func (s *replayService) groupID() string {
+	if s.canaryConfig.Replay.GroupID != "" {
+		return s.canaryConfig.Replay.GroupID
+	}
+	return s.canaryConfig.ConsumerGroupID + "-replay"
+}
+
+// offsets returns by partition the offset of the first record appended after the timestamp and
+// the end offset, the partitions without records since are left out
+func (s *replayService) offsets(ctx context.Context, since time.Time) (map[int]int64, map[int]int64, error) {
+	topic := s.canaryConfig.Topic
+	metadata, err := s.connector.KafkaClient.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		s.state.countKafkaError("Metadata", err)
+		return nil, nil, kafkaerr.Wrap(err)
+	}
+	var requests []kafka.OffsetRequest
+	for _, t := range metadata.Topics {
+		for _, p := range t.Partitions {
+			requests = append(requests, kafka.TimeOffsetOf(p.ID, since), kafka.LastOffsetOf(p.ID))
+		}
+	}
+	resp, err := s.connector.KafkaClient.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		s.state.countKafkaError("ListOffsets", err)
+		return nil, nil, kafkaerr.Wrap(err)
+	}
+
+	from, to := map[int]int64{}, map[int]int64{}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			s.state.countKafkaError("ListOffsets", p.Error)
+			return nil, nil, kafkaerr.Wrap(p.Error)
+		}
+		for offset := range p.Offsets {
+			if offset >= 0 && offset < p.LastOffset {
+				from[p.Partition] = offset
+				to[p.Partition] = p.LastOffset
+			}
+		}
+	}
+	return from, to, nil
+}
+
+// commit sets the offsets of the secondary group, outside any group generation
+func (s *replayService) commit(ctx context.Context, offsets map[int]int64) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	commits := make([]kafka.OffsetCommit, 0, len(offsets))
+	for partition, offset := range offsets {
+		commits = append(commits, kafka.OffsetCommit{Partition: partition, Offset: offset})
+	}
+	resp, err := s.connector.KafkaClient.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      s.groupID(),
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{s.canaryConfig.Topic: commits},
+	})
+	if err != nil {
+		s.state.countKafkaError("OffsetCommit", err)
+		return kafkaerr.Wrap(err)
+	}
+	for _, p := range resp.Topics[s.canaryConfig.Topic] {
+		if p.Error != nil {
+			s.state.countKafkaError("OffsetCommit", p.Error)
+			return kafkaerr.Wrap(p.Error)
+		}
+	}
+	return nil
+}
+
+// replay fetches the partition records from start until end, verifying each of them
+func (s *replayService) replay(ctx context.Context, verifier *replayVerifier, partition int, start, end int64) error {
+	offset := start
+	for offset < end {
+		fetched, err := s.connector.KafkaClient.Fetch(ctx, &kafka.FetchRequest{
+			Topic:     s.canaryConfig.Topic,
+			Partition: partition,
+			Offset:    offset,
+			MinBytes:  1,
+			MaxBytes:  1 << 20,
+			MaxWait:   time.Second,
+		})
+		if err == nil {
+			err = fetched.Error
+		}
+		if err != nil {
+			s.state.countKafkaError("Fetch", err)
+			return kafkaerr.Wrap(err)
+		}
+		for offset < end {
+			record, err := fetched.Records.ReadRecord()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("reading record at offset %d of partition %d: %w", offset, partition, err)
+			}
+			// the first batch returned can start before the requested offset
+			if record.Offset < offset {
+				continue
+			}
+			message := kafka.Message{Partition: partition, Offset: record.Offset, Headers: record.Headers}
+			if record.Value != nil {
+				if message.Value, err = io.ReadAll(record.Value); err != nil {
+					return err
+				}
+			}
+			verifier.verify(message)
+			offset = record.Offset + 1
+		}
+	}
+	return nil
+}
+
+// replayVerifier checks the replayed records decode and their sequences have no gap
+type replayVerifier struct {
+	state     *State
+	cipher    *recordCipher
+	sequences map[sequenceSource]int64
+
+	replayed  int64
+	corrupted int64
+	missing   int64
+}
+
+func newReplayVerifier(state *State, cipher *recordCipher) *replayVerifier {
+	return &replayVerifier{state: state, cipher: cipher, sequences: map[sequenceSource]int64{}}
+}
+
+// verify checks the record, the records of checks and the ones encrypted with unknown keys or by
+// newer canaries are skipped
+func (v *replayVerifier) verify(message kafka.Message) {
+	if _, ok := headerBytes(message, CheckHeader); ok {
+		return
+	}
+	value := message.Value
+	if keyID, ok := headerValue(message, KeyIDHeader); ok {
+		opened, err := v.cipher.open(keyID, value)
+		if errors.Is(err, ErrUnknownKey) {
+			replayRecords.In(v.state.metrics).WithLabelValues("skipped").Inc()
+			return
+		}
+		if err != nil {
+			v.corrupt()
+			return
+		}
+		value = opened
+	}
+	canaryMessage, err := NewCanaryMessage(value)
+	if isUnsupportedRecordVersion(err) {
+		replayRecords.In(v.state.metrics).WithLabelValues("skipped").Inc()
+		return
+	}
+	if err != nil {
+		v.corrupt()
+		return
+	}
+	v.replayed++
+	replayRecords.In(v.state.metrics).WithLabelValues("verified").Inc()
+
+	// records of canaries predating sequences
+	if canaryMessage.Sequence == 0 {
+		return
+	}
+	source, _ := headerValue(message, InstanceHeader)
+	key := sequenceSource{source, message.Partition}
+	// the first sequence of each source is where the replay started
+	if last, ok := v.sequences[key]; ok && canaryMessage.Sequence > last+1 {
+		missing := canaryMessage.Sequence - last - 1
+		v.missing += missing
+		replayMissing.In(v.state.metrics).Add(float64(missing))
+	}
+	if last, ok := v.sequences[key]; !ok || canaryMessage.Sequence > last {
+		v.sequences[key] = canaryMessage.Sequence
+	}
+}
+
+func (v *replayVerifier) corrupt() {
+	v.corrupted++
+	replayRecords.In(v.state.metrics).WithLabelValues("corrupted").Inc()
+}
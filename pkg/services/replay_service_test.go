@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayVerifier(t *testing.T) {
+	st := newTestState()
+	messages := testRecords(6)
+	v := newReplayVerifier(st, nil)
+	for i, message := range messages {
+		// retention deleted nothing in the middle but records 3 and 4 are gone
+		if i == 2 || i == 3 {
+			continue
+		}
+		v.verify(message)
+	}
+	v.verify(kafka.Message{Partition: 1, Value: []byte("garbage")})
+	v.verify(kafka.Message{Partition: 1, Value: []byte("x"), Headers: []kafka.Header{{Key: CheckHeader, Value: []byte("message_size")}}})
+	v.verify(kafka.Message{Partition: 1, Value: []byte("x"), Headers: []kafka.Header{{Key: KeyIDHeader, Value: []byte("other")}}})
+
+	assert.Equal(t, int64(4), v.replayed)
+	assert.Equal(t, int64(2), v.missing)
+	assert.Equal(t, int64(1), v.corrupted)
+}
+
+func TestReplayVerifierDuplicates(t *testing.T) {
+	st := newTestState()
+	messages := testRecords(3)
+	v := newReplayVerifier(st, nil)
+	for _, message := range append(messages, messages...) {
+		v.verify(message)
+	}
+	assert.Equal(t, int64(6), v.replayed)
+	assert.Zero(t, v.missing)
+}
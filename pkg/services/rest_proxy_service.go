@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var restProxyLatency = metrics.NewHistogramVec(prometheus.HistogramOpts{
+	Name:      "rest_proxy_latency",
+	Namespace: metricsNamespace,
+	Help:      "Latency of the records produced through the REST Proxy in milliseconds, until acknowledged or consumed",
+	Buckets:   []float64{10, 50, 100, 500, 1000, 5000},
+}, []string{"stage"})
+
+// restProxyService produces a record through a Confluent REST Proxy and consumes it with the
+// native client, the end-to-end signal of the environments exposing Kafka over HTTP
+type restProxyService struct {
+	state        *State
+	connector    *client.Connector
+	http         *http.Client
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewRestProxyService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &restProxyService{
+		state:        state,
+		connector:    connector,
+		http:         &http.Client{},
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *restProxyService) Name() string {
+	return "rest_proxy"
+}
+
+func (s *restProxyService) Interval() time.Duration {
+	return s.canaryConfig.RestProxy.Interval
+}
+
+func (s *restProxyService) Check(ctx context.Context) error {
+	value := []byte(fmt.Sprintf("%s-%d", s.Name(), time.Now().UnixNano()))
+
+	start := time.Now()
+	partition, offset, err := s.produce(ctx, value)
+	if err != nil {
+		return err
+	}
+	restProxyLatency.In(s.state.metrics).WithLabelValues("produce").Observe(float64(time.Since(start).Milliseconds()))
+
+	if err := s.consume(ctx, partition, offset, value); err != nil {
+		return err
+	}
+	restProxyLatency.In(s.state.metrics).WithLabelValues("end_to_end").Observe(float64(time.Since(start).Milliseconds()))
+
+	s.logger.Debug().
+		Int("partition", partition).
+		Int64("offset", offset).
+		Msg("Record produced through the REST Proxy consumed")
+	return nil
+}
+
+func (s *restProxyService) Close() {
+	s.http.CloseIdleConnections()
+}
+
+// restProxyProduceResponse is the response of the REST Proxy v2 produce API
+type restProxyProduceResponse struct {
+	Offsets []struct {
+		Partition int     `json:"partition"`
+		Offset    int64   `json:"offset"`
+		ErrorCode *int    `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+}
+
+// produce writes the value to the check topic with the REST Proxy v2 API, returning where it was
+// written
+func (s *restProxyService) produce(ctx context.Context, value []byte) (int, int64, error) {
+	config := s.canaryConfig.RestProxy
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"value": base64.StdEncoding.EncodeToString(value)}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	endpoint := strings.TrimSuffix(config.URL, "/") + "/topics/" + url.PathEscape(config.Topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if config.Username != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error producing through the REST Proxy: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, 0, fmt.Errorf("REST Proxy produce returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	var produced restProxyProduceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&produced); err != nil {
+		return 0, 0, fmt.Errorf("error decoding the REST Proxy produce response: %w", err)
+	}
+	if len(produced.Offsets) != 1 {
+		return 0, 0, fmt.Errorf("REST Proxy produce returned %d offsets for 1 record", len(produced.Offsets))
+	}
+	result := produced.Offsets[0]
+	if result.ErrorCode != nil {
+		message := ""
+		if result.Error != nil {
+			message = *result.Error
+		}
+		return 0, 0, fmt.Errorf("REST Proxy produce failed with error code %d: %s", *result.ErrorCode, message)
+	}
+	return result.Partition, result.Offset, nil
+}
+
+// consume fetches the record at the given offset with the native client and compares its value
+func (s *restProxyService) consume(ctx context.Context, partition int, offset int64, value []byte) error {
+	resp, err := s.connector.KafkaClient.Fetch(ctx, &kafka.FetchRequest{
+		Topic:     s.canaryConfig.RestProxy.Topic,
+		Partition: partition,
+		Offset:    offset,
+		MinBytes:  1,
+		MaxBytes:  1 << 20,
+		MaxWait:   time.Second,
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		s.state.countKafkaError("Fetch", err)
+		return kafkaerr.Wrap(err)
+	}
+
+	// the first batch returned can start before the requested offset
+	for {
+		record, err := resp.Records.ReadRecord()
+		if err != nil {
+			return fmt.Errorf("reading record at offset %d of partition %d: %w", offset, partition, err)
+		}
+		if record.Offset < offset {
+			continue
+		}
+		var consumed []byte
+		if record.Value != nil {
+			if consumed, err = io.ReadAll(record.Value); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(consumed, value) {
+			return fmt.Errorf("record at offset %d of partition %d isn't the one produced through the REST Proxy", offset, partition)
+		}
+		return nil
+	}
+}
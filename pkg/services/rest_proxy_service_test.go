@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestRestProxyProduce(t *testing.T) {
+	st := newTestState()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/kafka-canary-rest", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.binary.v2+json", r.Header.Get("Content-Type"))
+		user, password, _ := r.BasicAuth()
+		assert.Equal(t, "canary", user)
+		assert.Equal(t, "secret", password)
+
+		var body struct {
+			Records []struct {
+				Value string `json:"value"`
+			} `json:"records"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "cmVzdA==", body.Records[0].Value)
+		_, _ = w.Write([]byte(`{"offsets":[{"partition":2,"offset":42,"error_code":null,"error":null}]}`))
+	}))
+	defer server.Close()
+
+	logger := zerolog.Nop()
+	s := &restProxyService{
+		state: st,
+		http:  server.Client(),
+		canaryConfig: &canary.Config{RestProxy: canary.RestProxyConfig{
+			URL: server.URL + "/", Topic: "kafka-canary-rest", Username: "canary", Password: "secret",
+		}},
+		logger: &logger,
+	}
+	partition, offset, err := s.produce(context.Background(), []byte("rest"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, partition)
+	assert.Equal(t, int64(42), offset)
+}
+
+func TestRestProxyProduceErrors(t *testing.T) {
+	st := newTestState()
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"http error", http.StatusNotFound, `{"error_code":40401,"message":"Topic not found"}`},
+		{"record error", http.StatusOK, `{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"Kafka error"}]}`},
+		{"no offsets", http.StatusOK, `{"offsets":[]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			logger := zerolog.Nop()
+			s := &restProxyService{
+				state:        st,
+				http:         server.Client(),
+				canaryConfig: &canary.Config{RestProxy: canary.RestProxyConfig{URL: server.URL, Topic: "kafka-canary-rest"}},
+				logger:       &logger,
+			}
+			_, _, err := s.produce(context.Background(), []byte("rest"))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import "time"
+
+// ProduceResult contains the outcome of producing a single canary record
+type ProduceResult struct {
+	Partition int
+	MessageID int
+	// position of the record in its partition sequence
+	Sequence  int64
+	Timestamp time.Time
+	Latency   time.Duration
+	// broker timestamp of the record, only set when the topic uses LogAppendTime
+	LogAppendTime time.Time
+	Err           error
+	// set when the latency exceeded the partition's SLO threshold
+	Breach *LatencyBreach
+}
+
+// LatencyBreach contains the partition context captured when a record exceeds its latency SLO
+type LatencyBreach struct {
+	Threshold time.Duration
+	Leader    int
+	Replicas  []int
+	ISR       []int
+	// error describing the partition, the context above is empty when set
+	Err error
+}
+
+// ConsumeResult contains the outcome of consuming a single canary record
+type ConsumeResult struct {
+	Partition int
+	Offset    int64
+	MessageID int
+	// position of the record in its partition sequence, 0 for records of older canaries
+	Sequence  int64
+	Timestamp time.Time
+	Latency   time.Duration
+	// ID of the instance which produced the record, when known
+	Instance string
+	Err      error
+}
+
+// ReconcileResult contains the outcome of a topic reconcile
+type ReconcileResult struct {
+	TopicReconcileResult
+	Timestamp time.Time
+	Err       error
+}
+
+// CheckResult contains the outcome of running a CheckService
+type CheckResult struct {
+	Name      string
+	Timestamp time.Time
+	Latency   time.Duration
+	Err       error
+}
+
+// Callbacks defines optional functions invoked with the result of each canary check. The checks
+// run concurrently, so OnCheck may be called from several goroutines at once. OnProduce is called
+// with the records breaching their latency SLO once their partition is described, concurrently
+// with the next records.
+type Callbacks struct {
+	OnProduce   func(ProduceResult)
+	OnConsume   func(ConsumeResult)
+	OnReconcile func(ReconcileResult)
+	OnCheck     func(CheckResult)
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// number of finished roll reports kept
+const rollReportsKept = 20
+
+var (
+	// ErrRollActive is returned when starting a roll while another one is in progress
+	ErrRollActive = errors.New("a maintenance roll is already in progress")
+	// ErrNoRollActive is returned when ending a roll while none is in progress
+	ErrNoRollActive = errors.New("no maintenance roll in progress")
+)
+
+var (
+	rollActive = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "roll_active",
+		Namespace: metricsNamespace,
+		Help:      "Whether a maintenance roll is in progress (1)",
+	})
+
+	rollsFinished = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "rolls_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of finished maintenance rolls, by whether they were clean",
+	}, []string{"result"})
+)
+
+// rolls are the maintenance rolls of a canary
+type rolls struct {
+	lock sync.Mutex
+	// the roll in progress, nil without any
+	active *rollTracker
+	// the finished rolls, oldest first
+	reports []RollReport
+}
+
+// RollReport summarizes the canary impact of a maintenance roll, e.g. a broker rolling restart
+type RollReport struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	// nil while the roll is in progress
+	End                           *time.Time `json:"end,omitempty"`
+	Active                        bool       `json:"active"`
+	RecordsProduced               int64      `json:"recordsProduced"`
+	ProduceFailures               int64      `json:"produceFailures"`
+	MaxConsecutiveProduceFailures int64      `json:"maxConsecutiveProduceFailures"`
+	MaxLatencyMs                  int64      `json:"maxLatencyMs"`
+	RecordsLost                   int64      `json:"recordsLost"`
+	// no produce failure and no record lost during the roll
+	Clean bool `json:"clean"`
+}
+
+// rollTracker accumulates the impact of the roll in progress
+type rollTracker struct {
+	report RollReport
+	// failures in a row by partition
+	consecutiveFailures map[int]int64
+}
+
+// StartRoll marks the start of a maintenance roll, the produce and consume results are
+// accounted to it until it ends
+func (st *State) StartRoll(name string) (RollReport, error) {
+	r := &st.roll
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.active != nil {
+		return RollReport{}, ErrRollActive
+	}
+	r.active = &rollTracker{
+		report:              RollReport{Name: name, Start: time.Now(), Active: true, Clean: true},
+		consecutiveFailures: map[int]int64{},
+	}
+	rollActive.In(st.metrics).Set(1)
+	st.recordEvent(EventInfo, "roll", "maintenance roll %q started", name)
+	return r.active.report, nil
+}
+
+// EndRoll marks the end of the maintenance roll in progress, returning its report
+func (st *State) EndRoll() (RollReport, error) {
+	r := &st.roll
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.active == nil {
+		return RollReport{}, ErrNoRollActive
+	}
+	report := r.active.report
+	end := time.Now()
+	report.End = &end
+	report.Active = false
+	r.active = nil
+	rollActive.In(st.metrics).Set(0)
+
+	r.reports = append(r.reports, report)
+	if len(r.reports) > rollReportsKept {
+		r.reports = r.reports[len(r.reports)-rollReportsKept:]
+	}
+	if report.Clean {
+		rollsFinished.In(st.metrics).WithLabelValues("clean").Inc()
+		st.recordEvent(EventInfo, "roll", "maintenance roll %q was clean, max latency %dms", report.Name, report.MaxLatencyMs)
+	} else {
+		rollsFinished.In(st.metrics).WithLabelValues("impacted").Inc()
+		st.recordEvent(EventWarning, "roll", "maintenance roll %q impacted the canary: %d produce failures (%d in a row), %d records lost, max latency %dms",
+			report.Name, report.ProduceFailures, report.MaxConsecutiveProduceFailures, report.RecordsLost, report.MaxLatencyMs)
+	}
+	return report, nil
+}
+
+// RollReports returns the reports of the finished rolls, oldest first, followed by the one in
+// progress
+func (st *State) RollReports() []RollReport {
+	r := &st.roll
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	reports := make([]RollReport, 0, len(r.reports)+1)
+	reports = append(reports, r.reports...)
+	if r.active != nil {
+		reports = append(reports, r.active.report)
+	}
+	return reports
+}
+
+// observeRollProduce accounts a produce result to the roll in progress
+func (st *State) observeRollProduce(partition int, latency time.Duration, err error) {
+	st.roll.lock.Lock()
+	defer st.roll.lock.Unlock()
+	roll := st.roll.active
+	if roll == nil {
+		return
+	}
+	report := &roll.report
+	report.RecordsProduced++
+	if err != nil {
+		report.ProduceFailures++
+		report.Clean = false
+		roll.consecutiveFailures[partition]++
+		if failures := roll.consecutiveFailures[partition]; failures > report.MaxConsecutiveProduceFailures {
+			report.MaxConsecutiveProduceFailures = failures
+		}
+		return
+	}
+	roll.consecutiveFailures[partition] = 0
+	if ms := latency.Milliseconds(); ms > report.MaxLatencyMs {
+		report.MaxLatencyMs = ms
+	}
+}
+
+// observeRollLost accounts records lost to the roll in progress
+func (st *State) observeRollLost(lost int64) {
+	st.roll.lock.Lock()
+	defer st.roll.lock.Unlock()
+	roll := st.roll.active
+	if roll == nil {
+		return
+	}
+	roll.report.RecordsLost += lost
+	roll.report.Clean = false
+}
+
+// RollsHandler serves the roll reports as JSON on GET, starts a roll on POST, with an optional
+// name query parameter, and ends the roll in progress on DELETE, returning its report
+func (st *State) RollsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var result interface{}
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			name := r.URL.Query().Get("name")
+			if name == "" {
+				name = time.Now().UTC().Format(time.RFC3339)
+			}
+			result, err = st.StartRoll(name)
+		case http.MethodDelete:
+			result, err = st.EndRoll()
+		default:
+			result = st.RollReports()
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+		body, err := json.Marshal(result)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		_, _ = rw.Write(body)
+	})
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollReport(t *testing.T) {
+	st := newTestState()
+	_, err := st.EndRoll()
+	assert.ErrorIs(t, err, ErrNoRollActive)
+
+	_, err = st.StartRoll("brokers")
+	require.NoError(t, err)
+	_, err = st.StartRoll("again")
+	assert.ErrorIs(t, err, ErrRollActive)
+
+	failure := errors.New("not leader")
+	st.observeRollProduce(0, 20*time.Millisecond, nil)
+	st.observeRollProduce(1, 0, failure)
+	st.observeRollProduce(1, 0, failure)
+	st.observeRollProduce(0, 0, failure)
+	st.observeRollProduce(1, 350*time.Millisecond, nil)
+	st.observeRollProduce(1, 0, failure)
+	st.observeRollLost(2)
+
+	report, err := st.EndRoll()
+	require.NoError(t, err)
+	assert.Equal(t, "brokers", report.Name)
+	assert.False(t, report.Active)
+	assert.NotNil(t, report.End)
+	assert.Equal(t, int64(6), report.RecordsProduced)
+	assert.Equal(t, int64(4), report.ProduceFailures)
+	// the failures in a row are counted by partition
+	assert.Equal(t, int64(2), report.MaxConsecutiveProduceFailures)
+	assert.Equal(t, int64(350), report.MaxLatencyMs)
+	assert.Equal(t, int64(2), report.RecordsLost)
+	assert.False(t, report.Clean)
+
+	// nothing is accounted outside of a roll
+	st.observeRollLost(1)
+	reports := st.RollReports()
+	assert.Equal(t, report, reports[len(reports)-1])
+}
+
+func TestRollsHandler(t *testing.T) {
+	st := newTestState()
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		st.RollsHandler().ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/admin/rolls?name=zookeeper").Code)
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/admin/rolls").Code)
+	assert.Contains(t, serve(http.MethodGet, "/rolls").Body.String(), `"name":"zookeeper","start"`)
+
+	rec := serve(http.MethodDelete, "/admin/rolls")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"clean":true`)
+	assert.Equal(t, http.StatusConflict, serve(http.MethodDelete, "/admin/rolls").Code)
+}
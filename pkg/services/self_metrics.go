@@ -0,0 +1,36 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var (
+	serviceGoroutines = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "service_goroutines",
+		Namespace: metricsNamespace,
+		Help:      "Number of goroutines running per canary service",
+	}, []string{"service"})
+
+	recordsDropped = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "records_dropped_total",
+		Namespace: metricsNamespace,
+		Help:      "The total number of consumed records dropped without being accounted",
+	}, []string{"reason"})
+)
+
+// recordsCount counts the acknowledged and the consumed records of a canary instance, the
+// records in flight being the difference
+type recordsCount struct {
+	produced uint64
+	consumed uint64
+}
+
+// TrackGoroutine counts a running goroutine of the service, the returned function must be
+// called when the goroutine exits
+func (st *State) TrackGoroutine(service string) func() {
+	gauge := serviceGoroutines.In(st.metrics).WithLabelValues(service)
+	gauge.Inc()
+	return gauge.Dec
+}
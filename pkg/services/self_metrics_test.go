@@ -0,0 +1,74 @@
+package services
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestTrackGoroutine(t *testing.T) {
+	st := newTestState()
+	gauge := serviceGoroutines.In(st.metrics).WithLabelValues("test")
+	done := st.TrackGoroutine("test")
+	assert.Equal(t, 1.0, testutil.ToFloat64(gauge))
+	st.TrackGoroutine("test")()
+	assert.Equal(t, 1.0, testutil.ToFloat64(gauge), "exited goroutine")
+	done()
+	assert.Equal(t, 0.0, testutil.ToFloat64(gauge))
+}
+
+func TestRecordsInFlight(t *testing.T) {
+	st := newTestState()
+	inFlight := func() float64 {
+		families, err := st.metrics.Gather()
+		assert.NoError(t, err)
+		for _, family := range families {
+			if family.GetName() == "kafka_canary_records_in_flight" {
+				return family.Metric[0].GetGauge().GetValue()
+			}
+		}
+		return -1
+	}
+
+	atomic.AddUint64(&st.recordsCount.produced, 3)
+	assert.Equal(t, 3.0, inFlight())
+	atomic.AddUint64(&st.recordsCount.consumed, 3)
+	assert.Equal(t, 0.0, inFlight())
+}
+
+func TestRecordsInFlightOwnRecords(t *testing.T) {
+	s := newTestConsumer(t)
+	s.canaryConfig = &canary.Config{
+		ClientID:     "canary",
+		InstanceID:   "canary-0",
+		Coordination: canary.CoordinationConfig{Enabled: true, Instances: []string{"canary-0", "canary-1"}},
+	}
+	atomic.AddUint64(&s.state.recordsCount.produced, 1)
+
+	s.handle(testRecords(1)[0], nil)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&s.state.recordsCount.consumed))
+
+	// consumed in coordinated mode, but produced and counted by the other instance
+	message := testRecords(2)[1]
+	message.Headers = []kafka.Header{{Key: InstanceHeader, Value: []byte("canary-1")}}
+	s.handle(message, nil)
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&s.state.recordsCount.consumed))
+}
+
+func TestRecordsDroppedUnparseable(t *testing.T) {
+	s := newTestConsumer(t)
+	st := s.state
+	dropped := recordsDropped.In(st.metrics).WithLabelValues("unparseable")
+	before := testutil.ToFloat64(dropped)
+
+	s.handle(testRecords(1)[0], nil)
+	assert.Equal(t, before, testutil.ToFloat64(dropped), "canary record")
+
+	s.handle(kafka.Message{Partition: 1, Value: []byte("not a canary record")}, nil)
+	assert.Equal(t, before+1, testutil.ToFloat64(dropped))
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+var (
+	recordsLost = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "records_lost_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records missing from the partition sequences",
+	}, []string{"partition"})
+
+	recordsDuplicated = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "records_duplicated_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records consumed again or out of order in the partition sequences",
+	}, []string{"partition"})
+
+	sequenceStoresLock sync.Mutex
+	// stores by file path, or by canary for the ones in memory, shared by the producer and the
+	// consumer of a canary
+	sequenceStores = map[string]*sequenceStore{}
+)
+
+const (
+	// interval between the saves of the changed sequences to the store file
+	sequenceSaveInterval = 5 * time.Second
+	// the consumed sequences of a source not seen for that long are dropped, e.g. of the instances
+	// of a previous deployment named after their pod. A source seen again afterwards starts over.
+	sequenceExpiry = 24 * time.Hour
+)
+
+// sequenceStore keeps the last produced and verified sequence of each partition, persisted to a
+// file when configured so loss detection survives restarts. The changes are saved periodically
+// and when the store is closed, not on every record.
+type sequenceStore struct {
+	path string
+	// key of the store in sequenceStores
+	key    string
+	logger *zerolog.Logger
+	now    func() time.Time
+
+	lock      sync.Mutex
+	sequences map[string]sequenceEntry
+	// set when the sequences changed since the last save
+	dirty bool
+	// producer and consumer services using the store
+	refs int
+	stop chan struct{}
+	done chan struct{}
+}
+
+// sequenceEntry is a sequence and when it was last updated, in Unix milliseconds
+type sequenceEntry struct {
+	Sequence int64 `json:"sequence"`
+	Updated  int64 `json:"updated"`
+}
+
+// openSequenceStore returns the store persisted at the given path, shared by the services of
+// every canary using that file. Without a path the store is in memory only, shared by the
+// services of the canary only, identified by its scope. Each open needs a close.
+func openSequenceStore(path, scope string, logger *zerolog.Logger) (*sequenceStore, error) {
+	key := path
+	if path == "" {
+		key = "memory:" + scope
+	}
+	sequenceStoresLock.Lock()
+	defer sequenceStoresLock.Unlock()
+	if store, ok := sequenceStores[key]; ok {
+		store.lock.Lock()
+		store.refs++
+		store.lock.Unlock()
+		return store, nil
+	}
+
+	store := &sequenceStore{
+		path:      path,
+		key:       key,
+		logger:    logger,
+		now:       time.Now,
+		sequences: map[string]sequenceEntry{},
+		refs:      1,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if path != "" {
+		if err := store.load(); err != nil {
+			return nil, err
+		}
+	}
+	sequenceStores[key] = store
+	go store.run()
+	return store, nil
+}
+
+// sequenceScope returns the scope of the in-memory sequences of a canary, its cluster, topic and
+// instance
+func sequenceScope(canaryConfig canary.Config, connectorConfig client.ConnectorConfig) string {
+	return strings.Join(connectorConfig.BrokerAddrs, ",") + "/" + canaryConfig.Topic + "/" + canaryConfig.InstanceID
+}
+
+// load reads the sequences of the store file, also in the format of the previous versions
+// without the update times
+func (s *sequenceStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	now := s.now().UnixMilli()
+	for key, value := range values {
+		var entry sequenceEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			if err := json.Unmarshal(value, &entry.Sequence); err != nil {
+				return err
+			}
+			entry.Updated = now
+		}
+		s.sequences[key] = entry
+	}
+	return nil
+}
+
+// run saves the changed sequences and drops the expired ones periodically until the store closes
+func (s *sequenceStore) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(sequenceSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.expire()
+			s.save()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// close releases the store, the last service to close it stops it and saves the changes
+func (s *sequenceStore) close() {
+	sequenceStoresLock.Lock()
+	s.lock.Lock()
+	s.refs--
+	last := s.refs == 0
+	s.lock.Unlock()
+	if last {
+		delete(sequenceStores, s.key)
+	}
+	sequenceStoresLock.Unlock()
+	if !last {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.save()
+}
+
+// get returns the last sequence stored for the key, 0 if unknown
+func (s *sequenceStore) get(key string) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sequences[key].Sequence
+}
+
+// set stores the last sequence for the key
+func (s *sequenceStore) set(key string, sequence int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.update(key, sequence)
+}
+
+// verify checks the sequence follows the last verified one for the key, returning the number of
+// records skipped or whether it was already seen. The first sequence of a key is accepted as is.
+func (s *sequenceStore) verify(key string, sequence int64) (lost int64, duplicate bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	last, ok := s.sequences[key]
+	if ok && sequence <= last.Sequence {
+		return 0, true
+	}
+	if ok {
+		lost = sequence - last.Sequence - 1
+	}
+	s.update(key, sequence)
+	return lost, false
+}
+
+// update stores the sequence of the key, the lock held
+func (s *sequenceStore) update(key string, sequence int64) {
+	s.sequences[key] = sequenceEntry{Sequence: sequence, Updated: s.now().UnixMilli()}
+	s.dirty = true
+}
+
+// expire drops the consumed sequences not updated for the expiry. The produced ones are kept, so
+// the records produced after a long stop are never taken for duplicates.
+func (s *sequenceStore) expire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	expired := s.now().Add(-sequenceExpiry).UnixMilli()
+	for key, entry := range s.sequences {
+		if strings.HasPrefix(key, consumedSequencePrefix) && entry.Updated < expired {
+			delete(s.sequences, key)
+			s.dirty = true
+		}
+	}
+}
+
+// save writes the sequences to the store file when they changed, replacing it atomically
+func (s *sequenceStore) save() {
+	if s.path == "" {
+		return
+	}
+	s.lock.Lock()
+	if !s.dirty {
+		s.lock.Unlock()
+		return
+	}
+	data, err := json.Marshal(s.sequences)
+	s.dirty = false
+	s.lock.Unlock()
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		s.lock.Lock()
+		s.dirty = true
+		s.lock.Unlock()
+		s.logger.Warn().Err(err).Str("file", s.path).Msg("Error saving the sequences")
+	}
+}
+
+// writeFileAtomic writes the file through a temporary one renamed over it, so readers never see
+// a partial write
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceStoreVerify(t *testing.T) {
+	logger := zerolog.Nop()
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	store, err := openSequenceStore(path, "", &logger)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		sequence  int64
+		lost      int64
+		duplicate bool
+	}{
+		{sequence: 5},
+		{sequence: 6},
+		{sequence: 9, lost: 2},
+		{sequence: 9, duplicate: true},
+		{sequence: 7, duplicate: true},
+		{sequence: 10},
+	} {
+		lost, duplicate := store.verify("consumed/a/0", tc.sequence)
+		assert.Equal(t, tc.lost, lost, "sequence %d", tc.sequence)
+		assert.Equal(t, tc.duplicate, duplicate, "sequence %d", tc.sequence)
+	}
+
+	// the sequences are saved periodically, not on every record
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// a restarted canary reloads the sequences saved when closing
+	store.close()
+	reopened, err := openSequenceStore(path, "", &logger)
+	require.NoError(t, err)
+	defer reopened.close()
+	assert.NotSame(t, store, reopened)
+	assert.Equal(t, int64(10), reopened.get("consumed/a/0"))
+	lost, _ := reopened.verify("consumed/a/0", 12)
+	assert.Equal(t, int64(1), lost)
+}
+
+func TestSequenceStoreScope(t *testing.T) {
+	logger := zerolog.Nop()
+	a, err := openSequenceStore("", "broker:9092/canary/a", &logger)
+	require.NoError(t, err)
+	defer a.close()
+	b, err := openSequenceStore("", "broker:9092/canary/b", &logger)
+	require.NoError(t, err)
+	defer b.close()
+	// the consumer of canary a shares the store of its producer
+	consumer, err := openSequenceStore("", "broker:9092/canary/a", &logger)
+	require.NoError(t, err)
+	defer consumer.close()
+
+	a.set(producedSequenceKey(0), 10)
+	b.set(producedSequenceKey(0), 3)
+	assert.Same(t, a, consumer)
+	assert.Equal(t, int64(10), consumer.get(producedSequenceKey(0)))
+	assert.Equal(t, int64(3), b.get(producedSequenceKey(0)))
+
+	// file backed stores are shared by path
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	c, err := openSequenceStore(path, "broker:9092/canary/a", &logger)
+	require.NoError(t, err)
+	defer c.close()
+	d, err := openSequenceStore(path, "broker:9092/canary/b", &logger)
+	require.NoError(t, err)
+	defer d.close()
+	assert.Same(t, c, d)
+}
+
+func TestSequenceStoreSave(t *testing.T) {
+	logger := zerolog.Nop()
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	// the format of the previous versions, without the update times
+	require.NoError(t, os.WriteFile(path, []byte(`{"produced/0": 4, "consumed/old-pod/0": 4}`), 0o600))
+	store, err := openSequenceStore(path, "", &logger)
+	require.NoError(t, err)
+	defer store.close()
+	assert.Equal(t, int64(4), store.get("consumed/old-pod/0"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	// nothing changed, nothing saved
+	store.save()
+	unchanged, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.ModTime(), unchanged.ModTime())
+
+	store.set(producedSequenceKey(0), 5)
+	store.save()
+	reloaded := &sequenceStore{path: path, now: time.Now, sequences: map[string]sequenceEntry{}}
+	require.NoError(t, reloaded.load())
+	assert.Equal(t, int64(5), reloaded.sequences[producedSequenceKey(0)].Sequence)
+}
+
+func TestSequenceStoreExpire(t *testing.T) {
+	logger := zerolog.Nop()
+	store, err := openSequenceStore("", t.Name(), &logger)
+	require.NoError(t, err)
+	defer store.close()
+	now := time.Unix(1600000000, 0)
+	store.now = func() time.Time { return now }
+
+	store.set(producedSequenceKey(0), 10)
+	store.set(consumedSequenceKey("old-pod", 0), 10)
+	now = now.Add(sequenceExpiry - time.Minute)
+	store.set(consumedSequenceKey("new-pod", 0), 3)
+	now = now.Add(2 * time.Minute)
+	store.expire()
+
+	assert.Equal(t, int64(10), store.get(producedSequenceKey(0)), "produced sequences never expire")
+	assert.Equal(t, int64(3), store.get(consumedSequenceKey("new-pod", 0)))
+	store.lock.Lock()
+	_, ok := store.sequences[consumedSequenceKey("old-pod", 0)]
+	store.lock.Unlock()
+	assert.False(t, ok, "sequence of a gone source kept")
+}
@@ -0,0 +1,285 @@
+// Package servicestest provides fakes of the canary service interfaces, so the projects wrapping
+// the canary can unit test against them without a Kafka cluster. A fake method calls the function
+// field of the same name when set and returns zero values otherwise, and every call is counted by
+// method name.
+package servicestest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
+)
+
+var (
+	_ services.TopicService        = &TopicService{}
+	_ services.ProducerService     = &ProducerService{}
+	_ services.LeadersAware        = &ProducerService{}
+	_ services.RecordsSamplerAware = &ProducerService{}
+	_ services.ConsumerService     = &ConsumerService{}
+	_ services.RecordsSamplerAware = &ConsumerService{}
+	_ services.StatusService       = &StatusService{}
+	_ services.RecordsSampler      = &StatusService{}
+	_ services.ConnectionService   = &ConnectionService{}
+	_ services.CheckService        = &CheckService{}
+	_ services.PeriodicCheck       = &CheckService{}
+)
+
+// Recorder counts the calls of the fake methods, it's safe for concurrent use
+type Recorder struct {
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+func (r *Recorder) record(method string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.calls == nil {
+		r.calls = map[string]int{}
+	}
+	r.calls[method]++
+}
+
+// Calls returns the number of calls of the method, e.g. Calls("Reconcile")
+func (r *Recorder) Calls(method string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.calls[method]
+}
+
+// TopicService is a fake services.TopicService
+type TopicService struct {
+	Recorder
+	ReconcileFunc         func(ctx context.Context) (services.TopicReconcileResult, error)
+	DescribePartitionFunc func(ctx context.Context, partition int) (client.PartitionInfo, error)
+	CloseFunc             func()
+}
+
+func (s *TopicService) Reconcile(ctx context.Context) (services.TopicReconcileResult, error) {
+	s.record("Reconcile")
+	if s.ReconcileFunc != nil {
+		return s.ReconcileFunc(ctx)
+	}
+	return services.TopicReconcileResult{}, nil
+}
+
+func (s *TopicService) DescribePartition(ctx context.Context, partition int) (client.PartitionInfo, error) {
+	s.record("DescribePartition")
+	if s.DescribePartitionFunc != nil {
+		return s.DescribePartitionFunc(ctx, partition)
+	}
+	return client.PartitionInfo{}, nil
+}
+
+func (s *TopicService) Close() {
+	s.record("Close")
+	if s.CloseFunc != nil {
+		s.CloseFunc()
+	}
+}
+
+// ProducerService is a fake services.ProducerService, also aware of the partition leaders and of
+// the records sampler like the canary producer
+type ProducerService struct {
+	Recorder
+	SendFunc              func(ctx context.Context, partitionsAssignments []int) []services.ProduceResult
+	RefreshFunc           func()
+	CloseFunc             func()
+	SetLeadersFunc        func(leaders map[int32]int32)
+	SetRecordsSamplerFunc func(sampler services.RecordsSampler)
+}
+
+func (s *ProducerService) Send(ctx context.Context, partitionsAssignments []int) []services.ProduceResult {
+	s.record("Send")
+	if s.SendFunc != nil {
+		return s.SendFunc(ctx, partitionsAssignments)
+	}
+	return nil
+}
+
+func (s *ProducerService) Refresh() {
+	s.record("Refresh")
+	if s.RefreshFunc != nil {
+		s.RefreshFunc()
+	}
+}
+
+func (s *ProducerService) Close() {
+	s.record("Close")
+	if s.CloseFunc != nil {
+		s.CloseFunc()
+	}
+}
+
+func (s *ProducerService) SetLeaders(leaders map[int32]int32) {
+	s.record("SetLeaders")
+	if s.SetLeadersFunc != nil {
+		s.SetLeadersFunc(leaders)
+	}
+}
+
+func (s *ProducerService) SetRecordsSampler(sampler services.RecordsSampler) {
+	s.record("SetRecordsSampler")
+	if s.SetRecordsSamplerFunc != nil {
+		s.SetRecordsSamplerFunc(sampler)
+	}
+}
+
+// ConsumerService is a fake services.ConsumerService, also aware of the records sampler like the
+// canary consumer
+type ConsumerService struct {
+	Recorder
+	ConsumeFunc           func(handler func(services.ConsumeResult))
+	RefreshFunc           func()
+	LeadersFunc           func(ctx context.Context) (map[int]int, error)
+	CloseFunc             func()
+	SetRecordsSamplerFunc func(sampler services.RecordsSampler)
+}
+
+func (s *ConsumerService) Consume(handler func(services.ConsumeResult)) {
+	s.record("Consume")
+	if s.ConsumeFunc != nil {
+		s.ConsumeFunc(handler)
+	}
+}
+
+func (s *ConsumerService) Refresh() {
+	s.record("Refresh")
+	if s.RefreshFunc != nil {
+		s.RefreshFunc()
+	}
+}
+
+func (s *ConsumerService) Leaders(ctx context.Context) (map[int]int, error) {
+	s.record("Leaders")
+	if s.LeadersFunc != nil {
+		return s.LeadersFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *ConsumerService) Close() {
+	s.record("Close")
+	if s.CloseFunc != nil {
+		s.CloseFunc()
+	}
+}
+
+func (s *ConsumerService) SetRecordsSampler(sampler services.RecordsSampler) {
+	s.record("SetRecordsSampler")
+	if s.SetRecordsSamplerFunc != nil {
+		s.SetRecordsSamplerFunc(sampler)
+	}
+}
+
+// StatusService is a fake services.StatusService, also a records sampler like the canary status
+// service. Its handler answers 404 Not Found unless StatusHandlerFunc is set.
+type StatusService struct {
+	Recorder
+	OpenFunc            func()
+	CloseFunc           func()
+	StatusHandlerFunc   func() http.Handler
+	RecordsProducedFunc func(n int)
+	RecordsConsumedFunc func(n int)
+}
+
+func (s *StatusService) Open() {
+	s.record("Open")
+	if s.OpenFunc != nil {
+		s.OpenFunc()
+	}
+}
+
+func (s *StatusService) Close() {
+	s.record("Close")
+	if s.CloseFunc != nil {
+		s.CloseFunc()
+	}
+}
+
+func (s *StatusService) StatusHandler() http.Handler {
+	s.record("StatusHandler")
+	if s.StatusHandlerFunc != nil {
+		return s.StatusHandlerFunc()
+	}
+	return http.NotFoundHandler()
+}
+
+func (s *StatusService) RecordsProduced(n int) {
+	s.record("RecordsProduced")
+	if s.RecordsProducedFunc != nil {
+		s.RecordsProducedFunc(n)
+	}
+}
+
+func (s *StatusService) RecordsConsumed(n int) {
+	s.record("RecordsConsumed")
+	if s.RecordsConsumedFunc != nil {
+		s.RecordsConsumedFunc(n)
+	}
+}
+
+// ConnectionService is a fake services.ConnectionService
+type ConnectionService struct {
+	Recorder
+	OpenFunc  func()
+	CloseFunc func()
+}
+
+func (s *ConnectionService) Open() {
+	s.record("Open")
+	if s.OpenFunc != nil {
+		s.OpenFunc()
+	}
+}
+
+func (s *ConnectionService) Close() {
+	s.record("Close")
+	if s.CloseFunc != nil {
+		s.CloseFunc()
+	}
+}
+
+// CheckService is a fake services.CheckService, also a periodic check. It's named "fake" unless
+// NameFunc is set, and runs on every reconcile unless IntervalFunc is.
+type CheckService struct {
+	Recorder
+	NameFunc     func() string
+	CheckFunc    func(ctx context.Context) error
+	CloseFunc    func()
+	IntervalFunc func() time.Duration
+}
+
+func (s *CheckService) Name() string {
+	s.record("Name")
+	if s.NameFunc != nil {
+		return s.NameFunc()
+	}
+	return "fake"
+}
+
+func (s *CheckService) Check(ctx context.Context) error {
+	s.record("Check")
+	if s.CheckFunc != nil {
+		return s.CheckFunc(ctx)
+	}
+	return nil
+}
+
+func (s *CheckService) Close() {
+	s.record("Close")
+	if s.CloseFunc != nil {
+		s.CloseFunc()
+	}
+}
+
+func (s *CheckService) Interval() time.Duration {
+	s.record("Interval")
+	if s.IntervalFunc != nil {
+		return s.IntervalFunc()
+	}
+	return 0
+}
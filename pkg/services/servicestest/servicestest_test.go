@@ -0,0 +1,44 @@
+package servicestest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
+)
+
+func TestFakes(t *testing.T) {
+	topic := &TopicService{}
+	result, err := topic.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, services.TopicReconcileResult{}, result)
+
+	topic.ReconcileFunc = func(context.Context) (services.TopicReconcileResult, error) {
+		return services.TopicReconcileResult{Assignments: []int{0, 1}}, errors.New("expected cluster size not met")
+	}
+	result, err = topic.Reconcile(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, []int{0, 1}, result.Assignments)
+	assert.Equal(t, 2, topic.Calls("Reconcile"))
+	assert.Zero(t, topic.Calls("Close"))
+
+	var consumed []services.ConsumeResult
+	consumer := &ConsumerService{ConsumeFunc: func(handler func(services.ConsumeResult)) {
+		handler(services.ConsumeResult{Partition: 1, MessageID: 7})
+	}}
+	consumer.Consume(func(result services.ConsumeResult) { consumed = append(consumed, result) })
+	assert.Equal(t, []services.ConsumeResult{{Partition: 1, MessageID: 7}}, consumed)
+
+	check := &CheckService{CheckFunc: func(context.Context) error { return errors.New("broken") }}
+	assert.Equal(t, "fake", check.Name())
+	assert.Error(t, check.Check(context.Background()))
+
+	rec := httptest.NewRecorder()
+	(&StatusService{}).StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
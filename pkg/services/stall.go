@@ -0,0 +1,124 @@
+package services
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var partitionStalledDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "", "partition_stalled_seconds"),
+	"Seconds since a canary record was last consumed from the partition",
+	[]string{"partition"}, nil,
+)
+
+// stallTracker tracks the time each partition last progressed, it's safe for concurrent use
+type stallTracker struct {
+	lock sync.Mutex
+	now  func() time.Time
+	// time of the last record consumed by partition, or of the first one produced until then
+	progress map[int]time.Time
+}
+
+func newStallTracker(now func() time.Time) *stallTracker {
+	return &stallTracker{now: now, progress: map[int]time.Time{}}
+}
+
+// produced starts tracking the partition stall, if it isn't yet
+func (t *stallTracker) produced(partition int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.progress[partition]; !ok {
+		t.progress[partition] = t.now()
+	}
+}
+
+// consumed resets the partition stall
+func (t *stallTracker) consumed(partition int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.progress[partition] = t.now()
+}
+
+// forget stops tracking the stall of a partition
+func (t *stallTracker) forget(partition int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.progress, partition)
+}
+
+// stalled returns the partitions without progress for longer than the threshold, sorted
+func (t *stallTracker) stalled(threshold time.Duration) []int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := t.now()
+	stalled := []int{}
+	for partition, progress := range t.progress {
+		if now.Sub(progress) > threshold {
+			stalled = append(stalled, partition)
+		}
+	}
+	sort.Ints(stalled)
+	return stalled
+}
+
+// snapshot returns a copy of the time each partition last progressed
+func (t *stallTracker) snapshot() map[int]time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	progress := make(map[int]time.Time, len(t.progress))
+	for partition, last := range t.progress {
+		progress[partition] = last
+	}
+	return progress
+}
+
+// markProduced starts tracking the partition stall, if it isn't yet
+func (st *State) markProduced(partition int) {
+	st.stalls.produced(partition)
+}
+
+// markConsumed resets the partition stall
+func (st *State) markConsumed(partition int) {
+	st.stalls.consumed(partition)
+}
+
+// forgetPartition stops tracking the stall of a partition gone from the topic
+func (st *State) forgetPartition(partition int) {
+	st.stalls.forget(partition)
+}
+
+// StalledPartitions returns the partitions without records consumed for longer than the
+// threshold, sorted
+func (st *State) StalledPartitions(threshold time.Duration) []int {
+	return st.stalls.stalled(threshold)
+}
+
+// partitionProgressSnapshot returns a copy of the time each partition last progressed
+func (st *State) partitionProgressSnapshot() map[int]time.Time {
+	return st.stalls.snapshot()
+}
+
+// stallCollector exports the time since each partition last progressed on scrape
+type stallCollector struct {
+	tracker *stallTracker
+}
+
+func (stallCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- partitionStalledDesc
+}
+
+func (c stallCollector) Collect(ch chan<- prometheus.Metric) {
+	now := c.tracker.now()
+	for partition, progress := range c.tracker.snapshot() {
+		ch <- prometheus.MustNewConstMetric(
+			partitionStalledDesc,
+			prometheus.GaugeValue,
+			now.Sub(progress).Seconds(),
+			strconv.Itoa(partition),
+		)
+	}
+}
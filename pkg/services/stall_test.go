@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStallTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newStallTracker(func() time.Time { return now })
+	threshold := 2 * time.Minute
+
+	assert.Empty(t, tracker.stalled(threshold), "nothing produced")
+
+	tracker.produced(0)
+	tracker.produced(1)
+	now = now.Add(time.Minute)
+	// producing again doesn't reset the stall, only consuming does
+	tracker.produced(0)
+	tracker.consumed(1)
+	now = now.Add(90 * time.Second)
+	assert.Equal(t, []int{0}, tracker.stalled(threshold), "partition 0 never consumed")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, []int{0, 1}, tracker.stalled(threshold))
+
+	tracker.consumed(0)
+	assert.Equal(t, []int{1}, tracker.stalled(threshold), "partition 0 consumed")
+
+	tracker.forget(1)
+	assert.Empty(t, tracker.stalled(threshold), "partition 1 gone")
+	assert.Equal(t, map[int]time.Time{0: now}, tracker.snapshot())
+}
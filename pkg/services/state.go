@@ -0,0 +1,120 @@
+package services
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// State is the state shared by the services and checks of a canary: its metrics, its event log,
+// the health of its services and checks and the cluster seen by its last reconcile. Every canary
+// has its own, so the canaries of a process don't see each other's records nor failures.
+type State struct {
+	metrics *metrics.Registry
+	// the histograms whose buckets are configured
+	recordsProducedLatency     *prometheus.HistogramVec
+	rackRecordsProducedLatency *prometheus.HistogramVec
+	recordsEndToEndLatency     *prometheus.HistogramVec
+	// records produced by other instances in coordinated mode
+	recordsCrossZoneLatency *prometheus.HistogramVec
+
+	audit        auditLog
+	auth         authFailures
+	degraded     degradedServices
+	events       eventLog
+	failures     failureClasses
+	clock        clockSkews
+	cluster      clusterInfo
+	domains      failureDomains
+	checks       checkHealths
+	latency      latencySummary
+	roll         rolls
+	stalls       *stallTracker
+	topology     topology
+	warmUp       warmUp
+	firstRecord  firstRecord
+	recordsCount recordsCount
+
+	// set while producing is paused, read by the status
+	producingPaused int32
+	// set while Cruise Control is rebalancing, read by the status
+	rebalancing int32
+}
+
+// NewState returns the state of a canary with the given configuration
+func NewState(canaryConfig canary.Config) *State {
+	st := &State{
+		metrics: metrics.NewRegistry(),
+		recordsProducedLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:      "records_produced_latency",
+			Namespace: metricsNamespace,
+			Help:      "Records produced latency in milliseconds",
+			Buckets:   canaryConfig.ProducerLatencyBuckets,
+		}, []string{"clientid", "partition"}),
+		rackRecordsProducedLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:      "rack_records_produced_latency",
+			Namespace: metricsNamespace,
+			Help:      "Records produced latency in milliseconds, by rack of the partition leader at the time",
+			Buckets:   canaryConfig.ProducerLatencyBuckets,
+		}, []string{"rack"}),
+		recordsEndToEndLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:      "records_consumed_latency",
+			Namespace: metricsNamespace,
+			Help:      "Records end-to-end latency in milliseconds",
+			Buckets:   canaryConfig.EndToEndLatencyBuckets,
+		}, []string{"clientid", "partition"}),
+		recordsCrossZoneLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:      "records_consumed_cross_zone_latency",
+			Namespace: metricsNamespace,
+			Help:      "End-to-end latency of the records produced by other coordinated instances in milliseconds",
+			Buckets:   canaryConfig.EndToEndLatencyBuckets,
+		}, []string{"source_instance", "source_zone", "zone"}),
+		auth:        authFailures{failing: map[string]error{}},
+		degraded:    degradedServices{services: map[string]string{}, exported: map[string]bool{}},
+		events:      newEventLog(defaultEventLogSize),
+		failures:    failureClasses{classes: map[string]kafkaerr.Class{}},
+		clock:       clockSkews{skews: map[string]time.Duration{}},
+		checks:      checkHealths{healths: map[string]CheckHealth{}},
+		stalls:      newStallTracker(time.Now),
+		firstRecord: firstRecord{start: time.Now()},
+	}
+	st.metrics.MustRegister(
+		st.recordsProducedLatency,
+		st.rackRecordsProducedLatency,
+		st.recordsEndToEndLatency,
+		st.recordsCrossZoneLatency,
+		stallCollector{st.stalls},
+		rackCollector{st},
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:      "warming_up",
+			Namespace: metricsNamespace,
+			Help:      "Whether the canary is in its warm-up period, its failures don't fail the readiness nor the checks health",
+		}, func() float64 {
+			if st.WarmingUp() {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:      "records_in_flight",
+			Namespace: metricsNamespace,
+			Help:      "Number of records of this instance acknowledged but not consumed yet",
+		}, func() float64 {
+			return float64(atomic.LoadUint64(&st.recordsCount.produced)) - float64(atomic.LoadUint64(&st.recordsCount.consumed))
+		}),
+	)
+	st.SetEventLogSize(canaryConfig.EventLogSize)
+	st.SetClockSkewThreshold(canaryConfig.Clock.SkewThreshold)
+	st.DisableChecks(canaryConfig.DisabledChecks)
+	return st
+}
+
+// Metrics returns the registry of the canary metrics
+func (st *State) Metrics() *metrics.Registry {
+	return st.metrics
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+// newTestState returns the state of a canary with the default event log size
+func newTestState() *State {
+	return NewState(canary.Config{EventLogSize: defaultEventLogSize})
+}
+
+func TestStateIsolation(t *testing.T) {
+	first, second := newTestState(), newTestState()
+
+	first.markDegraded("producer", errors.New("produce failed"))
+	first.StartWarmUp(time.Minute)
+
+	assert.Contains(t, first.DegradedServices(), "producer")
+	assert.Empty(t, second.DegradedServices())
+	assert.Len(t, first.Events(), 1)
+	assert.Empty(t, second.Events())
+	assert.True(t, first.WarmingUp())
+	assert.False(t, second.WarmingUp())
+	assert.Equal(t, 1.0, testutil.ToFloat64(serviceDegraded.In(first.Metrics()).WithLabelValues("producer")))
+	assert.Equal(t, 0, testutil.CollectAndCount(serviceDegraded.In(second.Metrics())))
+}
+
+func TestNewState(t *testing.T) {
+	st := NewState(canary.Config{
+		EventLogSize:           2,
+		DisabledChecks:         []string{"clock"},
+		ProducerLatencyBuckets: []float64{10, 100},
+	})
+
+	for i := 0; i < 3; i++ {
+		st.recordEvent(EventInfo, "test", "event %d", i)
+	}
+	assert.Len(t, st.Events(), 2)
+	assert.Equal(t, HealthDisabled, st.CheckHealths()["clock"].State)
+
+	st.recordsProducedLatency.WithLabelValues("canary", "0").Observe(50)
+	families, err := st.Metrics().Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "kafka_canary_records_produced_latency" {
+			assert.Len(t, family.Metric[0].Histogram.Bucket, 2)
+		}
+	}
+}
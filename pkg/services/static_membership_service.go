@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// ErrStaticMembershipUnsupported is returned when the brokers don't support the static
+// membership, introduced by JoinGroup v5 in Kafka 2.3
+var ErrStaticMembershipUnsupported = errors.New("brokers don't support the consumer group static membership")
+
+var (
+	staticMembershipJoinLatency = metrics.NewHistogram(prometheus.HistogramOpts{
+		Name:      "static_membership_join_latency",
+		Namespace: metricsNamespace,
+		Help:      "Time to join and sync the static membership group in milliseconds",
+		Buckets:   []float64{10, 50, 100, 500, 1000, 5000, 10000, 30000},
+	})
+
+	staticMembershipGeneration = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "static_membership_generation",
+		Namespace: metricsNamespace,
+		Help:      "Generation of the static membership group, unchanged by the canary restarts within the session timeout",
+	})
+
+	staticMembershipRebalances = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "static_membership_rebalances_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of rebalances of the static membership group seen by the canary static member",
+	})
+
+	staticMembershipFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "static_membership_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of failed static membership requests, by API and error class",
+	}, []string{"api", "error_class"})
+)
+
+// staticMembershipService keeps a static member, with a group.instance.id, in a group of its own
+// and heartbeats it every interval. The canary consumer can't join with an instance ID, so this
+// member exercises the static membership protocol the streaming applications rely on. It never
+// leaves the group: restarting the canary within the session timeout must not rebalance it.
+type staticMembershipService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+
+	// current membership, the member ID is empty until joined
+	memberID   string
+	generation int
+	joined     bool
+	// whether the brokers were verified to support the static membership
+	supported bool
+}
+
+func NewStaticMembershipService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	if canaryConfig.StaticMembership.SessionTimeout <= canaryConfig.StaticMembership.Interval {
+		return nil, fmt.Errorf("static membership session timeout %s must be longer than its interval %s",
+			canaryConfig.StaticMembership.SessionTimeout, canaryConfig.StaticMembership.Interval)
+	}
+	if staticInstanceID(canaryConfig) == "" {
+		return nil, errors.New("static membership requires an instance ID, set canary.static-membership.instance-id or canary.instance-id")
+	}
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &staticMembershipService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *staticMembershipService) Name() string {
+	return "static_membership"
+}
+
+func (s *staticMembershipService) Interval() time.Duration {
+	return s.canaryConfig.StaticMembership.Interval
+}
+
+func (s *staticMembershipService) Check(ctx context.Context) error {
+	if !s.supported {
+		if err := s.verifySupport(ctx); err != nil {
+			return err
+		}
+		s.supported = true
+	}
+	if s.joined {
+		rejoin, err := s.heartbeat(ctx)
+		if err != nil || !rejoin {
+			return err
+		}
+	}
+	return s.join(ctx)
+}
+
+// Close keeps the membership, the broker only removes the static member once its session times
+// out, so a restarted canary takes its place back without a rebalance
+func (s *staticMembershipService) Close() {}
+
+// verifySupport returns ErrStaticMembershipUnsupported unless the brokers accept an instance ID,
+// the client would drop it silently otherwise
+func (s *staticMembershipService) verifySupport(ctx context.Context) error {
+	versions, err := s.connector.KafkaClient.ApiVersions(ctx, &kafka.ApiVersionsRequest{})
+	if err == nil {
+		err = versions.Error
+	}
+	if err != nil {
+		return kafkaerr.Wrap(err)
+	}
+	if !supportsVersion(versions, protocol.JoinGroup, 5) || !supportsVersion(versions, protocol.Heartbeat, 3) {
+		return ErrStaticMembershipUnsupported
+	}
+	return nil
+}
+
+// heartbeat keeps the membership alive, returning whether the member must rejoin the group
+func (s *staticMembershipService) heartbeat(ctx context.Context) (bool, error) {
+	resp, err := s.connector.KafkaClient.Heartbeat(ctx, &kafka.HeartbeatRequest{
+		GroupID:         s.groupID(),
+		GenerationID:    int32(s.generation),
+		MemberID:        s.memberID,
+		GroupInstanceID: staticInstanceID(*s.canaryConfig),
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.Is(err, kafka.RebalanceInProgress):
+		s.logger.Debug().Str("group", s.groupID()).Msg("Static membership group rebalancing, rejoining")
+		return true, nil
+	case errors.Is(err, kafka.UnknownMemberId), errors.Is(err, kafka.IllegalGeneration):
+		// the session timed out, the member joins again as a new one
+		s.logger.Warn().Err(err).Str("group", s.groupID()).Msg("Static member expired, rejoining")
+		s.memberID = ""
+		return true, nil
+	}
+	s.fail("Heartbeat", err)
+	return false, kafkaerr.Wrap(err)
+}
+
+// join joins the group with the instance ID, leading it when alone, and syncs the assignments
+func (s *staticMembershipService) join(ctx context.Context) error {
+	s.joined = false
+	start := time.Now()
+	resp, err := s.joinGroup(ctx)
+	if err == nil && errors.Is(resp.Error, kafka.MemberIDRequired) {
+		// the first join of a new member only returns the member ID to join with
+		s.memberID = resp.MemberID
+		resp, err = s.joinGroup(ctx)
+	}
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		if errors.Is(err, kafka.UnknownMemberId) {
+			s.memberID = ""
+		}
+		s.fail("JoinGroup", err)
+		return kafkaerr.Wrap(err)
+	}
+
+	var assignments []kafka.SyncGroupRequestAssignment
+	if resp.LeaderID == resp.MemberID {
+		// the members are only there to exercise the protocol, they are assigned no partition
+		for _, member := range resp.Members {
+			assignments = append(assignments, kafka.SyncGroupRequestAssignment{
+				MemberID: member.ID,
+				Assignment: kafka.GroupProtocolAssignment{
+					AssignedPartitions: map[string][]int{s.canaryConfig.Topic: {}},
+				},
+			})
+		}
+	}
+	sync, err := s.connector.KafkaClient.SyncGroup(ctx, &kafka.SyncGroupRequest{
+		GroupID:         s.groupID(),
+		GenerationID:    resp.GenerationID,
+		MemberID:        resp.MemberID,
+		GroupInstanceID: staticInstanceID(*s.canaryConfig),
+		ProtocolType:    "consumer",
+		ProtocolName:    resp.ProtocolName,
+		Assignments:     assignments,
+	})
+	if err == nil {
+		err = sync.Error
+	}
+	if err != nil {
+		s.fail("SyncGroup", err)
+		return kafkaerr.Wrap(err)
+	}
+	staticMembershipJoinLatency.In(s.state.metrics).Observe(float64(time.Since(start).Milliseconds()))
+
+	if s.generation != 0 && resp.GenerationID != s.generation {
+		staticMembershipRebalances.In(s.state.metrics).Inc()
+		s.state.recordEvent(EventInfo, s.Name(), "static membership group %s rebalanced to generation %d", s.groupID(), resp.GenerationID)
+	}
+	s.memberID = resp.MemberID
+	s.generation = resp.GenerationID
+	s.joined = true
+	staticMembershipGeneration.In(s.state.metrics).Set(float64(resp.GenerationID))
+
+	s.logger.Debug().
+		Str("group", s.groupID()).
+		Str("member", resp.MemberID).
+		Int("generation", resp.GenerationID).
+		Msg("Joined the static membership group")
+	return nil
+}
+
+func (s *staticMembershipService) joinGroup(ctx context.Context) (*kafka.JoinGroupResponse, error) {
+	config := s.canaryConfig.StaticMembership
+	return s.connector.KafkaClient.JoinGroup(ctx, &kafka.JoinGroupRequest{
+		GroupID:          s.groupID(),
+		MemberID:         s.memberID,
+		GroupInstanceID:  staticInstanceID(*s.canaryConfig),
+		SessionTimeout:   config.SessionTimeout,
+		RebalanceTimeout: config.SessionTimeout,
+		ProtocolType:     "consumer",
+		Protocols: []kafka.GroupProtocol{{
+			Name:     "range",
+			Metadata: kafka.GroupProtocolSubscription{Topics: []string{s.canaryConfig.Topic}},
+		}},
+	})
+}
+
+// fail counts the failed request, a fenced instance meaning another member uses the instance ID
+func (s *staticMembershipService) fail(api string, err error) {
+	s.state.countKafkaError(api, err)
+	staticMembershipFailed.In(s.state.metrics).WithLabelValues(api, string(kafkaerr.ClassOf(err))).Inc()
+	if errors.Is(err, kafka.FencedInstanceID) {
+		s.state.recordEvent(EventError, s.Name(), "instance ID %s of group %s fenced, another member uses it", staticInstanceID(*s.canaryConfig), s.groupID())
+	}
+}
+
+// groupID returns the group of the static member, derived from the canary one unless set
+func (s *staticMembershipService) groupID() string {
+	if s.canaryConfig.StaticMembership.GroupID != "" {
+		return s.canaryConfig.StaticMembership.GroupID
+	}
+	return s.canaryConfig.ConsumerGroupID + "-static"
+}
+
+// staticInstanceID returns the group.instance.id of the static member, the canary instance ID
+// unless set
+func staticInstanceID(canaryConfig canary.Config) string {
+	if canaryConfig.StaticMembership.InstanceID != "" {
+		return canaryConfig.StaticMembership.InstanceID
+	}
+	return canaryConfig.InstanceID
+}
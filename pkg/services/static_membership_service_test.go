@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/consumer"
+	"github.com/segmentio/kafka-go/protocol/heartbeat"
+	"github.com/segmentio/kafka-go/protocol/joingroup"
+	"github.com/segmentio/kafka-go/protocol/syncgroup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// fakeGroupTransport is a group coordinator with a single member, recording the requests
+type fakeGroupTransport struct {
+	joinGroupVersion int16
+	generation       int32
+	heartbeatErrors  []kafka.Error
+
+	joins      []*joingroup.Request
+	heartbeats []*heartbeat.Request
+}
+
+func (t *fakeGroupTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *apiversions.Request:
+		return &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+			{ApiKey: int16(protocol.JoinGroup), MaxVersion: t.joinGroupVersion},
+			{ApiKey: int16(protocol.Heartbeat), MaxVersion: 4},
+		}}, nil
+	case *joingroup.Request:
+		t.joins = append(t.joins, req)
+		if req.MemberID == "" {
+			return &joingroup.Response{ErrorCode: int16(kafka.MemberIDRequired), MemberID: "member-1"}, nil
+		}
+		metadata, err := protocol.Marshal(consumer.MaxVersionSupported, consumer.Subscription{Topics: []string{"__kafka_canary"}})
+		if err != nil {
+			return nil, err
+		}
+		return &joingroup.Response{
+			GenerationID: t.generation,
+			ProtocolName: "range",
+			LeaderID:     req.MemberID,
+			MemberID:     req.MemberID,
+			Members: []joingroup.ResponseMember{
+				{MemberID: req.MemberID, GroupInstanceID: req.GroupInstanceID, Metadata: metadata},
+			},
+		}, nil
+	case *syncgroup.Request:
+		return &syncgroup.Response{Assignments: req.Assignments[0].Assignment}, nil
+	case *heartbeat.Request:
+		t.heartbeats = append(t.heartbeats, req)
+		resp := &heartbeat.Response{}
+		if len(t.heartbeatErrors) > 0 {
+			resp.ErrorCode = int16(t.heartbeatErrors[0])
+			t.heartbeatErrors = t.heartbeatErrors[1:]
+		}
+		return resp, nil
+	}
+	return nil, errors.New("unsupported request")
+}
+
+func newTestStaticMembershipService(transport *fakeGroupTransport) *staticMembershipService {
+	st := newTestState()
+	logger := zerolog.Nop()
+	return &staticMembershipService{
+		state:     st,
+		connector: &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: transport}},
+		canaryConfig: &canary.Config{
+			Topic:            "__kafka_canary",
+			InstanceID:       "canary-0",
+			ConsumerGroupID:  "kafka-canary",
+			StaticMembership: canary.StaticMembershipConfig{Enabled: true},
+		},
+		logger: &logger,
+	}
+}
+
+func TestStaticMembershipService(t *testing.T) {
+	transport := &fakeGroupTransport{joinGroupVersion: 7, generation: 1}
+	s := newTestStaticMembershipService(transport)
+	st := s.state
+	rebalances := testutil.ToFloat64(staticMembershipRebalances.In(st.metrics))
+
+	// the first check joins with the instance ID, again with the member ID the broker requires
+	require.NoError(t, s.Check(context.Background()))
+	require.Len(t, transport.joins, 2)
+	for _, join := range transport.joins {
+		assert.Equal(t, "kafka-canary-static", join.GroupID)
+		assert.Equal(t, "canary-0", join.GroupInstanceID)
+	}
+	assert.Equal(t, "member-1", transport.joins[1].MemberID)
+	assert.True(t, s.joined)
+	assert.Equal(t, 1.0, testutil.ToFloat64(staticMembershipGeneration.In(st.metrics)))
+
+	// the next ones only heartbeat
+	require.NoError(t, s.Check(context.Background()))
+	require.Len(t, transport.heartbeats, 1)
+	assert.Equal(t, "canary-0", transport.heartbeats[0].GroupInstanceID)
+	assert.Len(t, transport.joins, 2)
+
+	// a rebalance makes the member rejoin with its member ID
+	transport.generation = 2
+	transport.heartbeatErrors = []kafka.Error{kafka.RebalanceInProgress}
+	require.NoError(t, s.Check(context.Background()))
+	require.Len(t, transport.joins, 3)
+	assert.Equal(t, "member-1", transport.joins[2].MemberID)
+	assert.Equal(t, 2.0, testutil.ToFloat64(staticMembershipGeneration.In(st.metrics)))
+	assert.Equal(t, rebalances+1, testutil.ToFloat64(staticMembershipRebalances.In(st.metrics)))
+
+	// an expired member joins as a new one
+	transport.heartbeatErrors = []kafka.Error{kafka.UnknownMemberId}
+	require.NoError(t, s.Check(context.Background()))
+	require.Len(t, transport.joins, 5)
+	assert.Equal(t, "", transport.joins[3].MemberID)
+
+	// the membership is kept on close
+	s.Close()
+	assert.Len(t, transport.joins, 5)
+}
+
+func TestStaticMembershipServiceUnsupported(t *testing.T) {
+	transport := &fakeGroupTransport{joinGroupVersion: 4}
+	s := newTestStaticMembershipService(transport)
+
+	assert.ErrorIs(t, s.Check(context.Background()), ErrStaticMembershipUnsupported)
+	assert.Empty(t, transport.joins)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// status formats served by the status handler
+const (
+	statusFormatJSON        = "application/json"
+	statusFormatText        = "text/plain; version=0.0.4"
+	statusFormatCloudEvents = "application/cloudevents+json"
+)
+
+// statusEventType is the CloudEvents type of the status events
+const statusEventType = "io.github.pecigonzalo.kafka-canary.status"
+
+// negotiateStatusFormat returns the first supported format of the Accept header, in order of
+// preference, JSON when none is
+func negotiateStatusFormat(accept string) string {
+	type candidate struct {
+		format  string
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil || quality <= 0 {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			candidates = append(candidates, candidate{statusFormatJSON, quality})
+		case "text/plain", "text/*":
+			candidates = append(candidates, candidate{statusFormatText, quality})
+		case "application/cloudevents+json":
+			candidates = append(candidates, candidate{statusFormatCloudEvents, quality})
+		}
+	}
+	if len(candidates) == 0 {
+		return statusFormatJSON
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].quality > candidates[j].quality })
+	return candidates[0].format
+}
+
+// statusText renders the status as a Prometheus-style plaintext summary
+func statusText(status Status) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s_status_consuming_percentage %g\n", metricsNamespace, status.Consuming.Percentage)
+	fmt.Fprintf(&b, "%s_status_consuming_time_window_seconds %g\n", metricsNamespace, status.Consuming.TimeWindow.Seconds())
+	if status.WarmingUp {
+		fmt.Fprintf(&b, "%s_status_warming_up 1\n", metricsNamespace)
+	}
+	if status.Rebalancing {
+		fmt.Fprintf(&b, "%s_status_rebalancing 1\n", metricsNamespace)
+	}
+	if status.ClockSkewed {
+		fmt.Fprintf(&b, "%s_status_clock_skewed 1\n", metricsNamespace)
+	}
+
+	services := make([]string, 0, len(status.Degraded))
+	for service := range status.Degraded {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		fmt.Fprintf(&b, "%s_status_degraded{service=%q} 1\n", metricsNamespace, service)
+	}
+
+	checks := make([]string, 0, len(status.Checks))
+	for check := range status.Checks {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		fmt.Fprintf(&b, "%s_status_check_state{check=%q,state=%q} 1\n", metricsNamespace, check, status.Checks[check].State)
+	}
+	if status.Failure != nil {
+		fmt.Fprintf(&b, "%s_status_probable_cause{cause=%q} 1\n", metricsNamespace, status.Failure.Cause)
+	}
+	return b.Bytes()
+}
+
+// statusCloudEvent renders the status as a CloudEvents 1.0 structured mode event
+func statusCloudEvent(status Status, updatedAt time.Time, instance string) ([]byte, error) {
+	return json.Marshal(struct {
+		SpecVersion     string `json:"specversion"`
+		ID              string `json:"id"`
+		Source          string `json:"source"`
+		Type            string `json:"type"`
+		Time            string `json:"time"`
+		DataContentType string `json:"datacontenttype"`
+		Data            Status `json:"data"`
+	}{
+		SpecVersion: "1.0",
+		// one event per snapshot, the same snapshot is served with the same ID
+		ID:              strconv.FormatInt(updatedAt.UnixNano(), 10),
+		Source:          "kafka-canary/" + instance,
+		Type:            statusEventType,
+		Time:            updatedAt.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            status,
+	})
+}
+
+// writeMaybeGzipped writes the body, gzipped when the client accepts it
+func writeMaybeGzipped(rw http.ResponseWriter, r *http.Request, body []byte) error {
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		_, err := rw.Write(body)
+		return err
+	}
+	rw.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(rw)
+	if _, err := gz.Write(body); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateStatusFormat(t *testing.T) {
+	tests := map[string]string{
+		"":                                   statusFormatJSON,
+		"*/*":                                statusFormatJSON,
+		"application/json":                   statusFormatJSON,
+		"text/plain":                         statusFormatText,
+		"application/cloudevents+json":       statusFormatCloudEvents,
+		"text/html, text/plain;q=0.5":        statusFormatText,
+		"application/json;q=0.2, text/plain": statusFormatText,
+		"text/plain;q=0, application/xml":    statusFormatJSON,
+		"application/cloudevents+json, */*":  statusFormatCloudEvents,
+		"invalid;;, application/cloudevents+json": statusFormatCloudEvents,
+	}
+	for accept, expected := range tests {
+		assert.Equal(t, expected, negotiateStatusFormat(accept), "Accept: %s", accept)
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	status := Status{
+		Consuming: ConsumingStatus{TimeWindow: 5 * time.Minute, Percentage: 99.5},
+		Degraded:  map[string]string{"producer": "timeout", "consumer": "timeout"},
+		Checks:    map[string]CheckHealth{"message_size": {State: HealthRecovering}},
+	}
+	assert.Equal(t, `kafka_canary_status_consuming_percentage 99.5
+kafka_canary_status_consuming_time_window_seconds 300
+kafka_canary_status_degraded{service="consumer"} 1
+kafka_canary_status_degraded{service="producer"} 1
+kafka_canary_status_check_state{check="message_size",state="RECOVERING"} 1
+`, string(statusText(status)))
+}
+
+func TestStatusCloudEvent(t *testing.T) {
+	updatedAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	body, err := statusCloudEvent(Status{Consuming: ConsumingStatus{Percentage: 100}}, updatedAt, "canary-0")
+	require.NoError(t, err)
+
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &event))
+	assert.Equal(t, "1.0", event["specversion"])
+	assert.Equal(t, "kafka-canary/canary-0", event["source"])
+	assert.Equal(t, statusEventType, event["type"])
+	assert.Equal(t, "2022-01-02T03:04:05Z", event["time"])
+	assert.Equal(t, 100.0, event["data"].(map[string]interface{})["Consuming"].(map[string]interface{})["Percentage"])
+}
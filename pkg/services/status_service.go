@@ -0,0 +1,316 @@
+package services
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/services/util"
+)
+
+// Status schema versions, the fields of a version are kept in the later ones so monitors parsing an
+// older version keep working
+const (
+	// StatusSchemaV1 has the consuming, degraded, checks and flags fields
+	StatusSchemaV1 = 1
+	// StatusSchemaV2 adds the producing, connection and per-partition details
+	StatusSchemaV2 = 2
+)
+
+// Status defines useful status related information
+type Status struct {
+	SchemaVersion int `json:"schema_version"`
+	Consuming     ConsumingStatus
+	// services flagged as degraded and the error that degraded them
+	Degraded map[string]string `json:",omitempty"`
+	// health of the additional checks
+	Checks map[string]CheckHealth `json:",omitempty"`
+	// set during the warm-up period, when the failures aren't reflected in the readiness
+	WarmingUp bool `json:",omitempty"`
+	// set while Cruise Control rebalances, when a latency regression is likely caused by it
+	Rebalancing bool `json:",omitempty"`
+	// set while the local clock skew exceeds the threshold, when the end-to-end latency is unreliable
+	ClockSkewed bool `json:",omitempty"`
+
+	// the fields below are only part of the schema v2
+	Producing  *ProducingStatus  `json:",omitempty"`
+	Connection *ConnectionStatus `json:",omitempty"`
+	Partitions []PartitionStatus `json:",omitempty"`
+	// probable cause of the failing services and checks, unset while nothing fails
+	Failure *FailureClassification `json:",omitempty"`
+}
+
+// ProducingStatus defines producing related status information
+type ProducingStatus struct {
+	TimeWindow time.Duration
+	// records produced over the time window
+	Records uint64
+	// set while producing is paused by backpressure
+	Paused bool
+}
+
+// ConnectionStatus defines the cluster as seen by the last topic reconcile
+type ConnectionStatus struct {
+	// time the cluster was last described, zero until it is
+	UpdatedAt  time.Time
+	Brokers    int
+	Controller int
+	// set while a service or check fails authenticating or being authorized
+	AuthFailing bool
+}
+
+// PartitionStatus defines the status of a canary topic partition
+type PartitionStatus struct {
+	Partition int
+	Leader    int
+	// time a record was last consumed from the partition, or the first produced to it until then
+	LastProgress time.Time
+	// set when the partition didn't progress for longer than the stall threshold
+	Stalled bool `json:",omitempty"`
+}
+
+// v1 returns the status in the schema v1
+func (s Status) v1() Status {
+	s.SchemaVersion = StatusSchemaV1
+	s.Producing, s.Connection, s.Partitions, s.Failure = nil, nil, nil, nil
+	return s
+}
+
+// ConsumingStatus defines consuming related status information
+type ConsumingStatus struct {
+	TimeWindow time.Duration
+	Percentage float64
+}
+
+type statusService struct {
+	state        *State
+	canaryConfig *canary.Config
+	// records ingested over the status time window
+	producedRecords *util.SlidingWindow
+	consumedRecords *util.SlidingWindow
+	// snapshots of the last computed status by schema version, served by the handler
+	snapshot     []byte
+	snapshotV1   []byte
+	lastStatus   Status
+	updatedAt    time.Time
+	snapshotLock sync.RWMutex
+	stop         chan struct{}
+	syncStop     sync.WaitGroup
+	logger       *zerolog.Logger
+}
+
+func NewStatusServiceService(state *State, canary canary.Config, logger *zerolog.Logger) StatusService {
+	interval := canary.StatusCheckInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	window := canary.StatusTimeWindow
+	if window < interval {
+		window = interval
+	}
+	return &statusService{
+		state:           state,
+		canaryConfig:    &canary,
+		producedRecords: util.NewSlidingWindow(window, interval),
+		consumedRecords: util.NewSlidingWindow(window, interval),
+		logger:          logger,
+	}
+}
+
+// Open computes a first status snapshot, from the restored state if any, and starts refreshing it
+// every status check interval
+func (s *statusService) Open() {
+	s.restoreState()
+	s.updateSnapshot()
+
+	interval := s.canaryConfig.StatusCheckInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	s.stop = make(chan struct{})
+	s.syncStop.Add(1)
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer s.state.TrackGoroutine("status")()
+		defer s.syncStop.Done()
+		for {
+			select {
+			case <-ticker.C:
+				s.updateSnapshot()
+				s.state.exportIntervalLatency()
+			case <-s.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (s *statusService) Close() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.syncStop.Wait()
+	s.stop = nil
+	s.saveState()
+}
+
+// Reset drops the records ingested so far and refreshes the snapshot, the consuming percentage
+// starting over from no data
+func (s *statusService) Reset() {
+	s.producedRecords.Reset()
+	s.consumedRecords.Reset()
+	s.updateSnapshot()
+}
+
+// RecordsProduced ingests records sent by the producer
+func (s *statusService) RecordsProduced(n int) {
+	s.producedRecords.Add(uint64(n))
+}
+
+// RecordsConsumed ingests records read by the consumer
+func (s *statusService) RecordsConsumed(n int) {
+	s.consumedRecords.Add(uint64(n))
+}
+
+// StatusHandler serves the last status snapshot, so requests never trigger any computation. The
+// format is negotiated with the Accept header, JSON by default, and the schema v1 is served with
+// the schema_version=1 query parameter, the latest otherwise.
+func (s *statusService) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		s.snapshotLock.RLock()
+		snapshot, status, updatedAt := s.snapshot, s.lastStatus, s.updatedAt
+		if r.URL.Query().Get("schema_version") == strconv.Itoa(StatusSchemaV1) {
+			snapshot, status = s.snapshotV1, status.v1()
+		}
+		s.snapshotLock.RUnlock()
+
+		if snapshot == nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		format := negotiateStatusFormat(r.Header.Get("Accept"))
+		body := snapshot
+		switch format {
+		case statusFormatText:
+			body = statusText(status)
+		case statusFormatCloudEvents:
+			var err error
+			body, err = statusCloudEvent(status, updatedAt, s.canaryConfig.InstanceID)
+			if err != nil {
+				s.logger.Error().Err(err).Msg("Marshal status event")
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		rw.Header().Add("Content-Type", format)
+		rw.Header().Add("Vary", "Accept, Accept-Encoding")
+		if err := writeMaybeGzipped(rw, r, body); err != nil {
+			s.logger.Err(err).Msg("Write response")
+		}
+	})
+}
+
+func (s *statusService) updateSnapshot() {
+	status := s.status()
+	jsonV1, err := json.Marshal(status.v1())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Marshal status")
+		return
+	}
+	json, err := json.Marshal(status)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Marshal status")
+		return
+	}
+
+	s.snapshotLock.Lock()
+	s.snapshot = json
+	s.snapshotV1 = jsonV1
+	s.lastStatus = status
+	s.updatedAt = time.Now()
+	s.snapshotLock.Unlock()
+}
+
+func (s *statusService) status() Status {
+	status := Status{
+		SchemaVersion: StatusSchemaV2,
+		Degraded:      s.state.DegradedServices(),
+		Checks:        s.state.CheckHealths(),
+		WarmingUp:     s.state.WarmingUp(),
+		Rebalancing:   s.state.Rebalancing(),
+		ClockSkewed:   s.state.ClockSkewed(),
+	}
+
+	// update consuming related status section
+	status.Consuming = ConsumingStatus{
+		TimeWindow: s.producedRecords.Covered(s.canaryConfig.StatusTimeWindow),
+	}
+	consumedPercentage, err := s.consumedPercentage()
+	if _, ok := err.(*util.ErrNoDataSamples); ok {
+		status.Consuming.Percentage = -1
+		s.logger.Error().Err(err).Msg("Error processing consumed records percentage")
+	} else {
+		status.Consuming.Percentage = consumedPercentage
+	}
+
+	status.Producing = &ProducingStatus{
+		TimeWindow: status.Consuming.TimeWindow,
+		Records:    s.producedRecords.Sum(s.canaryConfig.StatusTimeWindow),
+		Paused:     s.state.ProducerPaused(),
+	}
+	info, _ := s.state.lastClusterInfo()
+	status.Connection = &ConnectionStatus{
+		UpdatedAt:   info.UpdatedAt,
+		Brokers:     len(info.Brokers),
+		Controller:  info.Controller,
+		AuthFailing: len(s.state.AuthFailures()) > 0,
+	}
+	status.Partitions = s.state.partitionStatuses(info.Leaders, s.canaryConfig.StallThreshold)
+	status.Failure = s.state.ClassifyFailures()
+
+	return status
+}
+
+// partitionStatuses returns the status of the partitions tracked by the stall detection, sorted
+func (st *State) partitionStatuses(leaders map[int]int, stallThreshold time.Duration) []PartitionStatus {
+	progress := st.partitionProgressSnapshot()
+	partitions := make([]PartitionStatus, 0, len(progress))
+	for partition, last := range progress {
+		partitions = append(partitions, PartitionStatus{
+			Partition:    partition,
+			Leader:       leaders[partition],
+			LastProgress: last,
+			Stalled:      stallThreshold > 0 && time.Since(last) > stallThreshold,
+		})
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Partition < partitions[j].Partition })
+	return partitions
+}
+
+// consumedPercentage function processes the percentage of consumed messages in the specified time window
+func (s *statusService) consumedPercentage() (float64, error) {
+	// get number of records consumed and produced in the time window
+	window := s.canaryConfig.StatusTimeWindow
+	consumed := s.consumedRecords.Sum(window)
+	produced := s.producedRecords.Sum(window)
+
+	if produced == 0 {
+		return 0, &util.ErrNoDataSamples{}
+	}
+
+	percentage := float64(consumed*100) / float64(produced)
+	// rounding to two decimal digits
+	percentage = math.Round(percentage*100) / 100
+	s.logger.Info().Msgf("Status consumed percentage = %f", percentage)
+	return percentage, nil
+}
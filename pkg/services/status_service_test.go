@@ -0,0 +1,96 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func newTestStatusService() *statusService {
+	st := newTestState()
+	logger := zerolog.Nop()
+	return NewStatusServiceService(st, canary.Config{
+		StatusCheckInterval: time.Second,
+		StatusTimeWindow:    time.Minute,
+	}, &logger).(*statusService)
+}
+
+func TestStatusIngestion(t *testing.T) {
+	s := newTestStatusService()
+
+	// nothing produced yet
+	assert.Equal(t, -1.0, s.status().Consuming.Percentage)
+	assert.Equal(t, time.Duration(0), s.status().Consuming.TimeWindow)
+
+	s.RecordsProduced(10)
+	s.RecordsConsumed(8)
+	s.RecordsProduced(10)
+	s.RecordsConsumed(10)
+	assert.Equal(t, 90.0, s.status().Consuming.Percentage)
+	assert.Positive(t, s.status().Consuming.TimeWindow)
+}
+
+func TestStatusSchemaVersions(t *testing.T) {
+	s := newTestStatusService()
+	s.RecordsProduced(10)
+	s.RecordsConsumed(10)
+	s.updateSnapshot()
+
+	tests := []struct {
+		name    string
+		target  string
+		version float64
+		// keys expected, and not, in the payload
+		keys    []string
+		without []string
+	}{
+		{
+			name:    "latest by default",
+			target:  "/status",
+			version: StatusSchemaV2,
+			keys:    []string{"schema_version", "Consuming", "Producing", "Connection"},
+		},
+		{
+			name:    "v1 on request",
+			target:  "/status?schema_version=1",
+			version: StatusSchemaV1,
+			keys:    []string{"schema_version", "Consuming"},
+			without: []string{"Producing", "Connection", "Partitions"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+			require.Equal(t, 200, rec.Code)
+
+			var payload map[string]interface{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &payload))
+			assert.Equal(t, tt.version, payload["schema_version"])
+			for _, key := range tt.keys {
+				assert.Contains(t, payload, key)
+			}
+			for _, key := range tt.without {
+				assert.NotContains(t, payload, key)
+			}
+			// the v1 fields keep their names and meaning in every version
+			consuming := payload["Consuming"].(map[string]interface{})
+			assert.Equal(t, 100.0, consuming["Percentage"])
+		})
+	}
+}
+
+func BenchmarkStatusIngestion(b *testing.B) {
+	s := newTestStatusService()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.RecordsConsumed(1)
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/services/util"
+)
+
+// statusState is the status persisted across restarts, so the status is known right after a
+// deployment instead of a full time window later
+type statusState struct {
+	SavedAt  time.Time           `json:"savedAt"`
+	Produced []util.WindowBucket `json:"produced"`
+	Consumed []util.WindowBucket `json:"consumed"`
+}
+
+// restoreState restores the records ingested by the previous run, the ones older than the time
+// window are ignored
+func (s *statusService) restoreState() {
+	if s.canaryConfig.StatusStateFile == "" {
+		return
+	}
+	data, err := os.ReadFile(s.canaryConfig.StatusStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("Error reading the status state")
+		return
+	}
+	var state statusState
+	if err := json.Unmarshal(data, &state); err != nil {
+		s.logger.Warn().Err(err).Msg("Error decoding the status state")
+		return
+	}
+
+	s.producedRecords.Restore(state.Produced)
+	s.consumedRecords.Restore(state.Consumed)
+	s.logger.Info().
+		Time("savedAt", state.SavedAt).
+		Int("buckets", len(state.Produced)).
+		Msg("Restored status state")
+}
+
+// saveState persists the records ingested over the time window
+func (s *statusService) saveState() {
+	if s.canaryConfig.StatusStateFile == "" {
+		return
+	}
+	data, err := json.Marshal(statusState{
+		SavedAt:  time.Now(),
+		Produced: s.producedRecords.Buckets(),
+		Consumed: s.consumedRecords.Buckets(),
+	})
+	if err == nil {
+		err = writeFileAtomic(s.canaryConfig.StatusStateFile, data)
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Error saving the status state")
+	}
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/services/util"
+)
+
+func TestStatusStateRestore(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	config := canary.Config{
+		StatusCheckInterval: time.Second,
+		StatusTimeWindow:    time.Minute,
+		StatusStateFile:     filepath.Join(t.TempDir(), "status.json"),
+	}
+
+	previous := NewStatusServiceService(st, config, &logger).(*statusService)
+	previous.RecordsProduced(200)
+	previous.RecordsConsumed(100)
+	previous.saveState()
+
+	restored := NewStatusServiceService(st, config, &logger).(*statusService)
+	restored.restoreState()
+	assert.Equal(t, 50.0, restored.status().Consuming.Percentage)
+
+	// the ingested records add up with the restored ones
+	restored.RecordsConsumed(100)
+	assert.Equal(t, 100.0, restored.status().Consuming.Percentage)
+}
+
+func TestStatusStateStale(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	config := canary.Config{
+		StatusCheckInterval: time.Second,
+		StatusTimeWindow:    time.Minute,
+		StatusStateFile:     filepath.Join(t.TempDir(), "status.json"),
+	}
+
+	old := []util.WindowBucket{{Start: time.Now().Add(-time.Hour), Value: 100}}
+	data, err := json.Marshal(statusState{SavedAt: time.Now().Add(-time.Hour), Produced: old, Consumed: old})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(config.StatusStateFile, data, 0o600))
+
+	restored := NewStatusServiceService(st, config, &logger).(*statusService)
+	restored.restoreState()
+	assert.Equal(t, -1.0, restored.status().Consuming.Percentage)
+}
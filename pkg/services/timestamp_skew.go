@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+var brokerTimestampSkew = metrics.NewHistogramVec(prometheus.HistogramOpts{
+	Name:      "broker_timestamp_skew",
+	Namespace: metricsNamespace,
+	Help:      "Difference between the broker LogAppendTime and the producer timestamp in milliseconds, corrected by half the produce latency",
+	Buckets:   []float64{-10000, -1000, -500, -100, -50, -10, 0, 10, 50, 100, 500, 1000, 10000},
+}, []string{"broker"})
+
+// completed is the writer completion function, it keeps the LogAppendTime the brokers return
+// when the topic uses it, kafka-go only sets it on messages written without a timestamp
+func (s *producerService) completed(messages []kafka.Message, err error) {
+	if err != nil {
+		return
+	}
+	s.appendTimesLock.Lock()
+	defer s.appendTimesLock.Unlock()
+	for _, m := range messages {
+		if !m.Time.IsZero() {
+			s.appendTimes[m.Partition] = m.Time
+		}
+	}
+}
+
+// appendTime returns and forgets the LogAppendTime of the last record written to the partition
+func (s *producerService) appendTime(partition int) time.Time {
+	s.appendTimesLock.Lock()
+	defer s.appendTimesLock.Unlock()
+	t := s.appendTimes[partition]
+	delete(s.appendTimes, partition)
+	return t
+}
+
+// observeTimestampSkews exports the skew between the producer and broker timestamps by leader,
+// the broker is assumed to append the record halfway through the produce latency. The median
+// skew over the leaders estimates the local clock skew, a single broker drifting doesn't move it.
+func (s *producerService) observeTimestampSkews(results []ProduceResult) {
+	// the leaders found by the topic reconcile are used when known
+	leaders := s.leaders
+	var skews []time.Duration
+	for _, r := range results {
+		if r.LogAppendTime.IsZero() {
+			continue
+		}
+		if leaders == nil {
+			var err error
+			leaders, err = s.partitionLeaders()
+			if err != nil {
+				s.logger.Warn().Err(err).Msg("Error getting partition leaders, skipping timestamp skews")
+				return
+			}
+		}
+		leader, ok := leaders[r.Partition]
+		if !ok {
+			continue
+		}
+		skew := r.LogAppendTime.Sub(r.Timestamp.Add(r.Latency / 2))
+		brokerTimestampSkew.In(s.state.metrics).WithLabelValues(strconv.Itoa(leader)).Observe(float64(skew.Milliseconds()))
+		skews = append(skews, skew)
+	}
+	if len(skews) > 0 {
+		sort.Slice(skews, func(i, j int) bool { return skews[i] < skews[j] })
+		// the brokers being ahead means the local clock is behind
+		s.state.observeClockSkew(clockSourceLogAppendTime, -skews[len(skews)/2])
+	}
+}
+
+func (s *producerService) partitionLeaders() (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	metadata, err := s.client.KafkaClient.Metadata(ctx, &kafka.MetadataRequest{
+		Topics: []string{s.canaryConfig.Topic},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	leaders := map[int]int{}
+	for _, topic := range metadata.Topics {
+		for _, p := range topic.Partitions {
+			leaders[p.ID] = p.Leader.ID
+		}
+	}
+	return leaders, nil
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// unreachableTransport fails every request
+type unreachableTransport struct{}
+
+func (unreachableTransport) RoundTrip(context.Context, net.Addr, kafka.Request) (kafka.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestProducerAppendTimes(t *testing.T) {
+	st := newTestState()
+	s := &producerService{state: st, appendTimes: map[int]time.Time{}}
+	appended := time.Unix(1600000000, 0)
+
+	s.completed([]kafka.Message{{Partition: 0, Time: appended}, {Partition: 1}}, nil)
+	s.completed([]kafka.Message{{Partition: 2, Time: appended}}, errors.New("timeout"))
+	assert.Equal(t, appended, s.appendTime(0))
+	assert.True(t, s.appendTime(0).IsZero(), "append time forgotten once read")
+	assert.True(t, s.appendTime(1).IsZero(), "no LogAppendTime returned")
+	assert.True(t, s.appendTime(2).IsZero(), "failed write")
+}
+
+func TestObserveTimestampSkews(t *testing.T) {
+	st := newTestState()
+	logger := zerolog.Nop()
+	s := &producerService{
+		state:   st,
+		leaders: map[int]int{0: 1, 1: 2, 2: 3},
+		logger:  &logger,
+	}
+	samples := func(broker string) uint64 {
+		var m dto.Metric
+		require.NoError(t, brokerTimestampSkew.In(st.metrics).WithLabelValues(broker).(prometheus.Histogram).Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	before := samples("1")
+
+	produced := time.Unix(1600000000, 0)
+	result := func(partition int, skew time.Duration) ProduceResult {
+		// appended halfway through the produce latency, plus the broker skew
+		return ProduceResult{
+			Partition:     partition,
+			Timestamp:     produced,
+			Latency:       20 * time.Millisecond,
+			LogAppendTime: produced.Add(10*time.Millisecond + skew),
+		}
+	}
+	s.observeTimestampSkews([]ProduceResult{
+		result(0, 100*time.Millisecond),
+		result(1, 300*time.Millisecond),
+		result(2, 200*time.Millisecond),
+		// no leader known
+		result(5, time.Hour),
+		// the topic doesn't use LogAppendTime
+		{Partition: 0, Timestamp: produced, Latency: 20 * time.Millisecond},
+	})
+	assert.Equal(t, before+1, samples("1"))
+	assert.Equal(t, -200.0, testutil.ToFloat64(clockSkew.In(st.metrics).WithLabelValues(clockSourceLogAppendTime)), "median skew")
+
+	// the leaders are looked up when the topic wasn't reconciled yet
+	s.leaders = nil
+	s.canaryConfig = &canary.Config{Topic: "canary"}
+	s.client = &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: unreachableTransport{}}}
+	s.observeTimestampSkews([]ProduceResult{result(0, time.Second)})
+	assert.Equal(t, before+1, samples("1"), "skipped without leaders")
+	assert.Equal(t, -200.0, testutil.ToFloat64(clockSkew.In(st.metrics).WithLabelValues(clockSourceLogAppendTime)))
+}
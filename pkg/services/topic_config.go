@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	topicConfigDrift = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "topic_config_drift",
+		Namespace: metricsNamespace,
+		Help:      "Whether the live canary topic config differs (1) from the desired one, by config key",
+	}, []string{"topic", "key"})
+
+	topicConfigRemediated = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "topic_config_remediated_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of canary topic config drifts corrected by re-applying the desired config",
+	}, []string{"topic"})
+)
+
+// desiredTopicConfig returns the canary topic config, the one it's created with overridden by the
+// configured entries
+func (s *topicService) desiredTopicConfig() map[string]string {
+	desired := map[string]string{
+		"cleanup.policy":      cleanupPolicy,
+		"min.insync.replicas": strconv.Itoa(3), // TODO: Get broker count from Kafka
+	}
+	for key, value := range s.canaryConfig.TopicConfig.Desired {
+		desired[key] = value
+	}
+	return desired
+}
+
+// desiredTopicConfigEntries returns the desired canary topic config sorted by key
+func (s *topicService) desiredTopicConfigEntries() []kafka.ConfigEntry {
+	desired := s.desiredTopicConfig()
+	entries := make([]kafka.ConfigEntry, 0, len(desired))
+	for key, value := range desired {
+		entries = append(entries, kafka.ConfigEntry{ConfigName: key, ConfigValue: value})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ConfigName < entries[j].ConfigName })
+	return entries
+}
+
+// checkConfigDrift compares the effective canary topic config with the desired one, exporting the
+// differing keys and re-applying them when remediation is enabled. Failures are logged, a drift
+// doesn't fail the reconcile.
+func (s *topicService) checkConfigDrift(ctx context.Context, manage bool) {
+	desired := s.desiredTopicConfig()
+	keys := sortedKeys(desired)
+	actual, err := s.liveTopicConfig(ctx, keys)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("topic", s.canaryConfig.Topic).Msg("Error describing the topic config, drift unknown")
+		return
+	}
+
+	var drifted []kafka.ConfigEntry
+	for _, key := range keys {
+		value := 0.0
+		if actual[key] != desired[key] {
+			value = 1
+			drifted = append(drifted, kafka.ConfigEntry{ConfigName: key, ConfigValue: desired[key]})
+			if !s.drifted[key] {
+				s.logger.Warn().
+					Str("topic", s.canaryConfig.Topic).
+					Str("key", key).
+					Str("desired", desired[key]).
+					Str("actual", actual[key]).
+					Msg("Topic config drifted")
+				s.state.recordEvent(EventWarning, "topic", "config %s drifted to %q, desired %q", key, actual[key], desired[key])
+			}
+		}
+		s.metrics.configDrift.WithLabelValues(s.canaryConfig.Topic, key).Set(value)
+	}
+	s.drifted = map[string]bool{}
+	for _, entry := range drifted {
+		s.drifted[entry.ConfigName] = true
+	}
+	if len(drifted) == 0 || !manage || !s.canaryConfig.TopicConfig.Remediate {
+		return
+	}
+
+	if _, err := s.admin.UpdateTopicConfig(ctx, s.canaryConfig.Topic, drifted, true); err != nil {
+		labels := prometheus.Labels{
+			"topic":       s.canaryConfig.Topic,
+			"error_class": string(kafkaerr.ClassOf(err)),
+		}
+		s.metrics.alterConfigError.With(labels).Inc()
+		s.state.countKafkaError("IncrementalAlterConfigs", err)
+		s.logger.Error().Err(err).Str("topic", s.canaryConfig.Topic).Msg("Error correcting the topic config drift")
+		return
+	}
+	for _, entry := range drifted {
+		s.metrics.configDrift.WithLabelValues(s.canaryConfig.Topic, entry.ConfigName).Set(0)
+		delete(s.drifted, entry.ConfigName)
+	}
+	s.metrics.configRemediated.WithLabelValues(s.canaryConfig.Topic).Inc()
+	s.logger.Info().Str("topic", s.canaryConfig.Topic).Interface("config", drifted).Msg("Corrected the topic config drift")
+	s.state.recordEvent(EventInfo, "topic", "corrected the drift of %d config keys", len(drifted))
+}
+
+// initialTopicConfigEntries returns the desired entries the config of an existing canary topic
+// differs in, applied on the first reconcile. All of them are when the config can't be described.
+func (s *topicService) initialTopicConfigEntries(ctx context.Context) []kafka.ConfigEntry {
+	desired := s.desiredTopicConfig()
+	keys := sortedKeys(desired)
+	actual, err := s.liveTopicConfig(ctx, keys)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("topic", s.canaryConfig.Topic).Msg("Error describing the topic config, applying all of it")
+		return s.desiredTopicConfigEntries()
+	}
+	var entries []kafka.ConfigEntry
+	for _, key := range keys {
+		if actual[key] != desired[key] {
+			entries = append(entries, kafka.ConfigEntry{ConfigName: key, ConfigValue: desired[key]})
+		}
+	}
+	return entries
+}
+
+// liveTopicConfig describes the effective values of the canary topic config keys, including the
+// defaults the topic inherits
+func (s *topicService) liveTopicConfig(ctx context.Context, keys []string) (map[string]string, error) {
+	resp, err := s.admin.GetConnector().KafkaClient.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{
+		Resources: []kafka.DescribeConfigRequestResource{{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: s.canaryConfig.Topic,
+			ConfigNames:  keys,
+		}},
+	})
+	if err == nil && len(resp.Resources) > 0 && resp.Resources[0].Error != nil {
+		err = resp.Resources[0].Error
+	}
+	if err != nil {
+		s.state.countKafkaError("DescribeConfigs", err)
+		return nil, kafkaerr.Wrap(err)
+	}
+	actual := map[string]string{}
+	for _, resource := range resp.Resources {
+		for _, entry := range resource.ConfigEntries {
+			actual[entry.ConfigName] = entry.ConfigValue
+		}
+	}
+	return actual, nil
+}
+
+// sortedKeys returns the keys of the config sorted
+func sortedKeys(config map[string]string) []string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+)
+
+func TestDesiredTopicConfig(t *testing.T) {
+	s := &topicService{canaryConfig: canary.Config{
+		TopicConfig: canary.TopicConfigConfig{Desired: map[string]string{
+			"min.insync.replicas": "2",
+			"retention.ms":        "600000",
+		}},
+	}}
+
+	// the configured entries override the defaults, sorted for a stable create request
+	assert.Equal(t, []kafka.ConfigEntry{
+		{ConfigName: "cleanup.policy", ConfigValue: "delete"},
+		{ConfigName: "min.insync.replicas", ConfigValue: "2"},
+		{ConfigName: "retention.ms", ConfigValue: "600000"},
+	}, s.desiredTopicConfigEntries())
+}
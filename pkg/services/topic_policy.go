@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// topic policies checked on every reconcile
+const (
+	policyName              = "name"
+	policyReplicationFactor = "replication_factor"
+)
+
+var (
+	clusterTopics = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "cluster_topics",
+		Namespace: metricsNamespace,
+		Help:      "Number of topics in the cluster, internal ones included",
+	})
+
+	clusterPartitions = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "cluster_partitions",
+		Namespace: metricsNamespace,
+		Help:      "Number of partitions in the cluster, internal topics included",
+	})
+
+	topicPolicyViolations = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "topic_policy_violations",
+		Namespace: metricsNamespace,
+		Help:      "Number of topics violating the policy, internal topics excluded",
+	}, []string{"policy"})
+)
+
+// checkTopicPolicy lists the topics of the cluster, exporting their count and the ones violating
+// the naming and replication factor policies. Failures are logged, they don't fail the reconcile.
+func (s *topicService) checkTopicPolicy(ctx context.Context) {
+	// no topics requests them all
+	metadata, err := s.admin.GetConnector().KafkaClient.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		s.state.countKafkaError("Metadata", err)
+		s.logger.Warn().Err(err).Msg("Error listing the topics, the topic counts are stale")
+		return
+	}
+
+	violators, partitions := evaluateTopicPolicy(metadata.Topics, s.topicNamePattern, s.canaryConfig.TopicPolicy.MinReplicationFactor)
+	clusterTopics.In(s.state.metrics).Set(float64(len(metadata.Topics)))
+	clusterPartitions.In(s.state.metrics).Set(float64(partitions))
+
+	for policy, topics := range violators {
+		topicPolicyViolations.In(s.state.metrics).WithLabelValues(policy).Set(float64(len(topics)))
+		if !equalStrings(topics, s.violators[policy]) && len(topics) > 0 {
+			s.logger.Warn().Str("policy", policy).Strs("topics", topics).Msg("Topics violating the policy")
+		}
+	}
+	s.violators = violators
+}
+
+// evaluateTopicPolicy returns the sorted names of the topics violating each policy and the number
+// of partitions of the topics
+func evaluateTopicPolicy(topics []kafka.Topic, namePattern *regexp.Regexp, minReplicationFactor int) (map[string][]string, int) {
+	violators := map[string][]string{policyName: {}, policyReplicationFactor: {}}
+	partitions := 0
+	for _, topic := range topics {
+		partitions += len(topic.Partitions)
+		if topic.Internal {
+			continue
+		}
+		if namePattern != nil && !namePattern.MatchString(topic.Name) {
+			violators[policyName] = append(violators[policyName], topic.Name)
+		}
+		if minReplicationFactor > 0 && replicationFactor(topic) < minReplicationFactor {
+			violators[policyReplicationFactor] = append(violators[policyReplicationFactor], topic.Name)
+		}
+	}
+	for _, names := range violators {
+		sort.Strings(names)
+	}
+	return violators, partitions
+}
+
+// compileTopicNamePattern returns the regexp the topic names must match, nil without pattern
+func (s *topicService) compileTopicNamePattern() *regexp.Regexp {
+	pattern := s.canaryConfig.TopicPolicy.NamePattern
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		s.logger.Error().Err(err).Str("pattern", pattern).Msg("Invalid topic name pattern, the naming policy isn't checked")
+		return nil
+	}
+	return re
+}
+
+// replicationFactor returns the lowest replication factor of the topic partitions
+func replicationFactor(topic kafka.Topic) int {
+	rf := 0
+	for i, p := range topic.Partitions {
+		if i == 0 || len(p.Replicas) < rf {
+			rf = len(p.Replicas)
+		}
+	}
+	return rf
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateTopicPolicy(t *testing.T) {
+	replicas := func(n int) []kafka.Broker { return make([]kafka.Broker, n) }
+	topics := []kafka.Topic{
+		{Name: "orders.v1", Partitions: []kafka.Partition{{Replicas: replicas(3)}, {Replicas: replicas(3)}}},
+		{Name: "Scratch", Partitions: []kafka.Partition{{Replicas: replicas(1)}}},
+		{Name: "payments.v2", Partitions: []kafka.Partition{{Replicas: replicas(3)}, {Replicas: replicas(2)}}},
+		{Name: "__consumer_offsets", Internal: true, Partitions: []kafka.Partition{{Replicas: replicas(1)}}},
+	}
+
+	violators, partitions := evaluateTopicPolicy(topics, regexp.MustCompile(`^[a-z]+\.v[0-9]+$`), 3)
+	assert.Equal(t, 6, partitions)
+	assert.Equal(t, []string{"Scratch"}, violators[policyName])
+	assert.Equal(t, []string{"Scratch", "payments.v2"}, violators[policyReplicationFactor])
+
+	violators, _ = evaluateTopicPolicy(topics, nil, 0)
+	assert.Empty(t, violators[policyName])
+	assert.Empty(t, violators[policyReplicationFactor])
+}
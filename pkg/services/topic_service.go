@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+const (
+	// bounds of the polling of a created topic until its metadata is consistent, the backoff
+	// doubling from the initial one up to the max one
+	topicVisibilityTimeout    = 30 * time.Second
+	topicVisibilityBackoff    = 50 * time.Millisecond
+	topicVisibilityMaxBackoff = 2 * time.Second
+)
+
+var (
+	cleanupPolicy    string = "delete"
+	metricsNamespace        = metrics.Namespace
+
+	topicCreationFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "topic_creation_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of errors while creating the canary topic",
+	}, []string{"topic", "error_class"})
+
+	// describeClusterError = promauto.NewCounterVec(prometheus.CounterOpts{
+	// 	Name:      "topic_describe_cluster_error_total",
+	// 	Namespace: metricsNamespace,
+	// 	Help:      "Total number of errors while describing cluster",
+	// }, nil)
+
+	describeTopicError = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "topic_describe_error_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of errors while getting canary topic metadata",
+	}, []string{"topic", "error_class"})
+
+	// alterTopicAssignmentsError = promauto.NewCounterVec(prometheus.CounterOpts{
+	// 	Name:      "topic_alter_assignments_error_total",
+	// 	Namespace: metricsNamespace,
+	// 	Help:      "Total number of errors while altering partitions assignments for the canary topic",
+	// }, []string{"topic"})
+
+	alterTopicConfigurationError = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "topic_alter_configuration_error_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of errors while altering configuration for the canary topic",
+	}, []string{"topic", "error_class"})
+
+	partitionLeaderChanges = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "partition_leader_changes_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of leader changes of the canary topic partitions seen between reconciles",
+	}, []string{"partition"})
+
+	topicCreationVisibility = metrics.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "topic_creation_visibility_latency",
+		Namespace: metricsNamespace,
+		Help:      "Time from the canary topic creation until its metadata is consistent in milliseconds",
+		Buckets:   []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000},
+	}, []string{"topic"})
+)
+
+// topicMetrics contains the metrics updated by the topic reconciles
+type topicMetrics struct {
+	creationFailed   *prometheus.CounterVec
+	describeError    *prometheus.CounterVec
+	alterConfigError *prometheus.CounterVec
+	leaderChanges    *prometheus.CounterVec
+	configDrift      *prometheus.GaugeVec
+	configRemediated *prometheus.CounterVec
+	visibility       *prometheus.HistogramVec
+}
+
+// newTopicMetrics returns the topic metrics held by the registry
+func newTopicMetrics(r *metrics.Registry) topicMetrics {
+	return topicMetrics{
+		creationFailed:   topicCreationFailed.In(r),
+		describeError:    describeTopicError.In(r),
+		alterConfigError: alterTopicConfigurationError.In(r),
+		leaderChanges:    partitionLeaderChanges.In(r),
+		configDrift:      topicConfigDrift.In(r),
+		configRemediated: topicConfigRemediated.In(r),
+		visibility:       topicCreationVisibility.In(r),
+	}
+}
+
+// TopicReconcileResult contains the result of a topic reconcile
+type TopicReconcileResult struct {
+	// new partitions assignments across brokers
+	Assignments []int
+	// partition to leader assignments
+	Leaders map[int32]int32
+	// if the leaders changed since the previous reconcile, so the producer metadata needs a refresh
+	RefreshProducerMetadata bool
+}
+
+type topicService struct {
+	state  *State
+	logger *zerolog.Logger
+	admin  client.Client
+	// newAdmin creates the admin client, on the first reconcile and after a reset
+	newAdmin     func(ctx context.Context) (client.Client, error)
+	now          func() time.Time
+	metrics      topicMetrics
+	canaryConfig canary.Config
+	initialized  bool
+	// partition leaders seen on the previous reconcile
+	leaders map[int32]int32
+	// topic config keys differing from the desired ones on the previous reconcile
+	drifted map[string]bool
+	// topics violating each policy on the previous reconcile
+	violators        map[string][]string
+	topicNamePattern *regexp.Regexp
+	// set by a reset, the next reconcile bootstraps the topic again
+	rebootstrap int32
+}
+
+func NewTopicService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) TopicService {
+	newAdmin := func(ctx context.Context) (client.Client, error) {
+		return client.NewBrokerAdminClient(ctx,
+			client.BrokerAdminClientConfig{
+				ConnectorConfig: connectorConfig,
+				Auditor:         state.auditOperation,
+			}, logger)
+	}
+	return newTopicService(state, canaryConfig, logger, newAdmin, time.Now, newTopicMetrics(state.metrics))
+}
+
+// newTopicService returns a topic service creating its admin clients with newAdmin
+func newTopicService(
+	state *State,
+	canaryConfig canary.Config,
+	logger *zerolog.Logger,
+	newAdmin func(ctx context.Context) (client.Client, error),
+	now func() time.Time,
+	metrics topicMetrics,
+) *topicService {
+	s := &topicService{
+		state:        state,
+		logger:       logger,
+		newAdmin:     newAdmin,
+		now:          now,
+		metrics:      metrics,
+		canaryConfig: canaryConfig,
+		initialized:  false,
+	}
+	s.topicNamePattern = s.compileTopicNamePattern()
+	return s
+}
+
+// Reconcile makes sure the canary topic exists and is configured, flagging the service as degraded on failure
+func (s *topicService) Reconcile(ctx context.Context) (TopicReconcileResult, error) {
+	result, err := s.reconcile(ctx)
+	if err != nil {
+		s.state.markDegraded("topic", err)
+	} else {
+		s.state.markHealthy("topic")
+	}
+	return result, err
+}
+
+// Reset makes the next reconcile bootstrap the topic as on the first one: its config is applied
+// again, its drifts logged again and the producer metadata refreshed
+func (s *topicService) Reset() {
+	atomic.StoreInt32(&s.rebootstrap, 1)
+}
+
+func (s *topicService) reconcile(ctx context.Context) (TopicReconcileResult, error) {
+	result := TopicReconcileResult{}
+	if atomic.CompareAndSwapInt32(&s.rebootstrap, 1, 0) {
+		s.initialized, s.leaders, s.drifted = false, nil, nil
+	}
+
+	if _, err := s.adminClient(ctx); err != nil {
+		return result, err
+	}
+
+	_, err := s.admin.GetTopic(ctx, s.canaryConfig.Topic, false)
+
+	// If we lost the connection, reset
+	if client.IsTransientNetworkError(err) {
+		s.Close()
+		return result, kafkaerr.Wrap(err)
+	}
+
+	// assignment := s.requestAssignments()
+
+	// without topic management the topic is left to the cluster operators
+	manage := s.canaryConfig.CheckEnabled("topic_management")
+	if !manage && errors.Is(err, client.ErrTopicDoesNotExist) {
+		return result, fmt.Errorf("topic %s doesn't exist and topic management is disabled", s.canaryConfig.Topic)
+	}
+
+	// Create the topic if missing
+	// TODO: Update parition config if missmatch
+	var topic client.TopicInfo
+	if errors.Is(err, client.ErrTopicDoesNotExist) {
+		config := kafka.TopicConfig{
+			Topic:             s.canaryConfig.Topic,
+			NumPartitions:     3,
+			ReplicationFactor: 3,
+			// ReplicaAssignments: assignment,
+			ConfigEntries: s.desiredTopicConfigEntries(),
+		}
+		created := s.now()
+		err = s.admin.CreateTopic(ctx, config)
+		if err != nil {
+			labels := prometheus.Labels{
+				"topic":       s.canaryConfig.Topic,
+				"error_class": string(kafkaerr.ClassOf(err)),
+			}
+			s.metrics.creationFailed.With(labels).Inc()
+			s.state.countKafkaError("CreateTopics", err)
+			s.logger.Error().Str("topic", s.canaryConfig.Topic).Err(err).Msg("Error creating the topic")
+			return result, kafkaerr.Wrap(err)
+		}
+		s.logger.Info().Str("topic", s.canaryConfig.Topic).Msg("The canary topic was created")
+		// created with the desired config, there is nothing to alter
+		s.initialized = true
+		s.state.recordEvent(EventInfo, "topic", "created the canary topic %s", s.canaryConfig.Topic)
+		topic, err = s.awaitTopic(ctx, config, created)
+	} else {
+		topic, err = s.admin.GetTopic(ctx, s.canaryConfig.Topic, false)
+	}
+
+	// If cant describe we can't proceed
+	if err != nil {
+		labels := prometheus.Labels{
+			"topic":       s.canaryConfig.Topic,
+			"error_class": string(kafkaerr.ClassOf(err)),
+		}
+		s.metrics.describeError.With(labels).Inc()
+		s.state.countKafkaError("Metadata", err)
+		s.logger.Error().Err(err).Str("topic", s.canaryConfig.Topic).Msg("Error describing topic")
+		return result, kafkaerr.Wrap(err)
+	}
+
+	// Configure the existing topic on the first run, altering only the entries that differ
+	if manage && !s.initialized {
+		if entries := s.initialTopicConfigEntries(ctx); len(entries) > 0 {
+			if _, err := s.admin.UpdateTopicConfig(ctx, s.canaryConfig.Topic, entries, true); err != nil {
+				labels := prometheus.Labels{
+					"topic":       s.canaryConfig.Topic,
+					"error_class": string(kafkaerr.ClassOf(err)),
+				}
+				s.metrics.alterConfigError.With(labels).Inc()
+				s.state.countKafkaError("IncrementalAlterConfigs", err)
+				s.logger.Error().Err(err).Str("topic", s.canaryConfig.Topic).Msg("Error altering topic configuration")
+				return result, kafkaerr.Wrap(err)
+			}
+		}
+		s.initialized = true
+	}
+	s.checkConfigDrift(ctx, manage)
+
+	result.Assignments = topic.PartitionIDs()
+	result.Leaders = make(map[int32]int32, len(topic.Partitions))
+	for _, p := range topic.Partitions {
+		result.Leaders[int32(p.ID)] = int32(p.Leader)
+	}
+	result.RefreshProducerMetadata = s.leadersChanged(result.Leaders)
+	s.leaders = result.Leaders
+	s.updateClusterInfo(ctx)
+	s.checkTopicPolicy(ctx)
+
+	return result, nil
+}
+
+// awaitTopic polls the created topic with a bounded backoff until its metadata is consistent on
+// every broker, the brokers propagating the metadata of a new topic asynchronously, and observes
+// how long it took
+func (s *topicService) awaitTopic(ctx context.Context, config kafka.TopicConfig, created time.Time) (client.TopicInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, topicVisibilityTimeout)
+	defer cancel()
+	backoff := topicVisibilityBackoff
+	for {
+		topic, err := s.admin.GetTopic(ctx, config.Topic, false)
+		if err == nil {
+			err = topicConsistent(topic, config)
+		}
+		if err == nil {
+			err = s.topicPropagated(ctx, config)
+		}
+		if err == nil {
+			visible := s.now().Sub(created)
+			s.metrics.visibility.WithLabelValues(config.Topic).Observe(float64(visible.Milliseconds()))
+			s.logger.Debug().Str("topic", config.Topic).Dur("latency", visible).Msg("The canary topic metadata is consistent")
+			return topic, nil
+		}
+		s.logger.Debug().Err(err).Str("topic", config.Topic).Dur("backoff", backoff).Msg("Waiting for the canary topic metadata")
+		select {
+		case <-ctx.Done():
+			return client.TopicInfo{}, err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > topicVisibilityMaxBackoff {
+			backoff = topicVisibilityMaxBackoff
+		}
+	}
+}
+
+// topicConsistent returns an error unless the topic has all the partitions of its config, each with
+// a leader and all its replicas
+func topicConsistent(topic client.TopicInfo, config kafka.TopicConfig) error {
+	if len(topic.Partitions) != config.NumPartitions {
+		return fmt.Errorf("topic %s has %d partitions, expected %d", config.Topic, len(topic.Partitions), config.NumPartitions)
+	}
+	for _, p := range topic.Partitions {
+		if p.Leader < 0 {
+			return fmt.Errorf("partition %d of topic %s has no leader", p.ID, config.Topic)
+		}
+		if len(p.Replicas) != config.ReplicationFactor {
+			return fmt.Errorf("partition %d of topic %s has %d replicas, expected %d", p.ID, config.Topic, len(p.Replicas), config.ReplicationFactor)
+		}
+	}
+	return nil
+}
+
+// topicPropagated returns an error unless every broker serves the topic metadata with all the
+// partitions of its config, each with the same leader
+func (s *topicService) topicPropagated(ctx context.Context, config kafka.TopicConfig) error {
+	views, failed, err := brokerViews(ctx, s.state, s.admin.GetConnector().KafkaClient, config.Topic)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error fetching the topic %s metadata from brokers: %v", config.Topic, failed)
+	}
+	for broker, view := range views {
+		if len(view) != config.NumPartitions {
+			return fmt.Errorf("broker %d has %d partitions of topic %s, expected %d", broker, len(view), config.Topic, config.NumPartitions)
+		}
+		for partition, leader := range view {
+			if leader < 0 {
+				return fmt.Errorf("partition %d of topic %s has no leader on broker %d", partition, config.Topic, broker)
+			}
+		}
+	}
+	for broker, count := range divergentPartitions(views) {
+		if count > 0 {
+			return fmt.Errorf("broker %d has other leaders for %d partitions of topic %s", broker, count, config.Topic)
+		}
+	}
+	return nil
+}
+
+// leadersChanged returns true when the leaders differ from the previous reconcile, counting the
+// partitions whose leader moved
+func (s *topicService) leadersChanged(leaders map[int32]int32) bool {
+	if s.leaders == nil {
+		return true
+	}
+	changed := len(leaders) != len(s.leaders)
+	for partition, leader := range leaders {
+		previous, ok := s.leaders[partition]
+		if ok && previous != leader {
+			s.metrics.leaderChanges.WithLabelValues(strconv.Itoa(int(partition))).Inc()
+			s.logger.Info().
+				Int32("partition", partition).
+				Int32("previous", previous).
+				Int32("leader", leader).
+				Msg("Partition leader changed")
+			s.state.recordEvent(EventInfo, "topic", "partition %d leader changed from broker %d to %d", partition, previous, leader)
+		}
+		if !ok || previous != leader {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// DescribePartition returns the current leader and replicas of a canary topic partition
+func (s *topicService) DescribePartition(ctx context.Context, partition int) (client.PartitionInfo, error) {
+	admin, err := s.adminClient(ctx)
+	if err != nil {
+		return client.PartitionInfo{}, err
+	}
+
+	topic, err := admin.GetTopic(ctx, s.canaryConfig.Topic, false)
+	if err != nil {
+		return client.PartitionInfo{}, kafkaerr.Wrap(err)
+	}
+	for _, p := range topic.Partitions {
+		if p.ID == partition {
+			return p, nil
+		}
+	}
+	return client.PartitionInfo{}, fmt.Errorf("partition %d not found in topic %s", partition, s.canaryConfig.Topic)
+}
+
+// adminClient returns the admin client, creating it if needed
+func (s *topicService) adminClient(ctx context.Context) (client.Client, error) {
+	if s.admin == nil {
+		a, err := s.newAdmin(ctx)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Error creating cluster admin client")
+			return nil, kafkaerr.Wrap(err)
+		}
+		s.admin = a
+	}
+	return s.admin, nil
+}
+
+func (s *topicService) Close() {
+	s.logger.Info().Msg("Closing topic service")
+
+	if s.admin == nil {
+		return
+	}
+	if err := s.admin.Close(); err != nil {
+		s.logger.Error().Err(err).Msg("Error closing cluster admin")
+		s.state.markDegraded("topic", err)
+	}
+	// a new admin client is created on the next reconcile
+	s.admin = nil
+}
+
+// TODO: Implement
+// func (s *topicService) requestAssignments() {
+// 	brokers, err := s.admin.GetBrokerIDs(ctx)
+// 	if err != nil {
+// 		s.logger.Fatal().Err(err).Msg("Error getting broker information")
+// 	}
+//
+// 	brokersNumber := len(brokers)
+// 	partitions := max(currentPartitions, brokersNumber)
+// 	replicationFactor := min(brokersNumber, 3)
+// 	minISR := max(1, replicationFactor-1)
+//
+// 	assignments := kafka.PartitionAssignment{}
+// }
+//
+// func max(x, y int) int {
+// 	if x < y {
+// 		return y
+// 	}
+// 	return x
+// }
+//
+// func min(x, y int) int {
+// 	if x > y {
+// 		return y
+// 	}
+// 	return x
+// }
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/describeconfigs"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// fakeAdmin is an admin client of a cluster with the given brokers, each of its reconciles
+// describing the canary topic with the next of the topic results. The admin calls the topic
+// service must not make panic on the nil embedded client. The topic config is described as config
+// when set. The brokers serve the metadata of the last topic described, but for the first stale
+// topic metadata requests.
+type fakeAdmin struct {
+	client.Client
+
+	topics    []fakeTopicResult
+	createErr error
+	brokers   *[]int32
+	config    map[string]string
+	stale     int
+
+	gets          int
+	described     client.TopicInfo
+	topicMetadata int
+	created       []kafka.TopicConfig
+	updated       [][]kafka.ConfigEntry
+	closed        bool
+}
+
+type fakeTopicResult struct {
+	topic client.TopicInfo
+	err   error
+}
+
+func (a *fakeAdmin) GetTopic(_ context.Context, _ string, _ bool) (client.TopicInfo, error) {
+	result := a.topics[len(a.topics)-1]
+	if a.gets < len(a.topics) {
+		result = a.topics[a.gets]
+	}
+	a.gets++
+	a.described = result.topic
+	return result.topic, result.err
+}
+
+// brokerTopic returns the topic in the metadata of a broker, false while the broker is stale
+func (a *fakeAdmin) brokerTopic() (client.TopicInfo, bool) {
+	a.topicMetadata++
+	return a.described, a.topicMetadata > a.stale
+}
+
+func (a *fakeAdmin) CreateTopic(_ context.Context, config kafka.TopicConfig) error {
+	a.created = append(a.created, config)
+	return a.createErr
+}
+
+func (a *fakeAdmin) UpdateTopicConfig(_ context.Context, _ string, entries []kafka.ConfigEntry, _ bool) ([]string, error) {
+	a.updated = append(a.updated, entries)
+	return nil, nil
+}
+
+func (a *fakeAdmin) GetConnector() *client.Connector {
+	return &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: fakeMetadataTransport{a.brokers, a.config, a.brokerTopic}}}
+}
+
+func (a *fakeAdmin) Close() error {
+	a.closed = true
+	return nil
+}
+
+// fakeMetadataTransport answers the metadata requests with the brokers and the topic, and the
+// describe configs ones with the topic config, failing the others
+type fakeMetadataTransport struct {
+	brokers *[]int32
+	config  map[string]string
+	topic   func() (client.TopicInfo, bool)
+}
+
+func (t fakeMetadataTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	if req, ok := req.(*describeconfigs.Request); ok && t.config != nil {
+		resource := describeconfigs.ResponseResource{ResourceType: req.Resources[0].ResourceType, ResourceName: req.Resources[0].ResourceName}
+		for _, name := range req.Resources[0].ConfigNames {
+			resource.ConfigEntries = append(resource.ConfigEntries, describeconfigs.ResponseConfigEntry{ConfigName: name, ConfigValue: t.config[name]})
+		}
+		return &describeconfigs.Response{Resources: []describeconfigs.ResponseResource{resource}}, nil
+	}
+	if _, ok := req.(*metadata.Request); !ok || t.brokers == nil {
+		return nil, errors.New("unsupported request")
+	}
+	resp := &metadata.Response{}
+	for _, id := range *t.brokers {
+		resp.Brokers = append(resp.Brokers, metadata.ResponseBroker{NodeID: id, Host: "broker", Port: 9092})
+	}
+	for _, name := range req.(*metadata.Request).TopicNames {
+		topic, ok := t.topic()
+		if !ok {
+			resp.Topics = append(resp.Topics, metadata.ResponseTopic{Name: name, ErrorCode: int16(kafka.UnknownTopicOrPartition)})
+			continue
+		}
+		responseTopic := metadata.ResponseTopic{Name: name}
+		for _, p := range topic.Partitions {
+			partition := metadata.ResponsePartition{PartitionIndex: int32(p.ID), LeaderID: int32(p.Leader)}
+			for _, replica := range p.Replicas {
+				partition.ReplicaNodes = append(partition.ReplicaNodes, int32(replica))
+			}
+			responseTopic.Partitions = append(responseTopic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, responseTopic)
+	}
+	return resp, nil
+}
+
+func newTestTopicMetrics() topicMetrics {
+	counter := func() *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test"}, []string{"topic", "error_class"})
+	}
+	return topicMetrics{
+		creationFailed:   counter(),
+		describeError:    counter(),
+		alterConfigError: counter(),
+		leaderChanges:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test"}, []string{"partition"}),
+		configDrift:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"topic", "key"}),
+		configRemediated: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test"}, []string{"topic"}),
+		visibility:       prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test"}, []string{"topic"}),
+	}
+}
+
+func TestTopicServiceReconcile(t *testing.T) {
+	st := newTestState()
+	topic := client.TopicInfo{Name: "__kafka_canary", Partitions: []client.PartitionInfo{
+		{ID: 0, Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1, 2, 3}},
+		{ID: 1, Leader: 2, Replicas: []int{2, 3, 1}, ISR: []int{2, 3, 1}},
+		{ID: 2, Leader: 3, Replicas: []int{3, 1, 2}, ISR: []int{3, 1, 2}},
+	}}
+	desired := []kafka.ConfigEntry{
+		{ConfigName: "cleanup.policy", ConfigValue: "delete"},
+		{ConfigName: "min.insync.replicas", ConfigValue: "3"},
+	}
+	brokers := []int32{1, 2, 3}
+
+	tests := []struct {
+		name     string
+		disabled []string
+		admin    *fakeAdmin
+		// reconciles run, the result and error checked are the last ones
+		reconciles int
+		wantErr    bool
+		check      func(t *testing.T, s *topicService, admin *fakeAdmin, admins int, result TopicReconcileResult)
+	}{
+		{
+			name: "create on missing",
+			admin: &fakeAdmin{topics: []fakeTopicResult{
+				{err: client.ErrTopicDoesNotExist},
+				{topic: topic},
+			}, brokers: &brokers},
+			reconciles: 1,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, result TopicReconcileResult) {
+				require.Len(t, admin.created, 1)
+				assert.Equal(t, "__kafka_canary", admin.created[0].Topic)
+				assert.Equal(t, desired, admin.created[0].ConfigEntries)
+				// created with its config, it isn't altered afterwards
+				assert.Empty(t, admin.updated)
+				assert.True(t, s.initialized)
+				assert.Equal(t, []int{0, 1, 2}, result.Assignments)
+				assert.Equal(t, map[int32]int32{0: 1, 1: 2, 2: 3}, result.Leaders)
+				assert.True(t, result.RefreshProducerMetadata)
+			},
+		},
+		{
+			name: "create waits for consistent metadata",
+			admin: &fakeAdmin{topics: []fakeTopicResult{
+				{err: client.ErrTopicDoesNotExist},
+				{err: client.ErrTopicDoesNotExist},
+				{topic: client.TopicInfo{Name: "__kafka_canary", Partitions: topic.Partitions[:2]}},
+				{topic: client.TopicInfo{Name: "__kafka_canary", Partitions: []client.PartitionInfo{
+					{ID: 0, Leader: -1, Replicas: []int{1, 2, 3}},
+					topic.Partitions[1],
+					topic.Partitions[2],
+				}}},
+				{topic: topic},
+			}, brokers: &brokers},
+			reconciles: 1,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, result TopicReconcileResult) {
+				assert.Equal(t, 5, admin.gets)
+				assert.Equal(t, []int{0, 1, 2}, result.Assignments)
+				// a describe racing the metadata propagation isn't an error
+				assert.Equal(t, 0, testutil.CollectAndCount(s.metrics.describeError))
+				assert.Equal(t, 1, testutil.CollectAndCount(s.metrics.visibility))
+			},
+		},
+		{
+			name: "create waits for every broker",
+			admin: &fakeAdmin{topics: []fakeTopicResult{
+				{err: client.ErrTopicDoesNotExist},
+				{topic: topic},
+			}, brokers: &brokers, stale: 2},
+			reconciles: 1,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, result TopicReconcileResult) {
+				// described again once the stale brokers served the topic
+				assert.Equal(t, 3, admin.gets)
+				assert.Equal(t, []int{0, 1, 2}, result.Assignments)
+				assert.Equal(t, 1, testutil.CollectAndCount(s.metrics.visibility))
+			},
+		},
+		{
+			name:     "missing without topic management",
+			disabled: []string{"topic_management"},
+			admin: &fakeAdmin{topics: []fakeTopicResult{
+				{err: client.ErrTopicDoesNotExist},
+			}},
+			reconciles: 1,
+			wantErr:    true,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, _ TopicReconcileResult) {
+				assert.Empty(t, admin.created)
+				assert.Empty(t, admin.updated)
+			},
+		},
+		{
+			name: "create failure",
+			admin: &fakeAdmin{
+				topics:    []fakeTopicResult{{err: client.ErrTopicDoesNotExist}},
+				createErr: errors.New("policy violation"),
+			},
+			reconciles: 1,
+			wantErr:    true,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, _ TopicReconcileResult) {
+				assert.Equal(t, 1, testutil.CollectAndCount(s.metrics.creationFailed))
+				assert.False(t, s.initialized)
+			},
+		},
+		{
+			name: "transient error reset",
+			admin: &fakeAdmin{topics: []fakeTopicResult{
+				{err: io.ErrUnexpectedEOF},
+				{topic: topic},
+			}},
+			reconciles: 2,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, admins int, _ TopicReconcileResult) {
+				// the admin client is closed and a new one created by the next reconcile
+				assert.True(t, admin.closed)
+				assert.Equal(t, 2, admins)
+				assert.Empty(t, admin.created)
+			},
+		},
+		{
+			name:       "config alter on first run",
+			admin:      &fakeAdmin{topics: []fakeTopicResult{{topic: topic}}},
+			reconciles: 3,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, result TopicReconcileResult) {
+				assert.Equal(t, [][]kafka.ConfigEntry{desired}, admin.updated)
+				assert.True(t, s.initialized)
+				assert.False(t, result.RefreshProducerMetadata)
+			},
+		},
+		{
+			name: "config alter of the differing entries",
+			admin: &fakeAdmin{
+				topics: []fakeTopicResult{{topic: topic}},
+				config: map[string]string{"cleanup.policy": "delete", "min.insync.replicas": "2"},
+			},
+			reconciles: 2,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, _ TopicReconcileResult) {
+				assert.Equal(t, [][]kafka.ConfigEntry{{{ConfigName: "min.insync.replicas", ConfigValue: "3"}}}, admin.updated)
+			},
+		},
+		{
+			name: "config matching left alone",
+			admin: &fakeAdmin{
+				topics: []fakeTopicResult{{topic: topic}},
+				config: map[string]string{"cleanup.policy": "delete", "min.insync.replicas": "3"},
+			},
+			reconciles: 2,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, _ TopicReconcileResult) {
+				assert.Empty(t, admin.updated)
+				assert.True(t, s.initialized)
+			},
+		},
+		{
+			name:       "config left alone without topic management",
+			disabled:   []string{"topic_management"},
+			admin:      &fakeAdmin{topics: []fakeTopicResult{{topic: topic}}},
+			reconciles: 2,
+			check: func(t *testing.T, s *topicService, admin *fakeAdmin, _ int, _ TopicReconcileResult) {
+				assert.Empty(t, admin.updated)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admins := 0
+			newAdmin := func(context.Context) (client.Client, error) {
+				admins++
+				return tt.admin, nil
+			}
+			logger := zerolog.Nop()
+			s := newTopicService(st, canary.Config{Topic: "__kafka_canary", DisabledChecks: tt.disabled}, &logger, newAdmin, time.Now, newTestTopicMetrics())
+
+			var result TopicReconcileResult
+			var err error
+			for i := 0; i < tt.reconciles; i++ {
+				result, err = s.reconcile(context.Background())
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			tt.check(t, s, tt.admin, admins, result)
+		})
+	}
+}
+
+func TestTopicServiceReset(t *testing.T) {
+	st := newTestState()
+	topic := client.TopicInfo{Name: "__kafka_canary", Partitions: []client.PartitionInfo{
+		{ID: 0, Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1, 2, 3}},
+	}}
+	admin := &fakeAdmin{topics: []fakeTopicResult{{topic: topic}}}
+	logger := zerolog.Nop()
+	s := newTopicService(st, canary.Config{Topic: "__kafka_canary"}, &logger, func(context.Context) (client.Client, error) {
+		return admin, nil
+	}, time.Now, newTestTopicMetrics())
+
+	_, err := s.reconcile(context.Background())
+	require.NoError(t, err)
+	result, err := s.reconcile(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, admin.updated, 1)
+	assert.False(t, result.RefreshProducerMetadata)
+
+	// the next reconcile bootstraps the topic again
+	s.Reset()
+	result, err = s.reconcile(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, admin.updated, 2)
+	assert.True(t, result.RefreshProducerMetadata)
+}
+
+func TestTopicServiceReconcileBrokerScaleUp(t *testing.T) {
+	st := newTestState()
+	topic := client.TopicInfo{Name: "__kafka_canary", Partitions: []client.PartitionInfo{
+		{ID: 0, Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1, 2, 3}},
+		{ID: 1, Leader: 2, Replicas: []int{2, 3, 1}, ISR: []int{2, 3, 1}},
+		{ID: 2, Leader: 3, Replicas: []int{3, 1, 2}, ISR: []int{3, 1, 2}},
+	}}
+	brokers := []int32{1, 2, 3}
+	admin := &fakeAdmin{topics: []fakeTopicResult{{topic: topic}}, brokers: &brokers}
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	logger := zerolog.Nop()
+	s := newTopicService(st, canary.Config{Topic: "__kafka_canary"}, &logger,
+		func(context.Context) (client.Client, error) { return admin, nil },
+		func() time.Time { return now }, newTestTopicMetrics())
+
+	_, err := s.reconcile(context.Background())
+	require.NoError(t, err)
+
+	// the added brokers show up in the cluster info, the canary topic is left as is
+	brokers = append(brokers, 4, 5)
+	now = now.Add(time.Minute)
+	result, err := s.reconcile(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, result.Assignments)
+	assert.False(t, result.RefreshProducerMetadata)
+	assert.Empty(t, admin.created)
+
+	var info ClusterInfo
+	require.NoError(t, json.Unmarshal(st.cluster.snapshot, &info))
+	assert.Equal(t, now, info.UpdatedAt)
+	require.Len(t, info.Brokers, 5)
+	assert.Equal(t, 5, info.Brokers[4].ID)
+}
+
+func TestTopicServiceDescribePartition(t *testing.T) {
+	st := newTestState()
+	topic := client.TopicInfo{Name: "__kafka_canary", Partitions: []client.PartitionInfo{
+		{ID: 0, Leader: 1, Replicas: []int{1, 2, 3}, ISR: []int{1, 2}},
+	}}
+	logger := zerolog.Nop()
+	admin := &fakeAdmin{topics: []fakeTopicResult{{topic: topic}, {err: errors.New("connection refused")}}}
+	adminErr := error(nil)
+	s := newTopicService(st, canary.Config{Topic: "__kafka_canary"}, &logger, func(context.Context) (client.Client, error) {
+		return admin, adminErr
+	}, time.Now, newTestTopicMetrics())
+
+	partition, err := s.DescribePartition(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, topic.Partitions[0], partition)
+
+	_, err = s.DescribePartition(context.Background(), 0)
+	assert.ErrorContains(t, err, "connection refused")
+
+	admin.topics = []fakeTopicResult{{topic: topic}}
+	_, err = s.DescribePartition(context.Background(), 5)
+	assert.EqualError(t, err, "partition 5 not found in topic __kafka_canary")
+
+	s.admin = nil
+	adminErr = errors.New("no brokers")
+	_, err = s.DescribePartition(context.Background(), 0)
+	assert.ErrorContains(t, err, "no brokers")
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+)
+
+// SeriesDeleter is a labeled metric whose series can be deleted, e.g. a *prometheus.CounterVec
+type SeriesDeleter interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+type trackedLabel struct {
+	vec   metrics.Definition
+	label string
+}
+
+var (
+	trackedLabelsLock sync.Mutex
+	// metrics labeled by canary topic partition or by broker ID
+	partitionLabels []trackedLabel
+	brokerLabels    []trackedLabel
+)
+
+// topology is the canary topic partitions and the brokers seen by the last topic reconcile of a
+// canary
+type topology struct {
+	lock       sync.Mutex
+	partitions map[int]bool
+	brokers    map[int]bool
+	// incremented when series are deleted, so the cached metric children are dropped
+	generation uint64
+}
+
+func init() {
+	for _, vec := range []metrics.Definition{recordsProduced, recordsProducedFailed, recordsConsumed, recordsLost, recordsDuplicated,
+		partitionLeaderChanges, offsetForTimestampLatency, offsetForTimestampMismatch, produceFailureAttempts, produceFailureRecovery} {
+		TrackPartitionLabel(vec, "partition")
+	}
+	for _, vec := range []metrics.Definition{brokerTimestampSkew, metadataDivergence, metadataPartitions,
+		directLeaderLatency, directLeaderFailed, directLeaderUp} {
+		TrackBrokerLabel(vec, "broker")
+	}
+}
+
+// TrackPartitionLabel deletes the series of the metric whose label is a canary topic partition
+// once the partition is gone, instead of leaving them frozen
+func TrackPartitionLabel(vec metrics.Definition, label string) {
+	trackedLabelsLock.Lock()
+	defer trackedLabelsLock.Unlock()
+	partitionLabels = append(partitionLabels, trackedLabel{vec, label})
+}
+
+// TrackBrokerLabel deletes the series of the metric whose label is a broker ID once the broker
+// left the cluster, instead of leaving them frozen
+func TrackBrokerLabel(vec metrics.Definition, label string) {
+	trackedLabelsLock.Lock()
+	defer trackedLabelsLock.Unlock()
+	brokerLabels = append(brokerLabels, trackedLabel{vec, label})
+}
+
+// pruneTopology deletes the series of the partitions and brokers gone since the previous call,
+// returning them
+func (st *State) pruneTopology(partitions, brokers []int) (gonePartitions, goneBrokers []int) {
+	t := &st.topology
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var nextPartitions, nextBrokers map[int]bool
+	nextPartitions, gonePartitions = diffTopology(t.partitions, partitions)
+	nextBrokers, goneBrokers = diffTopology(t.brokers, brokers)
+	t.partitions, t.brokers = nextPartitions, nextBrokers
+
+	trackedLabelsLock.Lock()
+	partitionVecs := st.trackedSeries(partitionLabels)
+	brokerVecs := st.trackedSeries(brokerLabels)
+	trackedLabelsLock.Unlock()
+	// the histograms with configured buckets aren't defined metrics
+	for _, vec := range []*prometheus.HistogramVec{st.recordsProducedLatency, st.recordsEndToEndLatency} {
+		partitionVecs = append(partitionVecs, trackedSeries{vec, "partition"})
+	}
+
+	deleteSeries(partitionVecs, gonePartitions)
+	deleteSeries(brokerVecs, goneBrokers)
+	for _, partition := range gonePartitions {
+		st.forgetPartition(partition)
+	}
+	if len(gonePartitions) > 0 || len(goneBrokers) > 0 {
+		atomic.AddUint64(&t.generation, 1)
+	}
+	return gonePartitions, goneBrokers
+}
+
+// topologyGeneration returns the generation of the series, incremented when some are deleted
+func (st *State) topologyGeneration() uint64 {
+	return atomic.LoadUint64(&st.topology.generation)
+}
+
+// trackedSeries is a tracked label of a metric held by the registry of a canary
+type trackedSeries struct {
+	vec   SeriesDeleter
+	label string
+}
+
+func (st *State) trackedSeries(tracked []trackedLabel) []trackedSeries {
+	series := make([]trackedSeries, 0, len(tracked))
+	for _, t := range tracked {
+		if vec, ok := t.vec.CollectorIn(st.metrics).(SeriesDeleter); ok {
+			series = append(series, trackedSeries{vec, t.label})
+		}
+	}
+	return series
+}
+
+// diffTopology returns the current IDs as a set and the known ones missing from them
+func diffTopology(known map[int]bool, current []int) (map[int]bool, []int) {
+	next := make(map[int]bool, len(current))
+	for _, id := range current {
+		next[id] = true
+	}
+	var gone []int
+	for id := range known {
+		if !next[id] {
+			gone = append(gone, id)
+		}
+	}
+	return next, gone
+}
+
+func deleteSeries(tracked []trackedSeries, ids []int) {
+	for _, id := range ids {
+		value := strconv.Itoa(id)
+		for _, t := range tracked {
+			t.vec.DeletePartialMatch(prometheus.Labels{t.label: value})
+		}
+	}
+}
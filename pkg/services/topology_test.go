@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneTopology(t *testing.T) {
+	st := newTestState()
+	for _, partition := range []string{"0", "1", "2"} {
+		recordsLost.In(st.metrics).WithLabelValues(partition).Inc()
+	}
+	for _, broker := range []string{"1", "2"} {
+		metadataPartitions.In(st.metrics).WithLabelValues(broker).Set(3)
+	}
+
+	// the first reconcile only records the topology
+	gonePartitions, goneBrokers := st.pruneTopology([]int{0, 1, 2}, []int{1, 2})
+	assert.Empty(t, gonePartitions)
+	assert.Empty(t, goneBrokers)
+	assert.Equal(t, 3, testutil.CollectAndCount(recordsLost.In(st.metrics)))
+
+	// the cluster scaled down
+	st.markConsumed(2)
+	gonePartitions, goneBrokers = st.pruneTopology([]int{0, 1}, []int{1})
+	assert.Equal(t, []int{2}, gonePartitions)
+	assert.Equal(t, []int{2}, goneBrokers)
+	assert.Equal(t, 2, testutil.CollectAndCount(recordsLost.In(st.metrics)))
+	assert.Equal(t, 1, testutil.CollectAndCount(metadataPartitions.In(st.metrics)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metadataPartitions.In(st.metrics).WithLabelValues("1")))
+	_, tracked := st.partitionProgressSnapshot()[2]
+	assert.False(t, tracked, "stall of the partition gone still tracked")
+
+	st.pruneTopology([]int{0, 1, 2}, []int{1, 2})
+}
+
+func TestConsumerMetricsAfterPrune(t *testing.T) {
+	s := newTestConsumer(t)
+	st := s.state
+	st.pruneTopology([]int{0, 1}, nil)
+	messages := testRecords(2)
+	s.handle(messages[0], nil)
+
+	st.pruneTopology([]int{0}, nil)
+	st.pruneTopology([]int{0, 1}, nil)
+	s.handle(messages[1], nil)
+	assert.Equal(t, 1.0, testutil.ToFloat64(recordsConsumed.In(st.metrics).WithLabelValues("canary", "1")))
+}
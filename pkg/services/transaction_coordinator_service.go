@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+var (
+	transactionCoordinatorLatency = metrics.NewHistogram(prometheus.HistogramOpts{
+		Name:      "transaction_coordinator_latency",
+		Namespace: metricsNamespace,
+		Help:      "InitProducerId latency for the canary transactional ID in milliseconds",
+		Buckets:   []float64{5, 10, 50, 100, 500, 1000, 5000},
+	})
+
+	transactionCoordinatorFailed = metrics.NewCounterVec(prometheus.CounterOpts{
+		Name:      "transaction_coordinator_failed_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of failed InitProducerId requests for the canary transactional ID",
+	}, []string{"error_class"})
+)
+
+// transactionCoordinatorService initializes a transactional producer ID, exercising the
+// transaction coordinator path without running transactions
+type transactionCoordinatorService struct {
+	state        *State
+	connector    *client.Connector
+	canaryConfig *canary.Config
+	logger       *zerolog.Logger
+}
+
+func NewTransactionCoordinatorService(state *State, canaryConfig canary.Config, connectorConfig client.ConnectorConfig, logger *zerolog.Logger) (CheckService, error) {
+	connector, err := client.NewConnector(connectorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transactionCoordinatorService{
+		state:        state,
+		connector:    connector,
+		canaryConfig: &canaryConfig,
+		logger:       logger,
+	}, nil
+}
+
+func (s *transactionCoordinatorService) Name() string {
+	return "transaction_coordinator"
+}
+
+func (s *transactionCoordinatorService) Check(ctx context.Context) error {
+	start := time.Now()
+	// the transaction coordinator of the transactional ID is looked up by the transport
+	resp, err := s.connector.KafkaClient.InitProducerID(ctx, &kafka.InitProducerIDRequest{
+		TransactionalID:      s.canaryConfig.TransactionCoordinator.TransactionalID,
+		TransactionTimeoutMs: int(time.Minute.Milliseconds()),
+		ProducerID:           -1,
+		ProducerEpoch:        -1,
+	})
+	if err == nil {
+		err = resp.Error
+	}
+	if err != nil {
+		s.state.countKafkaError("InitProducerId", err)
+		transactionCoordinatorFailed.In(s.state.metrics).WithLabelValues(string(kafkaerr.ClassOf(err))).Inc()
+		return kafkaerr.Wrap(err)
+	}
+	transactionCoordinatorLatency.In(s.state.metrics).Observe(float64(time.Since(start).Milliseconds()))
+
+	s.logger.Debug().
+		Str("transactional_id", s.canaryConfig.TransactionCoordinator.TransactionalID).
+		Int("producer_id", resp.Producer.ProducerID).
+		Int("producer_epoch", resp.Producer.ProducerEpoch).
+		Msg("Initialized transactional producer ID")
+	return nil
+}
+
+func (s *transactionCoordinatorService) Close() {}
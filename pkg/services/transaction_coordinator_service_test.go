@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/initproducerid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/kafkaerr"
+)
+
+// fakeCoordinatorTransport answers the InitProducerId requests with the error, recording the
+// transactional IDs requested
+type fakeCoordinatorTransport struct {
+	code kafka.Error
+	err  error
+	ids  []string
+}
+
+func (t *fakeCoordinatorTransport) RoundTrip(_ context.Context, _ net.Addr, req protocol.Message) (protocol.Message, error) {
+	init, ok := req.(*initproducerid.Request)
+	if !ok {
+		return nil, errors.New("unsupported request")
+	}
+	t.ids = append(t.ids, init.TransactionalID)
+	if t.err != nil {
+		return nil, t.err
+	}
+	return &initproducerid.Response{ErrorCode: int16(t.code), ProducerID: 1000, ProducerEpoch: 1}, nil
+}
+
+func TestTransactionCoordinatorCheck(t *testing.T) {
+	st := newTestState()
+	latencies := func() uint64 {
+		var m dto.Metric
+		require.NoError(t, transactionCoordinatorLatency.In(st.metrics).(prometheus.Metric).Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	tests := []struct {
+		name      string
+		transport *fakeCoordinatorTransport
+		wantClass kafkaerr.Class
+	}{
+		{name: "producer ID initialized", transport: &fakeCoordinatorTransport{}},
+		{name: "coordinator unavailable", transport: &fakeCoordinatorTransport{code: kafka.GroupCoordinatorNotAvailable}, wantClass: kafkaerr.ClassCoordinator},
+		{name: "transactional ID denied", transport: &fakeCoordinatorTransport{code: kafka.TransactionalIDAuthorizationFailed}, wantClass: kafkaerr.ClassAuthz},
+		{
+			name:      "broker unreachable",
+			transport: &fakeCoordinatorTransport{err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}},
+			wantClass: kafkaerr.ClassNetwork,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			s := &transactionCoordinatorService{
+				state:     st,
+				connector: &client.Connector{KafkaClient: &kafka.Client{Addr: kafka.TCP("broker:9092"), Transport: tt.transport}},
+				canaryConfig: &canary.Config{
+					TransactionCoordinator: canary.TransactionCoordinatorConfig{TransactionalID: "kafka-canary"},
+				},
+				logger: &logger,
+			}
+			observed := latencies()
+			var failed float64
+			if tt.wantClass != "" {
+				failed = testutil.ToFloat64(transactionCoordinatorFailed.In(st.metrics).WithLabelValues(string(tt.wantClass)))
+			}
+
+			err := s.Check(context.Background())
+			assert.Equal(t, []string{"kafka-canary"}, tt.transport.ids)
+			if tt.wantClass == "" {
+				require.NoError(t, err)
+				assert.Equal(t, observed+1, latencies())
+				return
+			}
+			assert.Equal(t, tt.wantClass, kafkaerr.ClassOf(err))
+			assert.Equal(t, failed+1, testutil.ToFloat64(transactionCoordinatorFailed.In(st.metrics).WithLabelValues(string(tt.wantClass))))
+			assert.Equal(t, observed, latencies(), "failures aren't measured")
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindow aggregates the values added over a sliding time window of the given size, in
+// buckets of the given resolution, answering sums over any lookback up to its size. Buckets are
+// indexed by time, so adding a value is O(1) and buckets outliving the window are reset lazily
+// when their slot is reused.
+//
+// Unlike TimeWindowRing it needs no periodic sampling: the values are the increments themselves,
+// e.g. the number of records produced, not running totals.
+type SlidingWindow struct {
+	lock       sync.Mutex
+	resolution time.Duration
+	values     []uint64
+	// index of the time slot of each bucket, since the Unix epoch in resolution units
+	slots []int64
+	// first time slot a value was added or restored in
+	first int64
+	now   func() time.Time
+}
+
+// WindowBucket is the value aggregated by a sliding window bucket starting at the given time
+type WindowBucket struct {
+	Start time.Time `json:"start"`
+	Value uint64    `json:"value"`
+}
+
+// NewSlidingWindow returns a sliding window covering the given size, the resolution is raised
+// to cap the buckets at maxBufferBuckets
+func NewSlidingWindow(size time.Duration, resolution time.Duration) *SlidingWindow {
+	if resolution <= 0 {
+		resolution = time.Second
+	}
+	if size < resolution {
+		size = resolution
+	}
+	if size/resolution > maxBufferBuckets {
+		resolution = (size + maxBufferBuckets - 1) / maxBufferBuckets
+	}
+	buckets := int((size + resolution - 1) / resolution)
+	slots := make([]int64, buckets)
+	for i := range slots {
+		slots[i] = -1
+	}
+	return &SlidingWindow{
+		resolution: resolution,
+		values:     make([]uint64, buckets),
+		slots:      slots,
+		first:      -1,
+		now:        time.Now,
+	}
+}
+
+// Size returns the time window covered
+func (w *SlidingWindow) Size() time.Duration {
+	return time.Duration(len(w.values)) * w.resolution
+}
+
+// Add adds the value to the bucket of the current time
+func (w *SlidingWindow) Add(value uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.add(w.slot(w.now()), value)
+}
+
+// Sum returns the sum of the values added over the lookback, capped at the window size and
+// rounded up to the resolution
+func (w *SlidingWindow) Sum(lookback time.Duration) uint64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	current := w.slot(w.now())
+	oldest := current - w.lookbackSlots(lookback) + 1
+	var sum uint64
+	for i, slot := range w.slots {
+		if slot >= oldest && slot <= current {
+			sum += w.values[i]
+		}
+	}
+	return sum
+}
+
+// Covered returns the part of the lookback with values, i.e. the time since the first value
+// was added when more recent than the lookback start. It is 0 when nothing was added yet.
+func (w *SlidingWindow) Covered(lookback time.Duration) time.Duration {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.first < 0 {
+		return 0
+	}
+	current := w.slot(w.now())
+	slots := w.lookbackSlots(lookback)
+	if since := current - w.first + 1; since < slots {
+		slots = since
+	}
+	return time.Duration(slots) * w.resolution
+}
+
+// Buckets returns the buckets still in the window, from the oldest to the newest
+func (w *SlidingWindow) Buckets() []WindowBucket {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	current := w.slot(w.now())
+	oldest := current - int64(len(w.slots)) + 1
+	buckets := make([]WindowBucket, 0, len(w.slots))
+	for slot := oldest; slot <= current; slot++ {
+		i := w.index(slot)
+		if w.slots[i] == slot {
+			buckets = append(buckets, WindowBucket{Start: time.Unix(0, slot*int64(w.resolution)), Value: w.values[i]})
+		}
+	}
+	return buckets
+}
+
+// Restore adds the values of the buckets, as returned by Buckets, the ones outside of the window
+// are ignored
+func (w *SlidingWindow) Restore(buckets []WindowBucket) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	current := w.slot(w.now())
+	for _, bucket := range buckets {
+		slot := w.slot(bucket.Start)
+		if slot > current || slot <= current-int64(len(w.slots)) {
+			continue
+		}
+		w.add(slot, bucket.Value)
+	}
+}
+
+// Reset drops the values added so far, the window covers nothing until a value is added again
+func (w *SlidingWindow) Reset() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for i := range w.slots {
+		w.slots[i] = -1
+		w.values[i] = 0
+	}
+	w.first = -1
+}
+
+func (w *SlidingWindow) add(slot int64, value uint64) {
+	i := w.index(slot)
+	if w.slots[i] != slot {
+		w.slots[i] = slot
+		w.values[i] = 0
+	}
+	w.values[i] += value
+	if w.first < 0 || slot < w.first {
+		w.first = slot
+	}
+}
+
+func (w *SlidingWindow) slot(t time.Time) int64 {
+	return t.UnixNano() / int64(w.resolution)
+}
+
+func (w *SlidingWindow) index(slot int64) int {
+	return int(slot % int64(len(w.slots)))
+}
+
+// lookbackSlots returns the number of slots covered by the lookback, between 1 and the window
+func (w *SlidingWindow) lookbackSlots(lookback time.Duration) int64 {
+	slots := int64((lookback + w.resolution - 1) / w.resolution)
+	if slots < 1 {
+		slots = 1
+	}
+	if slots > int64(len(w.slots)) {
+		slots = int64(len(w.slots))
+	}
+	return slots
+}
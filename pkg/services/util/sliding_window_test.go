@@ -0,0 +1,139 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock drives the sliding window time in tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestWindow(size, resolution time.Duration) (*SlidingWindow, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	w := NewSlidingWindow(size, resolution)
+	w.now = clock.Now
+	return w, clock
+}
+
+func TestSlidingWindowSum(t *testing.T) {
+	w, clock := newTestWindow(time.Minute, 10*time.Second)
+	for i := 0; i < 6; i++ {
+		w.Add(1)
+		clock.now = clock.now.Add(10 * time.Second)
+	}
+	w.Add(10)
+
+	if got := w.Sum(10 * time.Second); got != 10 {
+		t.Errorf("got = %d, want = %d", got, 10)
+	}
+	if got := w.Sum(30 * time.Second); got != 12 {
+		t.Errorf("got = %d, want = %d", got, 12)
+	}
+	// the first bucket is out of the window
+	if got := w.Sum(time.Hour); got != 15 {
+		t.Errorf("got = %d, want = %d", got, 15)
+	}
+}
+
+func TestSlidingWindowExpiry(t *testing.T) {
+	w, clock := newTestWindow(time.Minute, 10*time.Second)
+	w.Add(5)
+	clock.now = clock.now.Add(2 * time.Minute)
+
+	if got := w.Sum(time.Minute); got != 0 {
+		t.Errorf("got = %d, want = %d", got, 0)
+	}
+	w.Add(1)
+	if got := w.Sum(time.Minute); got != 1 {
+		t.Errorf("got = %d, want = %d", got, 1)
+	}
+}
+
+func TestSlidingWindowCovered(t *testing.T) {
+	w, clock := newTestWindow(time.Minute, 10*time.Second)
+	if got := w.Covered(time.Minute); got != 0 {
+		t.Errorf("got = %v, want = %v", got, 0)
+	}
+	w.Add(1)
+	clock.now = clock.now.Add(20 * time.Second)
+	if got := w.Covered(time.Minute); got != 30*time.Second {
+		t.Errorf("got = %v, want = %v", got, 30*time.Second)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if got := w.Covered(time.Minute); got != time.Minute {
+		t.Errorf("got = %v, want = %v", got, time.Minute)
+	}
+}
+
+func TestSlidingWindowReset(t *testing.T) {
+	w, clock := newTestWindow(time.Minute, 10*time.Second)
+	w.Add(5)
+	clock.now = clock.now.Add(20 * time.Second)
+	w.Reset()
+
+	if got := w.Sum(time.Minute); got != 0 {
+		t.Errorf("got = %d, want = %d", got, 0)
+	}
+	if got := w.Covered(time.Minute); got != 0 {
+		t.Errorf("got = %v, want = %v", got, 0)
+	}
+	w.Add(1)
+	if got := w.Sum(time.Minute); got != 1 {
+		t.Errorf("got = %d, want = %d", got, 1)
+	}
+}
+
+func TestSlidingWindowRestore(t *testing.T) {
+	w, clock := newTestWindow(time.Minute, 10*time.Second)
+	for i := 0; i < 3; i++ {
+		w.Add(2)
+		clock.now = clock.now.Add(10 * time.Second)
+	}
+
+	restored, restoredClock := newTestWindow(time.Minute, 10*time.Second)
+	restoredClock.now = clock.now.Add(30 * time.Second)
+	restored.Restore(w.Buckets())
+	// the oldest bucket is out of the window by now
+	if got := restored.Sum(time.Minute); got != 4 {
+		t.Errorf("got = %d, want = %d", got, 4)
+	}
+}
+
+func TestSlidingWindowMaxBuckets(t *testing.T) {
+	w := NewSlidingWindow(24*time.Hour, time.Second)
+	if len(w.values) != maxBufferBuckets {
+		t.Errorf("got = %d, want = %d", len(w.values), maxBufferBuckets)
+	}
+	if w.Size() < 24*time.Hour {
+		t.Errorf("got = %v, want >= %v", w.Size(), 24*time.Hour)
+	}
+}
+
+func BenchmarkSlidingWindowAdd(b *testing.B) {
+	for _, size := range []time.Duration{time.Minute, time.Hour} {
+		b.Run(size.String(), func(b *testing.B) {
+			w := NewSlidingWindow(size, time.Second)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Add(1)
+			}
+		})
+	}
+}
+
+func BenchmarkSlidingWindowSum(b *testing.B) {
+	w := NewSlidingWindow(time.Hour, 10*time.Second)
+	w.Add(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Sum(5 * time.Minute)
+	}
+}
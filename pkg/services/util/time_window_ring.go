@@ -42,6 +42,8 @@ func (e *ErrNoDataSamples) Error() string {
 // ---------------------------------------------------------
 // | vX+1 (H) | v2 (T) | v3 | .... | vN | vN+1 | vN+2 | vX |  --> start to fill the buffer using first localion kicking out old value (time window is moving)
 // ---------------------------------------------------------
+//
+// Deprecated: use SlidingWindow, which needs no periodic sampling and answers any lookback.
 type TimeWindowRing struct {
 	buffer   []uint64
 	tail     int
@@ -100,3 +102,20 @@ func (rb *TimeWindowRing) IsEmpty() bool {
 func (rb *TimeWindowRing) Count() int {
 	return rb.count
 }
+
+// Samples returns the sampled values from the tail to the head
+func (rb *TimeWindowRing) Samples() []uint64 {
+	samples := make([]uint64, 0, rb.count)
+	for i := 0; i < rb.count; i++ {
+		samples = append(samples, rb.buffer[(rb.tail+i)%len(rb.buffer)])
+	}
+	return samples
+}
+
+// Restore adds the sampled values in order, as returned by Samples, keeping the most recent ones
+// when they don't fit in the buffer
+func (rb *TimeWindowRing) Restore(samples []uint64) {
+	for _, value := range samples {
+		rb.Put(value)
+	}
+}
@@ -34,3 +34,21 @@ func TestRingRolling(t *testing.T) {
 		t.Errorf("got = %d, want = %d", ring.Head(), 6)
 	}
 }
+
+func TestRingSamples(t *testing.T) {
+	ring := NewTimeWindowRing(10000, 2000)
+	for i := uint64(1); i <= 7; i++ {
+		ring.Put(i)
+	}
+	restored := NewTimeWindowRing(10000, 2000)
+	restored.Restore(ring.Samples())
+	if restored.Tail() != 3 {
+		t.Errorf("got = %d, want = %d", restored.Tail(), 3)
+	}
+	if restored.Head() != 7 {
+		t.Errorf("got = %d, want = %d", restored.Head(), 7)
+	}
+	if restored.Count() != 5 {
+		t.Errorf("got = %d, want = %d", restored.Count(), 5)
+	}
+}
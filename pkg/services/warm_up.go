@@ -0,0 +1,28 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// warmUp is the warm-up period of a canary
+type warmUp struct {
+	lock sync.RWMutex
+	// end of the warm-up period started with the canary
+	end time.Time
+}
+
+// StartWarmUp starts the warm-up period after the canary start, while connections are opened,
+// the metadata of a new topic propagates and the consumer group stabilizes
+func (st *State) StartWarmUp(period time.Duration) {
+	st.warmUp.lock.Lock()
+	defer st.warmUp.lock.Unlock()
+	st.warmUp.end = time.Now().Add(period)
+}
+
+// WarmingUp returns whether the canary is in its warm-up period
+func (st *State) WarmingUp() bool {
+	st.warmUp.lock.RLock()
+	defer st.warmUp.lock.RUnlock()
+	return time.Now().Before(st.warmUp.end)
+}
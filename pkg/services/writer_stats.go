@@ -0,0 +1,117 @@
+package services
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/pecigonzalo/kafka-canary/internal/metrics"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+var (
+	writerWrites = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "producer_writer_writes_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of produce requests sent by the producer writer",
+	})
+
+	writerMessages = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "producer_writer_messages_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of records written by the producer writer",
+	})
+
+	writerBytes = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "producer_writer_bytes_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of record bytes written by the producer writer",
+	})
+
+	writerErrors = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "producer_writer_errors_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of failed writes of the producer writer",
+	})
+
+	writerRetries = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "producer_writer_retries_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of write retries of the producer writer",
+	})
+
+	writerBatchFill = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "producer_writer_batch_fill_ratio",
+		Namespace: metricsNamespace,
+		Help:      "Average batch size of the producer writer over its max batch size",
+	})
+
+	writerBatchTime = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "producer_writer_batch_time",
+		Namespace: metricsNamespace,
+		Help:      "Time spent filling the producer writer batches in milliseconds",
+	}, []string{"stat"})
+
+	writerWriteTime = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "producer_writer_write_time",
+		Namespace: metricsNamespace,
+		Help:      "Time spent writing the producer writer batches in milliseconds",
+	}, []string{"stat"})
+
+	writerWaitTime = metrics.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "producer_writer_wait_time",
+		Namespace: metricsNamespace,
+		Help:      "Time the producer writer batches waited to be written, i.e. its queueing, in milliseconds",
+	}, []string{"stat"})
+
+	producerRoundDials = metrics.NewGauge(prometheus.GaugeOpts{
+		Name:      "producer_round_dials",
+		Namespace: metricsNamespace,
+		Help:      "Broker connections opened by the producer during the last produce round, 0 when they were all reused",
+	})
+
+	producerReconnectRounds = metrics.NewCounter(prometheus.CounterOpts{
+		Name:      "producer_reconnect_rounds_total",
+		Namespace: metricsNamespace,
+		Help:      "Total number of produce rounds opening broker connections, the first one excluded",
+	})
+)
+
+// exportWriterStats exports the writer stats since the previous call, kafka-go resets the
+// counters on every snapshot
+func (st *State) exportWriterStats(stats kafka.WriterStats) {
+	writerWrites.In(st.metrics).Add(float64(stats.Writes))
+	writerMessages.In(st.metrics).Add(float64(stats.Messages))
+	writerBytes.In(st.metrics).Add(float64(stats.Bytes))
+	writerErrors.In(st.metrics).Add(float64(stats.Errors))
+	writerRetries.In(st.metrics).Add(float64(stats.Retries))
+
+	if stats.MaxBatchSize > 0 {
+		writerBatchFill.In(st.metrics).Set(float64(stats.BatchSize.Avg) / float64(stats.MaxBatchSize))
+	}
+	setDurationStats(writerBatchTime.In(st.metrics), stats.BatchTime)
+	setDurationStats(writerWriteTime.In(st.metrics), stats.WriteTime)
+	setDurationStats(writerWaitTime.In(st.metrics), stats.WaitTime)
+}
+
+// exportConnectionStats exports the broker connections opened by the producer since the previous
+// round, nil before the first one. Connections opened every round mean they don't outlive the
+// produce interval, e.g. closed by a load balancer idle timeout, which real clients suffer too.
+func (st *State) exportConnectionStats(previous *client.ConnectionStats, current client.ConnectionStats) {
+	if previous == nil {
+		producerRoundDials.In(st.metrics).Set(float64(current.Dials))
+		return
+	}
+	dials := current.Dials - previous.Dials
+	producerRoundDials.In(st.metrics).Set(float64(dials))
+	if dials > 0 {
+		producerReconnectRounds.In(st.metrics).Inc()
+	}
+}
+
+func setDurationStats(gauge *prometheus.GaugeVec, stats kafka.DurationStats) {
+	for stat, value := range map[string]time.Duration{"avg": stats.Avg, "min": stats.Min, "max": stats.Max} {
+		gauge.WithLabelValues(stat).Set(float64(value.Milliseconds()))
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+func TestExportWriterStats(t *testing.T) {
+	st := newTestState()
+	writes, messages, retries := testutil.ToFloat64(writerWrites.In(st.metrics)), testutil.ToFloat64(writerMessages.In(st.metrics)), testutil.ToFloat64(writerRetries.In(st.metrics))
+	stats := kafka.WriterStats{
+		Writes:       2,
+		Messages:     6,
+		Retries:      1,
+		MaxBatchSize: 10,
+		BatchSize:    kafka.SummaryStats{Avg: 3},
+		WriteTime:    kafka.DurationStats{Avg: 20 * time.Millisecond, Min: 5 * time.Millisecond, Max: 40 * time.Millisecond},
+	}
+
+	// kafka-go resets the counters on every snapshot, they are added up
+	st.exportWriterStats(stats)
+	st.exportWriterStats(stats)
+	assert.Equal(t, writes+4, testutil.ToFloat64(writerWrites.In(st.metrics)))
+	assert.Equal(t, messages+12, testutil.ToFloat64(writerMessages.In(st.metrics)))
+	assert.Equal(t, retries+2, testutil.ToFloat64(writerRetries.In(st.metrics)))
+	assert.Equal(t, 0.3, testutil.ToFloat64(writerBatchFill.In(st.metrics)))
+	assert.Equal(t, 20.0, testutil.ToFloat64(writerWriteTime.In(st.metrics).WithLabelValues("avg")))
+	assert.Equal(t, 5.0, testutil.ToFloat64(writerWriteTime.In(st.metrics).WithLabelValues("min")))
+	assert.Equal(t, 40.0, testutil.ToFloat64(writerWriteTime.In(st.metrics).WithLabelValues("max")))
+
+	// no batch written yet
+	st.exportWriterStats(kafka.WriterStats{})
+	assert.Equal(t, 0.3, testutil.ToFloat64(writerBatchFill.In(st.metrics)), "batch fill kept without a max batch size")
+}
+
+func TestExportConnectionStats(t *testing.T) {
+	st := newTestState()
+	reconnects := testutil.ToFloat64(producerReconnectRounds.In(st.metrics))
+
+	st.exportConnectionStats(nil, client.ConnectionStats{Dials: 3})
+	assert.Equal(t, 3.0, testutil.ToFloat64(producerRoundDials.In(st.metrics)))
+	assert.Equal(t, reconnects, testutil.ToFloat64(producerReconnectRounds.In(st.metrics)), "first round")
+
+	st.exportConnectionStats(&client.ConnectionStats{Dials: 3}, client.ConnectionStats{Dials: 3})
+	assert.Equal(t, 0.0, testutil.ToFloat64(producerRoundDials.In(st.metrics)))
+	assert.Equal(t, reconnects, testutil.ToFloat64(producerReconnectRounds.In(st.metrics)), "connections reused")
+
+	st.exportConnectionStats(&client.ConnectionStats{Dials: 3}, client.ConnectionStats{Dials: 5})
+	assert.Equal(t, 2.0, testutil.ToFloat64(producerRoundDials.In(st.metrics)))
+	assert.Equal(t, reconnects+1, testutil.ToFloat64(producerReconnectRounds.In(st.metrics)))
+}
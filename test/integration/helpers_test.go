@@ -0,0 +1,63 @@
+//go:build integration
+
+// Package integration contains tests running the canary against a real Kafka cluster, see
+// docker-compose.yml, docker-compose-kraft.yml, docker-compose-single.yml and
+// docker-compose-tls.yml. Run them with:
+//
+//	KAFKA_CANARY_TEST_BROKERS=localhost:19092,localhost:19093,localhost:19094 \
+//		go test -tags integration ./test/integration/...
+package integration
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/topicctl/pkg/util"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+)
+
+// testBrokers returns the brokers from the given environment variable, skipping the test if unset
+func testBrokers(t *testing.T, env string) []string {
+	value := os.Getenv(env)
+	if value == "" {
+		t.Skipf("Skipping because %s is not set", env)
+	}
+	return strings.Split(value, ",")
+}
+
+func testLogger() *zerolog.Logger {
+	logger := zerolog.Nop()
+	if os.Getenv("KAFKA_CANARY_TEST_DEBUG") != "" {
+		logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.DebugLevel)
+	}
+	return &logger
+}
+
+func testAdminClient(t *testing.T, connectorConfig client.ConnectorConfig) *client.BrokerAdminClient {
+	admin, err := client.NewBrokerAdminClient(
+		context.Background(),
+		client.BrokerAdminClientConfig{ConnectorConfig: connectorConfig},
+		testLogger(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Close() }) // nolint: errcheck
+	return admin
+}
+
+// requireBrokers skips the test if the cluster has less than the given number of brokers
+func requireBrokers(t *testing.T, admin client.Client, count int) {
+	ids, err := admin.GetBrokerIDs(context.Background())
+	require.NoError(t, err)
+	if len(ids) < count {
+		t.Skipf("Skipping because the cluster has %d brokers, %d required", len(ids), count)
+	}
+}
+
+func testTopicName() string {
+	return util.RandomString("kafka-canary-test", 6)
+}
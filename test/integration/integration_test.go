@@ -0,0 +1,169 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	canary "github.com/pecigonzalo/kafka-canary"
+	canaryconfig "github.com/pecigonzalo/kafka-canary/pkg/canary"
+	"github.com/pecigonzalo/kafka-canary/pkg/client"
+	"github.com/pecigonzalo/kafka-canary/pkg/services"
+)
+
+func testCanaryConfig(topic string) canaryconfig.Config {
+	return canaryconfig.Config{
+		Topic:                       topic,
+		ClientID:                    "kafka-canary-test",
+		ConsumerGroupID:             topic + "-group",
+		ReconcileInterval:           time.Second,
+		StatusCheckInterval:         time.Second,
+		StatusTimeWindow:            time.Minute,
+		BootstrapBackoffMaxAttempts: 3,
+		BootstrapBackoffScale:       time.Second,
+		ProducerLatencyBuckets:      []float64{100, 500, 1000},
+		EndToEndLatencyBuckets:      []float64{100, 500, 1000},
+	}
+}
+
+func TestTopicServiceCreatesTopic(t *testing.T) {
+	connectorConfig := client.ConnectorConfig{BrokerAddrs: testBrokers(t, "KAFKA_CANARY_TEST_BROKERS")}
+	admin := testAdminClient(t, connectorConfig)
+	// the canary topic is created with a replication factor of 3
+	requireBrokers(t, admin, 3)
+
+	topic := testTopicName()
+	config := testCanaryConfig(topic)
+	topicService := services.NewTopicService(services.NewState(config), config, connectorConfig, testLogger())
+	defer topicService.Close()
+
+	result, err := topicService.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Assignments)
+
+	info, err := admin.GetTopic(context.Background(), topic, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, info.MaxReplication())
+
+	// reconciling an existing topic is a no-op
+	_, err = topicService.Reconcile(context.Background())
+	require.NoError(t, err)
+}
+
+func TestPartitionExpansion(t *testing.T) {
+	connectorConfig := client.ConnectorConfig{BrokerAddrs: testBrokers(t, "KAFKA_CANARY_TEST_BROKERS")}
+	admin := testAdminClient(t, connectorConfig)
+	ctx := context.Background()
+
+	topic := testTopicName()
+	err := admin.CreateTopic(ctx, kafka.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     2,
+		ReplicationFactor: 1,
+	})
+	require.NoError(t, err)
+
+	brokerIDs, err := admin.GetBrokerIDs(ctx)
+	require.NoError(t, err)
+	err = admin.AddPartitions(ctx, topic, []client.PartitionAssignment{
+		{ID: 2, Replicas: []int{brokerIDs[0]}},
+	})
+	require.NoError(t, err)
+
+	var info client.TopicInfo
+	require.Eventually(t, func() bool {
+		info, err = admin.GetTopic(ctx, topic, false)
+		return err == nil && len(info.Partitions) == 3
+	}, 30*time.Second, time.Second)
+}
+
+func TestCanaryProduceConsume(t *testing.T) {
+	brokers := testBrokers(t, "KAFKA_CANARY_TEST_BROKERS")
+	admin := testAdminClient(t, client.ConnectorConfig{BrokerAddrs: brokers})
+	requireBrokers(t, admin, 3)
+
+	consumed := make(chan canary.ConsumeResult, 100)
+	c, err := canary.New(canary.Config{
+		Brokers: brokers,
+		Canary:  testCanaryConfig(testTopicName()),
+		Callbacks: canary.Callbacks{
+			OnConsume: func(r canary.ConsumeResult) {
+				select {
+				case consumed <- r:
+				default:
+				}
+			},
+		},
+	}, testLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	timeout := time.After(90 * time.Second)
+	for {
+		select {
+		case r := <-consumed:
+			if r.Err == nil {
+				require.GreaterOrEqual(t, r.Latency, time.Duration(0))
+				return
+			}
+		case <-timeout:
+			t.Fatal("No canary record consumed")
+		}
+	}
+}
+
+func TestSASL(t *testing.T) {
+	brokers := testBrokers(t, "KAFKA_CANARY_TEST_SASL_BROKERS")
+	username := os.Getenv("KAFKA_CANARY_TEST_SASL_USERNAME")
+	password := os.Getenv("KAFKA_CANARY_TEST_SASL_PASSWORD")
+
+	for _, mechanism := range []client.SASLMechanism{
+		client.SASLMechanismPlain,
+		client.SASLMechanismScramSHA512,
+	} {
+		t.Run(string(mechanism), func(t *testing.T) {
+			admin := testAdminClient(t, client.ConnectorConfig{
+				BrokerAddrs: brokers,
+				SASL: client.SASLConfig{
+					Enabled:   true,
+					Mechanism: mechanism,
+					Username:  username,
+					Password:  password,
+				},
+			})
+			clusterID, err := admin.GetClusterID(context.Background())
+			require.NoError(t, err)
+			require.NotEmpty(t, clusterID)
+		})
+	}
+}
+
+func TestTLS(t *testing.T) {
+	brokers := testBrokers(t, "KAFKA_CANARY_TEST_TLS_BROKERS")
+
+	admin := testAdminClient(t, client.ConnectorConfig{
+		BrokerAddrs: brokers,
+		TLS: client.TLSConfig{
+			Enabled:    true,
+			CACertPath: os.Getenv("KAFKA_CANARY_TEST_TLS_CA"),
+			CertPath:   os.Getenv("KAFKA_CANARY_TEST_TLS_CERT"),
+			KeyPath:    os.Getenv("KAFKA_CANARY_TEST_TLS_KEY"),
+		},
+	})
+	clusterID, err := admin.GetClusterID(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, clusterID)
+}
@@ -0,0 +1,22 @@
+#!/usr/bin/env bash
+# Generates the CA, broker and client certificates used by docker-compose-tls.yml and the TLS
+# integration tests into test/tls/certs.
+set -euo pipefail
+
+dir="$(cd "$(dirname "$0")" && pwd)/certs"
+mkdir -p "$dir"
+cd "$dir"
+
+openssl req -x509 -newkey rsa:2048 -nodes -days 30 -subj "/CN=kafka-canary-test-ca" \
+	-keyout ca.key -out ca.pem
+
+for name in broker client; do
+	openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:2048 -out "$name.key"
+	openssl req -new -key "$name.key" -subj "/CN=kafka-canary-test-$name" -out "$name.csr"
+	openssl x509 -req -in "$name.csr" -CA ca.pem -CAkey ca.key -CAcreateserial -days 30 \
+		-extfile <(printf "subjectAltName=DNS:localhost,DNS:kafka1,IP:127.0.0.1") -out "$name.pem"
+	rm "$name.csr"
+done
+
+# the broker runs as a non-root user
+chmod 644 ./*.key